@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
@@ -86,15 +87,46 @@ func (p *PubAPI) GetPublication(c *gin.Context) {
 	c.JSON(http.StatusOK, pub)
 }
 
+// GetPublications returns all publications, a page at a time if the
+// caller asks for it.  The limit query parameter sets the page size and
+// the cursor parameter picks up where the previous page stopped, for
+// example /pubs?limit=10&cursor=<cursor>.  With count=true the
+// X-Total-Count header holds the number of publications in total, and
+// while there are more the Link header points at the next page.
 func (p *PubAPI) GetPublications(c *gin.Context) {
 
-	var pubList []schema.Publication
-	var pubItem schema.Publication
+	cursor, limit, count, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	//Lets query redis for all of the items
+	//Lets query redis for a page of the items, SCAN does not block
+	//redis the way KEYS does
 	pattern := "pubs:*"
-	ks, _ := p.client.Keys(p.context, pattern).Result()
+	ks, nextCursor, err := p.scanPage(pattern, cursor, limit)
+	if err != nil {
+		if errors.Is(err, errInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list publications in cache: " + err.Error()})
+		return
+	}
+
+	//Counting walks every key, so only do it when the caller asks
+	if count {
+		total, err := p.countKeys(pattern)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not count publications in cache: " + err.Error()})
+			return
+		}
+		setTotalCountHeader(c, total)
+	}
+
+	pubList := make([]schema.Publication, 0, len(ks))
 	for _, key := range ks {
+		var pubItem schema.Publication
 		err := p.getItemFromRedis(key, &pubItem)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find publication in cache with id=" + key})
@@ -103,6 +135,7 @@ func (p *PubAPI) GetPublications(c *gin.Context) {
 		pubList = append(pubList, pubItem)
 	}
 
+	setPageHeaders(c, nextCursor)
 	c.JSON(http.StatusOK, pubList)
 }
 
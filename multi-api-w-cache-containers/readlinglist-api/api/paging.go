@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//------------------------------------------------------------
+// PAGING
+//------------------------------------------------------------
+
+// MaxPageLimit is the largest page a caller can ask for with ?limit=
+const MaxPageLimit = 1000
+
+// TotalCountHeader tells the caller how many items there are in the
+// whole list, not just in the page they got back.  Counting means
+// walking the whole list, so it is only sent when the caller asks for
+// it with ?count=true.
+const TotalCountHeader = "X-Total-Count"
+
+// pageParams reads the limit, cursor and count query parameters of a
+// list request, for example /publists?limit=10&cursor=<cursor>&count=true.
+// Without a limit the whole list is returned, just like before paging
+// was added.  The bool says whether the caller wants the total count.
+func pageParams(c *gin.Context) (string, int, bool, error) {
+	limit := 0
+	if limitS := c.Query("limit"); limitS != "" {
+		var err error
+		limit, err = strconv.Atoi(limitS)
+		if err != nil || limit < 1 || limit > MaxPageLimit {
+			return "", 0, false, fmt.Errorf("limit must be a number from 1 to %d", MaxPageLimit)
+		}
+	}
+
+	count := false
+	if countS := c.Query("count"); countS != "" {
+		var err error
+		count, err = strconv.ParseBool(countS)
+		if err != nil {
+			return "", 0, false, errors.New("count must be true or false")
+		}
+	}
+	return c.Query("cursor"), limit, count, nil
+}
+
+// setTotalCountHeader tells the caller how many items there are in the
+// whole list
+func setTotalCountHeader(c *gin.Context, total int) {
+	c.Header(TotalCountHeader, strconv.Itoa(total))
+}
+
+// setPageHeaders adds, when there are more items, a Link header (RFC
+// 8288) that points at the next page.  The link keeps every other query
+// parameter of the request, so the caller can simply follow it until
+// there is no rel="next" link anymore.  Only count is dropped, a caller
+// that wants the total has it from the first page.
+func setPageHeaders(c *gin.Context, nextCursor string) {
+	if nextCursor == "" {
+		return
+	}
+
+	next := *c.Request.URL
+	query := next.Query()
+	query.Set("cursor", nextCursor)
+	query.Del("count")
+	next.RawQuery = query.Encode()
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
+
+// errInvalidCursor is returned by scanPage when a cursor was not handed
+// out by us, the handler turns it into a 400 Bad Request
+var errInvalidCursor = errors.New("invalid cursor")
+
+// redisScanCount is the COUNT hint we give to SCAN, roughly how many
+// keys redis looks at in one call.  It must not change between two
+// pages, because a page cursor points into a batch of this size.
+const redisScanCount = 100
+
+// The KEYS command walks the whole keyspace in one go, and redis does
+// nothing else until it is done, so a big cache stalls every client.
+// SCAN walks the keyspace a small batch at a time instead.  It hands
+// back a cursor with every batch, and we keep calling it with that
+// cursor until it returns 0.
+
+// countKeys returns the number of keys that match pattern.  It walks
+// the whole keyspace, so only call it when the caller asked for a count.
+func (rc *cache) countKeys(pattern string) (int, error) {
+	count := 0
+	iter := rc.client.Scan(rc.context, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(rc.context) {
+		count++
+	}
+	return count, iter.Err()
+}
+
+// scanPage returns up to limit keys that match pattern, starting at
+// cursor, and the cursor for the keys after them.  A limit of 0 means
+// no limit.
+//
+// A SCAN batch can hold more keys than we need for the page, and redis
+// cannot start halfway through a batch.  So our cursor holds the SCAN
+// cursor of the batch AND the last key we used from it.  The next page
+// asks redis for the same batch again, and skips the keys up to and
+// including that key.  The keys of a batch are sorted first, so this
+// also works if keys were added or removed in between.  Like SCAN
+// itself, a key that is added or removed while a caller is paging
+// may or may not show up.
+func (rc *cache) scanPage(pattern string, cursor string, limit int) ([]string, string, error) {
+	scanCursor, after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := make([]string, 0)
+	for {
+		batch, nextScanCursor, err := rc.client.Scan(rc.context, scanCursor, pattern, redisScanCount).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Strings(batch)
+		for _, key := range batch {
+			if after != "" && key <= after {
+				continue
+			}
+			if limit > 0 && len(keys) == limit {
+				//The page is full, but this batch has more keys
+				return keys, encodeCursor(scanCursor, keys[len(keys)-1]), nil
+			}
+			keys = append(keys, key)
+		}
+
+		//Redis is done with the keyspace when the cursor comes back as 0
+		if nextScanCursor == 0 {
+			return keys, "", nil
+		}
+		scanCursor, after = nextScanCursor, ""
+		if limit > 0 && len(keys) == limit {
+			return keys, encodeCursor(scanCursor, ""), nil
+		}
+	}
+}
+
+// Our cursors are "<scan cursor>:<last key>", base64 encoded so they
+// are safe to put in a url and callers do not try to build their own
+func encodeCursor(scanCursor uint64, after string) string {
+	raw := strconv.FormatUint(scanCursor, 10) + ":" + after
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (uint64, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", errInvalidCursor
+	}
+	scanCursorS, after, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", errInvalidCursor
+	}
+	scanCursor, err := strconv.ParseUint(scanCursorS, 10, 64)
+	if err != nil {
+		return 0, "", errInvalidCursor
+	}
+	return scanCursor, after, nil
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
@@ -177,15 +178,46 @@ func (r *ReadingListAPI) RedirectWithPublication(c *gin.Context) {
 	c.Redirect(http.StatusMovedPermanently, pub.Link)
 }
 
+// GetReadingLists returns all reading lists, a page at a time if the
+// caller asks for it.  The limit query parameter sets the page size and
+// the cursor parameter picks up where the previous page stopped, for
+// example /publists?limit=10&cursor=<cursor>.  With count=true the
+// X-Total-Count header holds the number of reading lists in total, and
+// while there are more the Link header points at the next page.
 func (r *ReadingListAPI) GetReadingLists(c *gin.Context) {
 
-	var readList []schema.ReadingList
-	var readItem schema.ReadingList
+	cursor, limit, count, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	//Lets query redis for all of the items
+	//Lets query redis for a page of the items, SCAN does not block
+	//redis the way KEYS does
 	pattern := "publist:*"
-	ks, _ := r.client.Keys(r.context, pattern).Result()
+	ks, nextCursor, err := r.scanPage(pattern, cursor, limit)
+	if err != nil {
+		if errors.Is(err, errInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not list reading lists in cache: " + err.Error()})
+		return
+	}
+
+	//Counting walks every key, so only do it when the caller asks
+	if count {
+		total, err := r.countKeys(pattern)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not count reading lists in cache: " + err.Error()})
+			return
+		}
+		setTotalCountHeader(c, total)
+	}
+
+	readList := make([]schema.ReadingList, 0, len(ks))
 	for _, key := range ks {
+		var readItem schema.ReadingList
 		err := r.getItemFromRedis(key, &readItem)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not find reading list in cache with id=" + key})
@@ -194,6 +226,7 @@ func (r *ReadingListAPI) GetReadingLists(c *gin.Context) {
 		readList = append(readList, readItem)
 	}
 
+	setPageHeaders(c, nextCursor)
 	c.JSON(http.StatusOK, readList)
 }
 
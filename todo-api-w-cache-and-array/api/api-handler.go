@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -36,15 +37,33 @@ func New() (*ToDoAPI, error) {
 //	  done using the c.AbortWithStatus() function
 
 // implementation for GET /todo
-// returns all todos
+// returns all todos, a page at a time if the caller asks for it.  The
+// limit query parameter sets the page size and the cursor parameter
+// picks up where the previous page stopped, for example
+// /todo?limit=10&cursor=<cursor>.  With count=true the X-Total-Count
+// header holds the number of todos in the whole list, and while there
+// are more todos the Link header points at the next page.
 func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 
-	todoList, err := td.db.GetAllItems()
+	cursor, limit, count, err := pageParams(c)
 	if err != nil {
+		log.Println("Error reading paging parameters: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := td.db.GetItemsPage(cursor, limit)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			log.Println("Error reading cursor: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Println("Error Getting All Items: ", err)
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	todoList := page.Items
 	//Note that the database returns a nil slice if there are no items
 	//in the database.  We need to convert this to an empty slice
 	//so that the JSON marshalling works correctly.  We want to return
@@ -53,6 +72,19 @@ func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 		todoList = make([]db.ToDoItem, 0)
 	}
 
+	//Counting can mean walking every item, so only do it when the
+	//caller asks
+	if count {
+		total, err := td.db.CountItems()
+		if err != nil {
+			log.Println("Error counting items: ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		setTotalCountHeader(c, total)
+	}
+
+	setPageHeaders(c, page.NextCursor)
 	c.JSON(http.StatusOK, todoList)
 }
 
@@ -147,7 +179,8 @@ func (td *ToDoAPI) GetToDo(c *gin.Context) {
 }
 
 // implementation for POST /todo
-// adds a new todo
+// adds a new todo, the id can be left out of the body and the
+// database will assign one, the response holds the new item
 func (td *ToDoAPI) AddToDo(c *gin.Context) {
 	var todoItem db.ToDoItem
 
@@ -168,7 +201,19 @@ func (td *ToDoAPI) AddToDo(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.AddItem(todoItem); err != nil {
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	//The database fills in the id, when the item does not have one,
+	//and the timestamps, so send back the item as it was stored rather
+	//than the item we were sent
+	todoItem, err := td.db.AddItem(todoItem)
+	if err != nil {
 		log.Println("Error adding item: ", err)
 		c.AbortWithStatus(http.StatusConflict)
 		return
@@ -187,12 +232,89 @@ func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
 		return
 	}
 
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
 	if err := td.db.UpdateItem(todoItem); err != nil {
 		log.Println("Error updating item: ", err)
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
+	//The database sets the timestamps, so send back the item as it
+	//was stored rather than the item we were sent
+	todoItem, err := td.db.GetItem(todoItem.Id)
+	if err != nil {
+		log.Println("Error reading back item: ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, todoItem)
+}
+
+// implementation for PATCH /todo/:id
+// changes part of a todo, the body is a patch document and the
+// Content-Type header says which kind:
+//
+//	application/merge-patch+json   {"done": true, "due": null}
+//	application/json-patch+json    [{"op": "add", "path": "/tags/-", "value": "work"}]
+//
+// A plain application/json body is read as a merge patch.  The fields
+// the patch does not mention are left as they are, so unlike PUT the
+// client does not have to send the whole todo.  The response holds the
+// patched todo.
+func (td *ToDoAPI) PatchToDo(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	patchType := c.ContentType()
+	if patchType == "application/json" {
+		patchType = db.MergePatchType
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		log.Println("Error reading body: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	patch, err := db.NewPatch(patchType, body)
+	if err != nil {
+		log.Println("Error reading patch: ", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, db.ErrUnsupportedPatch) {
+			status = http.StatusUnsupportedMediaType
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	todoItem, err := td.db.PatchItem(int(id64), patch)
+	if err != nil {
+		log.Println("Error patching item: ", err)
+		switch {
+		case errors.Is(err, db.ErrInvalidPatch):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err.Error() == "item does not exist":
+			c.AbortWithStatus(http.StatusNotFound)
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+
 	c.JSON(http.StatusOK, todoItem)
 }
 
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+// The steps of a todo are a sub resource under /todo/:id/steps, so a
+// client can add, change, complete, reorder and remove a single step
+// without sending the whole todo.  A step is named by its step number,
+// which stays the same when the steps are reordered.
+
+// stepParams reads the :id and, if the route has one, the :step
+// parameter.  If either is not a number it aborts with 400 and returns
+// false.
+func stepParams(c *gin.Context) (id int, num int, ok bool) {
+	id64, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return 0, 0, false
+	}
+	if c.Param("step") == "" {
+		return int(id64), 0, true
+	}
+	num64, err := strconv.ParseInt(c.Param("step"), 10, 32)
+	if err != nil {
+		log.Println("Error converting step to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return 0, 0, false
+	}
+	return int(id64), int(num64), true
+}
+
+// abortWithStepError answers a request that failed with err
+func abortWithStepError(c *gin.Context, err error) {
+	log.Println("Error changing steps: ", err)
+	switch {
+	case errors.Is(err, db.ErrInvalidStep):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrStepNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "item does not exist", err.Error() == db.RedisNilError:
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// implementation for GET /todo/:id/steps
+// returns the steps of a todo in order
+func (td *ToDoAPI) ListSteps(c *gin.Context) {
+	id, _, ok := stepParams(c)
+	if !ok {
+		return
+	}
+
+	steps, err := td.db.GetSteps(id)
+	if err != nil {
+		abortWithStepError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, steps)
+}
+
+// implementation for GET /todo/:id/steps/:step
+func (td *ToDoAPI) GetStep(c *gin.Context) {
+	id, num, ok := stepParams(c)
+	if !ok {
+		return
+	}
+
+	step, err := td.db.GetStep(id, num)
+	if err != nil {
+		abortWithStepError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, step)
+}
+
+// implementation for POST /todo/:id/steps
+// adds a step to the end of the steps, the body is the step, for
+// example {"description": "buy paint"}.  The step is returned with the
+// number it was given.
+func (td *ToDoAPI) AddStep(c *gin.Context) {
+	id, _, ok := stepParams(c)
+	if !ok {
+		return
+	}
+
+	var step db.Step
+	if err := c.ShouldBindJSON(&step); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	step, err := td.db.AddStep(id, step)
+	if err != nil {
+		abortWithStepError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, step)
+}
+
+// implementation for PUT /todo/:id/steps/:step
+// replaces a step, the step number in the body is ignored
+func (td *ToDoAPI) UpdateStep(c *gin.Context) {
+	id, num, ok := stepParams(c)
+	if !ok {
+		return
+	}
+
+	var step db.Step
+	if err := c.ShouldBindJSON(&step); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	step, err := td.db.UpdateStep(id, num, step)
+	if err != nil {
+		abortWithStepError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, step)
+}
+
+// implementation for PUT /todo/:id/steps/:step/done and
+// DELETE /todo/:id/steps/:step/done
+// completes a step, or un-completes it.  Completing the last open step
+// of a todo with auto_complete set completes the todo too, so the whole
+// todo is returned.
+func (td *ToDoAPI) ChangeStepDoneStatus(c *gin.Context) {
+	id, num, ok := stepParams(c)
+	if !ok {
+		return
+	}
+
+	done := c.Request.Method == http.MethodPut
+	todoItem, err := td.db.ChangeStepDoneStatus(id, num, done)
+	if err != nil {
+		abortWithStepError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, todoItem)
+}
+
+// implementation for DELETE /todo/:id/steps/:step
+func (td *ToDoAPI) DeleteStep(c *gin.Context) {
+	id, num, ok := stepParams(c)
+	if !ok {
+		return
+	}
+
+	if err := td.db.DeleteStep(id, num); err != nil {
+		abortWithStepError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// implementation for PUT /todo/:id/steps/order
+// puts the steps in a new order, the body lists every step number once
+// in the new order, for example [3, 1, 2].  The reordered steps are
+// returned.
+func (td *ToDoAPI) ReorderSteps(c *gin.Context) {
+	id, _, ok := stepParams(c)
+	if !ok {
+		return
+	}
+
+	var order []int
+	if err := c.ShouldBindJSON(&order); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	steps, err := td.db.ReorderSteps(id, order)
+	if err != nil {
+		abortWithStepError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, steps)
+}
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//------------------------------------------------------------
+// STEPS
+//------------------------------------------------------------
+
+// The steps of an item live in the .steps array of its json document.
+// Reading the whole item, changing a step and writing the item back
+// would lose a change someone else made in between, so every step
+// operation is a small Lua script instead.  Redis runs a script from
+// start to finish without running any other command in between, and
+// the script changes the array in place with the RedisJSON array
+// commands, JSON.ARRAPPEND to add a step, JSON.ARRPOP to remove one,
+// and JSON.SET on a path such as .steps[2] to change one.
+//
+// Every script ends the same way, it sets .updated_at, marks the item
+// done if it has auto_complete set and every step is now done, and
+// returns the whole item so the caller sees the result.
+
+// ErrStepNotFound is returned when an item has no step with the number
+// that was asked for
+var ErrStepNotFound = errors.New("step does not exist")
+
+// ErrInvalidStep is returned for a step or step order that cannot be
+// stored, it is the caller's mistake
+var ErrInvalidStep = errors.New("invalid step")
+
+// Validate checks a step before it is stored
+func (s Step) Validate() error {
+	if strings.TrimSpace(s.Description) == "" {
+		return fmt.Errorf("%w: the description cannot be empty", ErrInvalidStep)
+	}
+	return nil
+}
+
+// stepScriptHead loads the item at KEYS[1] for a step script.  Items
+// that were saved before they had steps have "steps": null, there is
+// nothing to append to, so we start them off with an empty array.
+const stepScriptHead = `
+local doc = redis.call('JSON.GET', KEYS[1], '.')
+if not doc then
+	return redis.error_reply('item does not exist')
+end
+local item = cjson.decode(doc)
+local steps = item.steps
+if type(steps) ~= 'table' then
+	steps = {}
+	redis.call('JSON.SET', KEYS[1], '.steps', '[]')
+end
+
+local function find(num)
+	for i, step in ipairs(steps) do
+		if step.step == num then
+			return i
+		end
+	end
+	return nil
+end
+`
+
+// stepScriptTail finishes a step script, ARGV[1] is the current time as
+// a json string
+const stepScriptTail = `
+redis.call('JSON.SET', KEYS[1], '.updated_at', ARGV[1])
+if item.auto_complete == true and item.done ~= true and #steps > 0 then
+	local allDone = true
+	for _, step in ipairs(steps) do
+		if step.done ~= true then
+			allDone = false
+		end
+	end
+	if allDone then
+		redis.call('JSON.SET', KEYS[1], '.done', 'true')
+		redis.call('JSON.SET', KEYS[1], '.completed_at', ARGV[1])
+	end
+end
+return redis.call('JSON.GET', KEYS[1], '.')
+`
+
+// newStepScript wraps the body of a step script with the head and tail
+func newStepScript(body string) *redis.Script {
+	return redis.NewScript(stepScriptHead + body + stepScriptTail)
+}
+
+// addStepScript appends the step in ARGV[2] with the next free step
+// number.  Numbers are never reused while the step with the highest
+// number is still there, so a client that just read the steps does not
+// end up changing a step it has never seen.
+var addStepScript = newStepScript(`
+local next = 1
+for _, step in ipairs(steps) do
+	if step.step >= next then
+		next = step.step + 1
+	end
+end
+local step = cjson.decode(ARGV[2])
+step.step = next
+redis.call('JSON.ARRAPPEND', KEYS[1], '.steps', cjson.encode(step))
+table.insert(steps, step)
+`)
+
+// updateStepScript replaces step number ARGV[2] with the step in ARGV[3]
+var updateStepScript = newStepScript(`
+local i = find(tonumber(ARGV[2]))
+if not i then
+	return redis.error_reply('step does not exist')
+end
+redis.call('JSON.SET', KEYS[1], '.steps[' .. (i - 1) .. ']', ARGV[3])
+steps[i] = cjson.decode(ARGV[3])
+`)
+
+// stepDoneScript sets the done flag of step number ARGV[2] to ARGV[3],
+// which is true or false
+var stepDoneScript = newStepScript(`
+local i = find(tonumber(ARGV[2]))
+if not i then
+	return redis.error_reply('step does not exist')
+end
+redis.call('JSON.SET', KEYS[1], '.steps[' .. (i - 1) .. '].done', ARGV[3])
+steps[i].done = ARGV[3] == 'true'
+`)
+
+// deleteStepScript removes step number ARGV[2]
+var deleteStepScript = newStepScript(`
+local i = find(tonumber(ARGV[2]))
+if not i then
+	return redis.error_reply('step does not exist')
+end
+redis.call('JSON.ARRPOP', KEYS[1], '.steps', i - 1)
+table.remove(steps, i)
+`)
+
+// reorderStepsScript puts the steps in the order of the step numbers in
+// ARGV[2], a json array that must name every step exactly once.  Moving
+// the steps one at a time would take an ARRPOP and an ARRINSERT per
+// step, so the new array is set in one go.
+var reorderStepsScript = newStepScript(`
+local order = cjson.decode(ARGV[2])
+if #order ~= #steps then
+	return redis.error_reply('invalid step order, it must list every step once')
+end
+local seen = {}
+local reordered = {}
+for _, num in ipairs(order) do
+	local i = find(num)
+	if not i or seen[num] then
+		return redis.error_reply('invalid step order, it must list every step once')
+	end
+	seen[num] = true
+	table.insert(reordered, steps[i])
+end
+steps = reordered
+if #steps > 0 then
+	redis.call('JSON.SET', KEYS[1], '.steps', cjson.encode(steps))
+end
+`)
+
+// runStepScript runs a step script on the item with id, args are
+// ARGV[2] onwards.  It returns the item as the script left it.
+func (t *ToDo) runStepScript(script *redis.Script, id int, args ...interface{}) (ToDoItem, error) {
+	now, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	args = append([]interface{}{string(now)}, args...)
+
+	doc, err := script.Run(t.context, t.cacheClient, []string{redisKeyFromId(id)}, args...).Text()
+	if err != nil {
+		return ToDoItem{}, stepScriptError(err)
+	}
+
+	var item ToDoItem
+	if err := json.Unmarshal([]byte(doc), &item); err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
+}
+
+// stepScriptError turns the errors the step scripts return into the
+// errors of this package
+func stepScriptError(err error) error {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "item does not exist"):
+		return errors.New("item does not exist")
+	case strings.Contains(msg, "step does not exist"):
+		return ErrStepNotFound
+	case strings.Contains(msg, "invalid step order"):
+		return fmt.Errorf("%w: the order must list every step once", ErrInvalidStep)
+	}
+	return err
+}
+
+// findStep returns the step with number num of item
+func findStep(item ToDoItem, num int) (Step, error) {
+	for _, step := range item.Steps {
+		if step.StepNum == num {
+			return step, nil
+		}
+	}
+	return Step{}, ErrStepNotFound
+}
+
+// GetSteps returns the steps of the item with id, in order.  An item
+// without steps returns an empty slice.
+func (t *ToDo) GetSteps(id int) ([]Step, error) {
+	item, err := t.GetItem(id)
+	if err != nil {
+		return nil, err
+	}
+	if item.Steps == nil {
+		return []Step{}, nil
+	}
+	return item.Steps, nil
+}
+
+// GetStep returns step number num of the item with id
+func (t *ToDo) GetStep(id int, num int) (Step, error) {
+	item, err := t.GetItem(id)
+	if err != nil {
+		return Step{}, err
+	}
+	return findStep(item, num)
+}
+
+// AddStep adds a step to the end of the steps of the item with id.  The
+// step number of step is ignored, the step gets the next free number.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//	(2) The item must exist in the DB, if not,
+//		return an error
+//	(3) The step must pass Validate
+//
+// Postconditions:
+//
+//	 (1) The step will be appended with JSON.ARRAPPEND, and returned
+//			with its number
+//		(2) If the item has auto_complete set, it is done when all of
+//			its steps are done, see stepScriptTail
+//		(3) If there is an error, it will be returned
+func (t *ToDo) AddStep(id int, step Step) (Step, error) {
+	if err := step.Validate(); err != nil {
+		return Step{}, err
+	}
+	step.StepNum = 0
+	data, err := json.Marshal(step)
+	if err != nil {
+		return Step{}, err
+	}
+
+	item, err := t.runStepScript(addStepScript, id, string(data))
+	if err != nil {
+		return Step{}, err
+	}
+	//The script appended the step, so it is the last one
+	return item.Steps[len(item.Steps)-1], nil
+}
+
+// UpdateStep replaces step number num of the item with id, the step
+// keeps its number
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//	(2) The item and the step must exist in the DB,
+//		if not, return an error
+//	(3) The step must pass Validate
+//
+// Postconditions:
+//
+//	 (1) The step will be set in place with JSON.SET, and returned
+//		(2) If the item has auto_complete set, it is done when all of
+//			its steps are done, see stepScriptTail
+//		(3) If there is an error, it will be returned
+func (t *ToDo) UpdateStep(id int, num int, step Step) (Step, error) {
+	if err := step.Validate(); err != nil {
+		return Step{}, err
+	}
+	step.StepNum = num
+	data, err := json.Marshal(step)
+	if err != nil {
+		return Step{}, err
+	}
+
+	item, err := t.runStepScript(updateStepScript, id, num, string(data))
+	if err != nil {
+		return Step{}, err
+	}
+	return findStep(item, num)
+}
+
+// ChangeStepDoneStatus marks step number num of the item with id done
+// or not done.  Only the done flag of the step is written.  It returns
+// the item, so the caller can see if the item was completed as well.
+func (t *ToDo) ChangeStepDoneStatus(id int, num int, value bool) (ToDoItem, error) {
+	//go-redis sends a bool as 1 or 0, the script wants the json
+	return t.runStepScript(stepDoneScript, id, num, strconv.FormatBool(value))
+}
+
+// DeleteStep removes step number num from the item with id, the other
+// steps keep their numbers
+func (t *ToDo) DeleteStep(id int, num int) error {
+	_, err := t.runStepScript(deleteStepScript, id, num)
+	return err
+}
+
+// ReorderSteps puts the steps of the item with id in the order of the
+// step numbers in order, which must name every step exactly once, if
+// not ErrInvalidStep is returned.  The reordered steps are returned.
+func (t *ToDo) ReorderSteps(id int, order []int) ([]Step, error) {
+	data, err := json.Marshal(order)
+	if err != nil {
+		return nil, err
+	}
+	item, err := t.runStepScript(reorderStepsScript, id, string(data))
+	if err != nil {
+		return nil, err
+	}
+	if item.Steps == nil {
+		return []Step{}, nil
+	}
+	return item.Steps, nil
+}
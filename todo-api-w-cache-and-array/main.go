@@ -71,6 +71,17 @@ func main() {
 	r.DELETE("/todo", apiHandler.DeleteAllToDo)
 	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
 	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.PATCH("/todo/:id", apiHandler.PatchToDo)
+
+	//The steps of a todo, see api/steps.go
+	r.GET("/todo/:id/steps", apiHandler.ListSteps)
+	r.POST("/todo/:id/steps", apiHandler.AddStep)
+	r.PUT("/todo/:id/steps/order", apiHandler.ReorderSteps)
+	r.GET("/todo/:id/steps/:step", apiHandler.GetStep)
+	r.PUT("/todo/:id/steps/:step", apiHandler.UpdateStep)
+	r.DELETE("/todo/:id/steps/:step", apiHandler.DeleteStep)
+	r.PUT("/todo/:id/steps/:step/done", apiHandler.ChangeStepDoneStatus)
+	r.DELETE("/todo/:id/steps/:step/done", apiHandler.ChangeStepDoneStatus)
 
 	r.GET("/crash", apiHandler.CrashSim)
 	r.GET("/health", apiHandler.HealthCheck)
@@ -0,0 +1,481 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+// redisJSONServer is an in process redis for the store to talk to, so
+// the tests do not need a redis container.  miniredis does not know the
+// RedisJSON module, so we register the JSON commands the store and the
+// step scripts use.  Documents are kept as plain strings, and paths can
+// name fields and array elements, such as .steps[2].done, which is all
+// the step scripts need.
+//
+// The commands also work from a Lua script.  miniredis holds its lock
+// while a script runs, so we cannot use its Get and Set functions, they
+// take the same lock.  Instead every command reads and writes the
+// document with the GET and SET commands, on the connection of the
+// caller, which is how a script reaches redis too.
+type redisJSONServer struct {
+	*miniredis.Miniredis
+
+	//Every connection runs on its own goroutine, the lock makes each
+	//command a single step, like real redis.  A script does not need
+	//it, nothing else runs while miniredis runs a script.
+	lock sync.Mutex
+}
+
+// newRedisJSONServer starts a redisJSONServer that is stopped when the
+// test ends
+func newRedisJSONServer(t *testing.T) *redisJSONServer {
+	s := &redisJSONServer{Miniredis: miniredis.RunT(t)}
+
+	commands := map[string]server.Cmd{
+		"JSON.SET":       s.jsonSet,
+		"JSON.GET":       s.jsonGet,
+		"JSON.DEL":       s.jsonDel,
+		"JSON.ARRAPPEND": s.jsonArrAppend,
+		"JSON.ARRPOP":    s.jsonArrPop,
+	}
+	for name, cmd := range commands {
+		if err := s.Server().Register(name, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// JSON.SET <key> <path> <json> [NX | XX]
+func (s *redisJSONServer) jsonSet(c *server.Peer, cmd string, args []string) {
+	if len(args) < 3 || len(args) > 4 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.SET' command")
+		return
+	}
+	key := args[0]
+	path, err := parsePath(args[1])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	value, err := decodeJSON(args[2])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+
+	defer s.begin(c)()
+
+	var doc interface{}
+	exists := s.exists(c, key)
+	if len(path) > 0 {
+		if !exists {
+			c.WriteError("ERR new objects must be created at the root")
+			return
+		}
+		if doc, err = s.load(c, key); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+		_, exists = getPath(doc, path)
+	}
+
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "NX":
+			if exists {
+				c.WriteNull()
+				return
+			}
+		case "XX":
+			if !exists {
+				c.WriteNull()
+				return
+			}
+		default:
+			c.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	if doc, err = setPath(doc, path, value); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	if err := s.store(c, key, doc); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	c.WriteOK()
+}
+
+// JSON.GET <key> [path]
+func (s *redisJSONServer) jsonGet(c *server.Peer, cmd string, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.GET' command")
+		return
+	}
+	var path []interface{}
+	if len(args) == 2 {
+		var err error
+		if path, err = parsePath(args[1]); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+	}
+
+	defer s.begin(c)()
+	if !s.exists(c, args[0]) {
+		c.WriteNull()
+		return
+	}
+	doc, err := s.load(c, args[0])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	value, ok := getPath(doc, path)
+	if !ok {
+		c.WriteError("ERR path does not exist")
+		return
+	}
+	data, _ := json.Marshal(value)
+	c.WriteBulk(string(data))
+}
+
+// JSON.DEL <key> [path]
+func (s *redisJSONServer) jsonDel(c *server.Peer, cmd string, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.DEL' command")
+		return
+	}
+	var path []interface{}
+	if len(args) == 2 {
+		var err error
+		if path, err = parsePath(args[1]); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+	}
+
+	defer s.begin(c)()
+	if !s.exists(c, args[0]) {
+		c.WriteInt(0)
+		return
+	}
+	if len(path) == 0 {
+		s.call(c, "DEL", args[0])
+		c.WriteInt(1)
+		return
+	}
+	field, ok := path[len(path)-1].(string)
+	if !ok {
+		c.WriteError("ERR only fields can be deleted by this test server")
+		return
+	}
+	doc, err := s.load(c, args[0])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	parent, _ := getPath(doc, path[:len(path)-1])
+	fields, ok := parent.(map[string]interface{})
+	if !ok {
+		c.WriteInt(0)
+		return
+	}
+	if _, ok := fields[field]; !ok {
+		c.WriteInt(0)
+		return
+	}
+	delete(fields, field)
+	if err := s.store(c, args[0], doc); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	c.WriteInt(1)
+}
+
+// JSON.ARRAPPEND <key> <path> <json> [json ...]
+func (s *redisJSONServer) jsonArrAppend(c *server.Peer, cmd string, args []string) {
+	if len(args) < 3 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.ARRAPPEND' command")
+		return
+	}
+	path, err := parsePath(args[1])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	var values []interface{}
+	for _, arg := range args[2:] {
+		value, err := decodeJSON(arg)
+		if err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+		values = append(values, value)
+	}
+
+	defer s.begin(c)()
+	doc, array, ok := s.loadArray(c, args[0], path)
+	if !ok {
+		return
+	}
+	array = append(array, values...)
+	if doc, err = setPath(doc, path, array); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	if err := s.store(c, args[0], doc); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	c.WriteInt(len(array))
+}
+
+// JSON.ARRPOP <key> [path [index]], the index counts from the end when
+// it is negative and is clamped to the array, like RedisJSON does
+func (s *redisJSONServer) jsonArrPop(c *server.Peer, cmd string, args []string) {
+	if len(args) < 1 || len(args) > 3 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.ARRPOP' command")
+		return
+	}
+	var path []interface{}
+	index := -1
+	var err error
+	if len(args) >= 2 {
+		if path, err = parsePath(args[1]); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+	}
+	if len(args) == 3 {
+		if index, err = strconv.Atoi(args[2]); err != nil {
+			c.WriteError("ERR the index must be an integer")
+			return
+		}
+	}
+
+	defer s.begin(c)()
+	doc, array, ok := s.loadArray(c, args[0], path)
+	if !ok {
+		return
+	}
+	if len(array) == 0 {
+		c.WriteNull()
+		return
+	}
+	if index < 0 {
+		index += len(array)
+	}
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(array) {
+		index = len(array) - 1
+	}
+	popped := array[index]
+	array = append(array[:index:index], array[index+1:]...)
+	if doc, err = setPath(doc, path, array); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	if err := s.store(c, args[0], doc); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	data, _ := json.Marshal(popped)
+	c.WriteBulk(string(data))
+}
+
+// begin starts a command for c, it returns the function that ends it
+func (s *redisJSONServer) begin(c *server.Peer) func() {
+	if inScript(c) {
+		return func() {}
+	}
+	s.lock.Lock()
+	return s.lock.Unlock
+}
+
+// inScript returns true if c is a redis.call() from a Lua script.
+// miniredis keeps this in the unexported connection context of c, so
+// we have to peek at it.
+func inScript(c *server.Peer) bool {
+	ctx := reflect.ValueOf(c.Ctx)
+	if ctx.Kind() != reflect.Pointer || ctx.IsNil() {
+		return false
+	}
+	nested := ctx.Elem().FieldByName("nested")
+	return nested.IsValid() && nested.Bool()
+}
+
+// call runs a plain redis command for c, and returns the reply
+func (s *redisJSONServer) call(c *server.Peer, args ...string) (interface{}, error) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	peer := server.NewPeer(w)
+	peer.Ctx = c.Ctx
+	s.Server().Dispatch(peer, args)
+	w.Flush()
+	return server.ParseReply(bufio.NewReader(&buf))
+}
+
+func (s *redisJSONServer) exists(c *server.Peer, key string) bool {
+	reply, err := s.call(c, "EXISTS", key)
+	return err == nil && reply == 1
+}
+
+// load reads the document at key
+func (s *redisJSONServer) load(c *server.Peer, key string) (interface{}, error) {
+	reply, err := s.call(c, "GET", key)
+	data, ok := reply.(string)
+	if err != nil || !ok {
+		return nil, errors.New("ERR no such key")
+	}
+	return decodeJSON(data)
+}
+
+// loadArray reads the document at key and the array at path in it.  If
+// either is missing it answers c with an error and returns false.
+func (s *redisJSONServer) loadArray(c *server.Peer, key string, path []interface{}) (interface{}, []interface{}, bool) {
+	if !s.exists(c, key) {
+		c.WriteError("ERR could not perform this operation on a key that doesn't exist")
+		return nil, nil, false
+	}
+	doc, err := s.load(c, key)
+	if err != nil {
+		c.WriteError(err.Error())
+		return nil, nil, false
+	}
+	value, _ := getPath(doc, path)
+	array, ok := value.([]interface{})
+	if !ok {
+		c.WriteError("ERR path is not an array")
+		return nil, nil, false
+	}
+	return doc, array, true
+}
+
+// store writes doc back to key
+func (s *redisJSONServer) store(c *server.Peer, key string, doc interface{}) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(c, "SET", key, string(data))
+	return err
+}
+
+// decodeJSON decodes a json value, numbers are kept as they were sent
+func decodeJSON(data string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, errors.New("ERR invalid json")
+	}
+	return value, nil
+}
+
+// parsePath splits a path such as .steps[2].done into its parts, field
+// names are strings and array indexes are ints.  The root, . or $, has
+// no parts.
+func parsePath(path string) ([]interface{}, error) {
+	invalid := errors.New("ERR invalid path " + path)
+	rest := strings.TrimPrefix(path, "$")
+	if rest == "." {
+		rest = ""
+	}
+
+	var parts []interface{}
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, invalid
+			}
+			parts = append(parts, rest[1:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, invalid
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, invalid
+			}
+			parts = append(parts, index)
+			rest = rest[end+1:]
+		default:
+			return nil, invalid
+		}
+	}
+	return parts, nil
+}
+
+// getPath returns the value at path in doc, and false if it is not there
+func getPath(doc interface{}, path []interface{}) (interface{}, bool) {
+	for _, part := range path {
+		switch part := part.(type) {
+		case string:
+			fields, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = fields[part]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := doc.([]interface{})
+			if !ok || part >= len(array) {
+				return nil, false
+			}
+			doc = array[part]
+		}
+	}
+	return doc, true
+}
+
+// setPath sets the value at path in doc and returns the new document.
+// The parent of the value must already be there, and an array element
+// must already exist, this only replaces it.
+func setPath(doc interface{}, path []interface{}, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, ok := getPath(doc, path[:len(path)-1])
+	if !ok {
+		return nil, errors.New("ERR the parent of the path does not exist")
+	}
+	switch part := path[len(path)-1].(type) {
+	case string:
+		fields, ok := parent.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("ERR the parent of the path is not an object")
+		}
+		fields[part] = value
+	case int:
+		array, ok := parent.([]interface{})
+		if !ok || part >= len(array) {
+			return nil, errors.New("ERR array index out of range")
+		}
+		array[part] = value
+	}
+	return doc, nil
+}
@@ -0,0 +1,220 @@
+package tests
+
+//These tests drive the step routes through their http handlers, the
+//same way a client would, against the in process redis of
+//redisjson_test.go, so the Lua step scripts really run.
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter returns a router with the /todo and step routes of
+// main.go, backed by a new in process redis
+func newTestRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	s := newRedisJSONServer(t)
+	t.Setenv("REDIS_URL", s.Addr())
+	apiHandler, err := api.New()
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.POST("/todo", apiHandler.AddToDo)
+	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.GET("/todo/:id/steps", apiHandler.ListSteps)
+	r.POST("/todo/:id/steps", apiHandler.AddStep)
+	r.PUT("/todo/:id/steps/order", apiHandler.ReorderSteps)
+	r.GET("/todo/:id/steps/:step", apiHandler.GetStep)
+	r.PUT("/todo/:id/steps/:step", apiHandler.UpdateStep)
+	r.DELETE("/todo/:id/steps/:step", apiHandler.DeleteStep)
+	r.PUT("/todo/:id/steps/:step/done", apiHandler.ChangeStepDoneStatus)
+	r.DELETE("/todo/:id/steps/:step/done", apiHandler.ChangeStepDoneStatus)
+	return r
+}
+
+// doRequest sends a request to the router, body is sent as it is if it
+// is a string, and marshalled to json otherwise, unless it is nil
+func doRequest(r http.Handler, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	switch body := body.(type) {
+	case nil:
+	case string:
+		data = []byte(body)
+	default:
+		data, _ = json.Marshal(body)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// addTodo adds item and the steps with these descriptions to it
+func addTodo(t *testing.T, r http.Handler, item db.ToDoItem, steps ...string) {
+	res := doRequest(r, http.MethodPost, "/todo", item)
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	for _, description := range steps {
+		res = doRequest(r, http.MethodPost, "/todo/1/steps", db.Step{Description: description})
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	}
+}
+
+// stepNums returns the step numbers of the steps in a response, in order
+func stepNums(t *testing.T, res *httptest.ResponseRecorder) []int {
+	require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+	var steps []db.Step
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &steps))
+	nums := []int{}
+	for _, step := range steps {
+		nums = append(nums, step.StepNum)
+	}
+	return nums
+}
+
+func TestStepNumbering(t *testing.T) {
+	r := newTestRouter(t)
+
+	//The item is stored with "steps": null, the first step starts the
+	//array off
+	addTodo(t, r, db.ToDoItem{Id: 1, Title: "paint the fence"}, "buy paint", "sand", "paint")
+	assert.Equal(t, []int{1, 2, 3}, stepNums(t, doRequest(r, http.MethodGet, "/todo/1/steps", nil)))
+
+	//The step number in the body is ignored
+	res := doRequest(r, http.MethodPost, "/todo/1/steps", db.Step{StepNum: 9, Description: "clean up"})
+	require.Equal(t, http.StatusOK, res.Code)
+	var step db.Step
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &step))
+	assert.Equal(t, db.Step{StepNum: 4, Description: "clean up"}, step)
+
+	//Deleting a step leaves the other numbers alone, and its number is
+	//not handed out again while a higher one is still there
+	require.Equal(t, http.StatusOK, doRequest(r, http.MethodDelete, "/todo/1/steps/2", nil).Code)
+	res = doRequest(r, http.MethodPost, "/todo/1/steps", db.Step{Description: "admire"})
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, []int{1, 3, 4, 5}, stepNums(t, doRequest(r, http.MethodGet, "/todo/1/steps", nil)))
+
+	//Updating a step keeps its number and its place
+	res = doRequest(r, http.MethodPut, "/todo/1/steps/3", db.Step{StepNum: 7, Description: "sand twice"})
+	require.Equal(t, http.StatusOK, res.Code)
+	res = doRequest(r, http.MethodGet, "/todo/1/steps/3", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &step))
+	assert.Equal(t, db.Step{StepNum: 3, Description: "sand twice"}, step)
+	assert.Equal(t, []int{1, 3, 4, 5}, stepNums(t, doRequest(r, http.MethodGet, "/todo/1/steps", nil)))
+}
+
+func TestStepErrors(t *testing.T) {
+	r := newTestRouter(t)
+	addTodo(t, r, db.ToDoItem{Id: 1, Title: "paint the fence"}, "buy paint")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"list missing item", http.MethodGet, "/todo/42/steps", nil, http.StatusNotFound},
+		{"add to missing item", http.MethodPost, "/todo/42/steps", db.Step{Description: "x"}, http.StatusNotFound},
+		{"get missing item", http.MethodGet, "/todo/42/steps/1", nil, http.StatusNotFound},
+		{"reorder missing item", http.MethodPut, "/todo/42/steps/order", []int{1}, http.StatusNotFound},
+		{"get missing step", http.MethodGet, "/todo/1/steps/9", nil, http.StatusNotFound},
+		{"update missing step", http.MethodPut, "/todo/1/steps/9", db.Step{Description: "x"}, http.StatusNotFound},
+		{"complete missing step", http.MethodPut, "/todo/1/steps/9/done", nil, http.StatusNotFound},
+		{"delete missing step", http.MethodDelete, "/todo/1/steps/9", nil, http.StatusNotFound},
+		{"bad id", http.MethodGet, "/todo/one/steps", nil, http.StatusBadRequest},
+		{"bad step", http.MethodGet, "/todo/1/steps/one", nil, http.StatusBadRequest},
+		{"empty description", http.MethodPost, "/todo/1/steps", db.Step{Description: " "}, http.StatusBadRequest},
+		{"not json", http.MethodPost, "/todo/1/steps", "buy paint", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := doRequest(r, tt.method, tt.path, tt.body)
+			assert.Equal(t, tt.status, res.Code, res.Body.String())
+		})
+	}
+
+	//None of them changed the steps
+	assert.Equal(t, []int{1}, stepNums(t, doRequest(r, http.MethodGet, "/todo/1/steps", nil)))
+}
+
+func TestReorderSteps(t *testing.T) {
+	r := newTestRouter(t)
+	addTodo(t, r, db.ToDoItem{Id: 1, Title: "paint the fence"}, "buy paint", "sand", "paint")
+
+	res := doRequest(r, http.MethodPut, "/todo/1/steps/order", []int{3, 1, 2})
+	assert.Equal(t, []int{3, 1, 2}, stepNums(t, res))
+	assert.Equal(t, []int{3, 1, 2}, stepNums(t, doRequest(r, http.MethodGet, "/todo/1/steps", nil)))
+
+	//The order must name every step exactly once
+	for _, body := range []string{`[1, 2]`, `[1, 2, 3, 3]`, `[1, 1, 2]`, `[1, 2, 9]`, `[]`, `{"order": [1, 2, 3]}`} {
+		t.Run(body, func(t *testing.T) {
+			res := doRequest(r, http.MethodPut, "/todo/1/steps/order", body)
+			assert.Equal(t, http.StatusBadRequest, res.Code, res.Body.String())
+		})
+	}
+	assert.Equal(t, []int{3, 1, 2}, stepNums(t, doRequest(r, http.MethodGet, "/todo/1/steps", nil)))
+
+	//An item without steps has nothing to reorder
+	res = doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Id: 2, Title: "no steps"})
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, []int{}, stepNums(t, doRequest(r, http.MethodPut, "/todo/2/steps/order", []int{})))
+}
+
+func TestAutoComplete(t *testing.T) {
+	r := newTestRouter(t)
+	addTodo(t, r, db.ToDoItem{Id: 1, Title: "paint the fence", AutoComplete: true}, "buy paint", "paint")
+
+	getItem := func(res *httptest.ResponseRecorder) db.ToDoItem {
+		require.Equal(t, http.StatusOK, res.Code, res.Body.String())
+		var item db.ToDoItem
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &item))
+		return item
+	}
+
+	//Completing a step returns the whole item, it is not done until the
+	//last step is
+	item := getItem(doRequest(r, http.MethodPut, "/todo/1/steps/1/done", nil))
+	assert.True(t, item.Steps[0].IsDone)
+	assert.False(t, item.IsDone)
+	assert.Nil(t, item.CompletedAt)
+
+	item = getItem(doRequest(r, http.MethodPut, "/todo/1/steps/2/done", nil))
+	assert.True(t, item.IsDone)
+	require.NotNil(t, item.CompletedAt)
+	assert.Equal(t, item.UpdatedAt, item.CompletedAt)
+
+	//It was stored that way, not just returned
+	stored := getItem(doRequest(r, http.MethodGet, "/todo/1", nil))
+	assert.True(t, stored.IsDone)
+	assert.Equal(t, item.CompletedAt, stored.CompletedAt)
+
+	//Un-completing a step leaves the item done
+	item = getItem(doRequest(r, http.MethodDelete, "/todo/1/steps/2/done", nil))
+	assert.False(t, item.Steps[1].IsDone)
+	assert.True(t, item.IsDone)
+}
+
+func TestNoAutoComplete(t *testing.T) {
+	r := newTestRouter(t)
+	addTodo(t, r, db.ToDoItem{Id: 1, Title: "paint the fence"}, "buy paint")
+
+	res := doRequest(r, http.MethodPut, "/todo/1/steps/1/done", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var item db.ToDoItem
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &item))
+	assert.True(t, item.Steps[0].IsDone)
+	assert.False(t, item.IsDone)
+}
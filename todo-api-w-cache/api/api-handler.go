@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -36,15 +38,33 @@ func New() (*ToDoAPI, error) {
 //	  done using the c.AbortWithStatus() function
 
 // implementation for GET /todo
-// returns all todos
+// returns all todos, a page at a time if the caller asks for it.  The
+// limit query parameter sets the page size and the cursor parameter
+// picks up where the previous page stopped, for example
+// /todo?limit=10&cursor=<cursor>.  With count=true the X-Total-Count
+// header holds the number of todos in the whole list, and while there
+// are more todos the Link header points at the next page.
 func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 
-	todoList, err := td.db.GetAllItems()
+	cursor, limit, count, err := pageParams(c)
 	if err != nil {
+		log.Println("Error reading paging parameters: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := td.db.GetItemsPage(cursor, limit)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			log.Println("Error reading cursor: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Println("Error Getting All Items: ", err)
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	todoList := page.Items
 	//Note that the database returns a nil slice if there are no items
 	//in the database.  We need to convert this to an empty slice
 	//so that the JSON marshalling works correctly.  We want to return
@@ -53,68 +73,70 @@ func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 		todoList = make([]db.ToDoItem, 0)
 	}
 
+	//Counting can mean walking every item, so only do it when the
+	//caller asks
+	if count {
+		total, err := td.db.CountItems()
+		if err != nil {
+			log.Println("Error counting items: ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		setTotalCountHeader(c, total)
+	}
+
+	setPageHeaders(c, page.NextCursor)
 	c.JSON(http.StatusOK, todoList)
 }
 
 // implementation for GET /v2/todo
-// returns todos that are either done or not done
-// depending on the value of the done query parameter
-// for example, /v2/todo?done=true will return all
-// todos that are done.  Note you can have multiple
-// query parameters, for example /v2/todo?done=true&foo=bar
+// returns the todos that match a query.  The q query parameter holds a
+// filter, for example /v2/todo?q=done:false tag:work due<2026-11-01
+// (remember to url encode it), see db.Query for the syntax.  The sort
+// and order query parameters choose the order of the results, for
+// example /v2/todo?q=tag:work&sort=due&order=desc.  The done=true|false
+// parameter from the first version of this endpoint still works, it is
+// simply added to the filter.
 func (td *ToDoAPI) ListSelectTodos(c *gin.Context) {
-	//lets first load the data
-	todoList, err := td.db.GetAllItems()
-	if err != nil {
-		log.Println("Error Getting Database Items: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
-		return
-	}
-	//If the database is empty, make an empty slice so that the
-	//JSON marshalling works correctly
-	if todoList == nil {
-		todoList = make([]db.ToDoItem, 0)
-	}
+	filter := c.Query("q")
 
 	//Note that the query parameter is a string, so we
 	//need to convert it to a bool
-	doneS := c.Query("done")
-
-	//if the doneS is empty, then we will return all items
-	if doneS == "" {
-		c.JSON(http.StatusOK, todoList)
-		return
+	if doneS := c.Query("done"); doneS != "" {
+		done, err := strconv.ParseBool(doneS)
+		if err != nil {
+			log.Println("Error converting done to bool: ", err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		filter += fmt.Sprintf(" done:%t", done)
 	}
 
-	//Now we can handle the case where doneS is not empty
-	//and we need to filter the list based on the doneS value
-
-	done, err := strconv.ParseBool(doneS)
+	//A bad query is the caller's mistake, so we tell them what
+	//is wrong with it
+	query, err := db.NewQuery(filter, c.Query("sort"), c.Query("order"))
 	if err != nil {
-		log.Println("Error converting done to bool: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		log.Println("Error parsing query: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	//Now we need to filter the list based on the done value
-	//that was passed in.  We will create a new slice and
-	//only add items that match the done value
-	var filteredList []db.ToDoItem
-	for _, item := range todoList {
-		if item.IsDone == done {
-			filteredList = append(filteredList, item)
-		}
+	todoList, err := td.db.QueryItems(query)
+	if err != nil {
+		log.Println("Error Getting Database Items: ", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
 
 	//Note that the database returns a nil slice if there are no items
 	//in the database.  We need to convert this to an empty slice
 	//so that the JSON marshalling works correctly.  We want to return
 	//an empty slice, not a nil slice. This will result in the json being []
-	if filteredList == nil {
-		filteredList = make([]db.ToDoItem, 0)
+	if todoList == nil {
+		todoList = make([]db.ToDoItem, 0)
 	}
 
-	c.JSON(http.StatusOK, filteredList)
+	c.JSON(http.StatusOK, todoList)
 }
 
 // implementation for GET /todo/:id
@@ -147,7 +169,8 @@ func (td *ToDoAPI) GetToDo(c *gin.Context) {
 }
 
 // implementation for POST /todo
-// adds a new todo
+// adds a new todo, the id can be left out of the body and the
+// database will assign one, the response holds the new item
 func (td *ToDoAPI) AddToDo(c *gin.Context) {
 	var todoItem db.ToDoItem
 
@@ -168,7 +191,19 @@ func (td *ToDoAPI) AddToDo(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.AddItem(todoItem); err != nil {
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	//The database fills in the id, when the item does not have one,
+	//and the timestamps, so send back the item as it was stored rather
+	//than the item we were sent
+	todoItem, err := td.db.AddItem(todoItem)
+	if err != nil {
 		log.Println("Error adding item: ", err)
 		c.AbortWithStatus(http.StatusConflict)
 		return
@@ -187,12 +222,89 @@ func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
 		return
 	}
 
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
 	if err := td.db.UpdateItem(todoItem); err != nil {
 		log.Println("Error updating item: ", err)
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
+	//The database sets the timestamps, so send back the item as it
+	//was stored rather than the item we were sent
+	todoItem, err := td.db.GetItem(todoItem.Id)
+	if err != nil {
+		log.Println("Error reading back item: ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, todoItem)
+}
+
+// implementation for PATCH /todo/:id
+// changes part of a todo, the body is a patch document and the
+// Content-Type header says which kind:
+//
+//	application/merge-patch+json   {"done": true, "due": null}
+//	application/json-patch+json    [{"op": "add", "path": "/tags/-", "value": "work"}]
+//
+// A plain application/json body is read as a merge patch.  The fields
+// the patch does not mention are left as they are, so unlike PUT the
+// client does not have to send the whole todo.  The response holds the
+// patched todo.
+func (td *ToDoAPI) PatchToDo(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	patchType := c.ContentType()
+	if patchType == "application/json" {
+		patchType = db.MergePatchType
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		log.Println("Error reading body: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	patch, err := db.NewPatch(patchType, body)
+	if err != nil {
+		log.Println("Error reading patch: ", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, db.ErrUnsupportedPatch) {
+			status = http.StatusUnsupportedMediaType
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	todoItem, err := td.db.PatchItem(int(id64), patch)
+	if err != nil {
+		log.Println("Error patching item: ", err)
+		switch {
+		case errors.Is(err, db.ErrInvalidPatch):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case err.Error() == "item does not exist":
+			c.AbortWithStatus(http.StatusNotFound)
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+
 	c.JSON(http.StatusOK, todoItem)
 }
 
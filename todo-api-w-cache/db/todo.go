@@ -1,28 +1,29 @@
 package db
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"sort"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/nitishm/go-rejson/v4"
+	"github.com/nitishm/go-rejson/v4/rjs"
 )
 
-// ToDoItem is the struct that represents a single ToDo item
-type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
-}
-
 const (
 	RedisNilError        = "redis: nil"
 	RedisDefaultLocation = "0.0.0.0:6379"
 	RedisKeyPrefix       = "todo:"
+
+	//RedisNextIdKey holds the last id handed out by AddItem.  It is
+	//deliberately outside of the todo: prefix, so it is never mistaken
+	//for a todo item
+	RedisNextIdKey = "todo-next-id"
 )
 
 type cache struct {
@@ -135,16 +136,148 @@ func (t *ToDo) getItemFromRedis(key string, item *ToDoItem) error {
 	return nil
 }
 
+// Helper to store an item that must not exist yet, it returns an
+// "item already exists" error if the key is taken
+func (t *ToDo) setNewItemInRedis(item ToDoItem) error {
+	res, err := t.jsonHelper.JSONSet(redisKeyFromId(item.Id), ".", item, rjs.SetOptionNX)
+	if err != nil {
+		return err
+	}
+	//With NX, redis answers nil instead of OK when the key already
+	//exists.  The rejson helper turns that nil into a nil result, not
+	//an error, so this is how we find out
+	if res == nil {
+		return errors.New("item already exists")
+	}
+	return nil
+}
+
+//------------------------------------------------------------
+// REDIS FIELD UPDATE HELPERS
+//------------------------------------------------------------
+
+// RedisJSON can change part of a document in place, JSON.SET with a
+// path such as .done only replaces that field, and JSON.ARRAPPEND adds
+// to the end of an array without sending the array again.  So a patch
+// that flips done, or adds a tag, does not rewrite the whole item.
+
+// fieldChange is a single change to a top level field of an item
+type fieldChange struct {
+	path string
+	//op is JSON.SET, JSON.DEL or JSON.ARRAPPEND
+	op     string
+	values []json.RawMessage
+}
+
+// changedFields compares the json of two items, field by field, and
+// returns the changes that turn old into item
+func changedFields(old ToDoItem, item ToDoItem) ([]fieldChange, error) {
+	oldFields, err := jsonFields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := jsonFields(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []fieldChange
+	for name, value := range newFields {
+		oldValue, ok := oldFields[name]
+		if ok && bytes.Equal(oldValue, value) {
+			continue
+		}
+		path := "." + name
+		if added, ok := appendedElements(oldValue, value); ok {
+			changes = append(changes, fieldChange{path: path, op: "JSON.ARRAPPEND", values: added})
+			continue
+		}
+		changes = append(changes, fieldChange{path: path, op: "JSON.SET", values: []json.RawMessage{value}})
+	}
+	//Fields marked omitempty disappear from the json when they are
+	//cleared, so they have to be removed from the document
+	for name := range oldFields {
+		if _, ok := newFields[name]; !ok {
+			changes = append(changes, fieldChange{path: "." + name, op: "JSON.DEL"})
+		}
+	}
+
+	//Maps have no order, sorting keeps the commands the same every time
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path < changes[j].path
+	})
+	return changes, nil
+}
+
+// jsonFields returns the top level fields of the json of an item
+func jsonFields(item ToDoItem) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// appendedElements returns the elements added to the end of an array, if
+// the new array is the old array plus some more elements.  An old value
+// that is missing or null is not an array redis can append to.
+func appendedElements(old json.RawMessage, value json.RawMessage) ([]json.RawMessage, bool) {
+	var oldArray, newArray []json.RawMessage
+	if json.Unmarshal(old, &oldArray) != nil || oldArray == nil {
+		return nil, false
+	}
+	if json.Unmarshal(value, &newArray) != nil || len(newArray) <= len(oldArray) {
+		return nil, false
+	}
+	for i := range oldArray {
+		if !bytes.Equal(oldArray[i], newArray[i]) {
+			return nil, false
+		}
+	}
+	return newArray[len(oldArray):], true
+}
+
+// setChangedFields writes the fields that differ between old and item to
+// the document at key.  The commands are sent in one pipeline, so it is a
+// single round trip to redis however many fields changed.
+func (t *ToDo) setChangedFields(key string, old ToDoItem, item ToDoItem) error {
+	changes, err := changedFields(old, item)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	_, err = t.cacheClient.Pipelined(t.context, func(pipe redis.Pipeliner) error {
+		for _, change := range changes {
+			args := []interface{}{change.op, key, change.path}
+			for _, value := range change.values {
+				args = append(args, string(value))
+			}
+			pipe.Do(t.context, args...)
+		}
+		return nil
+	})
+	return err
+}
+
 //------------------------------------------------------------
 // THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
 //------------------------------------------------------------
 
-// AddItem accepts a ToDoItem and adds it to the DB.
+// AddItem accepts a ToDoItem and adds it to the DB.  An item without an
+// id (an Id of 0) is given the next free id.  The stored item, with its
+// id and timestamps, is returned.
 // Preconditions:   (1) The database file must exist and be a valid
 //
-//					(2) The item must not already exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
+//					(2) If the item has an id, it must not already exist
+//	    				in the DB because we use the item.Id as the key,
+//						this function must check if the item already
 //	    				exists in the DB, if so, return an error
 //
 // Postconditions:
@@ -152,23 +285,46 @@ func (t *ToDo) getItemFromRedis(key string, item *ToDoItem) error {
 //	 (1) The item will be added to the DB
 //		(2) The DB file will be saved with the item added
 //		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
-
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(item.Id)
-	var existingItem ToDoItem
-	if err := t.getItemFromRedis(redisKey, &existingItem); err == nil {
-		return errors.New("item already exists")
+func (t *ToDo) AddItem(item ToDoItem) (ToDoItem, error) {
+	if item.Id < 0 {
+		return ToDoItem{}, errors.New("item id cannot be negative")
+	}
+	if err := item.Validate(); err != nil {
+		return ToDoItem{}, err
 	}
 
-	//Add item to database with JSON Set
-	if _, err := t.jsonHelper.JSONSet(redisKey, ".", item); err != nil {
-		return err
+	//The database owns the timestamps, so set them before we
+	//store the item
+	stampNewItem(&item)
+
+	//An item with its own id is stored under that id.  The NX option
+	//tells redis to only set the key if it does not exist yet, so the
+	//check and the write are a single atomic step
+	if item.Id != 0 {
+		if err := t.setNewItemInRedis(item); err != nil {
+			return ToDoItem{}, err
+		}
+		return item, nil
 	}
 
-	//If everything is ok, return nil for the error
-	return nil
+	//Otherwise we ask redis for the next id.  INCR is atomic, so two
+	//API instances sharing this redis never get the same number.  An
+	//id can still be taken by an item that was added with its own id,
+	//in that case we simply move on to the next number.
+	for {
+		nextId, err := t.cacheClient.Incr(t.context, RedisNextIdKey).Result()
+		if err != nil {
+			return ToDoItem{}, err
+		}
+		item.Id = int(nextId)
+		err = t.setNewItemInRedis(item)
+		if err == nil {
+			return item, nil
+		}
+		if err.Error() != "item already exists" {
+			return ToDoItem{}, err
+		}
+	}
 }
 
 // DeleteItem accepts an item id and removes it from the DB.
@@ -203,7 +359,14 @@ func (t *ToDo) DeleteItem(id int) error {
 func (t *ToDo) DeleteAll() error {
 
 	pattern := RedisKeyPrefix + "*"
-	ks, _ := t.cacheClient.Keys(t.context, pattern).Result()
+	ks, err := t.scanKeys(pattern)
+	if err != nil {
+		return err
+	}
+	//DEL needs at least one key
+	if len(ks) == 0 {
+		return nil
+	}
 	//Note delete can take a collection of keys.  In go we can
 	//expand a slice into individual arguments by using the ...
 	//operator
@@ -241,6 +404,12 @@ func (t *ToDo) UpdateItem(item ToDoItem) error {
 	if err := t.getItemFromRedis(redisKey, &existingItem); err != nil {
 		return errors.New("item does not exist")
 	}
+	if err := item.Validate(); err != nil {
+		return err
+	}
+
+	//Keep the timestamps that belong to the existing item
+	stampUpdatedItem(existingItem, &item)
 
 	//Add item to database with JSON Set.  Note there is no update
 	//functionality, so we just overwrite the existing item
@@ -298,13 +467,53 @@ func (t *ToDo) GetItem(id int) (ToDoItem, error) {
 //	 (1) The items status in the database will be updated
 //		(2) If there is an error, it will be returned.
 //		(3) This function MUST use existing functionality for most of its
-//			work.  For example, it should call GetItem() to get the item
-//			from the DB, then it should call UpdateItem() to update the
-//			item in the DB (after the status is changed).
+//			work.  It is a patch of the done flag, so PatchItem() does
+//			the work, and only the done flag and the timestamps are
+//			written to redis, not the whole item.
 func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
+	_, err := t.PatchItem(id, donePatch(value))
+	return err
+}
 
-	//update was successful
-	return errors.New("not implemented")
+// PatchItem accepts an item id and a patch, and applies the patch to the
+// item in the DB.  Only the fields the patch changes are written back,
+// see setChangedFields.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be updated in the DB, and returned
+//		(2) If the patch cannot be applied, an ErrInvalidPatch error
+//			will be returned and the item is left alone
+//		(3) If there is an error, it will be returned
+func (t *ToDo) PatchItem(id int, patch Patch) (ToDoItem, error) {
+
+	redisKey := redisKeyFromId(id)
+	var existingItem ToDoItem
+	if err := t.getItemFromRedis(redisKey, &existingItem); err != nil {
+		if isRedisNilError(err) {
+			return ToDoItem{}, errors.New("item does not exist")
+		}
+		return ToDoItem{}, err
+	}
+
+	//Redis cannot apply a json patch for us, so we apply it to our
+	//copy of the item, and then send redis just the fields that changed
+	item, err := patch.Apply(existingItem)
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	stampUpdatedItem(existingItem, &item)
+
+	if err := t.setChangedFields(redisKey, existingItem, item); err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
 }
 
 // GetAllItems returns all items from the DB.  If successful it
@@ -321,14 +530,24 @@ func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 
 	//Now that we have the DB loaded, lets crate a slice
 	var toDoList []ToDoItem
-	var toDoItem ToDoItem
 
 	//Lets query redis for all of the items
 	pattern := RedisKeyPrefix + "*"
-	ks, _ := t.cacheClient.Keys(t.context, pattern).Result()
+	ks, err := t.scanKeys(pattern)
+	if err != nil {
+		return nil, err
+	}
 	for _, key := range ks {
+		//Use a fresh item for every key, json.Unmarshal leaves fields
+		//that are missing from the json alone, so reusing one item
+		//would leak tags or dates from the previous item into this one
+		var toDoItem ToDoItem
 		err := t.getItemFromRedis(key, &toDoItem)
 		if err != nil {
+			//The item was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
 			return nil, err
 		}
 		toDoList = append(toDoList, toDoItem)
@@ -337,6 +556,81 @@ func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 	return toDoList, nil
 }
 
+// GetItemsPage returns one page of the items in the DB.  An empty
+// cursor starts at the first item, after that the cursor is the
+// NextCursor of the page before.  A limit of 0 means no limit, so the
+// page holds every item that is left.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The cursor must be empty or come from an
+//	    				earlier page, if not, return ErrInvalidCursor
+//
+// Postconditions:
+//
+//	 (1) Up to limit items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty page
+//		(3) The database file will not be modified
+func (t *ToDo) GetItemsPage(cursor string, limit int) (ItemPage, error) {
+
+	//Redis hands out the keys in its own order, which is not the
+	//order of the ids, see scanPage for how the cursor works
+	pattern := RedisKeyPrefix + "*"
+	keys, nextCursor, err := t.scanPage(pattern, cursor, limit)
+	if err != nil {
+		return ItemPage{}, err
+	}
+
+	page := ItemPage{
+		Items:      make([]ToDoItem, 0, len(keys)),
+		NextCursor: nextCursor,
+	}
+	for _, key := range keys {
+		var toDoItem ToDoItem
+		err := t.getItemFromRedis(key, &toDoItem)
+		if err != nil {
+			//The item was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
+			return ItemPage{}, err
+		}
+		page.Items = append(page.Items, toDoItem)
+	}
+
+	return page, nil
+}
+
+// CountItems returns the number of items in the DB.  Counting means
+// scanning every key, SCAN never blocks redis the way KEYS does but it
+// still walks the whole keyspace, so the api only counts when the
+// caller asks for it.
+func (t *ToDo) CountItems() (int, error) {
+	return t.countKeys(RedisKeyPrefix + "*")
+}
+
+// QueryItems returns the items that match the query, sorted the way the
+// query asks for, see Query for the filter syntax.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The matching items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *ToDo) QueryItems(q Query) ([]ToDoItem, error) {
+
+	//Redis cannot run our query for us, so we load every item and
+	//run the query on this side, exactly the way the other stores do
+	toDoList, err := t.GetAllItems()
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Apply(toDoList), nil
+}
+
 // PrintItem accepts a ToDoItem and prints it to the console
 // in a JSON pretty format. As some help, look at the
 // json.MarshalIndent() function from our in class go tutorial.
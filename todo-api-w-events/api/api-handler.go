@@ -1,9 +1,12 @@
 package api
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"sync/atomic"
+	"time"
 
 	"drexel.edu/todo-events/db"
 	"drexel.edu/todo-events/events"
@@ -13,8 +16,14 @@ import (
 // The api package creates and maintains a reference to the data handler
 // this is a good design practice
 type ToDoAPI struct {
-	db           *db.ToDo
-	eventHandler *events.ToDoEventManager
+	db *db.ToDo
+	//eventHandler is nil until eventing is set up, it is read by every
+	//request, so it is swapped atomically, use Notify to send an event
+	eventHandler atomic.Pointer[events.ToDoEventManager]
+	//feed sends the changes to the clients of /todo/events
+	feed *events.Feed
+	//webhooks sends the changes to the urls registered with /webhooks
+	webhooks *events.WebhookDispatcher
 }
 
 func New() (*ToDoAPI, error) {
@@ -26,27 +35,83 @@ func New() (*ToDoAPI, error) {
 
 	//By default we will not be doing eventing
 	return &ToDoAPI{
-		db:           dbHandler,
-		eventHandler: nil,
+		db: dbHandler,
 	}, nil
 }
 
+// NewWithEventLog is New in event sourcing mode, the todos are kept in
+// the event log in dir, see db.NewWithEventLog
+func NewWithEventLog(dir string) (*ToDoAPI, error) {
+	dbHandler, err := db.NewWithEventLog(dir, db.DefaultSnapshotEvery)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ToDoAPI{
+		db: dbHandler,
+	}, nil
+}
+
+// AddEventListener creates the event manager with a subscriber that logs
+// every event, the feed of /todo/events and the webhooks, and starts it
 func (td *ToDoAPI) AddEventListener() {
-	td.eventHandler = events.NewToDoEventManager()
-	td.eventHandler.Start()
+	eventHandler := events.NewToDoEventManager()
+	if err := eventHandler.Subscribe("log", events.LogHandlers(), events.SubscribeOptions{}); err != nil {
+		log.Println("Error subscribing the event logger: ", err)
+	}
+	td.feed = events.NewFeed(events.DefaultFeedReplay)
+	//The feed is quick, a big queue means it practically never drops
+	//an event that a client could want to replay
+	err := eventHandler.Subscribe("feed", td.feed.Handlers(), events.SubscribeOptions{BufferSize: 1024})
+	if err != nil {
+		log.Println("Error subscribing the feed: ", err)
+	}
+	td.webhooks = events.NewWebhookDispatcher()
+	//The dispatcher sends and retries in the background, so it is quick
+	//too
+	err = eventHandler.Subscribe("webhooks", td.webhooks.Handlers(), events.SubscribeOptions{BufferSize: 1024})
+	if err != nil {
+		log.Println("Error subscribing the webhooks: ", err)
+	}
+	eventHandler.Start()
+	td.eventHandler.Store(eventHandler)
+}
+
+// ErrNoEventing is returned when eventing is needed but was never set
+// up, see AddEventListener
+var ErrNoEventing = errors.New("eventing is not set up")
+
+// Subscribe adds a subscriber to the event manager, see
+// events.ToDoEventManager.Subscribe
+func (td *ToDoAPI) Subscribe(name string, handlers events.Handlers, options events.SubscribeOptions) error {
+	eventHandler := td.eventHandler.Load()
+	if eventHandler == nil {
+		return ErrNoEventing
+	}
+	return eventHandler.Subscribe(name, handlers, options)
+}
+
+// SetWebhookRetryPolicy changes how webhook deliveries are retried
+func (td *ToDoAPI) SetWebhookRetryPolicy(policy events.RetryPolicy) {
+	td.webhooks.SetRetryPolicy(policy)
 }
 
 func (td *ToDoAPI) ConnectEventListener(eventManager *events.ToDoEventManager) {
-	td.eventHandler = eventManager
+	td.eventHandler.Store(eventManager)
 }
 
 func (td *ToDoAPI) StopEventListener() {
-	td.eventHandler.Stop()
+	if eventHandler := td.eventHandler.Load(); eventHandler != nil {
+		eventHandler.Stop()
+	}
 }
 
+// Notify sends event to the event manager, if there is one.  The
+// handlers always send their events through here, so a request works
+// the same whether eventing is set up, stopped or running.
 func (td *ToDoAPI) Notify(event *events.ToDoEvent) {
-	if td.eventHandler == nil {
-		td.eventHandler.Notify(event)
+	if eventHandler := td.eventHandler.Load(); eventHandler != nil {
+		eventHandler.Notify(event)
 	}
 }
 
@@ -62,15 +127,33 @@ func (td *ToDoAPI) Notify(event *events.ToDoEvent) {
 //	  done using the c.AbortWithStatus() function
 
 // implementation for GET /todo
-// returns all todos
+// returns all todos, a page at a time if the caller asks for it.  The
+// limit query parameter sets the page size and the cursor parameter
+// picks up where the previous page stopped, for example
+// /todo?limit=10&cursor=<cursor>.  With count=true the X-Total-Count
+// header holds the number of todos in the whole list, and while there
+// are more todos the Link header points at the next page.
 func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 
-	todoList, err := td.db.GetAllItems()
+	cursor, limit, count, err := pageParams(c)
 	if err != nil {
+		log.Println("Error reading paging parameters: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := td.db.GetItemsPage(cursor, limit)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			log.Println("Error reading cursor: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Println("Error Getting All Items: ", err)
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	todoList := page.Items
 	//Note that the database returns a nil slice if there are no items
 	//in the database.  We need to convert this to an empty slice
 	//so that the JSON marshalling works correctly.  We want to return
@@ -80,8 +163,21 @@ func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 	}
 
 	evnt := events.NewEvent(events.ToDoQueryEvent, "todoList", todoList)
-	td.eventHandler.Notify(evnt)
+	td.Notify(evnt)
+
+	//Counting can mean walking every item, so only do it when the
+	//caller asks
+	if count {
+		total, err := td.db.CountItems()
+		if err != nil {
+			log.Println("Error counting items: ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		setTotalCountHeader(c, total)
+	}
 
+	setPageHeaders(c, page.NextCursor)
 	c.JSON(http.StatusOK, todoList)
 }
 
@@ -171,14 +267,74 @@ func (td *ToDoAPI) GetToDo(c *gin.Context) {
 	}
 
 	evnt := events.NewEvent(events.ToDoQueryEvent, "todoItem", todoItem)
-	td.eventHandler.Notify(evnt)
+	td.Notify(evnt)
 	//Git will automatically convert the struct to JSON
 	//and set the content-type header to application/json
 	c.JSON(http.StatusOK, todoItem)
 }
 
+// EventSeqHeader holds the sequence number of the event in the event
+// log that last changed an item, see GetToDoAsOf
+const EventSeqHeader = "X-Event-Seq"
+
+// implementation for GET /todo/:id/as-of
+// returns a todo as it was at a point in the past, given either as a
+// time, /todo/7/as-of?at=2023-11-02T14:03:11Z, or as the sequence number
+// of an event in the event log, /todo/7/as-of?seq=12.  This only works
+// when the api keeps an event log, see NewWithEventLog.
+func (td *ToDoAPI) GetToDoAsOf(c *gin.Context) {
+	id64, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	atS, seqS := c.Query("at"), c.Query("seq")
+	var todoItem db.ToDoItem
+	var changedAt uint64
+	switch {
+	case (atS == "") == (seqS == ""):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "give either at or seq"})
+		return
+	case atS != "":
+		var at time.Time
+		at, err = time.Parse(time.RFC3339Nano, atS)
+		if err != nil {
+			log.Println("Error converting at to a time: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "at must be an RFC 3339 time"})
+			return
+		}
+		todoItem, changedAt, err = td.db.ItemAtTime(int(id64), at)
+	default:
+		var seq uint64
+		seq, err = strconv.ParseUint(seqS, 10, 64)
+		if err != nil {
+			log.Println("Error converting seq to uint64: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "seq must be a positive number"})
+			return
+		}
+		todoItem, changedAt, err = td.db.ItemAtSeq(int(id64), seq)
+	}
+
+	if errors.Is(err, db.ErrNoEventLog) {
+		log.Println("Error getting item history: ", err)
+		c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Println("Item not found: ", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	c.Header(EventSeqHeader, strconv.FormatUint(changedAt, 10))
+	c.JSON(http.StatusOK, todoItem)
+}
+
 // implementation for POST /todo
-// adds a new todo
+// adds a new todo, the id can be left out of the body and the
+// database will assign one, the response holds the new item
 func (td *ToDoAPI) AddToDo(c *gin.Context) {
 	var todoItem db.ToDoItem
 
@@ -199,13 +355,27 @@ func (td *ToDoAPI) AddToDo(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.AddItem(todoItem); err != nil {
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	//The database fills in the id, when the item does not have one,
+	//and the timestamps, so send back the item as it was stored rather
+	//than the item we were sent
+	todoItem, err := td.db.AddItem(todoItem)
+	if err != nil {
 		log.Println("Error adding item: ", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
-	evnt := events.NewEvent(events.ToDoAddEvent, "todoItem", todoItem)
-	td.eventHandler.Notify(evnt)
+
+	evnt := events.NewChangeEvent(events.ToDoAddEvent, "todoItem", todoItem,
+		requestID(c), nil, todoItem)
+	td.Notify(evnt)
 
 	c.JSON(http.StatusOK, todoItem)
 }
@@ -220,15 +390,28 @@ func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.UpdateItem(todoItem); err != nil {
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	//The events carry the item as it was before the update and as it
+	//was stored, the database hands back both, read under the same
+	//lock as the update, so another request cannot get in between
+	change, err := td.db.UpdateItem(todoItem)
+	if err != nil {
 		log.Println("Error updating item: ", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	evnt := events.NewEvent(events.ToDoUpdateEvent, "todoItem", todoItem)
-	td.eventHandler.Notify(evnt)
-	c.JSON(http.StatusOK, todoItem)
+	evnt := events.NewChangeEvent(events.ToDoUpdateEvent, "todoItem", change.After,
+		requestID(c), change.Before, change.After)
+	td.Notify(evnt)
+	c.JSON(http.StatusOK, change.After)
 }
 
 // implementation for DELETE /todo/:id
@@ -237,14 +420,21 @@ func (td *ToDoAPI) DeleteToDo(c *gin.Context) {
 	idS := c.Param("id")
 	id64, _ := strconv.ParseInt(idS, 10, 32)
 
-	if err := td.db.DeleteItem(int(id64)); err != nil {
+	//The event carries the item that was deleted.  Deleting an item
+	//that is not there changes nothing, so there is no event for it,
+	//and of two deletes of the same item only one finds it.
+	before, found, err := td.db.DeleteItem(int(id64))
+	if err != nil {
 		log.Println("Error deleting item: ", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	evnt := events.NewEvent(events.ToDoDeleteEvent, "id", id64)
-	td.eventHandler.Notify(evnt)
+	if found {
+		evnt := events.NewChangeEvent(events.ToDoDeleteEvent, "id", id64,
+			requestID(c), before, nil)
+		td.Notify(evnt)
+	}
 
 	c.Status(http.StatusOK)
 }
@@ -253,14 +443,21 @@ func (td *ToDoAPI) DeleteToDo(c *gin.Context) {
 // deletes all todos
 func (td *ToDoAPI) DeleteAllToDo(c *gin.Context) {
 
-	if err := td.db.DeleteAll(); err != nil {
+	//Every item that is deleted gets its own delete event, so a
+	//subscriber does not have to know what "all" was.  The database
+	//returns the items it deleted, in the same step as the delete.
+	todoList, err := td.db.DeleteAll()
+	if err != nil {
 		log.Println("Error deleting all items: ", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	evnt := events.NewEvent(events.ToDoDeleteEvent, "id", "all")
-	td.eventHandler.Notify(evnt)
+	for _, item := range todoList {
+		evnt := events.NewChangeEvent(events.ToDoDeleteEvent, "id", item.Id,
+			requestID(c), item, nil)
+		td.Notify(evnt)
+	}
 
 	c.Status(http.StatusOK)
 }
@@ -290,9 +487,14 @@ func (td *ToDoAPI) HealthCheck(c *gin.Context) {
 		})
 }
 
-// implementation for GET /events/:enableFlag
-// Controls if eventing is enabled or disabled
+// implementation for GET /event/:enableFlag
+// Controls if eventing is enabled or disabled, see also
+// UpdateEventConfig
 func (td *ToDoAPI) EventEnabler(c *gin.Context) {
+	eventHandler, ok := td.eventingReady(c)
+	if !ok {
+		return
+	}
 
 	//Note go is minimalistic, so we have to get the
 	//id parameter using the Param() function, and then
@@ -310,11 +512,11 @@ func (td *ToDoAPI) EventEnabler(c *gin.Context) {
 	if eFlag {
 		//Enable Eventing
 		log.Println("Enabling Eventing")
-		td.eventHandler.Start()
+		eventHandler.Start()
 	} else {
 		//Disable Eventing
 		log.Println("Disabling Eventing")
-		td.eventHandler.Stop()
+		eventHandler.Stop()
 	}
 
 	c.JSON(http.StatusOK, gin.H{"eventResetMode": eFlag})
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"drexel.edu/todo-events/events"
+	"github.com/gin-gonic/gin"
+)
+
+// The /events/config resource is the control panel of the event manager,
+// it turns eventing, or single event types, on and off while the api is
+// running, and shows how the subscribers are keeping up.
+
+// EventConfigUpdate is the body of PUT /events/config, fields that are
+// left out are not changed, for example
+// {"enabled": true, "types": {"todo.query": false}}
+type EventConfigUpdate struct {
+	Enabled *bool           `json:"enabled"`
+	Types   map[string]bool `json:"types"`
+}
+
+// eventingReady returns the event manager, or aborts with 503 if
+// eventing was never set up
+func (td *ToDoAPI) eventingReady(c *gin.Context) (*events.ToDoEventManager, bool) {
+	eventHandler := td.eventHandler.Load()
+	if eventHandler == nil {
+		log.Println("Error with eventing: ", ErrNoEventing)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": ErrNoEventing.Error()})
+		return nil, false
+	}
+	return eventHandler, true
+}
+
+// implementation for GET /events/config
+// returns whether eventing is on, which event types are sent, how many
+// events were skipped because they were off, and the queue depth and the
+// delivered and dropped counts of every subscriber
+func (td *ToDoAPI) GetEventConfig(c *gin.Context) {
+	eventHandler, ok := td.eventingReady(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, eventHandler.Status())
+}
+
+// implementation for PUT /events/config
+// changes the event configuration and returns it, as GET does.  Nothing
+// is changed if one of the types is unknown.  Turning eventing off waits
+// for the subscribers to handle what is in their queues, or for the
+// request to be cancelled.
+func (td *ToDoAPI) UpdateEventConfig(c *gin.Context) {
+	eventHandler, ok := td.eventingReady(c)
+	if !ok {
+		return
+	}
+
+	var update EventConfigUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	//Check every type before we change any of them
+	types := make(map[events.EventIDType]bool, len(update.Types))
+	for name, enabled := range update.Types {
+		id, ok := events.ParseStreamType(name)
+		if !ok {
+			err := fmt.Errorf("unknown event type %q", name)
+			log.Println("Error updating event config: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		types[id] = enabled
+	}
+
+	for id, enabled := range types {
+		log.Printf("Setting %s events to %t", events.StreamType(id), enabled)
+		eventHandler.SetTypeEnabled(id, enabled)
+	}
+
+	if update.Enabled != nil {
+		if *update.Enabled {
+			log.Println("Enabling Eventing")
+			eventHandler.Start()
+		} else {
+			log.Println("Disabling Eventing")
+			if err := eventHandler.Shutdown(c.Request.Context()); err != nil {
+				log.Println("Error waiting for the subscribers: ", err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, eventHandler.Status())
+}
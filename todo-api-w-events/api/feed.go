@@ -0,0 +1,244 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drexel.edu/todo-events/db"
+	"drexel.edu/todo-events/events"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// A web front end that wants to notice changes does not have to poll
+// GET /todo, it can follow GET /todo/events, a Server-Sent Events
+// stream, or GET /todo/events/ws, the same events over a WebSocket.
+// Both send every add, update and delete as the events.StreamEnvelope
+// json that is also published to redis.
+//
+// Both take the same parameters:
+//   - done=true or done=false only sends the changes of items that are,
+//     or were before the change, done or not done.  An item that is
+//     completed still shows up in a done=false feed, so the client can
+//     take it off its list.
+//   - The Last-Event-ID header, or the last_event_id query parameter
+//     for a browser WebSocket, which cannot set headers, resumes after
+//     that event.  A browser EventSource sends the header by itself
+//     when it reconnects.  If the event is too old, or from before the
+//     api restarted, a reset event tells the client to load GET /todo
+//     again, the changes after it follow as usual.
+
+// LastEventIDHeader is the SSE header that names the last event a client saw
+const LastEventIDHeader = "Last-Event-ID"
+
+// ResetEventType is sent to a client that cannot be resumed
+const ResetEventType = "reset"
+
+// feedHeartbeat is how often an idle connection gets a keep alive, so
+// proxies do not close it
+const feedHeartbeat = 15 * time.Second
+
+// feedWriteTimeout is how long a WebSocket client has to take a message
+const feedWriteTimeout = 10 * time.Second
+
+// The api already allows any origin, see cors.Default in main.go
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// changeFeed is a client that is listening to the feed
+type changeFeed struct {
+	replay   []*events.ToDoEvent
+	listener *events.FeedListener
+	found    bool
+	filter   func(event *events.ToDoEvent) bool
+}
+
+// listenForChanges reads the parameters of a feed request and starts
+// listening.  If it cannot, it aborts the request and returns false.
+func (td *ToDoAPI) listenForChanges(c *gin.Context) (*changeFeed, bool) {
+	if td.feed == nil {
+		log.Println("Error following changes: eventing is not set up")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	filter := func(event *events.ToDoEvent) bool { return true }
+	if doneS := c.Query("done"); doneS != "" {
+		done, err := strconv.ParseBool(doneS)
+		if err != nil {
+			log.Println("Error converting done to bool: ", err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return nil, false
+		}
+		filter = func(event *events.ToDoEvent) bool {
+			return itemDoneIs(event.Before, done) || itemDoneIs(event.After, done)
+		}
+	}
+
+	lastID := c.GetHeader(LastEventIDHeader)
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+
+	replay, listener, found := td.feed.Listen(lastID)
+	return &changeFeed{
+		replay:   replay,
+		listener: listener,
+		found:    found,
+		filter:   filter,
+	}, true
+}
+
+// itemDoneIs returns true if item is a todo with its done flag set to done
+func itemDoneIs(item any, done bool) bool {
+	todoItem, ok := item.(db.ToDoItem)
+	return ok && todoItem.IsDone == done
+}
+
+// implementation for GET /todo/events
+// streams the changes to the todos as Server-Sent Events, the event
+// name is the type of the change, for example todo.add, and the id is
+// the id of the change, for Last-Event-ID
+func (td *ToDoAPI) TodoEvents(c *gin.Context) {
+	feed, ok := td.listenForChanges(c)
+	if !ok {
+		return
+	}
+	defer td.feed.Unlisten(feed.listener)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	//Stops nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(event *events.ToDoEvent) bool {
+		if !feed.filter(event) {
+			return true
+		}
+		envelope, err := events.NewStreamEnvelope(event)
+		if err != nil {
+			log.Println("Error sending event: ", err)
+			return true
+		}
+		err = sse.Encode(c.Writer, sse.Event{Id: envelope.ID, Event: envelope.Type, Data: envelope})
+		return err == nil
+	}
+
+	if !feed.found {
+		sse.Encode(c.Writer, sse.Event{Event: ResetEventType, Data: gin.H{"type": ResetEventType}})
+	}
+	for _, event := range feed.replay {
+		if !send(event) {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-feed.listener.C:
+			//We fell behind and were dropped, the client reconnects
+			//with Last-Event-ID and catches up
+			if !ok || !send(event) {
+				return
+			}
+		case <-heartbeat.C:
+			//A line that starts with a colon is a comment
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// implementation for GET /todo/events/ws
+// sends the changes to the todos over a WebSocket, every message is a
+// change, or {"type": "reset"}.  Messages from the client are ignored.
+func (td *ToDoAPI) TodoEventsWS(c *gin.Context) {
+	feed, ok := td.listenForChanges(c)
+	if !ok {
+		return
+	}
+	defer td.feed.Unlisten(feed.listener)
+
+	//Upgrade answers the request itself if it fails
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Println("Error upgrading to a websocket: ", err)
+		return
+	}
+	defer conn.Close()
+
+	//Someone has to read, or we never see the client close the socket
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(message any) bool {
+		conn.SetWriteDeadline(time.Now().Add(feedWriteTimeout))
+		return conn.WriteJSON(message) == nil
+	}
+	send := func(event *events.ToDoEvent) bool {
+		if !feed.filter(event) {
+			return true
+		}
+		envelope, err := events.NewStreamEnvelope(event)
+		if err != nil {
+			log.Println("Error sending event: ", err)
+			return true
+		}
+		return write(envelope)
+	}
+
+	if !feed.found && !write(gin.H{"type": ResetEventType}) {
+		return
+	}
+	for _, event := range feed.replay {
+		if !send(event) {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-feed.listener.C:
+			if !ok {
+				//We fell behind, the client reconnects with
+				//last_event_id and catches up
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"),
+					time.Now().Add(feedWriteTimeout))
+				return
+			}
+			if !send(event) {
+				return
+			}
+		case <-heartbeat.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(feedWriteTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxPageLimit is the largest page a caller can ask for with ?limit=
+const MaxPageLimit = 1000
+
+// TotalCountHeader tells the caller how many items there are in the
+// whole list, not just in the page they got back.  Counting means
+// walking the whole list, so it is only sent when the caller asks for
+// it with ?count=true.
+const TotalCountHeader = "X-Total-Count"
+
+// pageParams reads the limit, cursor and count query parameters of a
+// list request, for example /todo?limit=10&cursor=<cursor>&count=true.
+// Without a limit the whole list is returned, just like before paging
+// was added.  The bool says whether the caller wants the total count.
+func pageParams(c *gin.Context) (string, int, bool, error) {
+	limit := 0
+	if limitS := c.Query("limit"); limitS != "" {
+		var err error
+		limit, err = strconv.Atoi(limitS)
+		if err != nil || limit < 1 || limit > MaxPageLimit {
+			return "", 0, false, fmt.Errorf("limit must be a number from 1 to %d", MaxPageLimit)
+		}
+	}
+
+	count := false
+	if countS := c.Query("count"); countS != "" {
+		var err error
+		count, err = strconv.ParseBool(countS)
+		if err != nil {
+			return "", 0, false, errors.New("count must be true or false")
+		}
+	}
+	return c.Query("cursor"), limit, count, nil
+}
+
+// setTotalCountHeader tells the caller how many items there are in the
+// whole list
+func setTotalCountHeader(c *gin.Context, total int) {
+	c.Header(TotalCountHeader, strconv.Itoa(total))
+}
+
+// setPageHeaders adds, when there are more items, a Link header (RFC
+// 8288) that points at the next page.  The link keeps every other query
+// parameter of the request, so the caller can simply follow it until
+// there is no rel="next" link anymore.  Only count is dropped, a caller
+// that wants the total has it from the first page.
+func setPageHeaders(c *gin.Context, nextCursor string) {
+	if nextCursor == "" {
+		return
+	}
+
+	next := *c.Request.URL
+	query := next.Query()
+	query.Set("cursor", nextCursor)
+	query.Del("count")
+	next.RawQuery = query.Encode()
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+}
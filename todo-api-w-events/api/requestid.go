@@ -0,0 +1,36 @@
+package api
+
+import (
+	"drexel.edu/todo-events/events"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the id of a request.  A caller can send one to
+// follow its request through the events, if not the api makes one up,
+// either way it is sent back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength stops a caller from putting something huge in every
+// event
+const maxRequestIDLength = 128
+
+const requestIDKey = "requestID"
+
+// RequestID is the middleware that gives every request an id
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = events.NewID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// requestID returns the id RequestID gave the request, or "" if the
+// middleware is not in use
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/todo-events/events"
+	"github.com/gin-gonic/gin"
+)
+
+// The /webhooks resource registers urls that are sent every add, update
+// and delete, see events.WebhookDispatcher for the signature, retries
+// and dead letters.
+
+// webhookID reads the :id parameter, if it is not a number it aborts
+// with 400 and returns false
+func webhookID(c *gin.Context) (int, bool) {
+	id64, err := strconv.ParseInt(c.Param("id"), 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return 0, false
+	}
+	return int(id64), true
+}
+
+// abortWithWebhookError answers a webhook request that failed with err
+func abortWithWebhookError(c *gin.Context, err error) {
+	log.Println("Error with webhooks: ", err)
+	switch {
+	case errors.Is(err, events.ErrInvalidWebhook):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, events.ErrWebhookNotFound), errors.Is(err, events.ErrDeadLetterNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// webhooksReady aborts with 503 if eventing was never set up
+func (td *ToDoAPI) webhooksReady(c *gin.Context) bool {
+	if td.webhooks == nil {
+		log.Println("Error with webhooks: eventing is not set up")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+// implementation for GET /webhooks
+// returns every webhook, without its secret
+func (td *ToDoAPI) ListWebhooks(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+	c.JSON(http.StatusOK, td.webhooks.List())
+}
+
+// implementation for POST /webhooks
+// registers a webhook, the body is for example
+// {"url": "https://example.com/hook", "events": ["todo.add"]}.  The
+// webhook is returned with its secret, which is the only time it is
+// shown, keep it to check the signatures.  A secret can also be sent
+// in the body.
+func (td *ToDoAPI) AddWebhook(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+
+	var hook events.Webhook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	hook, err := td.webhooks.Add(hook)
+	if err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, hook)
+}
+
+// implementation for GET /webhooks/:id
+func (td *ToDoAPI) GetWebhook(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	hook, err := td.webhooks.Get(id)
+	if err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, hook)
+}
+
+// implementation for DELETE /webhooks/:id
+func (td *ToDoAPI) DeleteWebhook(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	if err := td.webhooks.Delete(id); err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// implementation for GET /webhooks/:id/deliveries
+// returns the last delivery attempts of a webhook, oldest first
+func (td *ToDoAPI) ListWebhookDeliveries(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	attempts, err := td.webhooks.Attempts(id)
+	if err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, attempts)
+}
+
+// implementation for GET /webhooks/dead-letters
+// returns the deliveries that ran out of attempts
+func (td *ToDoAPI) ListDeadLetters(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+	c.JSON(http.StatusOK, td.webhooks.DeadLetters())
+}
+
+// implementation for POST /webhooks/dead-letters/:id/retry
+// sends a dead letter again, it starts over with a full set of attempts
+func (td *ToDoAPI) RetryDeadLetter(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	if err := td.webhooks.Redeliver(id); err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// implementation for DELETE /webhooks/dead-letters/:id
+func (td *ToDoAPI) DeleteDeadLetter(c *gin.Context) {
+	if !td.webhooksReady(c) {
+		return
+	}
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	if err := td.webhooks.DeleteDeadLetter(id); err != nil {
+		abortWithWebhookError(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//------------------------------------------------------------
+// EVENT LOG
+//------------------------------------------------------------
+
+// In event sourcing mode the source of truth is not the map of items, it
+// is an append only log of every change, one json StoredEvent per line
+// in <dir>/events.log.  The map is a projection, apply builds it by
+// playing the events in order, both when a change is made and when the
+// log is replayed on startup.
+//
+// Replaying a long log takes a while, so every so many events the map
+// is written to <dir>/snapshot.json, together with the sequence number
+// of the last event in it.  On startup the snapshot is loaded and only
+// the events after it are replayed.  The log itself is never trimmed,
+// it is also the history that ItemAtSeq and ItemAtTime read.
+
+// The types of StoredEvent
+const (
+	EventAdd       = "add"
+	EventUpdate    = "update"
+	EventDelete    = "delete"
+	EventDeleteAll = "delete_all"
+)
+
+// DefaultSnapshotEvery is how many events are written between snapshots
+const DefaultSnapshotEvery = 100
+
+// ErrNoEventLog is returned for history requests when the database is
+// not in event sourcing mode
+var ErrNoEventLog = errors.New("the database is not keeping an event log")
+
+// StoredEvent is a change as it is kept in the event log.  Seq goes up
+// by one for every event.  An add or update holds the whole item as it
+// was stored, timestamps included, so replaying it gives the same item.
+type StoredEvent struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	Id   int       `json:"id,omitempty"`
+	Item *ToDoItem `json:"item,omitempty"`
+}
+
+// snapshot is the projection after event Seq
+type snapshot struct {
+	Seq    uint64     `json:"seq"`
+	Time   time.Time  `json:"time"`
+	NextId int        `json:"next_id"`
+	Items  []ToDoItem `json:"items"`
+}
+
+type eventLog struct {
+	logFile      string
+	snapshotFile string
+	file         *os.File
+	//size is where the last whole event in the log ends
+	size int64
+	//seq is the sequence number of the last event in the log
+	seq           uint64
+	snapshotEvery int
+	sinceSnapshot int
+}
+
+// openEventLog opens, or creates, the event log in dir.  It returns the
+// last snapshot, which is empty if there is none, and the events after
+// it.
+func openEventLog(dir string, snapshotEvery int) (*eventLog, snapshot, []StoredEvent, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = DefaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, snapshot{}, nil, err
+	}
+	l := &eventLog{
+		logFile:       filepath.Join(dir, "events.log"),
+		snapshotFile:  filepath.Join(dir, "snapshot.json"),
+		snapshotEvery: snapshotEvery,
+	}
+
+	snap, err := l.readSnapshot()
+	if err != nil {
+		return nil, snapshot{}, nil, err
+	}
+
+	file, err := os.OpenFile(l.logFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, snapshot{}, nil, err
+	}
+
+	var replay []StoredEvent
+	good, err := readEvents(file, func(event StoredEvent) bool {
+		if event.Seq > snap.Seq {
+			replay = append(replay, event)
+		}
+		l.seq = event.Seq
+		return true
+	})
+	if err != nil {
+		file.Close()
+		return nil, snapshot{}, nil, err
+	}
+
+	//A crash in the middle of a write leaves half a line at the end,
+	//that change was never applied, so we cut it off
+	if info, err := file.Stat(); err == nil && info.Size() > good {
+		log.Printf("Cutting %d bytes of an unfinished event off the end of %s", info.Size()-good, l.logFile)
+		if err := file.Truncate(good); err != nil {
+			file.Close()
+			return nil, snapshot{}, nil, err
+		}
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, snapshot{}, nil, err
+	}
+	if l.seq < snap.Seq {
+		file.Close()
+		return nil, snapshot{}, nil, fmt.Errorf("the snapshot is at event %d but the log ends at %d", snap.Seq, l.seq)
+	}
+
+	l.file = file
+	l.size = good
+	l.sinceSnapshot = len(replay)
+	return l, snap, replay, nil
+}
+
+// readEvents calls found for every whole event in r, in order, until it
+// returns false.  It returns the offset after the last whole line, a
+// last line without a newline is not whole, it is still being written.
+func readEvents(r io.Reader, found func(event StoredEvent) bool) (int64, error) {
+	reader := bufio.NewReader(r)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+
+		var event StoredEvent
+		if err := json.Unmarshal(bytes.TrimSpace(line), &event); err != nil {
+			//Only the last line can be broken, by a crash
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("event log is corrupt after byte %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+		if !found(event) {
+			return offset, nil
+		}
+	}
+}
+
+// append gives event the next sequence number, and the time if it has
+// none, and writes it to the log.  The change is only applied once it
+// is on disk.
+func (l *eventLog) append(event *StoredEvent) error {
+	event.Seq = l.seq + 1
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := l.file.Write(data); err != nil {
+		return l.cutBack(err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return l.cutBack(err)
+	}
+	l.size += int64(len(data))
+	l.seq = event.Seq
+	l.sinceSnapshot++
+	return nil
+}
+
+// cutBack undoes an append that failed with err.  The write may have
+// left part of the event in the log, a full disk for example, and the
+// next event must not be glued to it, so the log is cut back to the end
+// of the last whole event.
+func (l *eventLog) cutBack(err error) error {
+	if truncErr := l.file.Truncate(l.size); truncErr != nil {
+		return fmt.Errorf("%w, and cutting the log back failed: %v", err, truncErr)
+	}
+	if _, seekErr := l.file.Seek(l.size, io.SeekStart); seekErr != nil {
+		return fmt.Errorf("%w, and cutting the log back failed: %v", err, seekErr)
+	}
+	return err
+}
+
+// snapshotDue returns true when it is time for a snapshot
+func (l *eventLog) snapshotDue() bool {
+	return l.sinceSnapshot >= l.snapshotEvery
+}
+
+func (l *eventLog) readSnapshot() (snapshot, error) {
+	data, err := os.ReadFile(l.snapshotFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return snapshot{NextId: 1}, nil
+	}
+	if err != nil {
+		return snapshot{}, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snapshot{}, fmt.Errorf("reading snapshot %s: %w", l.snapshotFile, err)
+	}
+	return snap, nil
+}
+
+// writeSnapshot writes the projection after the last event.  It writes
+// a new file and renames it, so a crash never leaves half a snapshot.
+// The new file is flushed to disk before the rename, and the directory
+// after it, the same way the todo cli saves its database.
+func (l *eventLog) writeSnapshot(items DbMap, nextId int) error {
+	snap := snapshot{
+		Seq:    l.seq,
+		Time:   time.Now().UTC(),
+		NextId: nextId,
+		Items:  make([]ToDoItem, 0, len(items)),
+	}
+	for _, item := range items {
+		snap.Items = append(snap.Items, item)
+	}
+	sort.Slice(snap.Items, func(i, j int) bool { return snap.Items[i].Id < snap.Items[j].Id })
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := l.snapshotFile + ".tmp"
+	if err := writeFileSynced(tmp, data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, l.snapshotFile); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(l.snapshotFile)); err != nil {
+		return err
+	}
+	l.sinceSnapshot = 0
+	return nil
+}
+
+// writeFileSynced is os.WriteFile, but the data is on disk, not just in
+// the page cache, when it returns
+func writeFileSynced(fileName string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// history calls found for the events in the log, from the first one,
+// until it returns false.  It reads its own copy of the file, so it
+// does not get in the way of append.
+func (l *eventLog) history(found func(event StoredEvent) bool) error {
+	file, err := os.Open(l.logFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = readEvents(file, found)
+	return err
+}
+
+func (l *eventLog) close() error {
+	return l.file.Close()
+}
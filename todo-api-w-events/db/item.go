@@ -0,0 +1,83 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Priority is the optional priority of a ToDoItem.  Items without a
+// priority have the empty string, PriorityNone.
+type Priority string
+
+const (
+	PriorityNone   Priority = ""
+	PriorityLow    Priority = "low"
+	PriorityMedium Priority = "medium"
+	PriorityHigh   Priority = "high"
+)
+
+// ToDoItem is the struct that represents a single ToDo item.
+//
+// Everything after IsDone is optional and marked omitempty, so records
+// that were saved before these fields existed still load, they just come
+// back with the fields empty.  The CreatedAt, UpdatedAt and CompletedAt
+// timestamps are owned by the database, it sets them on every add and
+// update and ignores whatever the caller put in them.
+type ToDoItem struct {
+	Id          int        `json:"id"`
+	Title       string     `json:"title"`
+	IsDone      bool       `json:"done"`
+	DueDate     *time.Time `json:"due,omitempty"`
+	Priority    Priority   `json:"priority,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Notes       string     `json:"notes,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Validate checks the optional fields of an item, it returns an error
+// describing the first problem it finds
+func (item ToDoItem) Validate() error {
+	switch item.Priority {
+	case PriorityNone, PriorityLow, PriorityMedium, PriorityHigh:
+	default:
+		return fmt.Errorf("invalid priority %q, use low, medium or high", item.Priority)
+	}
+	for _, tag := range item.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t\r\n") {
+			return fmt.Errorf("invalid tag %q, tags cannot be empty or contain spaces", tag)
+		}
+	}
+	return nil
+}
+
+// stampNewItem sets the timestamps of an item that is being added
+func stampNewItem(item *ToDoItem) {
+	now := time.Now().UTC()
+	item.CreatedAt = &now
+	item.UpdatedAt = &now
+	item.CompletedAt = nil
+	if item.IsDone {
+		item.CompletedAt = &now
+	}
+}
+
+// stampUpdatedItem sets the timestamps of an item that replaces old.  The
+// creation time never changes, and the completion time is only set when
+// the item goes from not done to done, marking a done item as done again
+// keeps the original completion time.
+func stampUpdatedItem(old ToDoItem, item *ToDoItem) {
+	now := time.Now().UTC()
+	item.CreatedAt = old.CreatedAt
+	item.UpdatedAt = &now
+	switch {
+	case !item.IsDone:
+		item.CompletedAt = nil
+	case old.IsDone:
+		item.CompletedAt = old.CompletedAt
+	default:
+		item.CompletedAt = &now
+	}
+}
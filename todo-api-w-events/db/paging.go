@@ -0,0 +1,19 @@
+package db
+
+import "errors"
+
+//------------------------------------------------------------
+// PAGING
+//------------------------------------------------------------
+
+// ErrInvalidCursor is returned when a cursor was not handed out by
+// GetItemsPage, the api turns it into a 400 Bad Request
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ItemPage is one page of the todo list.  NextCursor is passed back to
+// GetItemsPage to get the page after this one, it is empty on the last
+// page.
+type ItemPage struct {
+	Items      []ToDoItem
+	NextCursor string
+}
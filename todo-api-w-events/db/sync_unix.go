@@ -0,0 +1,15 @@
+//go:build !windows
+
+package db
+
+import "os"
+
+// syncDir flushes a directory so that a rename inside of it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
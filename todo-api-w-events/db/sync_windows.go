@@ -0,0 +1,9 @@
+//go:build windows
+
+package db
+
+// syncDir is a no-op on windows, directories cannot be opened for syncing
+// and NTFS journals the rename for us
+func syncDir(dir string) error {
+	return nil
+}
@@ -4,15 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
-// ToDoItem is the struct that represents a single ToDo item
-type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
-}
-
 // DbMap is a type alias for a map of ToDoItems.  The key
 // will be the ToDoItem.Id and the value will be the ToDoItem
 type DbMap map[int]ToDoItem
@@ -24,7 +22,16 @@ type DbMap map[int]ToDoItem
 // This is just a mock, so we will only be managing an in memory
 // map
 type ToDo struct {
+	//lock guards everything below, the api calls us from many
+	//goroutines at once, and in event sourcing mode the events must
+	//be applied in the order they are written to the log
+	lock    sync.RWMutex
 	toDoMap DbMap
+	//nextId is the id that AddItem gives to the next item added
+	//without an id.  It never goes down, so ids are not reused
+	nextId int
+	//events is the event log in event sourcing mode, nil otherwise
+	events *eventLog
 	//more things would be included in a real implementation
 }
 
@@ -39,6 +46,7 @@ func New() (*ToDo, error) {
 	//a valid empty DB, lets create the ToDo struct
 	toDo := &ToDo{
 		toDoMap: make(map[int]ToDoItem),
+		nextId:  1,
 	}
 
 	// We should be all set here, the ToDo struct is ready to go
@@ -46,16 +54,98 @@ func New() (*ToDo, error) {
 	return toDo, nil
 }
 
+// NewWithEventLog is New in event sourcing mode, the event log in dir is
+// the source of truth, see eventlog.go.  The items are rebuilt from the
+// last snapshot and the events after it, and a snapshot is written
+// every snapshotEvery events, 0 means DefaultSnapshotEvery.
+func NewWithEventLog(dir string, snapshotEvery int) (*ToDo, error) {
+	events, snap, replay, err := openEventLog(dir, snapshotEvery)
+	if err != nil {
+		return nil, err
+	}
+
+	toDo := &ToDo{
+		toDoMap: make(map[int]ToDoItem),
+		nextId:  snap.NextId,
+		events:  events,
+	}
+	for _, item := range snap.Items {
+		toDo.toDoMap[item.Id] = item
+	}
+	for _, event := range replay {
+		toDo.apply(event)
+	}
+	log.Printf("Loaded %d items from %s, the snapshot at event %d and %d events after it",
+		len(toDo.toDoMap), dir, snap.Seq, len(replay))
+
+	return toDo, nil
+}
+
+// Close closes the event log, if there is one
+func (t *ToDo) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.events == nil {
+		return nil
+	}
+	return t.events.close()
+}
+
+// record makes a change.  In event sourcing mode the event is written to
+// the log first, and only applied once it is there.  The lock must be
+// held.
+func (t *ToDo) record(event StoredEvent) error {
+	if t.events != nil {
+		if err := t.events.append(&event); err != nil {
+			return err
+		}
+	}
+	t.apply(event)
+
+	//The log is the source of truth, a snapshot only makes the next
+	//start quicker, so a failed one is not the caller's problem
+	if t.events != nil && t.events.snapshotDue() {
+		if err := t.events.writeSnapshot(t.toDoMap, t.nextId); err != nil {
+			log.Println("Error writing snapshot: ", err)
+		}
+	}
+	return nil
+}
+
+// apply plays a single event on the items, it is all the projection
+// there is.  It must not fail, the event already happened.
+func (t *ToDo) apply(event StoredEvent) {
+	switch event.Type {
+	case EventAdd, EventUpdate:
+		t.toDoMap[event.Item.Id] = *event.Item
+		//An item added with an id of its own pushes the counter past
+		//that id, so the counter only ever moves forward
+		if event.Item.Id >= t.nextId {
+			t.nextId = event.Item.Id + 1
+		}
+	case EventDelete:
+		delete(t.toDoMap, event.Id)
+	case EventDeleteAll:
+		//To delete everything, we can just create a new map
+		//and assign it to our existing map.  The garbage collector
+		//will clean up the old map for us
+		t.toDoMap = make(map[int]ToDoItem)
+	}
+}
+
 //------------------------------------------------------------
 // THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
 //------------------------------------------------------------
 
-// AddItem accepts a ToDoItem and adds it to the DB.
+// AddItem accepts a ToDoItem and adds it to the DB.  An item without an
+// id (an Id of 0) is given the next free id.  The stored item, with its
+// id and timestamps, is returned.
 // Preconditions:   (1) The database file must exist and be a valid
 //
-//					(2) The item must not already exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
+//					(2) If the item has an id, it must not already exist
+//	    				in the DB because we use the item.Id as the key,
+//						this function must check if the item already
 //	    				exists in the DB, if so, return an error
 //
 // Postconditions:
@@ -63,20 +153,39 @@ func New() (*ToDo, error) {
 //	 (1) The item will be added to the DB
 //		(2) The DB file will be saved with the item added
 //		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
+func (t *ToDo) AddItem(item ToDoItem) (ToDoItem, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	//Hand out the next id to items that do not have one
+	if item.Id == 0 {
+		item.Id = t.nextId
+	}
+	if item.Id < 0 {
+		return ToDoItem{}, errors.New("item id cannot be negative")
+	}
 
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
 	_, ok := t.toDoMap[item.Id]
 	if ok {
-		return errors.New("item already exists")
+		return ToDoItem{}, errors.New("item already exists")
+	}
+	if err := item.Validate(); err != nil {
+		return ToDoItem{}, err
 	}
 
-	//Now that we know the item doesn't exist, lets add it to our map
-	t.toDoMap[item.Id] = item
+	//The database owns the timestamps, so set them before we
+	//add the item to our map
+	stampNewItem(&item)
+	//The event happened when the item says it did
+	event := StoredEvent{Time: *item.UpdatedAt, Type: EventAdd, Id: item.Id, Item: &item}
+	if err := t.record(event); err != nil {
+		return ToDoItem{}, err
+	}
 
-	//If everything is ok, return nil for the error
-	return nil
+	//If everything is ok, return the stored item
+	return item, nil
 }
 
 // DeleteItem accepts an item id and removes it from the DB.
@@ -91,29 +200,56 @@ func (t *ToDo) AddItem(item ToDoItem) error {
 //
 //	 (1) The item will be removed from the DB
 //		(2) The DB file will be saved with the item removed
-//		(3) If there is an error, it will be returned
-func (t *ToDo) DeleteItem(id int) error {
+//		(3) The deleted item is returned, read under the same lock
+//			as the delete, and true if there was one to delete
+//		(4) If there is an error, it will be returned
+func (t *ToDo) DeleteItem(id int) (ToDoItem, bool, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
 	// we should if item exists before trying to delete it
 	// this is a good practice, return an error if the
-	// item does not exist
+	// item does not exist.  Deleting an item that is not
+	// there changes nothing, so there is nothing to record
+	existingItem, ok := t.toDoMap[id]
+	if !ok {
+		return ToDoItem{}, false, nil
+	}
 
-	//Now lets use the built-in go delete() function to remove
+	//apply uses the built-in go delete() function to remove
 	//the item from our map
-	delete(t.toDoMap, id)
-
-	return nil
+	if err := t.record(StoredEvent{Type: EventDelete, Id: id}); err != nil {
+		return ToDoItem{}, false, err
+	}
+	return existingItem, true, nil
 }
 
-// DeleteAll removes all items from the DB.
+// DeleteAll removes all items from the DB, and returns the items it
+// removed, ordered by id.  They are read under the same lock as the
+// delete, so an item added at the same time is either deleted and in
+// the list, or still there.
 // It will be exposed via a DELETE /todo endpoint
-func (t *ToDo) DeleteAll() error {
-	//To delete everything, we can just create a new map
-	//and assign it to our existing map.  The garbage collector
-	//will clean up the old map for us
-	t.toDoMap = make(map[int]ToDoItem)
+func (t *ToDo) DeleteAll() ([]ToDoItem, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
-	return nil
+	deleted := make([]ToDoItem, 0, len(t.toDoMap))
+	for _, item := range t.toDoMap {
+		deleted = append(deleted, item)
+	}
+	sort.Slice(deleted, func(i, j int) bool { return deleted[i].Id < deleted[j].Id })
+
+	if err := t.record(StoredEvent{Type: EventDeleteAll}); err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// ItemChange is what UpdateItem did to an item, Before is the item as it
+// was and After the item as it was stored
+type ItemChange struct {
+	Before ToDoItem
+	After  ToDoItem
 }
 
 // UpdateItem accepts a ToDoItem and updates it in the DB.
@@ -128,21 +264,31 @@ func (t *ToDo) DeleteAll() error {
 //
 //	 (1) The item will be updated in the DB
 //		(2) The DB file will be saved with the item updated
-//		(3) If there is an error, it will be returned
-func (t *ToDo) UpdateItem(item ToDoItem) error {
+//		(3) The item as it was and as it was stored are returned,
+//			both read under the same lock as the update
+//		(4) If there is an error, it will be returned
+func (t *ToDo) UpdateItem(item ToDoItem) (ItemChange, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
 	// Check if item exists before trying to update it
 	// this is a good practice, return an error if the
 	// item does not exist
-	_, ok := t.toDoMap[item.Id]
+	existingItem, ok := t.toDoMap[item.Id]
 	if !ok {
-		return errors.New("item does not exist")
+		return ItemChange{}, errors.New("item does not exist")
+	}
+	if err := item.Validate(); err != nil {
+		return ItemChange{}, err
 	}
 
-	//Now that we know the item exists, lets update it
-	t.toDoMap[item.Id] = item
-
-	return nil
+	//Now that we know the item exists, lets update it, keeping
+	//the timestamps that belong to the existing item
+	stampUpdatedItem(existingItem, &item)
+	if err := t.record(StoredEvent{Time: *item.UpdatedAt, Type: EventUpdate, Id: item.Id, Item: &item}); err != nil {
+		return ItemChange{}, err
+	}
+	return ItemChange{Before: existingItem, After: item}, nil
 }
 
 // GetItem accepts an item id and returns the item from the DB.
@@ -160,6 +306,8 @@ func (t *ToDo) UpdateItem(item ToDoItem) error {
 //			along with an empty ToDoItem
 //		(3) The database file will not be modified
 func (t *ToDo) GetItem(id int) (ToDoItem, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 
 	// Check if item exists before trying to get it
 	// this is a good practice, return an error if the
@@ -172,6 +320,76 @@ func (t *ToDo) GetItem(id int) (ToDoItem, error) {
 	return item, nil
 }
 
+// ItemAtSeq returns the item with id as it was right after event seq,
+// and the sequence number of the event that last changed it by then.
+// Preconditions:   (1) The database must be in event sourcing mode,
+//
+//		if not, return ErrNoEventLog
+//	(2) The item must have existed after event seq,
+//		if not, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be rebuilt from the event log
+//		(2) The database will not be modified
+func (t *ToDo) ItemAtSeq(id int, seq uint64) (ToDoItem, uint64, error) {
+	return t.itemAsOf(id, func(event StoredEvent) bool {
+		return event.Seq <= seq
+	})
+}
+
+// ItemAtTime returns the item with id as it was at time at, and the
+// sequence number of the event that last changed it by then.  It works
+// like ItemAtSeq.
+func (t *ToDo) ItemAtTime(id int, at time.Time) (ToDoItem, uint64, error) {
+	return t.itemAsOf(id, func(event StoredEvent) bool {
+		return !event.Time.After(at)
+	})
+}
+
+// itemAsOf plays the events of the log that happened before returns
+// true for, on the item with id.  Only the item is rebuilt, not the
+// whole projection.
+func (t *ToDo) itemAsOf(id int, before func(event StoredEvent) bool) (ToDoItem, uint64, error) {
+	t.lock.RLock()
+	events := t.events
+	var last uint64
+	if events != nil {
+		last = events.seq
+	}
+	t.lock.RUnlock()
+
+	if events == nil {
+		return ToDoItem{}, 0, ErrNoEventLog
+	}
+
+	var item *ToDoItem
+	var changedAt uint64
+	err := events.history(func(event StoredEvent) bool {
+		//Events written after we started are not part of the answer
+		if event.Seq > last || !before(event) {
+			return false
+		}
+		switch {
+		case event.Type == EventDeleteAll:
+			item, changedAt = nil, event.Seq
+		case event.Id != id:
+		case event.Type == EventDelete:
+			item, changedAt = nil, event.Seq
+		default:
+			item, changedAt = event.Item, event.Seq
+		}
+		return true
+	})
+	if err != nil {
+		return ToDoItem{}, 0, err
+	}
+	if item == nil {
+		return ToDoItem{}, 0, errors.New("item does not exist")
+	}
+	return *item, changedAt, nil
+}
+
 // ChangeItemDoneStatus accepts an item id and a boolean status.
 // It returns an error if the status could not be updated for any
 // reason.  For example, the item itself does not exist, or an
@@ -209,6 +427,8 @@ func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
 //			along with an empty slice
 //		(3) The database file will not be modified
 func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
 
 	//Now that we have the DB loaded, lets crate a slice
 	var toDoList []ToDoItem
@@ -222,6 +442,67 @@ func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 	return toDoList, nil
 }
 
+// GetItemsPage returns one page of the items in the DB, ordered by id.
+// An empty cursor starts at the first item, after that the cursor is the
+// NextCursor of the page before.  A limit of 0 means no limit, so the
+// page holds every item that is left.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The cursor must be empty or come from an
+//	    				earlier page, if not, return ErrInvalidCursor
+//
+// Postconditions:
+//
+//	 (1) Up to limit items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty page
+//		(3) The database file will not be modified
+func (t *ToDo) GetItemsPage(cursor string, limit int) (ItemPage, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	//Our cursor is simply the id of the last item on the previous
+	//page, the next page starts right after it
+	after := 0
+	if cursor != "" {
+		var err error
+		after, err = strconv.Atoi(cursor)
+		if err != nil || after < 0 {
+			return ItemPage{}, ErrInvalidCursor
+		}
+	}
+
+	//Maps have no order, so sort the ids to get a stable order from
+	//one page to the next
+	ids := make([]int, 0, len(t.toDoMap))
+	for id := range t.toDoMap {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	page := ItemPage{Items: make([]ToDoItem, 0)}
+	for _, id := range ids {
+		if limit > 0 && len(page.Items) == limit {
+			//There is at least one more item, so hand out a cursor
+			page.NextCursor = strconv.Itoa(page.Items[len(page.Items)-1].Id)
+			break
+		}
+		page.Items = append(page.Items, t.toDoMap[id])
+	}
+
+	return page, nil
+}
+
+// CountItems returns the number of items in the DB
+func (t *ToDo) CountItems() (int, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return len(t.toDoMap), nil
+}
+
 // PrintItem accepts a ToDoItem and prints it to the console
 // in a JSON pretty format. As some help, look at the
 // json.MarshalIndent() function from our in class go tutorial.
@@ -1,5 +1,11 @@
 package events
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
 type EventIDType int
 
 const (
@@ -10,16 +16,76 @@ const (
 	ToDoErrorEvent
 )
 
+// AllEventIDs lists every event type, in order
+var AllEventIDs = []EventIDType{
+	ToDoQueryEvent,
+	ToDoAddEvent,
+	ToDoUpdateEvent,
+	ToDoDeleteEvent,
+	ToDoErrorEvent,
+}
+
+// String returns the name of an event type, for example "add"
+func (id EventIDType) String() string {
+	switch id {
+	case ToDoQueryEvent:
+		return "query"
+	case ToDoAddEvent:
+		return "add"
+	case ToDoUpdateEvent:
+		return "update"
+	case ToDoDeleteEvent:
+		return "delete"
+	case ToDoErrorEvent:
+		return "error"
+	}
+	return "unknown"
+}
+
+// ToDoEvent is a single event.  Seq is handed out by the event manager
+// when the event is sent, it goes up by one for every event, so a
+// subscriber can tell the order of the events and notice a gap.  ID is
+// unique across restarts, Seq is not.
+//
+// An event that changes an item also carries the item Before and After
+// the change, Before is nil for an add and After is nil for a delete,
+// and the id of the request that made the change.
 type ToDoEvent struct {
+	ID        string
 	EventID   EventIDType
 	EventData map[string]any
+	Seq       uint64
+	Time      time.Time
+	RequestID string
+	Before    any
+	After     any
 }
 
 func NewEvent(id EventIDType, key string, value any) *ToDoEvent {
 	return &ToDoEvent{
+		ID:      NewID(),
 		EventID: id,
 		EventData: map[string]any{
 			key: value,
 		},
+		Time: time.Now().UTC(),
 	}
 }
+
+// NewChangeEvent is NewEvent for an add, update or delete, before and
+// after are the item before and after the change
+func NewChangeEvent(id EventIDType, key string, value any, requestID string, before any, after any) *ToDoEvent {
+	event := NewEvent(id, key, value)
+	event.RequestID = requestID
+	event.Before = before
+	event.After = after
+	return event
+}
+
+// NewID returns a random id, 32 hex characters
+func NewID() string {
+	b := make([]byte, 16)
+	//crypto/rand does not fail on the platforms go supports
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -2,92 +2,277 @@ package events
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 )
 
+// ToDoEventManager is the event bus of the todo api.  The api sends
+// every event to Notify, and the manager hands it to each subscriber
+// that has a handler for its type.
+//
+// Every subscriber has its own buffered queue and its own goroutine, so
+// Notify only has to put the event on the queues, it never waits for a
+// handler to run.  What happens when a queue is full is up to the
+// backpressure policy of the subscriber, see SubscribeOptions.
 type ToDoEventManager struct {
-	ctx      context.Context
-	cancel   context.CancelFunc
-	queue    chan *ToDoEvent
-	isActive bool
+	//lifecycle makes Start, Stop, Subscribe and Unsubscribe run one at
+	//a time.  lock guards isActive, subscribers and disabled, it is only
+	//held for a moment, so Notify never waits for a subscriber that is
+	//stopping.
+	lifecycle   sync.Mutex
+	lock        sync.RWMutex
+	isActive    bool
+	subscribers []*subscriber
+	//disabled holds the event types that are not sent, it is replaced,
+	//never changed, so Notify can use it after it lets go of the lock
+	disabled map[EventIDType]bool
+
+	seq atomic.Uint64
+	//skipped counts the events Notify did not send, because the manager
+	//was stopped or their type was disabled
+	skipped atomic.Uint64
+}
+
+// ManagerStatus is what the manager is doing, see Status
+type ManagerStatus struct {
+	Enabled bool `json:"enabled"`
+	//Types says for every event type, by its stream type, for example
+	//"todo.add", if it is sent
+	Types       map[string]bool   `json:"types"`
+	Skipped     uint64            `json:"skipped"`
+	Subscribers []SubscriberStats `json:"subscribers"`
 }
 
+// ErrSubscriberExists is returned by Subscribe for a name that is taken
+var ErrSubscriberExists = errors.New("subscriber already exists")
+
 func NewToDoEventManager() *ToDoEventManager {
-	return &ToDoEventManager{
-		ctx:      nil,
-		cancel:   nil,
-		queue:    make(chan *ToDoEvent),
-		isActive: false,
+	return &ToDoEventManager{}
+}
+
+// Subscribe registers handlers under name.  If the manager is running
+// the subscriber starts getting events right away, otherwise when the
+// manager starts.
+func (em *ToDoEventManager) Subscribe(name string, handlers Handlers, options SubscribeOptions) error {
+	em.lifecycle.Lock()
+	defer em.lifecycle.Unlock()
+
+	for _, s := range em.subscribers {
+		if s.name == name {
+			return fmt.Errorf("%w: %s", ErrSubscriberExists, name)
+		}
+	}
+	s := newSubscriber(name, handlers, options)
+	if em.isActive {
+		s.start()
+	}
+
+	//Notify may be walking the old slice, so we make a new one
+	em.lock.Lock()
+	em.subscribers = append(em.subscribers[:len(em.subscribers):len(em.subscribers)], s)
+	em.lock.Unlock()
+	return nil
+}
+
+// Unsubscribe removes the subscriber with name, after it has handled
+// the events that are already in its queue
+func (em *ToDoEventManager) Unsubscribe(name string) {
+	em.lifecycle.Lock()
+	var removed *subscriber
+	em.lock.Lock()
+	for i, s := range em.subscribers {
+		if s.name == name {
+			removed = s
+			em.subscribers = append(em.subscribers[:i:i], em.subscribers[i+1:]...)
+			break
+		}
+	}
+	em.lock.Unlock()
+
+	var done <-chan struct{}
+	if removed != nil && em.isActive {
+		done = removed.stop()
+	}
+	em.lifecycle.Unlock()
+
+	if done != nil {
+		<-done
 	}
 }
 
 func (em *ToDoEventManager) Start() {
-	if !em.isActive {
-		em.ctx, em.cancel = context.WithCancel(context.Background())
-		em.isActive = true
-		go em.eventLoop()
+	em.lifecycle.Lock()
+	defer em.lifecycle.Unlock()
+
+	if em.isActive {
+		return
+	}
+	log.Println("Starting Event Manager...")
+	for _, s := range em.subscribers {
+		s.start()
 	}
+	em.lock.Lock()
+	em.isActive = true
+	em.lock.Unlock()
+}
+
+// Stop stops the manager, see Shutdown
+func (em *ToDoEventManager) Stop() {
+	em.Shutdown(context.Background())
 }
 
-func (em *ToDoEventManager) eventLoop() {
-	log.Println("Starting Event Loop...")
-	for {
+// Shutdown stops taking events, and waits until every subscriber has
+// handled the events in its queue, or ctx is done.  Events that are not
+// handled by then are handled after Shutdown returns.
+func (em *ToDoEventManager) Shutdown(ctx context.Context) error {
+	em.lifecycle.Lock()
+	if !em.isActive {
+		em.lifecycle.Unlock()
+		return nil
+	}
+	log.Println("Stopping Event Manager...")
+	em.lock.Lock()
+	em.isActive = false
+	em.lock.Unlock()
+
+	//A Notify that started before we stopped may still be sending, a
+	//subscriber waits for it before it closes its queue
+	draining := make([]<-chan struct{}, 0, len(em.subscribers))
+	for _, s := range em.subscribers {
+		draining = append(draining, s.stop())
+	}
+	em.lifecycle.Unlock()
+
+	for _, done := range draining {
 		select {
-		case <-em.ctx.Done():
-			log.Println("Stopping Event Manager...")
-			return
-		case event := <-em.queue:
-			log.Printf("\n--> Received Event: %+v\n", event.EventData)
-			em.processEvent(event)
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+	return nil
 }
 
-func (em *ToDoEventManager) Stop() {
-	if em.isActive {
-		em.cancel()
-		em.isActive = false
+// IsActive returns true while the manager is running
+func (em *ToDoEventManager) IsActive() bool {
+	em.lock.RLock()
+	defer em.lock.RUnlock()
+	return em.isActive
+}
+
+// SetTypeEnabled turns sending events of type id on or off, all types
+// are on to start with.  It can be called while events are being sent,
+// an event that Notify is already sending is still sent.
+func (em *ToDoEventManager) SetTypeEnabled(id EventIDType, enabled bool) {
+	em.lock.Lock()
+	defer em.lock.Unlock()
+
+	disabled := make(map[EventIDType]bool, len(em.disabled)+1)
+	for t := range em.disabled {
+		disabled[t] = true
+	}
+	if enabled {
+		delete(disabled, id)
+	} else {
+		disabled[id] = true
 	}
+	em.disabled = disabled
 }
 
+// TypeEnabled returns true if events of type id are sent
+func (em *ToDoEventManager) TypeEnabled(id EventIDType) bool {
+	em.lock.RLock()
+	defer em.lock.RUnlock()
+	return !em.disabled[id]
+}
+
+// Notify sends event to the subscribers that handle its type, and gives
+// it the next sequence number.  Events sent while the manager is stopped,
+// or of a type that is disabled, are dropped and counted as skipped.
 func (em *ToDoEventManager) Notify(event *ToDoEvent) {
-	if em.isActive {
-		em.queue <- event
+	em.lock.RLock()
+	isActive, subscribers, disabled := em.isActive, em.subscribers, em.disabled
+	em.lock.RUnlock()
+
+	if !isActive || disabled[event.EventID] {
+		em.skipped.Add(1)
+		return
+	}
+	event.Seq = em.seq.Add(1)
+	for _, s := range subscribers {
+		if s.wants(event.EventID) {
+			s.deliver(event)
+		}
+	}
+}
+
+// Stats returns the counters of every subscriber
+func (em *ToDoEventManager) Stats() []SubscriberStats {
+	em.lock.RLock()
+	subscribers := em.subscribers
+	em.lock.RUnlock()
+
+	stats := make([]SubscriberStats, 0, len(subscribers))
+	for _, s := range subscribers {
+		stats = append(stats, s.stats())
 	}
+	return stats
 }
 
-func (em *ToDoEventManager) processEvent(event *ToDoEvent) {
-	switch event.EventID {
-	case ToDoQueryEvent:
-		em.processQueryEvent(event)
-	case ToDoAddEvent:
-		em.processAddEvent(event)
-	case ToDoUpdateEvent:
-		em.processUpdateEvent(event)
-	case ToDoDeleteEvent:
-		em.processDeleteEvent(event)
-	case ToDoErrorEvent:
-		em.processErrorEvent(event)
+// Status returns whether the manager is running, which event types it
+// sends, and the counters of every subscriber
+func (em *ToDoEventManager) Status() ManagerStatus {
+	em.lock.RLock()
+	isActive, disabled := em.isActive, em.disabled
+	em.lock.RUnlock()
+
+	types := make(map[string]bool, len(AllEventIDs))
+	for _, id := range AllEventIDs {
+		types[StreamType(id)] = !disabled[id]
+	}
+	return ManagerStatus{
+		Enabled:     isActive,
+		Types:       types,
+		Skipped:     em.skipped.Load(),
+		Subscribers: em.Stats(),
+	}
+}
+
+//------------------------------------------------------------
+// LOGGING SUBSCRIBER
+//------------------------------------------------------------
+
+// LogHandlers are the handlers of the subscriber the api starts with,
+// they log every event
+func LogHandlers() Handlers {
+	return Handlers{
+		ToDoQueryEvent:  processQueryEvent,
+		ToDoAddEvent:    processAddEvent,
+		ToDoUpdateEvent: processUpdateEvent,
+		ToDoDeleteEvent: processDeleteEvent,
+		ToDoErrorEvent:  processErrorEvent,
 	}
 }
 
-func (em *ToDoEventManager) processQueryEvent(event *ToDoEvent) {
-	fmt.Println("Processing Query Event")
+func processQueryEvent(event *ToDoEvent) {
+	log.Printf("Processing Query Event %d", event.Seq)
 }
 
-func (em *ToDoEventManager) processAddEvent(event *ToDoEvent) {
-	fmt.Println("Processing Add Event")
+func processAddEvent(event *ToDoEvent) {
+	log.Printf("Processing Add Event %d: %+v", event.Seq, event.EventData)
 }
 
-func (em *ToDoEventManager) processUpdateEvent(event *ToDoEvent) {
-	fmt.Println("Processing Update Event")
+func processUpdateEvent(event *ToDoEvent) {
+	log.Printf("Processing Update Event %d: %+v", event.Seq, event.EventData)
 }
 
-func (em *ToDoEventManager) processDeleteEvent(event *ToDoEvent) {
-	fmt.Println("Processing Delete Event")
+func processDeleteEvent(event *ToDoEvent) {
+	log.Printf("Processing Delete Event %d: %+v", event.Seq, event.EventData)
 }
 
-func (em *ToDoEventManager) processErrorEvent(event *ToDoEvent) {
-	fmt.Println("Processing Error Event")
+func processErrorEvent(event *ToDoEvent) {
+	log.Printf("Processing Error Event %d: %+v", event.Seq, event.EventData)
 }
@@ -0,0 +1,124 @@
+package events
+
+import "sync"
+
+//------------------------------------------------------------
+// LIVE FEED
+//------------------------------------------------------------
+
+// DefaultFeedReplay is how many events a feed keeps for replay
+const DefaultFeedReplay = 256
+
+// feedListenerBuffer is how many events a listener can fall behind
+// before it is dropped
+const feedListenerBuffer = 64
+
+// FeedListener is a client of the feed, C gets every event and is
+// closed when the listener is dropped
+type FeedListener struct {
+	C <-chan *ToDoEvent
+	c chan *ToDoEvent
+}
+
+// Feed is a subscriber that hands the add, update and delete events to
+// the clients that are listening right now, the SSE and WebSocket
+// clients of the api.  It keeps the last events in a replay buffer, so a
+// client that lost its connection can pick up after the last event it
+// saw, as long as that event is still in the buffer.
+//
+// A listener that does not keep up is dropped, its channel is closed.
+// The client reconnects and catches up from the buffer, rather than
+// holding up the other listeners.
+type Feed struct {
+	lock sync.Mutex
+	//replay holds the last events, oldest first
+	replay    []*ToDoEvent
+	maxReplay int
+	listeners map[*FeedListener]struct{}
+}
+
+// NewFeed returns a feed that keeps the last maxReplay events
+func NewFeed(maxReplay int) *Feed {
+	if maxReplay <= 0 {
+		maxReplay = DefaultFeedReplay
+	}
+	return &Feed{
+		maxReplay: maxReplay,
+		listeners: make(map[*FeedListener]struct{}),
+	}
+}
+
+// Handlers are the handlers to subscribe the feed with
+func (f *Feed) Handlers() Handlers {
+	return Handlers{
+		ToDoAddEvent:    f.publish,
+		ToDoUpdateEvent: f.publish,
+		ToDoDeleteEvent: f.publish,
+	}
+}
+
+func (f *Feed) publish(event *ToDoEvent) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.replay) == f.maxReplay {
+		//Shift rather than reslice, so the array does not grow forever
+		copy(f.replay, f.replay[1:])
+		f.replay = f.replay[:len(f.replay)-1]
+	}
+	f.replay = append(f.replay, event)
+
+	for l := range f.listeners {
+		select {
+		case l.c <- event:
+		default:
+			delete(f.listeners, l)
+			close(l.c)
+		}
+	}
+}
+
+// Listen adds a listener.  If lastID is the ID of an event, the events
+// after it are returned to replay before the listener's own events,
+// nothing is lost or sent twice in between.  If lastID is not in the
+// replay buffer, because it is too old or from before a restart, found
+// is false and the client has to load everything again.
+func (f *Feed) Listen(lastID string) (replay []*ToDoEvent, listener *FeedListener, found bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	found = lastID == ""
+	if !found {
+		for i := len(f.replay) - 1; i >= 0; i-- {
+			if f.replay[i].ID == lastID {
+				replay = append(replay, f.replay[i+1:]...)
+				found = true
+				break
+			}
+		}
+	}
+
+	c := make(chan *ToDoEvent, feedListenerBuffer)
+	listener = &FeedListener{C: c, c: c}
+	f.listeners[listener] = struct{}{}
+	return replay, listener, found
+}
+
+// Unlisten removes a listener, it is fine to call it for a listener
+// that was dropped
+func (f *Feed) Unlisten(listener *FeedListener) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.listeners[listener]; ok {
+		delete(f.listeners, listener)
+		close(listener.c)
+	}
+}
+
+// Listeners returns how many clients are listening
+func (f *Feed) Listeners() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.listeners)
+}
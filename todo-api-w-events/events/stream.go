@@ -0,0 +1,215 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//------------------------------------------------------------
+// REDIS STREAMS
+//------------------------------------------------------------
+
+// The events above never leave the process.  StreamPublisher is a
+// subscriber that appends the add, update and delete events to a Redis
+// Stream, so other services can react to todo changes.  Every entry of
+// the stream has two fields, type, for example todo.add, and envelope,
+// the event as StreamEnvelope json.
+//
+// A service reads the stream with a consumer group, every group sees
+// every event, and the consumers of one group share the events between
+// them.  A consumer acknowledges an event once it has handled it, an
+// event that was read but not acknowledged is read again by the group,
+// so a consumer must be able to handle an event twice.
+
+// DefaultStream is the stream the api publishes to
+const DefaultStream = "todo:events"
+
+// DefaultStreamMaxLen is roughly how many events the stream keeps, the
+// oldest are trimmed
+const DefaultStreamMaxLen = 10000
+
+// StreamEnvelopeVersion is the version of StreamEnvelope.  A field may
+// be added without changing it, a field is never removed or changed
+// without changing it.
+const StreamEnvelopeVersion = 1
+
+// StreamEnvelope is the json that is published for every event
+type StreamEnvelope struct {
+	Version   int             `json:"version"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Seq       uint64          `json:"seq"`
+	Timestamp time.Time       `json:"timestamp"`
+	RequestID string          `json:"request_id,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+}
+
+// StreamType returns the type of an event on the stream, for example
+// todo.add
+func StreamType(id EventIDType) string {
+	return "todo." + id.String()
+}
+
+// ParseStreamType returns the event type of a stream type, it returns
+// false if there is no such type
+func ParseStreamType(streamType string) (EventIDType, bool) {
+	for _, id := range AllEventIDs {
+		if StreamType(id) == streamType {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// NewStreamEnvelope returns the envelope for event
+func NewStreamEnvelope(event *ToDoEvent) (StreamEnvelope, error) {
+	before, err := json.Marshal(event.Before)
+	if err != nil {
+		return StreamEnvelope{}, err
+	}
+	after, err := json.Marshal(event.After)
+	if err != nil {
+		return StreamEnvelope{}, err
+	}
+	return StreamEnvelope{
+		Version:   StreamEnvelopeVersion,
+		ID:        event.ID,
+		Type:      StreamType(event.EventID),
+		Seq:       event.Seq,
+		Timestamp: event.Time,
+		RequestID: event.RequestID,
+		Before:    before,
+		After:     after,
+	}, nil
+}
+
+// StreamPublisher publishes events to a Redis Stream
+type StreamPublisher struct {
+	client  redis.Cmdable
+	stream  string
+	maxLen  int64
+	timeout time.Duration
+}
+
+// NewStreamPublisher returns a publisher that appends to stream, and
+// keeps roughly maxLen events in it.  A maxLen of 0 keeps every event.
+func NewStreamPublisher(client redis.Cmdable, stream string, maxLen int64) *StreamPublisher {
+	return &StreamPublisher{
+		client:  client,
+		stream:  stream,
+		maxLen:  maxLen,
+		timeout: 5 * time.Second,
+	}
+}
+
+// Handlers are the handlers to subscribe the publisher with, only the
+// events that change an item are published
+func (p *StreamPublisher) Handlers() Handlers {
+	return Handlers{
+		ToDoAddEvent:    p.handle,
+		ToDoUpdateEvent: p.handle,
+		ToDoDeleteEvent: p.handle,
+	}
+}
+
+func (p *StreamPublisher) handle(event *ToDoEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	if _, err := p.Publish(ctx, event); err != nil {
+		log.Printf("Error publishing event %s to stream %s: %v", event.ID, p.stream, err)
+	}
+}
+
+// Publish appends event to the stream and returns its stream id
+func (p *StreamPublisher) Publish(ctx context.Context, event *ToDoEvent) (string, error) {
+	envelope, err := NewStreamEnvelope(event)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return "", err
+	}
+
+	return p.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: []interface{}{"type", envelope.Type, "envelope", string(data)},
+	}).Result()
+}
+
+// StreamMessage is an event read from the stream, StreamID is the id to
+// acknowledge it with
+type StreamMessage struct {
+	StreamID string
+	Envelope StreamEnvelope
+}
+
+// CreateGroup creates the consumer group group on stream, and the stream
+// if there is none yet.  start is the stream id the group starts after,
+// "$" for the events published from now on and "0" for every event the
+// stream still has.  A group that already exists is left as it is.
+func CreateGroup(ctx context.Context, client redis.Cmdable, stream string, group string, start string) error {
+	err := client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// ReadGroup reads up to count events for consumer in group, the events
+// no consumer of the group has read yet.  It waits up to block for an
+// event, and returns no events and no error if none came.
+func ReadGroup(ctx context.Context, client redis.Cmdable, stream string, group string, consumer string, count int64, block time.Duration) ([]StreamMessage, error) {
+	streams, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []StreamMessage
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			message, err := decodeStreamMessage(m)
+			if err != nil {
+				return messages, err
+			}
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+// Ack tells group that the events with the stream ids are handled
+func Ack(ctx context.Context, client redis.Cmdable, stream string, group string, ids ...string) error {
+	return client.XAck(ctx, stream, group, ids...).Err()
+}
+
+func decodeStreamMessage(m redis.XMessage) (StreamMessage, error) {
+	data, ok := m.Values["envelope"].(string)
+	if !ok {
+		return StreamMessage{}, errors.New("stream entry " + m.ID + " has no envelope")
+	}
+	var envelope StreamEnvelope
+	if err := json.Unmarshal([]byte(data), &envelope); err != nil {
+		return StreamMessage{}, err
+	}
+	return StreamMessage{StreamID: m.ID, Envelope: envelope}, nil
+}
@@ -0,0 +1,202 @@
+package events
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// HandlerFunc handles a single event
+type HandlerFunc func(event *ToDoEvent)
+
+// Handlers maps the event types a subscriber wants to the function
+// that handles each one, the subscriber never sees the other types
+type Handlers map[EventIDType]HandlerFunc
+
+// HandleAll returns Handlers that send every event type to handler
+func HandleAll(handler HandlerFunc) Handlers {
+	handlers := Handlers{}
+	for _, id := range AllEventIDs {
+		handlers[id] = handler
+	}
+	return handlers
+}
+
+// BackpressurePolicy says what Notify does when the queue of a
+// subscriber is full, because the subscriber cannot keep up
+type BackpressurePolicy int
+
+const (
+	//DropOldest throws away the oldest queued event to make room, the
+	//subscriber always sees the latest events.  It is the default, a
+	//subscriber that keeps the default never slows down a request.
+	DropOldest BackpressurePolicy = iota
+
+	//DropNewest throws away the event that does not fit, the
+	//subscriber sees the events that were queued first
+	DropNewest
+
+	//Block makes Notify wait until there is room, nothing is lost, but
+	//a slow subscriber slows down every request that sends an event.
+	//Only use it for a subscriber that must see every event.
+	Block
+)
+
+// DefaultBufferSize is the queue size of a subscriber that does not ask
+// for one
+const DefaultBufferSize = 64
+
+// SubscribeOptions tunes the delivery to a subscriber, the zero value is
+// a queue of DefaultBufferSize with the DropOldest policy
+type SubscribeOptions struct {
+	BufferSize int
+	Policy     BackpressurePolicy
+}
+
+// SubscriberStats counts what happened to the events of a subscriber,
+// Pending is how many events are waiting in its queue of Capacity
+type SubscriberStats struct {
+	Name      string `json:"name"`
+	Pending   int    `json:"pending"`
+	Capacity  int    `json:"capacity"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// subscriber is a registered subscriber.  While the manager runs, it
+// has its own queue and its own goroutine, so one slow subscriber does
+// not hold up the others.
+type subscriber struct {
+	name     string
+	handlers Handlers
+	options  SubscribeOptions
+
+	//queue is replaced every time the manager starts, it is closed when
+	//the manager stops, and done is closed once the goroutine has
+	//handled everything that was left in it.  lock guards both, and
+	//closed, deliver holds it for reading while it sends, so stop knows
+	//nobody is still sending when it closes the queue.
+	lock   sync.RWMutex
+	closed bool
+	queue  chan *ToDoEvent
+	done   chan struct{}
+
+	//queueLock stops two DropOldest senders from both making room and
+	//then both failing to send
+	queueLock sync.Mutex
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newSubscriber(name string, handlers Handlers, options SubscribeOptions) *subscriber {
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultBufferSize
+	}
+	return &subscriber{
+		name:     name,
+		handlers: handlers,
+		options:  options,
+		closed:   true,
+	}
+}
+
+// wants returns true if the subscriber handles events of type id
+func (s *subscriber) wants(id EventIDType) bool {
+	_, ok := s.handlers[id]
+	return ok
+}
+
+// start gives the subscriber a new queue and starts its goroutine
+func (s *subscriber) start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.queue = make(chan *ToDoEvent, s.options.BufferSize)
+	s.done = make(chan struct{})
+	s.closed = false
+	go s.run(s.queue, s.done)
+}
+
+// stop closes the queue, the goroutine handles what is left and then
+// closes the channel stop returns
+func (s *subscriber) stop() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	close(s.queue)
+	return s.done
+}
+
+func (s *subscriber) run(queue <-chan *ToDoEvent, done chan<- struct{}) {
+	defer close(done)
+	for event := range queue {
+		s.handle(event)
+	}
+}
+
+// handle calls the handler for event.  A handler that panics loses that
+// one event, not the subscriber.
+func (s *subscriber) handle(event *ToDoEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Event subscriber %s panicked handling event %d: %v", s.name, event.Seq, r)
+		}
+	}()
+	s.handlers[event.EventID](event)
+	s.delivered.Add(1)
+}
+
+// deliver queues event following the backpressure policy.  A Block
+// subscriber that calls Notify from its own handler can wait for room
+// that only it can make, so it must not do that.
+func (s *subscriber) deliver(event *ToDoEvent) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	//The manager stopped after Notify found this subscriber
+	if s.closed {
+		return
+	}
+
+	switch s.options.Policy {
+	case Block:
+		s.queue <- event
+	case DropNewest:
+		select {
+		case s.queue <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	default:
+		s.queueLock.Lock()
+		defer s.queueLock.Unlock()
+		for {
+			select {
+			case s.queue <- event:
+				return
+			default:
+			}
+			//Make room, unless the goroutine just did
+			select {
+			case <-s.queue:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+func (s *subscriber) stats() SubscriberStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return SubscriberStats{
+		Name:      s.name,
+		Pending:   len(s.queue),
+		Capacity:  s.options.BufferSize,
+		Delivered: s.delivered.Load(),
+		Dropped:   s.dropped.Load(),
+	}
+}
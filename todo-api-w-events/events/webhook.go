@@ -0,0 +1,513 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------
+// WEBHOOKS
+//------------------------------------------------------------
+
+// A webhook is a url that is sent every add, update and delete, as a
+// POST with the StreamEnvelope json as the body.  The body is signed
+// with the secret of the webhook, so the receiver knows it came from us:
+//
+//	X-Todo-Timestamp: 1699999999
+//	X-Todo-Signature: sha256=<hex hmac-sha256 of "<timestamp>.<body>">
+//
+// The receiver computes the same hmac with its copy of the secret, see
+// SignWebhook, compares the two with hmac.Equal, and rejects a timestamp
+// that is more than a few minutes old, so an old request cannot be
+// played back.
+//
+// A delivery that does not get a 2xx answer is tried again, waiting
+// twice as long each time, see RetryPolicy.  When it runs out of
+// attempts it goes on the dead letter list, from where it can be sent
+// again by hand.  Every attempt is logged.
+
+const (
+	WebhookEventHeader     = "X-Todo-Event"
+	WebhookDeliveryHeader  = "X-Todo-Delivery"
+	WebhookAttemptHeader   = "X-Todo-Attempt"
+	WebhookTimestampHeader = "X-Todo-Timestamp"
+	WebhookSignatureHeader = "X-Todo-Signature"
+)
+
+// maxAttemptLog is how many delivery attempts are kept per webhook
+const maxAttemptLog = 100
+
+// maxDeadLetters is how many dead letters are kept, the oldest go first
+const maxDeadLetters = 1000
+
+// maxConcurrentDeliveries is how many requests the webhooks send at once
+const maxConcurrentDeliveries = 16
+
+var (
+	ErrWebhookNotFound    = errors.New("webhook does not exist")
+	ErrInvalidWebhook     = errors.New("invalid webhook")
+	ErrDeadLetterNotFound = errors.New("dead letter does not exist")
+)
+
+// Webhook is a registered callback.  Events lists the types it wants,
+// for example todo.add, an empty list means every type.  The secret is
+// only shown when the webhook is created.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks a webhook before it is registered
+func (h Webhook) Validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: the url must be an absolute http or https url", ErrInvalidWebhook)
+	}
+	for _, t := range h.Events {
+		if t != StreamType(ToDoAddEvent) && t != StreamType(ToDoUpdateEvent) && t != StreamType(ToDoDeleteEvent) {
+			return fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+	}
+	return nil
+}
+
+// wants returns true if the webhook is sent events of type eventType
+func (h Webhook) wants(eventType string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, t := range h.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryAttempt is the log entry of a single request to a webhook
+type DeliveryAttempt struct {
+	WebhookID  int       `json:"webhook_id"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	Success    bool      `json:"success"`
+}
+
+// DeadLetter is a delivery that ran out of attempts
+type DeadLetter struct {
+	ID        int             `json:"id"`
+	WebhookID int             `json:"webhook_id"`
+	EventID   string          `json:"event_id"`
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  time.Time       `json:"failed_at"`
+}
+
+// RetryPolicy says how often a delivery is tried, and how long to wait
+// in between.  The wait starts at InitialBackoff and doubles with every
+// attempt, up to MaxBackoff.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy tries for about a minute, 1s, 2s, 4s, 8s, 16s and 32s
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    7,
+	InitialBackoff: time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+// Backoff returns how long to wait before attempt number attempt, the
+// first attempt does not wait
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	wait := p.InitialBackoff
+	for i := 2; i < attempt && wait < p.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// SignWebhook returns the signature of body, sent at timestamp, as it is
+// sent in the X-Todo-Signature header
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher is the subscriber that keeps the webhooks and sends
+// them the events
+type WebhookDispatcher struct {
+	lock        sync.Mutex
+	hooks       map[int]Webhook
+	nextId      int
+	attempts    map[int][]DeliveryAttempt
+	deadLetters []DeadLetter
+	nextDeadId  int
+	retry       RetryPolicy
+	closed      bool
+
+	client  *http.Client
+	sending chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+func NewWebhookDispatcher() *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &WebhookDispatcher{
+		hooks:      make(map[int]Webhook),
+		nextId:     1,
+		attempts:   make(map[int][]DeliveryAttempt),
+		nextDeadId: 1,
+		retry:      DefaultRetryPolicy,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		sending:    make(chan struct{}, maxConcurrentDeliveries),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Handlers are the handlers to subscribe the dispatcher with
+func (w *WebhookDispatcher) Handlers() Handlers {
+	return Handlers{
+		ToDoAddEvent:    w.dispatch,
+		ToDoUpdateEvent: w.dispatch,
+		ToDoDeleteEvent: w.dispatch,
+	}
+}
+
+// SetRetryPolicy changes the retry policy of the deliveries that start
+// from now on
+func (w *WebhookDispatcher) SetRetryPolicy(policy RetryPolicy) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	w.retry = policy
+}
+
+// Add registers a webhook, it gets the next id, and a secret if it has
+// none.  The webhook is returned with its secret.
+func (w *WebhookDispatcher) Add(hook Webhook) (Webhook, error) {
+	if err := hook.Validate(); err != nil {
+		return Webhook{}, err
+	}
+	if hook.Secret == "" {
+		hook.Secret = NewID()
+	}
+	if hook.Events == nil {
+		hook.Events = []string{}
+	}
+	hook.CreatedAt = time.Now().UTC()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	hook.ID = w.nextId
+	w.nextId++
+	w.hooks[hook.ID] = hook
+	return hook, nil
+}
+
+// Get returns the webhook with id, without its secret
+func (w *WebhookDispatcher) Get(id int) (Webhook, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	hook, ok := w.hooks[id]
+	if !ok {
+		return Webhook{}, ErrWebhookNotFound
+	}
+	hook.Secret = ""
+	return hook, nil
+}
+
+// List returns every webhook by id, without their secrets
+func (w *WebhookDispatcher) List() []Webhook {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	hooks := make([]Webhook, 0, len(w.hooks))
+	for _, hook := range w.hooks {
+		hook.Secret = ""
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+	return hooks
+}
+
+// Delete removes a webhook and its log, deliveries that are waiting
+// to be tried again are dropped
+func (w *WebhookDispatcher) Delete(id int) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, ok := w.hooks[id]; !ok {
+		return ErrWebhookNotFound
+	}
+	delete(w.hooks, id)
+	delete(w.attempts, id)
+	return nil
+}
+
+// Attempts returns the last delivery attempts of a webhook, oldest first
+func (w *WebhookDispatcher) Attempts(id int) ([]DeliveryAttempt, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, ok := w.hooks[id]; !ok {
+		return nil, ErrWebhookNotFound
+	}
+	return append([]DeliveryAttempt{}, w.attempts[id]...), nil
+}
+
+// DeadLetters returns the deliveries that ran out of attempts, oldest
+// first
+func (w *WebhookDispatcher) DeadLetters() []DeadLetter {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return append([]DeadLetter{}, w.deadLetters...)
+}
+
+// Redeliver takes a dead letter off the list and sends it again, with a
+// fresh set of attempts
+func (w *WebhookDispatcher) Redeliver(id int) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for i, dead := range w.deadLetters {
+		if dead.ID != id {
+			continue
+		}
+		hook, ok := w.hooks[dead.WebhookID]
+		if !ok {
+			return ErrWebhookNotFound
+		}
+		w.deadLetters = append(w.deadLetters[:i], w.deadLetters[i+1:]...)
+		w.start(hook, dead.EventID, dead.EventType, dead.Payload)
+		return nil
+	}
+	return ErrDeadLetterNotFound
+}
+
+// DeleteDeadLetter throws a dead letter away
+func (w *WebhookDispatcher) DeleteDeadLetter(id int) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for i, dead := range w.deadLetters {
+		if dead.ID == id {
+			w.deadLetters = append(w.deadLetters[:i], w.deadLetters[i+1:]...)
+			return nil
+		}
+	}
+	return ErrDeadLetterNotFound
+}
+
+// Close stops the deliveries and waits for them, or until ctx is done.
+// A delivery that is stopped before it succeeds goes on the dead letter
+// list.
+func (w *WebhookDispatcher) Close(ctx context.Context) error {
+	w.lock.Lock()
+	w.closed = true
+	w.lock.Unlock()
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *WebhookDispatcher) dispatch(event *ToDoEvent) {
+	envelope, err := NewStreamEnvelope(event)
+	if err != nil {
+		log.Printf("Error sending event %s to the webhooks: %v", event.ID, err)
+		return
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("Error sending event %s to the webhooks: %v", event.ID, err)
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, hook := range w.hooks {
+		if hook.wants(envelope.Type) {
+			w.start(hook, envelope.ID, envelope.Type, payload)
+		}
+	}
+}
+
+// start starts a delivery in its own goroutine, the lock must be held,
+// so Close does not miss it
+func (w *WebhookDispatcher) start(hook Webhook, eventID string, eventType string, payload []byte) {
+	if w.closed {
+		return
+	}
+	w.running.Add(1)
+	go w.deliver(hook, w.retry, eventID, eventType, payload)
+}
+
+// deliver sends payload to hook until it gets a 2xx, or runs out of
+// attempts and gives up
+func (w *WebhookDispatcher) deliver(hook Webhook, policy RetryPolicy, eventID string, eventType string, payload []byte) {
+	defer w.running.Done()
+
+	var lastError string
+	attempt := 1
+	for ; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(policy.Backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				w.giveUp(hook, eventID, eventType, payload, attempt-1, "stopped: "+lastError)
+				return
+			}
+			//The webhook may have been deleted while we waited
+			if _, err := w.Get(hook.ID); err != nil {
+				return
+			}
+		}
+
+		result := w.post(hook, eventID, eventType, payload, attempt)
+		w.logAttempt(result)
+		if result.Success {
+			return
+		}
+		lastError = result.Error
+	}
+	w.giveUp(hook, eventID, eventType, payload, policy.MaxAttempts, lastError)
+}
+
+// post makes a single attempt
+func (w *WebhookDispatcher) post(hook Webhook, eventID string, eventType string, payload []byte, attempt int) DeliveryAttempt {
+	result := DeliveryAttempt{
+		WebhookID: hook.ID,
+		EventID:   eventID,
+		EventType: eventType,
+		Attempt:   attempt,
+		Time:      time.Now().UTC(),
+	}
+
+	w.sending <- struct{}{}
+	defer func() { <-w.sending }()
+
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-webhooks")
+	req.Header.Set(WebhookEventHeader, eventType)
+	req.Header.Set(WebhookDeliveryHeader, eventID)
+	req.Header.Set(WebhookAttemptHeader, strconv.Itoa(attempt))
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, timestamp, payload))
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	result.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	//Read some of the body, so the connection can be used again
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !result.Success {
+		result.Error = resp.Status
+	}
+	return result
+}
+
+func (w *WebhookDispatcher) logAttempt(attempt DeliveryAttempt) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	//A webhook that was deleted has no log anymore
+	if _, ok := w.hooks[attempt.WebhookID]; !ok {
+		return
+	}
+	attempts := append(w.attempts[attempt.WebhookID], attempt)
+	if len(attempts) > maxAttemptLog {
+		attempts = attempts[len(attempts)-maxAttemptLog:]
+	}
+	w.attempts[attempt.WebhookID] = attempts
+}
+
+func (w *WebhookDispatcher) giveUp(hook Webhook, eventID string, eventType string, payload []byte, attempts int, lastError string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	//Nobody wants the events of a webhook that was deleted
+	if _, ok := w.hooks[hook.ID]; !ok {
+		return
+	}
+	log.Printf("Giving up on sending event %s to webhook %d after %d attempts: %s", eventID, hook.ID, attempts, lastError)
+
+	w.deadLetters = append(w.deadLetters, DeadLetter{
+		ID:        w.nextDeadId,
+		WebhookID: hook.ID,
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+		Attempts:  attempts,
+		LastError: lastError,
+		FailedAt:  time.Now().UTC(),
+	})
+	w.nextDeadId++
+	if len(w.deadLetters) > maxDeadLetters {
+		w.deadLetters = w.deadLetters[len(w.deadLetters)-maxDeadLetters:]
+	}
+}
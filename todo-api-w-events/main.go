@@ -1,20 +1,26 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 
 	"drexel.edu/todo-events/api"
+	"drexel.edu/todo-events/events"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 )
 
 // Global variables to hold the command line flags to drive the todo CLI
 // application
 var (
-	hostFlag string
-	portFlag uint
+	hostFlag     string
+	portFlag     uint
+	redisFlag    string
+	streamFlag   string
+	eventLogFlag string
 )
 
 // processCmdLineFlags parses the command line flags for our CLI
@@ -48,6 +54,18 @@ func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
 
+	//The add, update and delete events are published to a Redis Stream
+	//when we are given a redis address, for example:
+	//go run main.go --redis localhost:6379
+	flag.StringVar(&redisFlag, "redis", os.Getenv("REDIS_URL"),
+		"Publish the todo changes to this redis (env REDIS_URL), leave empty to not publish")
+	flag.StringVar(&streamFlag, "stream", events.DefaultStream, "The stream to publish the todo changes to")
+
+	//In event sourcing mode the todos are kept in an event log, and
+	//survive a restart, for example: go run main.go --event-log ./data/events
+	flag.StringVar(&eventLogFlag, "event-log", os.Getenv("TODO_EVENT_LOG"),
+		"Keep the todos in an event log in this directory (env TODO_EVENT_LOG), leave empty to keep them in memory")
+
 	flag.Parse()
 }
 
@@ -58,8 +76,15 @@ func main() {
 	processCmdLineFlags()
 	r := gin.Default()
 	r.Use(cors.Default())
+	r.Use(api.RequestID())
 
-	apiHandler, err := api.New()
+	var apiHandler *api.ToDoAPI
+	var err error
+	if eventLogFlag != "" {
+		apiHandler, err = api.NewWithEventLog(eventLogFlag)
+	} else {
+		apiHandler, err = api.New()
+	}
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -67,12 +92,40 @@ func main() {
 
 	apiHandler.AddEventListener()
 
+	if redisFlag != "" {
+		client := redis.NewClient(&redis.Options{Addr: redisFlag})
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			fmt.Println("Error connecting to redis: ", err)
+			os.Exit(1)
+		}
+		publisher := events.NewStreamPublisher(client, streamFlag, events.DefaultStreamMaxLen)
+		err := apiHandler.Subscribe("stream", publisher.Handlers(),
+			events.SubscribeOptions{BufferSize: 1024})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	r.GET("/todo", apiHandler.ListAllTodos)
 	r.POST("/todo", apiHandler.AddToDo)
 	r.PUT("/todo", apiHandler.UpdateToDo)
 	r.DELETE("/todo", apiHandler.DeleteAllToDo)
 	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
 	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.GET("/todo/:id/as-of", apiHandler.GetToDoAsOf)
+	r.GET("/todo/events", apiHandler.TodoEvents)
+	r.GET("/todo/events/ws", apiHandler.TodoEventsWS)
+
+	//Webhooks are sent every add, update and delete
+	r.GET("/webhooks", apiHandler.ListWebhooks)
+	r.POST("/webhooks", apiHandler.AddWebhook)
+	r.GET("/webhooks/:id", apiHandler.GetWebhook)
+	r.DELETE("/webhooks/:id", apiHandler.DeleteWebhook)
+	r.GET("/webhooks/:id/deliveries", apiHandler.ListWebhookDeliveries)
+	r.GET("/webhooks/dead-letters", apiHandler.ListDeadLetters)
+	r.POST("/webhooks/dead-letters/:id/retry", apiHandler.RetryDeadLetter)
+	r.DELETE("/webhooks/dead-letters/:id", apiHandler.DeleteDeadLetter)
 
 	//These are some extra endpoints that will be used to demonstrate
 	//a few resiliency features of GoLang Gin, and healthchecks
@@ -80,6 +133,10 @@ func main() {
 	r.GET("/health", apiHandler.HealthCheck)
 	r.GET("/event/:enableFlag", apiHandler.EventEnabler)
 
+	//The event configuration can be changed while the api is running
+	r.GET("/events/config", apiHandler.GetEventConfig)
+	r.PUT("/events/config", apiHandler.UpdateEventConfig)
+
 	//We will now show a common way to version an API and add a new
 	//version of an API handler under /v2.  This new API will support
 	//a path parameter to search for todos based on a status
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"drexel.edu/todo-events/api"
+	"drexel.edu/todo-events/db"
+	"drexel.edu/todo-events/events"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newConfigRouter is newTestRouter with the /events/config routes, and a
+// subscriber that counts the adds and updates it gets
+func newConfigRouter(t *testing.T) (*gin.Engine, *api.ToDoAPI, *atomic.Int32) {
+	r, apiHandler := newTestRouter(t, nil)
+	t.Cleanup(apiHandler.StopEventListener)
+	r.GET("/events/config", apiHandler.GetEventConfig)
+	r.PUT("/events/config", apiHandler.UpdateEventConfig)
+	r.GET("/event/:enableFlag", apiHandler.EventEnabler)
+
+	var count atomic.Int32
+	handler := func(event *events.ToDoEvent) { count.Add(1) }
+	err := apiHandler.Subscribe("count", events.Handlers{
+		events.ToDoAddEvent:    handler,
+		events.ToDoUpdateEvent: handler,
+	}, events.SubscribeOptions{Policy: events.Block})
+	require.NoError(t, err)
+	return r, apiHandler, &count
+}
+
+func getEventConfig(t *testing.T, r *gin.Engine) events.ManagerStatus {
+	w := doRequest(r, http.MethodGet, "/events/config", nil, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status events.ManagerStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	return status
+}
+
+func subscriberStats(status events.ManagerStatus, name string) events.SubscriberStats {
+	for _, stats := range status.Subscribers {
+		if stats.Name == name {
+			return stats
+		}
+	}
+	return events.SubscriberStats{}
+}
+
+func Test_NoEventListener(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	apiHandler, err := api.New()
+	require.NoError(t, err)
+	r := gin.New()
+	r.POST("/todo", apiHandler.AddToDo)
+	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.GET("/events/config", apiHandler.GetEventConfig)
+	r.GET("/event/:enableFlag", apiHandler.EventEnabler)
+
+	//Without eventing the todos still work, there is just no event
+	w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodGet, "/todo/1", nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(r, http.MethodGet, "/events/config", nil, "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = doRequest(r, http.MethodGet, "/event/true", nil, "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.ErrorIs(t, apiHandler.Subscribe("count", events.Handlers{}, events.SubscribeOptions{}), api.ErrNoEventing)
+	apiHandler.StopEventListener()
+}
+
+func Test_EventConfig(t *testing.T) {
+	r, _, count := newConfigRouter(t)
+
+	status := getEventConfig(t, r)
+	assert.True(t, status.Enabled)
+	assert.Len(t, status.Types, len(events.AllEventIDs))
+	assert.True(t, status.Types["todo.add"])
+	assert.Equal(t, events.SubscriberStats{Name: "count", Capacity: events.DefaultBufferSize}, subscriberStats(status, "count"))
+
+	//Adds are turned off, updates are still sent
+	w := doRequest(r, http.MethodPut, "/events/config", gin.H{"types": gin.H{"todo.add": false}}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodPut, "/todo", db.ToDoItem{Id: 1, Title: "Paint the fence white"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Eventually(t, func() bool {
+		return subscriberStats(getEventConfig(t, r), "count").Delivered == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	status = getEventConfig(t, r)
+	assert.False(t, status.Types["todo.add"])
+	assert.Equal(t, uint64(1), status.Skipped)
+	assert.Equal(t, int32(1), count.Load())
+
+	//An unknown type changes nothing, not even the types that are known
+	w = doRequest(r, http.MethodPut, "/events/config", gin.H{"types": gin.H{"todo.add": true, "todo.bogus": false}}, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.False(t, getEventConfig(t, r).Types["todo.add"])
+	w = doRequest(r, http.MethodPut, "/events/config", "not an object", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	//Turning eventing off skips every event, until it is turned on again
+	w = doRequest(r, http.MethodPut, "/events/config", gin.H{"enabled": false, "types": gin.H{"todo.add": true}}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Mow the lawn"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	status = getEventConfig(t, r)
+	assert.False(t, status.Enabled)
+	assert.Equal(t, uint64(2), status.Skipped)
+
+	w = doRequest(r, http.MethodGet, "/event/true", nil, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Wash the car"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Eventually(t, func() bool { return count.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, getEventConfig(t, r).Enabled)
+}
+
+func Test_EventConfigWhileBusy(t *testing.T) {
+	r, _, _ := newConfigRouter(t)
+
+	//Requests keep coming while eventing is turned off and on, run with
+	//-race to check that this is safe
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "")
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		enabled := i%2 == 1
+		w := doRequest(r, http.MethodPut, "/events/config", gin.H{"enabled": enabled, "types": gin.H{"todo.query": enabled}}, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	wg.Wait()
+
+	//Every add was either sent to the count subscriber or skipped
+	assert.True(t, getEventConfig(t, r).Enabled)
+	assert.Eventually(t, func() bool {
+		status := getEventConfig(t, r)
+		return subscriberStats(status, "count").Delivered+status.Skipped == 100
+	}, 5*time.Second, 10*time.Millisecond)
+}
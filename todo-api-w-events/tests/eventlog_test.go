@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"drexel.edu/todo-events/api"
+	"drexel.edu/todo-events/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openEventLog opens the event log in dir, and closes it when the test
+// is over
+func openEventLog(t *testing.T, dir string, snapshotEvery int) *db.ToDo {
+	store, err := db.NewWithEventLog(dir, snapshotEvery)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func Test_EventLogReplay(t *testing.T) {
+	dir := t.TempDir()
+	store := openEventLog(t, dir, 3)
+
+	for _, title := range []string{"one", "two", "three", "four", "five"} {
+		_, err := store.AddItem(db.ToDoItem{Title: title})
+		require.NoError(t, err)
+	}
+	_, err := store.UpdateItem(db.ToDoItem{Id: 2, Title: "two, done", IsDone: true})
+	require.NoError(t, err)
+	_, _, err = store.DeleteItem(5)
+	require.NoError(t, err)
+	before, err := store.GetAllItems()
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	//7 events with a snapshot every 3, the snapshot is at event 6
+	data, err := os.ReadFile(filepath.Join(dir, "snapshot.json"))
+	require.NoError(t, err)
+	var snap struct {
+		Seq uint64 `json:"seq"`
+	}
+	require.NoError(t, json.Unmarshal(data, &snap))
+	assert.Equal(t, uint64(6), snap.Seq)
+
+	reopened := openEventLog(t, dir, 3)
+	after, err := reopened.GetAllItems()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, before, after)
+
+	//The ids are not handed out again, 5 was deleted
+	item, err := reopened.AddItem(db.ToDoItem{Title: "six"})
+	require.NoError(t, err)
+	assert.Equal(t, 6, item.Id)
+
+	//Without the snapshot the whole log is replayed, with the same result
+	require.NoError(t, reopened.Close())
+	require.NoError(t, os.Remove(filepath.Join(dir, "snapshot.json")))
+	replayed := openEventLog(t, dir, 3)
+	items, err := replayed.GetAllItems()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, append(before, item), items)
+}
+
+func Test_EventLogUnfinishedWrite(t *testing.T) {
+	dir := t.TempDir()
+	store := openEventLog(t, dir, 0)
+	_, err := store.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	//A crash in the middle of a write
+	logFile := filepath.Join(dir, "events.log")
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"seq":2,"type":"add","item":{"id":2,"ti`)
+	require.NoError(t, err)
+	file.Close()
+
+	reopened := openEventLog(t, dir, 0)
+	items, err := reopened.GetAllItems()
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+	item, err := reopened.AddItem(db.ToDoItem{Title: "two"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, item.Id)
+	require.NoError(t, reopened.Close())
+
+	again := openEventLog(t, dir, 0)
+	items, err = again.GetAllItems()
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+}
+
+func Test_EventLogCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	store := openEventLog(t, dir, 0)
+	_, err := store.AddItem(db.ToDoItem{Title: "one"})
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	//A broken line in the middle is not a crash, we do not guess
+	logFile := filepath.Join(dir, "events.log")
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(logFile, append([]byte("not json\n"), data...), 0644))
+
+	_, err = db.NewWithEventLog(dir, 0)
+	assert.Error(t, err)
+}
+
+func Test_ItemAsOf(t *testing.T) {
+	store := openEventLog(t, t.TempDir(), 0)
+
+	_, err := store.AddItem(db.ToDoItem{Title: "Paint the fence"})
+	require.NoError(t, err)
+	_, err = store.AddItem(db.ToDoItem{Title: "Mow the lawn"})
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	afterAdd := time.Now()
+	time.Sleep(5 * time.Millisecond)
+	_, err = store.UpdateItem(db.ToDoItem{Id: 1, Title: "Paint the fence white"})
+	require.NoError(t, err)
+	_, _, err = store.DeleteItem(1)
+	require.NoError(t, err)
+	_, err = store.DeleteAll()
+	require.NoError(t, err)
+	_, err = store.AddItem(db.ToDoItem{Id: 1, Title: "Paint the gate"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		seq       uint64
+		title     string
+		changedAt uint64
+	}{
+		{0, "", 0},
+		{1, "Paint the fence", 1},
+		{2, "Paint the fence", 1},
+		{3, "Paint the fence white", 3},
+		{4, "", 0},
+		{5, "", 0},
+		{6, "Paint the gate", 6},
+		{100, "Paint the gate", 6},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.FormatUint(tt.seq, 10), func(t *testing.T) {
+			item, changedAt, err := store.ItemAtSeq(1, tt.seq)
+			if tt.title == "" {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.title, item.Title)
+			assert.Equal(t, tt.changedAt, changedAt)
+		})
+	}
+
+	item, changedAt, err := store.ItemAtTime(1, afterAdd)
+	require.NoError(t, err)
+	assert.Equal(t, "Paint the fence", item.Title)
+	assert.Equal(t, uint64(1), changedAt)
+	_, _, err = store.ItemAtTime(2, afterAdd.Add(-time.Hour))
+	assert.Error(t, err)
+
+	//Without an event log there is no history
+	memory, err := db.New()
+	require.NoError(t, err)
+	_, _, err = memory.ItemAtSeq(1, 1)
+	assert.ErrorIs(t, err, db.ErrNoEventLog)
+}
+
+func Test_AsOfEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	apiHandler, err := api.NewWithEventLog(t.TempDir())
+	require.NoError(t, err)
+	apiHandler.AddEventListener()
+	t.Cleanup(apiHandler.StopEventListener)
+	r := gin.New()
+	r.POST("/todo", apiHandler.AddToDo)
+	r.PUT("/todo", apiHandler.UpdateToDo)
+	r.GET("/todo/:id/as-of", apiHandler.GetToDoAsOf)
+
+	w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var added db.ToDoItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+	at := added.UpdatedAt.Format(time.RFC3339Nano)
+	time.Sleep(5 * time.Millisecond)
+	w = doRequest(r, http.MethodPut, "/todo", db.ToDoItem{Id: 1, Title: "Paint the fence white"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	tests := []struct {
+		query  string
+		status int
+		title  string
+		seq    string
+	}{
+		{"seq=1", http.StatusOK, "Paint the fence", "1"},
+		{"seq=2", http.StatusOK, "Paint the fence white", "2"},
+		{"at=" + at, http.StatusOK, "Paint the fence", "1"},
+		{"at=2000-01-01T00:00:00Z", http.StatusNotFound, "", ""},
+		{"", http.StatusBadRequest, "", ""},
+		{"seq=1&at=" + at, http.StatusBadRequest, "", ""},
+		{"seq=-1", http.StatusBadRequest, "", ""},
+		{"at=yesterday", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := doRequest(r, http.MethodGet, "/todo/1/as-of?"+tt.query, nil, "")
+			require.Equal(t, tt.status, w.Code)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var item db.ToDoItem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+			assert.Equal(t, tt.title, item.Title)
+			assert.Equal(t, tt.seq, w.Header().Get(api.EventSeqHeader))
+		})
+	}
+
+	//The in memory api has no history
+	memory, _ := newTestRouter(t, nil)
+	w = doRequest(memory, http.MethodGet, "/todo/1/as-of?seq=1", nil, "")
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+}
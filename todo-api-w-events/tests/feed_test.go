@@ -0,0 +1,233 @@
+package tests
+
+//These tests follow /todo/events from a real http server, the feed is
+//a stream that never ends, which httptest.NewRecorder cannot handle.
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo-events/api"
+	"drexel.edu/todo-events/db"
+	"drexel.edu/todo-events/events"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent is a single Server-Sent Event
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// envelope decodes the data of the event
+func (e sseEvent) envelope(t *testing.T) events.StreamEnvelope {
+	var envelope events.StreamEnvelope
+	require.NoError(t, json.Unmarshal([]byte(e.Data), &envelope))
+	return envelope
+}
+
+// newTestServer starts a server with the routes of newTestRouter
+func newTestServer(t *testing.T) *httptest.Server {
+	r, apiHandler := newTestRouter(t, nil)
+	srv := httptest.NewServer(r)
+	t.Cleanup(apiHandler.StopEventListener)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// followSSE opens the SSE feed at path, with the Last-Event-ID header
+// set to lastID unless it is empty, and returns the events as they come
+func followSSE(t *testing.T, srv *httptest.Server, path string, lastID string) <-chan sseEvent {
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	require.NoError(t, err)
+	if lastID != "" {
+		req.Header.Set(api.LastEventIDHeader, lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	t.Cleanup(func() { resp.Body.Close() })
+
+	feed := make(chan sseEvent, 100)
+	go func() {
+		defer close(feed)
+		var event sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				feed <- event
+				event = sseEvent{}
+				continue
+			}
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "id":
+				event.ID = value
+			case "event":
+				event.Event = value
+			case "data":
+				event.Data = value
+			}
+		}
+	}()
+	return feed
+}
+
+// nextSSE waits for the next event of feed
+func nextSSE(t *testing.T, feed <-chan sseEvent) sseEvent {
+	select {
+	case event, ok := <-feed:
+		require.True(t, ok, "the feed was closed")
+		return event
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event came")
+	}
+	return sseEvent{}
+}
+
+// noSSE checks that feed sends nothing for a little while
+func noSSE(t *testing.T, feed <-chan sseEvent) {
+	select {
+	case event := <-feed:
+		assert.Fail(t, "unexpected event", "%+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func addItem(t *testing.T, srv *httptest.Server, item db.ToDoItem) db.ToDoItem {
+	data, _ := json.Marshal(item)
+	resp, err := http.Post(srv.URL+"/todo", "application/json", strings.NewReader(string(data)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&item))
+	return item
+}
+
+func updateItem(t *testing.T, srv *httptest.Server, item db.ToDoItem) {
+	data, _ := json.Marshal(item)
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/todo", strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func Test_SSEFeed(t *testing.T) {
+	srv := newTestServer(t)
+	feed := followSSE(t, srv, "/todo/events", "")
+
+	added := addItem(t, srv, db.ToDoItem{Title: "Paint the fence"})
+	event := nextSSE(t, feed)
+	envelope := event.envelope(t)
+	assert.Equal(t, "todo.add", event.Event)
+	assert.Equal(t, envelope.ID, event.ID)
+	assert.Equal(t, "todo.add", envelope.Type)
+	assert.Equal(t, "Paint the fence", decodeItem(t, envelope.After).Title)
+	assert.Nil(t, decodeItem(t, envelope.Before))
+
+	added.IsDone = true
+	updateItem(t, srv, added)
+	event = nextSSE(t, feed)
+	assert.Equal(t, "todo.update", event.Event)
+	assert.True(t, decodeItem(t, event.envelope(t).After).IsDone)
+}
+
+func Test_SSEFeedDoneFilter(t *testing.T) {
+	srv := newTestServer(t)
+	open := followSSE(t, srv, "/todo/events?done=false", "")
+
+	addItem(t, srv, db.ToDoItem{Title: "Already done", IsDone: true})
+	item := addItem(t, srv, db.ToDoItem{Title: "Paint the fence"})
+	event := nextSSE(t, open)
+	assert.Equal(t, "Paint the fence", decodeItem(t, event.envelope(t).After).Title)
+
+	//Completing an item still shows up, so the client can drop it
+	item.IsDone = true
+	updateItem(t, srv, item)
+	event = nextSSE(t, open)
+	assert.Equal(t, "todo.update", event.Event)
+	assert.False(t, decodeItem(t, event.envelope(t).Before).IsDone)
+	noSSE(t, open)
+
+	resp, err := http.Get(srv.URL + "/todo/events?done=maybe")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func Test_SSEFeedResume(t *testing.T) {
+	srv := newTestServer(t)
+	first := followSSE(t, srv, "/todo/events", "")
+
+	var ids []string
+	for _, title := range []string{"one", "two", "three"} {
+		addItem(t, srv, db.ToDoItem{Title: title})
+		ids = append(ids, nextSSE(t, first).ID)
+	}
+
+	//The client comes back after it saw "one", it gets the rest, in
+	//order, and then the live events
+	resumed := followSSE(t, srv, "/todo/events", ids[0])
+	assert.Equal(t, ids[1], nextSSE(t, resumed).ID)
+	assert.Equal(t, ids[2], nextSSE(t, resumed).ID)
+	addItem(t, srv, db.ToDoItem{Title: "four"})
+	event := nextSSE(t, resumed)
+	assert.Equal(t, "four", decodeItem(t, event.envelope(t).After).Title)
+
+	//The client saw everything there is, nothing is replayed
+	latest := followSSE(t, srv, "/todo/events", event.ID)
+	noSSE(t, latest)
+
+	//An event the api does not know, it is too old or from before a
+	//restart, so the client has to start over
+	reset := followSSE(t, srv, "/todo/events", "not-an-event")
+	assert.Equal(t, api.ResetEventType, nextSSE(t, reset).Event)
+	addItem(t, srv, db.ToDoItem{Title: "five"})
+	assert.Equal(t, "todo.add", nextSSE(t, reset).Event)
+}
+
+func Test_WebSocketFeed(t *testing.T) {
+	srv := newTestServer(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/todo/events/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?done=false", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	addItem(t, srv, db.ToDoItem{Title: "Already done", IsDone: true})
+	addItem(t, srv, db.ToDoItem{Title: "Paint the fence"})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var envelope events.StreamEnvelope
+	require.NoError(t, conn.ReadJSON(&envelope))
+	assert.Equal(t, "todo.add", envelope.Type)
+	assert.Equal(t, "Paint the fence", decodeItem(t, envelope.After).Title)
+
+	//A browser WebSocket cannot set Last-Event-ID, so it resumes with
+	//the query parameter
+	resumed, _, err := websocket.DefaultDialer.Dial(url+"?last_event_id=not-an-event", nil)
+	require.NoError(t, err)
+	defer resumed.Close()
+	resumed.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reset map[string]any
+	require.NoError(t, resumed.ReadJSON(&reset))
+	assert.Equal(t, api.ResetEventType, reset["type"])
+
+	//A plain GET is not a websocket
+	resp, err := http.Get(srv.URL + "/todo/events/ws")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,326 @@
+package tests
+
+//These tests publish to miniredis, an in process stand-in for redis, so
+//they do not need a redis container.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/todo-events/api"
+	"drexel.edu/todo-events/db"
+	"drexel.edu/todo-events/events"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testStream = "todo:events:test"
+
+// newTestRedis starts a miniredis and returns a client for it
+func newTestRedis(t *testing.T) *redis.Client {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newTestRouter returns a router with the /todo routes of main.go, if
+// client is not nil its events are published to it
+func newTestRouter(t *testing.T, client *redis.Client) (*gin.Engine, *api.ToDoAPI) {
+	gin.SetMode(gin.TestMode)
+
+	apiHandler, err := api.New()
+	require.NoError(t, err)
+	apiHandler.AddEventListener()
+	if client != nil {
+		publisher := events.NewStreamPublisher(client, testStream, 0)
+		//Block, so no event is dropped while the test is checking them
+		err = apiHandler.Subscribe("stream", publisher.Handlers(),
+			events.SubscribeOptions{Policy: events.Block})
+		require.NoError(t, err)
+	}
+
+	r := gin.New()
+	r.Use(api.RequestID())
+	r.POST("/todo", apiHandler.AddToDo)
+	r.PUT("/todo", apiHandler.UpdateToDo)
+	r.DELETE("/todo", apiHandler.DeleteAllToDo)
+	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
+	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.GET("/todo/:id/as-of", apiHandler.GetToDoAsOf)
+	r.GET("/todo/events", apiHandler.TodoEvents)
+	r.GET("/todo/events/ws", apiHandler.TodoEventsWS)
+	r.GET("/webhooks", apiHandler.ListWebhooks)
+	r.POST("/webhooks", apiHandler.AddWebhook)
+	r.GET("/webhooks/:id", apiHandler.GetWebhook)
+	r.DELETE("/webhooks/:id", apiHandler.DeleteWebhook)
+	r.GET("/webhooks/:id/deliveries", apiHandler.ListWebhookDeliveries)
+	r.GET("/webhooks/dead-letters", apiHandler.ListDeadLetters)
+	r.POST("/webhooks/dead-letters/:id/retry", apiHandler.RetryDeadLetter)
+	r.DELETE("/webhooks/dead-letters/:id", apiHandler.DeleteDeadLetter)
+	return r, apiHandler
+}
+
+// doRequest sends a request with the X-Request-ID header set to
+// requestID
+func doRequest(r http.Handler, method string, path string, body interface{}, requestID string) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(api.RequestIDHeader, requestID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// readAll reads every event on the stream with a new consumer group
+func readAll(t *testing.T, client *redis.Client, group string) []events.StreamMessage {
+	ctx := context.Background()
+	require.NoError(t, events.CreateGroup(ctx, client, testStream, group, "0"))
+	messages, err := events.ReadGroup(ctx, client, testStream, group, "reader", 100, 0)
+	require.NoError(t, err)
+	return messages
+}
+
+func decodeItem(t *testing.T, data json.RawMessage) *db.ToDoItem {
+	var item *db.ToDoItem
+	require.NoError(t, json.Unmarshal(data, &item))
+	return item
+}
+
+func Test_PublishEnvelope(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	publisher := events.NewStreamPublisher(client, testStream, 0)
+
+	item := db.ToDoItem{Id: 7, Title: "Paint the fence"}
+	event := events.NewChangeEvent(events.ToDoAddEvent, "todoItem", item, "req-1", nil, item)
+	event.Seq = 42
+	streamID, err := publisher.Publish(ctx, event)
+	require.NoError(t, err)
+
+	entries, err := client.XRange(ctx, testStream, "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, streamID, entries[0].ID)
+	assert.Equal(t, "todo.add", entries[0].Values["type"])
+
+	//The envelope is a contract with other services, so check the
+	//json itself and not just that it round trips
+	var envelope map[string]any
+	require.NoError(t, json.Unmarshal([]byte(entries[0].Values["envelope"].(string)), &envelope))
+	assert.Equal(t, float64(events.StreamEnvelopeVersion), envelope["version"])
+	assert.Equal(t, event.ID, envelope["id"])
+	assert.Len(t, event.ID, 32)
+	assert.Equal(t, "todo.add", envelope["type"])
+	assert.Equal(t, float64(42), envelope["seq"])
+	assert.Equal(t, event.Time.Format(time.RFC3339Nano), envelope["timestamp"])
+	assert.Equal(t, "req-1", envelope["request_id"])
+	assert.Nil(t, envelope["before"])
+	assert.Contains(t, envelope, "before")
+	assert.Equal(t, map[string]any{"id": float64(7), "title": "Paint the fence", "done": false}, envelope["after"])
+}
+
+func Test_PublishTrimsStream(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	publisher := events.NewStreamPublisher(client, testStream, 10)
+
+	for i := 0; i < 50; i++ {
+		event := events.NewChangeEvent(events.ToDoDeleteEvent, "id", i, "", db.ToDoItem{Id: i}, nil)
+		_, err := publisher.Publish(ctx, event)
+		require.NoError(t, err)
+	}
+
+	//The trimming is approximate, redis may keep a few more
+	length, err := client.XLen(ctx, testStream).Result()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, length, int64(10))
+	assert.Less(t, length, int64(50))
+}
+
+func Test_ConsumerGroups(t *testing.T) {
+	client := newTestRedis(t)
+	ctx := context.Background()
+	publisher := events.NewStreamPublisher(client, testStream, 0)
+
+	//Creating a group twice is fine, every service can create its group
+	//when it starts
+	require.NoError(t, events.CreateGroup(ctx, client, testStream, "audit", "$"))
+	require.NoError(t, events.CreateGroup(ctx, client, testStream, "audit", "$"))
+	require.NoError(t, events.CreateGroup(ctx, client, testStream, "search", "$"))
+
+	for i := 1; i <= 4; i++ {
+		item := db.ToDoItem{Id: i, Title: "item"}
+		_, err := publisher.Publish(ctx, events.NewChangeEvent(events.ToDoAddEvent, "todoItem", item, "", nil, item))
+		require.NoError(t, err)
+	}
+
+	//The consumers of one group share the events
+	first, err := events.ReadGroup(ctx, client, testStream, "audit", "audit-1", 3, 0)
+	require.NoError(t, err)
+	second, err := events.ReadGroup(ctx, client, testStream, "audit", "audit-2", 3, 0)
+	require.NoError(t, err)
+	assert.Len(t, first, 3)
+	assert.Len(t, second, 1)
+	assert.Equal(t, 4, decodeItem(t, second[0].Envelope.After).Id)
+
+	//Another group sees every event
+	all, err := events.ReadGroup(ctx, client, testStream, "search", "search-1", 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	//Nothing is left to read, and an empty read is not an error
+	none, err := events.ReadGroup(ctx, client, testStream, "audit", "audit-1", 10, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	//Until they are acknowledged the events are pending
+	pending, err := client.XPending(ctx, testStream, "audit").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), pending.Count)
+	for _, m := range append(first, second...) {
+		require.NoError(t, events.Ack(ctx, client, testStream, "audit", m.StreamID))
+	}
+	pending, err = client.XPending(ctx, testStream, "audit").Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+}
+
+func Test_APIPublishesChanges(t *testing.T) {
+	client := newTestRedis(t)
+	r, apiHandler := newTestRouter(t, client)
+
+	w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "req-add")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "req-add", w.Header().Get(api.RequestIDHeader))
+	var added db.ToDoItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &added))
+
+	changed := added
+	changed.Title = "Paint the fence white"
+	w = doRequest(r, http.MethodPut, "/todo", changed, "req-update")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	//Reads, and deleting an item that is not there, are not published
+	w = doRequest(r, http.MethodGet, "/todo/1", nil, "req-get")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodDelete, "/todo/99", nil, "req-missing")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = doRequest(r, http.MethodDelete, "/todo/1", nil, "req-delete")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	//Stopping drains the queue of the publisher
+	apiHandler.StopEventListener()
+
+	messages := readAll(t, client, "test")
+	require.Len(t, messages, 3)
+
+	add, update, del := messages[0].Envelope, messages[1].Envelope, messages[2].Envelope
+	assert.Equal(t, "todo.add", add.Type)
+	assert.Equal(t, "req-add", add.RequestID)
+	assert.Nil(t, decodeItem(t, add.Before))
+	assert.Equal(t, "Paint the fence", decodeItem(t, add.After).Title)
+
+	assert.Equal(t, "todo.update", update.Type)
+	assert.Equal(t, "req-update", update.RequestID)
+	assert.Equal(t, "Paint the fence", decodeItem(t, update.Before).Title)
+	assert.Equal(t, "Paint the fence white", decodeItem(t, update.After).Title)
+
+	assert.Equal(t, "todo.delete", del.Type)
+	assert.Equal(t, "req-delete", del.RequestID)
+	assert.Equal(t, "Paint the fence white", decodeItem(t, del.Before).Title)
+	assert.Nil(t, decodeItem(t, del.After))
+
+	//Sequence numbers go up, the query event in between took one too
+	assert.Less(t, add.Seq, update.Seq)
+	assert.Less(t, update.Seq, del.Seq)
+	assert.NotEqual(t, add.ID, update.ID)
+}
+
+func Test_DeleteAllPublishesEveryItem(t *testing.T) {
+	client := newTestRedis(t)
+	r, apiHandler := newTestRouter(t, client)
+
+	for _, title := range []string{"one", "two", "three"} {
+		w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: title}, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		//Without an X-Request-ID the api makes one up
+		assert.Len(t, w.Header().Get(api.RequestIDHeader), 32)
+	}
+	w := doRequest(r, http.MethodDelete, "/todo", nil, "req-delete-all")
+	require.Equal(t, http.StatusOK, w.Code)
+	apiHandler.StopEventListener()
+
+	messages := readAll(t, client, "test")
+	require.Len(t, messages, 6)
+	ids := []int{}
+	for _, m := range messages[3:] {
+		assert.Equal(t, "todo.delete", m.Envelope.Type)
+		assert.Equal(t, "req-delete-all", m.Envelope.RequestID)
+		ids = append(ids, decodeItem(t, m.Envelope.Before).Id)
+	}
+	assert.ElementsMatch(t, []int{1, 2, 3}, ids)
+}
+
+// Test_ConcurrentChangesPublishTheirOwnItems checks that every event
+// carries the item it replaced, even with other requests changing the
+// same item at the same time
+func Test_ConcurrentChangesPublishTheirOwnItems(t *testing.T) {
+	client := newTestRedis(t)
+	r, apiHandler := newTestRouter(t, client)
+
+	for _, title := range []string{"update me", "delete me"} {
+		w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: title}, "")
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	const workers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item := db.ToDoItem{Id: 1, Title: "update " + string(rune('a'+i))}
+			w := doRequest(r, http.MethodPut, "/todo", item, "")
+			assert.Equal(t, http.StatusOK, w.Code)
+			w = doRequest(r, http.MethodDelete, "/todo/2", nil, "")
+			assert.Equal(t, http.StatusOK, w.Code)
+		}(i)
+	}
+	wg.Wait()
+	apiHandler.StopEventListener()
+
+	//Every update replaced a different version of the item, and only
+	//one of the deletes found the item to delete
+	befores := map[string]bool{}
+	afters := map[string]bool{"update me": true}
+	deletes := 0
+	for _, m := range readAll(t, client, "test")[2:] {
+		switch m.Envelope.Type {
+		case "todo.update":
+			before := decodeItem(t, m.Envelope.Before).Title
+			assert.False(t, befores[before], "two updates replaced %q", before)
+			befores[before] = true
+			afters[decodeItem(t, m.Envelope.After).Title] = true
+		case "todo.delete":
+			deletes++
+			assert.Equal(t, "delete me", decodeItem(t, m.Envelope.Before).Title)
+		}
+	}
+	assert.Len(t, befores, workers)
+	for before := range befores {
+		assert.True(t, afters[before], "%q was never stored", before)
+	}
+	assert.Equal(t, 1, deletes)
+}
@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"drexel.edu/todo-events/db"
+	"drexel.edu/todo-events/events"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fastRetries keeps the retry tests short
+var fastRetries = events.RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     50 * time.Millisecond,
+}
+
+// receivedHook is a request a test receiver got
+type receivedHook struct {
+	Header http.Header
+	Body   []byte
+}
+
+// newReceiver starts a server that answers every request with the
+// status status returns, and hands the requests to the channel
+func newReceiver(t *testing.T, status func() int) (*httptest.Server, <-chan receivedHook) {
+	received := make(chan receivedHook, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedHook{Header: r.Header.Clone(), Body: body}
+		w.WriteHeader(status())
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received
+}
+
+func nextHook(t *testing.T, received <-chan receivedHook) receivedHook {
+	select {
+	case hook := <-received:
+		return hook
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no webhook came")
+	}
+	return receivedHook{}
+}
+
+func registerWebhook(t *testing.T, r *gin.Engine, hook events.Webhook) events.Webhook {
+	w := doRequest(r, http.MethodPost, "/webhooks", hook, "")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &hook))
+	return hook
+}
+
+func Test_WebhookSignedDelivery(t *testing.T) {
+	r, apiHandler := newTestRouter(t, nil)
+	t.Cleanup(apiHandler.StopEventListener)
+	receiver, received := newReceiver(t, func() int { return http.StatusNoContent })
+
+	hook := registerWebhook(t, r, events.Webhook{
+		URL:    receiver.URL,
+		Events: []string{"todo.add", "todo.delete"},
+	})
+	assert.Equal(t, 1, hook.ID)
+	assert.Len(t, hook.Secret, 32)
+
+	//The secret is only shown once
+	w := doRequest(r, http.MethodGet, "/webhooks", nil, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), hook.Secret)
+
+	w = doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "req-add")
+	require.Equal(t, http.StatusOK, w.Code)
+	var item db.ToDoItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+
+	got := nextHook(t, received)
+	assert.Equal(t, "todo.add", got.Header.Get(events.WebhookEventHeader))
+	assert.Equal(t, "1", got.Header.Get(events.WebhookAttemptHeader))
+	timestamp := got.Header.Get(events.WebhookTimestampHeader)
+	assert.Equal(t, events.SignWebhook(hook.Secret, timestamp, got.Body), got.Header.Get(events.WebhookSignatureHeader))
+	assert.NotEqual(t, events.SignWebhook("wrong secret", timestamp, got.Body), got.Header.Get(events.WebhookSignatureHeader))
+
+	var envelope events.StreamEnvelope
+	require.NoError(t, json.Unmarshal(got.Body, &envelope))
+	assert.Equal(t, got.Header.Get(events.WebhookDeliveryHeader), envelope.ID)
+	assert.Equal(t, "req-add", envelope.RequestID)
+	assert.Equal(t, "Paint the fence", decodeItem(t, envelope.After).Title)
+
+	//The webhook did not ask for updates
+	item.Title = "Paint the fence white"
+	w = doRequest(r, http.MethodPut, "/todo", item, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodDelete, "/todo/1", nil, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	got = nextHook(t, received)
+	assert.Equal(t, "todo.delete", got.Header.Get(events.WebhookEventHeader))
+
+	//The attempt is logged once the receiver has answered
+	var attempts []events.DeliveryAttempt
+	require.Eventually(t, func() bool {
+		w := doRequest(r, http.MethodGet, "/webhooks/1/deliveries", nil, "")
+		json.Unmarshal(w.Body.Bytes(), &attempts)
+		return len(attempts) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, attempts[0].Success)
+	assert.Equal(t, http.StatusNoContent, attempts[0].StatusCode)
+}
+
+func Test_WebhookRetries(t *testing.T) {
+	r, apiHandler := newTestRouter(t, nil)
+	t.Cleanup(apiHandler.StopEventListener)
+	apiHandler.SetWebhookRetryPolicy(fastRetries)
+
+	//Fails twice, then works
+	var calls atomic.Int32
+	receiver, received := newReceiver(t, func() int {
+		if calls.Add(1) <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	hook := registerWebhook(t, r, events.Webhook{URL: receiver.URL})
+
+	w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	first := nextHook(t, received)
+	nextHook(t, received)
+	third := nextHook(t, received)
+	assert.Equal(t, "3", third.Header.Get(events.WebhookAttemptHeader))
+	assert.Equal(t, first.Body, third.Body)
+
+	assert.Eventually(t, func() bool {
+		var attempts []events.DeliveryAttempt
+		w := doRequest(r, http.MethodGet, "/webhooks/1/deliveries", nil, "")
+		json.Unmarshal(w.Body.Bytes(), &attempts)
+		return len(attempts) == 3 && attempts[2].Success
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w = doRequest(r, http.MethodGet, "/webhooks/dead-letters", nil, "")
+	assert.JSONEq(t, "[]", w.Body.String())
+	assert.Equal(t, 1, hook.ID)
+}
+
+func Test_WebhookDeadLetters(t *testing.T) {
+	r, apiHandler := newTestRouter(t, nil)
+	t.Cleanup(apiHandler.StopEventListener)
+	apiHandler.SetWebhookRetryPolicy(fastRetries)
+
+	var healthy atomic.Bool
+	receiver, received := newReceiver(t, func() int {
+		if healthy.Load() {
+			return http.StatusOK
+		}
+		return http.StatusInternalServerError
+	})
+	registerWebhook(t, r, events.Webhook{URL: receiver.URL})
+
+	w := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var dead []events.DeadLetter
+	require.Eventually(t, func() bool {
+		w := doRequest(r, http.MethodGet, "/webhooks/dead-letters", nil, "")
+		json.Unmarshal(w.Body.Bytes(), &dead)
+		return len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Equal(t, "todo.add", dead[0].EventType)
+	assert.Equal(t, "500 Internal Server Error", dead[0].LastError)
+	for i := 0; i < 3; i++ {
+		nextHook(t, received)
+	}
+
+	//Once the receiver is fixed the dead letter can be sent again
+	healthy.Store(true)
+	w = doRequest(r, http.MethodPost, "/webhooks/dead-letters/1/retry", nil, "")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	got := nextHook(t, received)
+	assert.Equal(t, dead[0].EventID, got.Header.Get(events.WebhookDeliveryHeader))
+	assert.JSONEq(t, string(dead[0].Payload), string(got.Body))
+
+	w = doRequest(r, http.MethodGet, "/webhooks/dead-letters", nil, "")
+	assert.JSONEq(t, "[]", w.Body.String())
+	w = doRequest(r, http.MethodPost, "/webhooks/dead-letters/1/retry", nil, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func Test_WebhookResource(t *testing.T) {
+	r, apiHandler := newTestRouter(t, nil)
+	t.Cleanup(apiHandler.StopEventListener)
+
+	tests := []struct {
+		name string
+		hook events.Webhook
+	}{
+		{"no url", events.Webhook{}},
+		{"relative url", events.Webhook{URL: "/hook"}},
+		{"not http", events.Webhook{URL: "ftp://example.com/hook"}},
+		{"unknown event", events.Webhook{URL: "http://example.com/hook", Events: []string{"todo.query"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doRequest(r, http.MethodPost, "/webhooks", tt.hook, "")
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+
+	hook := registerWebhook(t, r, events.Webhook{URL: "http://example.com/hook", Secret: "my secret"})
+	assert.Equal(t, "my secret", hook.Secret)
+	assert.Equal(t, []string{}, hook.Events)
+
+	w := doRequest(r, http.MethodGet, "/webhooks/1", nil, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "my secret")
+
+	w = doRequest(r, http.MethodDelete, "/webhooks/1", nil, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = doRequest(r, http.MethodGet, "/webhooks/1", nil, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(r, http.MethodGet, "/webhooks/1/deliveries", nil, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = doRequest(r, http.MethodDelete, "/webhooks/x", nil, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func Test_WebhookBackoff(t *testing.T) {
+	policy := events.RetryPolicy{MaxAttempts: 6, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	var waits []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		waits = append(waits, policy.Backoff(attempt))
+	}
+	assert.Equal(t, []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, waits)
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -10,18 +12,38 @@ import (
 )
 
 // The api package creates and maintains a reference to the data handler
-// this is a good design practice
+// this is a good design practice.  The handler is a db.TodoStore, so the
+// api works the same way whether the items live in memory, in a json
+// file or in redis.  The audit log of the changes lives in the same
+// place.
 type ToDoAPI struct {
-	db *db.ToDo
+	db       db.TodoStore
+	auditLog db.AuditLog
 }
 
+// New returns an api that keeps its todos in memory
 func New() (*ToDoAPI, error) {
 	dbHandler, err := db.New()
 	if err != nil {
 		return nil, err
 	}
 
-	return &ToDoAPI{db: dbHandler}, nil
+	return NewWithStore(dbHandler)
+}
+
+// NewWithStore returns an api that keeps its todos in store, use
+// db.NewStore() to pick one of the stores by name
+func NewWithStore(store db.TodoStore) (*ToDoAPI, error) {
+	if store == nil {
+		return nil, errors.New("the api needs a store")
+	}
+
+	auditLog, err := db.NewAuditLogFor(store)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ToDoAPI{db: store, auditLog: auditLog}, nil
 }
 
 //Below we implement the API functions.  Some of the framework
@@ -36,15 +58,33 @@ func New() (*ToDoAPI, error) {
 //	  done using the c.AbortWithStatus() function
 
 // implementation for GET /todo
-// returns all todos
+// returns all todos, a page at a time if the caller asks for it.  The
+// limit query parameter sets the page size and the cursor parameter
+// picks up where the previous page stopped, for example
+// /todo?limit=10&cursor=<cursor>.  With count=true the X-Total-Count
+// header holds the number of todos in the whole list, and while there
+// are more todos the Link header points at the next page.
 func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 
-	todoList, err := td.db.GetAllItems()
+	cursor, limit, count, err := pageParams(c)
 	if err != nil {
+		log.Println("Error reading paging parameters: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := td.db.GetItemsPage(cursor, limit)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			log.Println("Error reading cursor: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Println("Error Getting All Items: ", err)
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	todoList := page.Items
 	//Note that the database returns a nil slice if there are no items
 	//in the database.  We need to convert this to an empty slice
 	//so that the JSON marshalling works correctly.  We want to return
@@ -53,72 +93,76 @@ func (td *ToDoAPI) ListAllTodos(c *gin.Context) {
 		todoList = make([]db.ToDoItem, 0)
 	}
 
+	//Counting can mean walking every item, so only do it when the
+	//caller asks
+	if count {
+		total, err := td.db.CountItems()
+		if err != nil {
+			log.Println("Error counting items: ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		setTotalCountHeader(c, total)
+	}
+
+	setPageHeaders(c, page.NextCursor)
 	c.JSON(http.StatusOK, todoList)
 }
 
 // implementation for GET /v2/todo
-// returns todos that are either done or not done
-// depending on the value of the done query parameter
-// for example, /v2/todo?done=true will return all
-// todos that are done.  Note you can have multiple
-// query parameters, for example /v2/todo?done=true&foo=bar
+// returns the todos that match a query.  The q query parameter holds a
+// filter, for example /v2/todo?q=done:false tag:work due<2026-11-01
+// (remember to url encode it), see db.Query for the syntax.  The sort
+// and order query parameters choose the order of the results, for
+// example /v2/todo?q=tag:work&sort=due&order=desc.  The done=true|false
+// parameter from the first version of this endpoint still works, it is
+// simply added to the filter.
 func (td *ToDoAPI) ListSelectTodos(c *gin.Context) {
-	//lets first load the data
-	todoList, err := td.db.GetAllItems()
-	if err != nil {
-		log.Println("Error Getting Database Items: ", err)
-		c.AbortWithStatus(http.StatusNotFound)
-		return
-	}
-	//If the database is empty, make an empty slice so that the
-	//JSON marshalling works correctly
-	if todoList == nil {
-		todoList = make([]db.ToDoItem, 0)
-	}
+	filter := c.Query("q")
 
 	//Note that the query parameter is a string, so we
 	//need to convert it to a bool
-	doneS := c.Query("done")
-
-	//if the doneS is empty, then we will return all items
-	if doneS == "" {
-		c.JSON(http.StatusOK, todoList)
-		return
+	if doneS := c.Query("done"); doneS != "" {
+		done, err := strconv.ParseBool(doneS)
+		if err != nil {
+			log.Println("Error converting done to bool: ", err)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		filter += fmt.Sprintf(" done:%t", done)
 	}
 
-	//Now we can handle the case where doneS is not empty
-	//and we need to filter the list based on the doneS value
-
-	done, err := strconv.ParseBool(doneS)
+	//A bad query is the caller's mistake, so we tell them what
+	//is wrong with it
+	query, err := db.NewQuery(filter, c.Query("sort"), c.Query("order"))
 	if err != nil {
-		log.Println("Error converting done to bool: ", err)
-		c.AbortWithStatus(http.StatusBadRequest)
+		log.Println("Error parsing query: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	//Now we need to filter the list based on the done value
-	//that was passed in.  We will create a new slice and
-	//only add items that match the done value
-	var filteredList []db.ToDoItem
-	for _, item := range todoList {
-		if item.IsDone == done {
-			filteredList = append(filteredList, item)
-		}
+	todoList, err := td.db.QueryItems(query)
+	if err != nil {
+		log.Println("Error Getting Database Items: ", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
 	}
 
 	//Note that the database returns a nil slice if there are no items
 	//in the database.  We need to convert this to an empty slice
 	//so that the JSON marshalling works correctly.  We want to return
 	//an empty slice, not a nil slice. This will result in the json being []
-	if filteredList == nil {
-		filteredList = make([]db.ToDoItem, 0)
+	if todoList == nil {
+		todoList = make([]db.ToDoItem, 0)
 	}
 
-	c.JSON(http.StatusOK, filteredList)
+	c.JSON(http.StatusOK, todoList)
 }
 
 // implementation for GET /todo/:id
-// returns a single todo
+// returns a single todo, with its version in the ETag header.  A
+// request with If-None-Match: <etag> gets a 304 Not Modified, and no
+// body, if the todo has not changed since.
 func (td *ToDoAPI) GetToDo(c *gin.Context) {
 
 	//Note go is minimalistic, so we have to get the
@@ -141,13 +185,22 @@ func (td *ToDoAPI) GetToDo(c *gin.Context) {
 		return
 	}
 
+	//If the client sent the ETag of the version it already has, there
+	//is no need to send the todo again
+	c.Header("ETag", etag(todoItem))
+	if noneMatch(c, todoItem) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
 	//Git will automatically convert the struct to JSON
 	//and set the content-type header to application/json
 	c.JSON(http.StatusOK, todoItem)
 }
 
 // implementation for POST /todo
-// adds a new todo
+// adds a new todo, the id can be left out of the body and the
+// database will assign one, the response holds the new item
 func (td *ToDoAPI) AddToDo(c *gin.Context) {
 	var todoItem db.ToDoItem
 
@@ -168,17 +221,43 @@ func (td *ToDoAPI) AddToDo(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.AddItem(todoItem); err != nil {
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	//The database fills in the id, when the item does not have one,
+	//and the timestamps, so send back the item as it was stored rather
+	//than the item we were sent
+	todoItem, err := td.db.AddItem(todoItem)
+	if err != nil {
 		log.Println("Error adding item: ", err)
+		//If-None-Match: * asks us to only create the todo, never to
+		//replace one, so a todo with this id is a failed precondition
+		if _, star := parseETags(c.GetHeader("If-None-Match")); star && err.Error() == "item already exists" {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	td.audit(c, db.AuditAdd, todoItem.Id, nil, todoItem)
 
+	c.Header("ETag", etag(todoItem))
 	c.JSON(http.StatusOK, todoItem)
 }
 
 // implementation for PUT /todo
-// Web api standards use PUT for Updates
+// Web api standards use PUT for Updates.  To make sure the update does
+// not overwrite someone else's change, send the ETag from the GET in an
+// If-Match header, or leave the version from the GET in the body.  If
+// the todo changed in the meantime the response is a 412.  The store
+// checks If-Match in the same step as the update.  If-None-Match is
+// checked before the update, so it is best effort only, see
+// noneMatchFailed.
 func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
 	var todoItem db.ToDoItem
 	if err := c.ShouldBindJSON(&todoItem); err != nil {
@@ -187,26 +266,168 @@ func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.UpdateItem(todoItem); err != nil {
+	//The optional fields, such as the priority and the tags, have
+	//rules that the json binding cannot check for us
+	if err := todoItem.Validate(); err != nil {
+		log.Println("Invalid item: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	//The store checks the todo we ask for in the same step as the
+	//update, If-Match takes precedence over the version in the body
+	match, err := ifMatch(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if match.Version == 0 {
+		match.Version = todoItem.Version
+	}
+	if td.noneMatchFailed(c, todoItem.Id) {
+		return
+	}
+
+	//The store hands back the todo as it was before the update, read in
+	//the same step as the update, for the audit log
+	change, err := td.db.UpdateItem(todoItem, match)
+	if err != nil {
 		log.Println("Error updating item: ", err)
+		//Every store reports a missing item the same way
+		if err.Error() == "item does not exist" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, db.ErrVersionMismatch) {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	//The database sets the timestamps, so send back the item as it
+	//was stored rather than the item we were sent
+	todoItem = change.After
+	td.audit(c, db.AuditUpdate, todoItem.Id, change.Before, todoItem)
+
+	c.Header("ETag", etag(todoItem))
+	c.JSON(http.StatusOK, todoItem)
+}
+
+// implementation for PATCH /todo/:id
+// changes part of a todo, the body is a patch document and the
+// Content-Type header says which kind:
+//
+//	application/merge-patch+json   {"done": true, "due": null}
+//	application/json-patch+json    [{"op": "add", "path": "/tags/-", "value": "work"}]
+//
+// A plain application/json body is read as a merge patch.  The fields
+// the patch does not mention are left as they are, so unlike PUT the
+// client does not have to send the whole todo.  The response holds the
+// patched todo.  If-Match and If-None-Match work the same way they do
+// for PUT.
+func (td *ToDoAPI) PatchToDo(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	patchType := c.ContentType()
+	if patchType == "application/json" {
+		patchType = db.MergePatchType
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		log.Println("Error reading body: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	patch, err := db.NewPatch(patchType, body)
+	if err != nil {
+		log.Println("Error reading patch: ", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, db.ErrUnsupportedPatch) {
+			status = http.StatusUnsupportedMediaType
+		}
+		c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	match, err := ifMatch(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if td.noneMatchFailed(c, int(id64)) {
+		return
+	}
+
+	//A todo that is not there is a 404, and the todo as it was before
+	//the patch goes to the audit log
+	change, err := td.db.PatchItem(int(id64), match, patch)
+	if err != nil {
+		log.Println("Error patching item: ", err)
+		switch {
+		case errors.Is(err, db.ErrInvalidPatch):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, db.ErrVersionMismatch):
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		case err.Error() == "item does not exist":
+			c.AbortWithStatus(http.StatusNotFound)
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+	todoItem := change.After
+	td.audit(c, db.AuditPatch, todoItem.Id, change.Before, todoItem)
+
+	c.Header("ETag", etag(todoItem))
 	c.JSON(http.StatusOK, todoItem)
 }
 
 // implementation for DELETE /todo/:id
-// deletes a todo
+// deletes a todo, with If-Match only if it has not changed.  If-Match
+// and If-None-Match work the same way they do for PUT.
 func (td *ToDoAPI) DeleteToDo(c *gin.Context) {
 	idS := c.Param("id")
 	id64, _ := strconv.ParseInt(idS, 10, 32)
 
-	if err := td.db.DeleteItem(int(id64)); err != nil {
+	match, err := ifMatch(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if td.noneMatchFailed(c, int(id64)) {
+		return
+	}
+
+	//DeleteItem hands back the todo it deleted, for the audit log
+	before, err := td.db.DeleteItem(int(id64), match)
+	if err != nil {
 		log.Println("Error deleting item: ", err)
+		//Every store reports a missing item the same way
+		if err.Error() == "item does not exist" {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, db.ErrVersionMismatch) {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	td.audit(c, db.AuditDelete, int(id64), before, nil)
 
 	c.Status(http.StatusOK)
 }
@@ -215,12 +436,21 @@ func (td *ToDoAPI) DeleteToDo(c *gin.Context) {
 // deletes all todos
 func (td *ToDoAPI) DeleteAllToDo(c *gin.Context) {
 
-	if err := td.db.DeleteAll(); err != nil {
+	//Every todo that is deleted gets its own audit entry, so the
+	//history of a todo ends with its delete.  The database returns the
+	//todos it deleted, read in the same step as the delete, so a todo
+	//added in the meantime is either deleted and audited, or neither.
+	todoList, err := td.db.DeleteAll()
+	if err != nil {
 		log.Println("Error deleting all items: ", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	for _, todoItem := range todoList {
+		td.audit(c, db.AuditDelete, todoItem.Id, todoItem, nil)
+	}
+
 	c.Status(http.StatusOK)
 }
 
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+// Every change made through the api is written to the audit log, see
+// db.AuditLog.  The api has no logins, so the actor is whoever the
+// client says it is in the X-Actor header.
+
+const (
+	//ActorHeader names who is making the change, for the audit log
+	ActorHeader = "X-Actor"
+
+	//RequestIDHeader ties an audit entry to a request.  A client can
+	//send its own id, otherwise the api makes one up, either way it is
+	//sent back in the response.
+	RequestIDHeader = "X-Request-ID"
+
+	//AuditResource is the resource the todos are logged under
+	AuditResource = "todo"
+
+	//anonymousActor is the actor of a change without an X-Actor header
+	anonymousActor = "anonymous"
+)
+
+// requestID returns the id of the request, and makes one up the first
+// time it is asked if the client did not send one
+func requestID(c *gin.Context) string {
+	if id := c.GetString(RequestIDHeader); id != "" {
+		return id
+	}
+	id := c.GetHeader(RequestIDHeader)
+	if id == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	c.Set(RequestIDHeader, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// auditJSON returns the json of an item for the audit log, nil becomes
+// null
+func auditJSON(item any) json.RawMessage {
+	if item == nil {
+		return json.RawMessage("null")
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		log.Println("Error converting item for the audit log: ", err)
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+// audit records a change to the todo with id, before and after are the
+// todo before and after the change.  The change has already been made
+// when we get here, so a failure is logged rather than turned into an
+// error for the client.
+func (td *ToDoAPI) audit(c *gin.Context, action string, id int, before any, after any) {
+	actor := c.GetHeader(ActorHeader)
+	if actor == "" {
+		actor = anonymousActor
+	}
+
+	_, err := td.auditLog.Record(db.AuditEntry{
+		Resource:  AuditResource,
+		Id:        id,
+		Action:    action,
+		Actor:     actor,
+		ClientIP:  c.ClientIP(),
+		RequestId: requestID(c),
+		Old:       auditJSON(before),
+		New:       auditJSON(after),
+	})
+	if err != nil {
+		log.Println("Error writing the audit log: ", err)
+	}
+}
+
+// implementation for GET /audit
+// returns the audit entries of a resource, oldest first, for example
+// /audit?resource=todo&id=7 for the changes of todo 7.  Without id the
+// entries of every todo are returned.
+func (td *ToDoAPI) GetAudit(c *gin.Context) {
+	resource := c.Query("resource")
+	if resource != AuditResource {
+		log.Println("Unknown audit resource: ", resource)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "resource must be " + AuditResource})
+		return
+	}
+
+	id := db.AuditAnyId
+	if idS := c.Query("id"); idS != "" {
+		id64, err := strconv.ParseInt(idS, 10, 32)
+		if err != nil || id64 < 0 {
+			log.Println("Error converting id to int64: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "id must be a positive number"})
+			return
+		}
+		id = int(id64)
+	}
+
+	entries, err := td.auditLog.Entries(resource, id)
+	if err != nil {
+		log.Println("Error reading the audit log: ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+)
+
+// Every todo has a version that goes up each time it changes.  A todo
+// that is deleted and added again with the same id starts over at
+// version 1 though, so the version alone does not tell two todos apart.
+// The ETag of a todo is the version and the time the todo was created,
+// in quotes, for example "3-lq2x1k9vj4g0".  Clients treat it as opaque.
+// A client that sends the ETag back in an If-Match header is saying "only
+// make this change if nobody changed the todo since I read it".  If
+// somebody did, the api answers 412 Precondition Failed and the client
+// should read the todo again.
+
+// etag returns the ETag header for a todo.  A todo stored before the
+// store kept the created time has only its version in the tag.
+func etag(item db.ToDoItem) string {
+	tag := strconv.Itoa(item.Version)
+	if item.CreatedAt != nil {
+		tag += "-" + strconv.FormatInt(item.CreatedAt.UnixNano(), 36)
+	}
+	return `"` + tag + `"`
+}
+
+// parseETags splits an If-Match or If-None-Match header into its
+// entity tags, star is true if the header is *
+func parseETags(header string) (tags []string, star bool) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		switch tag {
+		case "":
+		case "*":
+			star = true
+		default:
+			tags = append(tags, tag)
+		}
+	}
+	return tags, star
+}
+
+// ifMatch returns the todo the If-Match header asks for, as the version
+// and created time in its ETag.  The store compares them in the same
+// step as the change, so a stale tag of a todo that was deleted and added
+// again has the wrong created time and fails, even if the new todo is
+// back at the same version.  It returns the zero Match, which matches
+// any todo, when there is no If-Match header or it is *, a todo has to
+// exist to be changed anyway.  If-Match compares strictly, so a weak ETag
+// (W/"3") or a tag that is not one of ours never matches, we ask for
+// version -1 for those, a version no todo ever has.
+func ifMatch(c *gin.Context) (db.Match, error) {
+	tags, star := parseETags(c.GetHeader("If-Match"))
+	if star || len(tags) == 0 {
+		return db.Match{}, nil
+	}
+	if len(tags) > 1 {
+		return db.Match{}, errors.New("If-Match can only hold one ETag")
+	}
+
+	never := db.Match{Version: -1}
+	tag, ok := strings.CutPrefix(tags[0], `"`)
+	if !ok {
+		return never, nil
+	}
+	tag, ok = strings.CutSuffix(tag, `"`)
+	if !ok {
+		return never, nil
+	}
+	versionS, createdS, hasCreated := strings.Cut(tag, "-")
+	version, err := strconv.Atoi(versionS)
+	if err != nil || version < 1 {
+		return never, nil
+	}
+	match := db.Match{Version: version}
+	if hasCreated {
+		nanos, err := strconv.ParseInt(createdS, 36, 64)
+		if err != nil {
+			return never, nil
+		}
+		created := time.Unix(0, nanos).UTC()
+		match.CreatedAt = &created
+	}
+	return match, nil
+}
+
+// noneMatch returns true if the If-None-Match header matches item, that
+// is if it is * or holds the ETag of item.  If-None-Match compares
+// loosely, so W/"3" matches version 3 too.
+func noneMatch(c *gin.Context, item db.ToDoItem) bool {
+	tags, star := parseETags(c.GetHeader("If-None-Match"))
+	if star {
+		return true
+	}
+	for _, tag := range tags {
+		if strings.TrimPrefix(tag, "W/") == etag(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// noneMatchFailed checks the If-None-Match header of a request that
+// changes the todo with id.  If the header matches the todo as it is now
+// the request must not go ahead, we answer 412 and return true.  Unlike
+// If-Match this is not checked in the same step as the change, we read
+// the todo first, so it is best effort only.  A todo that changes to
+// match the header between our read and the change is still changed.
+func (td *ToDoAPI) noneMatchFailed(c *gin.Context, id int) bool {
+	if c.GetHeader("If-None-Match") == "" {
+		return false
+	}
+	current, err := td.db.GetItem(id)
+	if err != nil {
+		//The store reports the missing todo when we try to change it
+		return false
+	}
+	if noneMatch(c, current) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "the todo matches If-None-Match"})
+		return true
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//------------------------------------------------------------
+// AUDIT LOG
+//------------------------------------------------------------
+
+// The audit log records every change made through the api, who made it,
+// from where, and what the item looked like before and after.  Entries
+// are only ever added, there is no way to change or remove one, not even
+// DeleteAll touches them.  The log lives in the same place as the items,
+// see NewAuditLogFor.
+
+// The actions of an AuditEntry
+const (
+	AuditAdd    = "add"
+	AuditUpdate = "update"
+	AuditPatch  = "patch"
+	AuditDelete = "delete"
+)
+
+// AuditAnyId asks Entries for the entries of every id
+const AuditAnyId = -1
+
+// AuditEntry is a single change.  Old is null for an add and New is null
+// for a delete.  Seq is handed out by the log, it goes up by one for
+// every entry, so the entries of one id can be put in order with the
+// entries of the others.
+type AuditEntry struct {
+	Seq       int64           `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Resource  string          `json:"resource"`
+	Id        int             `json:"id"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor"`
+	ClientIP  string          `json:"client_ip"`
+	RequestId string          `json:"request_id"`
+	Old       json.RawMessage `json:"old"`
+	New       json.RawMessage `json:"new"`
+}
+
+// AuditLog is a place to keep audit entries.  Record gives the entry its
+// Seq, and its Time if it has none, and returns it.  Entries returns the
+// entries of one resource, oldest first, only those of id unless id is
+// AuditAnyId.
+type AuditLog interface {
+	Record(entry AuditEntry) (AuditEntry, error)
+	Entries(resource string, id int) ([]AuditEntry, error)
+}
+
+// NewAuditLogFor returns the audit log that lives next to store, in
+// memory for the memory store, in a file next to the json file of the
+// file store, and in redis for the redis store
+func NewAuditLogFor(store TodoStore) (AuditLog, error) {
+	switch s := store.(type) {
+	case *ToDo:
+		return NewMemoryAuditLog(), nil
+	case *FileStore:
+		name := strings.TrimSuffix(s.fileName, filepath.Ext(s.fileName)) + "-audit.jsonl"
+		return NewFileAuditLog(name)
+	case *RedisStore:
+		return &RedisAuditLog{cache: s.cache}, nil
+	}
+	return nil, fmt.Errorf("no audit log for a %T", store)
+}
+
+// stamp fills in the fields Record owns
+func stamp(entry AuditEntry, seq int64) AuditEntry {
+	entry.Seq = seq
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	return entry
+}
+
+// matches returns true if entry is one of the entries Entries asked for
+func (entry AuditEntry) matches(resource string, id int) bool {
+	return entry.Resource == resource && (id == AuditAnyId || entry.Id == id)
+}
+
+//------------------------------------------------------------
+// MEMORY AUDIT LOG
+//------------------------------------------------------------
+
+// MemoryAuditLog keeps the entries in memory, like the memory store
+// keeps the items, they are gone when the api stops
+type MemoryAuditLog struct {
+	lock    sync.RWMutex
+	entries []AuditEntry
+}
+
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{}
+}
+
+func (l *MemoryAuditLog) Record(entry AuditEntry) (AuditEntry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry = stamp(entry, int64(len(l.entries))+1)
+	l.entries = append(l.entries, entry)
+	return entry, nil
+}
+
+func (l *MemoryAuditLog) Entries(resource string, id int) ([]AuditEntry, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	entries := []AuditEntry{}
+	for _, entry := range l.entries {
+		if entry.matches(resource, id) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+//------------------------------------------------------------
+// FILE AUDIT LOG
+//------------------------------------------------------------
+
+// FileAuditLog appends the entries to a file, one json entry per line.
+// The file is only ever appended to, an entry that is on disk stays
+// as it was written.
+type FileAuditLog struct {
+	fileName string
+	lock     sync.Mutex
+	//seq is the Seq of the last entry in the file
+	seq int64
+}
+
+// NewFileAuditLog opens, or creates, the audit log in fileName
+func NewFileAuditLog(fileName string) (*FileAuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return nil, err
+	}
+	l := &FileAuditLog{fileName: fileName}
+
+	//The next entry carries on from the last one in the file
+	entries, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		l.seq = entries[len(entries)-1].Seq
+	}
+	return l, nil
+}
+
+// read returns every entry in the file
+func (l *FileAuditLog) read() ([]AuditEntry, error) {
+	file, err := os.Open(l.fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	//The old and new items can make for long lines
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("audit log %s is corrupt: %w", l.fileName, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (l *FileAuditLog) Record(entry AuditEntry) (AuditEntry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry = stamp(entry, l.seq+1)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	file, err := os.OpenFile(l.fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	defer file.Close()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return AuditEntry{}, err
+	}
+	if err := file.Sync(); err != nil {
+		return AuditEntry{}, err
+	}
+
+	l.seq = entry.Seq
+	return entry, nil
+}
+
+func (l *FileAuditLog) Entries(resource string, id int) ([]AuditEntry, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	all, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	entries := []AuditEntry{}
+	for _, entry := range all {
+		if entry.matches(resource, id) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+//------------------------------------------------------------
+// REDIS AUDIT LOG
+//------------------------------------------------------------
+
+const (
+	//RedisAuditKeyPrefix starts the keys of the audit lists,
+	//audit:<resource> holds every entry of a resource and
+	//audit:<resource>:<id> the entries of one id.  It is outside of the
+	//todo: prefix, so DeleteAll leaves the audit log alone.
+	RedisAuditKeyPrefix = "audit:"
+
+	//RedisAuditSeqKey holds the Seq of the last entry
+	RedisAuditSeqKey = "audit-seq"
+)
+
+// RedisAuditLog keeps the entries in redis lists, in the same redis as
+// the items
+type RedisAuditLog struct {
+	cache
+}
+
+func redisAuditKey(resource string, id int) string {
+	if id == AuditAnyId {
+		return RedisAuditKeyPrefix + resource
+	}
+	return fmt.Sprintf("%s%s:%d", RedisAuditKeyPrefix, resource, id)
+}
+
+func (l *RedisAuditLog) Record(entry AuditEntry) (AuditEntry, error) {
+	seq, err := l.cacheClient.Incr(l.context, RedisAuditSeqKey).Result()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	entry = stamp(entry, seq)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	//Both lists get the entry, or neither does
+	pipe := l.cacheClient.TxPipeline()
+	pipe.RPush(l.context, redisAuditKey(entry.Resource, AuditAnyId), data)
+	pipe.RPush(l.context, redisAuditKey(entry.Resource, entry.Id), data)
+	if _, err := pipe.Exec(l.context); err != nil {
+		return AuditEntry{}, err
+	}
+	return entry, nil
+}
+
+func (l *RedisAuditLog) Entries(resource string, id int) ([]AuditEntry, error) {
+	values, err := l.cacheClient.LRange(l.context, redisAuditKey(resource, id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, len(values))
+	for _, value := range values {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	//Two entries recorded at the same time can be pushed in the other
+	//order than they got their Seq
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries, nil
+}
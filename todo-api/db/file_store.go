@@ -0,0 +1,362 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+//------------------------------------------------------------
+// JSON FILE STORE
+//------------------------------------------------------------
+
+// FileStore keeps the todo items in a json file, in the same layout the
+// todo CLI uses, so the api can serve a database that was built with the
+// CLI.  Every call reads the file, and every change writes it back, so
+// the items survive a restart of the api.
+//
+// The mutex makes sure that two requests never interleave their
+// read/modify/write cycles.  It only protects us from ourselves, do not
+// point the CLI at the file while the api is changing it.
+type FileStore struct {
+	fileName string
+	lock     sync.Mutex
+}
+
+// fileContents is the layout of the json file.  Besides the items it
+// holds the id that will be handed to the next item added without one.
+// Older files are a plain json array of items, we still read those and
+// write them back in the new layout.
+type fileContents struct {
+	NextId int        `json:"next_id"`
+	Items  []ToDoItem `json:"items"`
+}
+
+// NewFileStore is a constructor function that returns a pointer to a new
+// FileStore.  It takes the name of the json file that holds the items,
+// if the file does not exist it is created with no items in it.
+func NewFileStore(fileName string) (*FileStore, error) {
+	f := &FileStore{fileName: fileName}
+
+	if _, err := os.Stat(fileName); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+			return nil, err
+		}
+		if err := f.save(fileContents{NextId: 1}); err != nil {
+			return nil, err
+		}
+	}
+
+	//Make sure we can actually read what is there before we hand the
+	//store to the api
+	if _, err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+//------------------------------------------------------------
+// FILE HELPERS
+//------------------------------------------------------------
+
+// load reads the json file and returns its contents
+func (f *FileStore) load() (fileContents, error) {
+	data, err := os.ReadFile(f.fileName)
+	if err != nil {
+		return fileContents{}, err
+	}
+
+	var contents fileContents
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &contents.Items)
+	} else {
+		err = json.Unmarshal(trimmed, &contents)
+	}
+	if err != nil {
+		return fileContents{}, err
+	}
+
+	//Never hand out an id that is already taken, an old file has no
+	//counter at all
+	for _, item := range contents.Items {
+		if item.Id >= contents.NextId {
+			contents.NextId = item.Id + 1
+		}
+	}
+	if contents.NextId < 1 {
+		contents.NextId = 1
+	}
+	return contents, nil
+}
+
+// save writes the contents to the json file.  It writes a temporary file
+// first and then renames it over the real one, so a crash halfway through
+// never leaves a half written file behind.
+func (f *FileStore) save(contents fileContents) error {
+	if contents.Items == nil {
+		contents.Items = make([]ToDoItem, 0)
+	}
+	sort.Slice(contents.Items, func(i, j int) bool {
+		return contents.Items[i].Id < contents.Items[j].Id
+	})
+
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.fileName), filepath.Base(f.fileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.fileName)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// find returns the position of the item with id, or -1
+func (c fileContents) find(id int) int {
+	for i, item := range c.Items {
+		if item.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// update loads the file, lets fn change the contents and saves them if
+// fn does not return an error
+func (f *FileStore) update(fn func(contents *fileContents) error) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	contents, err := f.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(&contents); err != nil {
+		return err
+	}
+	return f.save(contents)
+}
+
+// read loads the file for a call that does not change anything
+func (f *FileStore) read() (fileContents, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.load()
+}
+
+//------------------------------------------------------------
+// THESE ARE THE PUBLIC FUNCTIONS, SEE TodoStore
+//------------------------------------------------------------
+
+// AddItem accepts a ToDoItem and adds it to the file.  An item without
+// an id (an Id of 0) is given the next free id.  The stored item, with
+// its id and timestamps, is returned.
+func (f *FileStore) AddItem(item ToDoItem) (ToDoItem, error) {
+	if item.Id < 0 {
+		return ToDoItem{}, errors.New("item id cannot be negative")
+	}
+	if err := item.Validate(); err != nil {
+		return ToDoItem{}, err
+	}
+
+	err := f.update(func(contents *fileContents) error {
+		if item.Id == 0 {
+			item.Id = contents.NextId
+		}
+		if contents.find(item.Id) >= 0 {
+			return errors.New("item already exists")
+		}
+		if item.Id >= contents.NextId {
+			contents.NextId = item.Id + 1
+		}
+
+		stampNewItem(&item)
+		contents.Items = append(contents.Items, item)
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
+}
+
+// DeleteItem accepts an item id and removes it from the file, and
+// returns the item it removed.  It returns an error if the item does not
+// exist, or if it is not the item in match.
+func (f *FileStore) DeleteItem(id int, match Match) (ToDoItem, error) {
+	var deleted ToDoItem
+	err := f.update(func(contents *fileContents) error {
+		i := contents.find(id)
+		if i < 0 {
+			return errors.New("item does not exist")
+		}
+		if err := checkMatch(contents.Items[i], match); err != nil {
+			return err
+		}
+		deleted = contents.Items[i]
+		contents.Items = append(contents.Items[:i], contents.Items[i+1:]...)
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	return deleted, nil
+}
+
+// DeleteAll removes all items from the file, and returns the items it
+// removed, ordered by id.  The id counter is kept, so ids are not reused.
+func (f *FileStore) DeleteAll() ([]ToDoItem, error) {
+	var deleted []ToDoItem
+	err := f.update(func(contents *fileContents) error {
+		//save keeps the items in the file ordered by id
+		deleted = contents.Items
+		contents.Items = nil
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if deleted == nil {
+		deleted = make([]ToDoItem, 0)
+	}
+	return deleted, nil
+}
+
+// UpdateItem accepts a ToDoItem and replaces the item with the same id
+// in the file, and returns the item as it was and as it was stored.  It
+// returns an error if the item does not exist, or if the item in the file
+// is not the item in match.
+func (f *FileStore) UpdateItem(item ToDoItem, match Match) (ItemChange, error) {
+	if err := item.Validate(); err != nil {
+		return ItemChange{}, err
+	}
+
+	var change ItemChange
+	err := f.update(func(contents *fileContents) error {
+		i := contents.find(item.Id)
+		if i < 0 {
+			return errors.New("item does not exist")
+		}
+		if err := checkMatch(contents.Items[i], match); err != nil {
+			return err
+		}
+
+		//Keep the timestamps that belong to the existing item
+		stampUpdatedItem(contents.Items[i], &item)
+		change = ItemChange{Before: contents.Items[i], After: item}
+		contents.Items[i] = item
+		return nil
+	})
+	if err != nil {
+		return ItemChange{}, err
+	}
+	return change, nil
+}
+
+// PatchItem accepts an item id and a patch, and applies the patch to the
+// item in the file.  It returns the item as it was and as it was
+// patched, or an error if the item does not exist, is not the item in
+// match or the patch cannot be applied.
+func (f *FileStore) PatchItem(id int, match Match, patch Patch) (ItemChange, error) {
+	var change ItemChange
+	err := f.update(func(contents *fileContents) error {
+		i := contents.find(id)
+		if i < 0 {
+			return errors.New("item does not exist")
+		}
+		if err := checkMatch(contents.Items[i], match); err != nil {
+			return err
+		}
+
+		item, err := patch.Apply(contents.Items[i])
+		if err != nil {
+			return err
+		}
+		stampUpdatedItem(contents.Items[i], &item)
+		change = ItemChange{Before: contents.Items[i], After: item}
+		contents.Items[i] = item
+		return nil
+	})
+	if err != nil {
+		return ItemChange{}, err
+	}
+	return change, nil
+}
+
+// ChangeItemDoneStatus marks the item with id as done or not done
+func (f *FileStore) ChangeItemDoneStatus(id int, value bool) error {
+	_, err := f.PatchItem(id, Match{}, donePatch(value))
+	return err
+}
+
+// GetItem accepts an item id and returns the item from the file
+func (f *FileStore) GetItem(id int) (ToDoItem, error) {
+	contents, err := f.read()
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	i := contents.find(id)
+	if i < 0 {
+		return ToDoItem{}, errors.New("item does not exist")
+	}
+	return contents.Items[i], nil
+}
+
+// GetAllItems returns all of the items in the file
+func (f *FileStore) GetAllItems() ([]ToDoItem, error) {
+	contents, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return contents.Items, nil
+}
+
+// GetItemsPage returns one page of the items in the file, ordered by id,
+// see ToDo.GetItemsPage for how the cursor and limit work
+func (f *FileStore) GetItemsPage(cursor string, limit int) (ItemPage, error) {
+	contents, err := f.read()
+	if err != nil {
+		return ItemPage{}, err
+	}
+	return pageById(contents.Items, cursor, limit)
+}
+
+// CountItems returns the number of items in the file
+func (f *FileStore) CountItems() (int, error) {
+	contents, err := f.read()
+	if err != nil {
+		return 0, err
+	}
+	return len(contents.Items), nil
+}
+
+// QueryItems returns the items that match the query, sorted the way the
+// query asks for, see Query for the filter syntax
+func (f *FileStore) QueryItems(q Query) ([]ToDoItem, error) {
+	contents, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	return q.Apply(contents.Items), nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Priority is the optional priority of a ToDoItem.  Items without a
+// priority have the empty string, PriorityNone.
+type Priority string
+
+const (
+	PriorityNone   Priority = ""
+	PriorityLow    Priority = "low"
+	PriorityMedium Priority = "medium"
+	PriorityHigh   Priority = "high"
+)
+
+// ToDoItem is the struct that represents a single ToDo item.
+//
+// Everything after IsDone is optional and marked omitempty, so records
+// that were saved before these fields existed still load, they just come
+// back with the fields empty.  The CreatedAt, UpdatedAt and CompletedAt
+// timestamps are owned by the database, it sets them on every add and
+// update and ignores whatever the caller put in them.
+//
+// Version is owned by the database as well.  It is 1 when an item is
+// added and goes up by one every time the item changes, so a client can
+// tell whether the item it read is still the current one, see Match.
+type ToDoItem struct {
+	Id          int        `json:"id"`
+	Title       string     `json:"title"`
+	IsDone      bool       `json:"done"`
+	DueDate     *time.Time `json:"due,omitempty"`
+	Priority    Priority   `json:"priority,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	Notes       string     `json:"notes,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Version     int        `json:"version,omitempty"`
+}
+
+// Validate checks the optional fields of an item, it returns an error
+// describing the first problem it finds
+func (item ToDoItem) Validate() error {
+	switch item.Priority {
+	case PriorityNone, PriorityLow, PriorityMedium, PriorityHigh:
+	default:
+		return fmt.Errorf("invalid priority %q, use low, medium or high", item.Priority)
+	}
+	for _, tag := range item.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t\r\n") {
+			return fmt.Errorf("invalid tag %q, tags cannot be empty or contain spaces", tag)
+		}
+	}
+	return nil
+}
+
+// stampNewItem sets the timestamps and the version of an item that is
+// being added
+func stampNewItem(item *ToDoItem) {
+	now := time.Now().UTC()
+	item.Version = 1
+	item.CreatedAt = &now
+	item.UpdatedAt = &now
+	item.CompletedAt = nil
+	if item.IsDone {
+		item.CompletedAt = &now
+	}
+}
+
+// stampUpdatedItem sets the timestamps and the version of an item that
+// replaces old.  The creation time never changes, and the completion time
+// is only set when the item goes from not done to done, marking a done
+// item as done again keeps the original completion time.
+func stampUpdatedItem(old ToDoItem, item *ToDoItem) {
+	now := time.Now().UTC()
+	item.Version = old.Version + 1
+	item.CreatedAt = old.CreatedAt
+	item.UpdatedAt = &now
+	switch {
+	case !item.IsDone:
+		item.CompletedAt = nil
+	case old.IsDone:
+		item.CompletedAt = old.CompletedAt
+	default:
+		item.CompletedAt = &now
+	}
+}
+
+//------------------------------------------------------------
+// VERSIONS
+//------------------------------------------------------------
+
+// ErrVersionMismatch is returned when a caller asks to change a version
+// of an item that is no longer the current one, because someone else
+// changed or deleted the item since the caller read it.  The item is
+// left alone, the caller should read it again and retry.
+var ErrVersionMismatch = errors.New("item has changed, version does not match")
+
+// Match is the item a caller read and now wants to change, its version
+// and the time it was created.  An item that is deleted and added again
+// with the same id starts over at version 1, so the version alone does
+// not tell the two apart, the created time does.  A Version of 0 matches
+// any item, and a nil CreatedAt matches any created time, items stored
+// before the store kept the created time do not have one.
+type Match struct {
+	Version   int
+	CreatedAt *time.Time
+}
+
+// MatchOf returns the Match of item as it is now
+func MatchOf(item ToDoItem) Match {
+	return Match{Version: item.Version, CreatedAt: item.CreatedAt}
+}
+
+// checkMatch returns ErrVersionMismatch if stored is not the item the
+// caller read, see Match
+func checkMatch(stored ToDoItem, match Match) error {
+	if match.Version == 0 {
+		return nil
+	}
+	if stored.Version != match.Version {
+		return ErrVersionMismatch
+	}
+	if match.CreatedAt != nil && (stored.CreatedAt == nil || !stored.CreatedAt.Equal(*match.CreatedAt)) {
+		return ErrVersionMismatch
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+//------------------------------------------------------------
+// PAGING
+//------------------------------------------------------------
+
+// ErrInvalidCursor is returned when a cursor was not handed out by
+// GetItemsPage, the api turns it into a 400 Bad Request
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ItemPage is one page of the todo list.  NextCursor is passed back to
+// GetItemsPage to get the page after this one, it is empty on the last
+// page.
+type ItemPage struct {
+	Items      []ToDoItem
+	NextCursor string
+}
+
+// pageById returns one page of items, ordered by id, for the stores that
+// hold every item in memory.  The cursor is simply the id of the last
+// item on the previous page, the next page starts right after it.
+func pageById(items []ToDoItem, cursor string, limit int) (ItemPage, error) {
+	after := 0
+	if cursor != "" {
+		var err error
+		after, err = strconv.Atoi(cursor)
+		if err != nil || after < 0 {
+			return ItemPage{}, ErrInvalidCursor
+		}
+	}
+
+	//Sort a copy by id to get a stable order from one page to the next
+	sorted := make([]ToDoItem, 0, len(items))
+	for _, item := range items {
+		if item.Id > after {
+			sorted = append(sorted, item)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Id < sorted[j].Id
+	})
+
+	page := ItemPage{Items: make([]ToDoItem, 0)}
+	for _, item := range sorted {
+		if limit > 0 && len(page.Items) == limit {
+			//There is at least one more item, so hand out a cursor
+			page.NextCursor = strconv.Itoa(page.Items[len(page.Items)-1].Id)
+			break
+		}
+		page.Items = append(page.Items, item)
+	}
+
+	return page, nil
+}
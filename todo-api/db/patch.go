@@ -0,0 +1,115 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+//------------------------------------------------------------
+// PARTIAL UPDATES
+//------------------------------------------------------------
+
+// These are the two kinds of patch documents we accept, named by the
+// content type a client sends them with
+const (
+	//MergePatchType is a JSON Merge Patch (RFC 7396), a json object
+	//with just the fields to change, null removes a field, for example
+	//{"done": true, "due": null}
+	MergePatchType = "application/merge-patch+json"
+
+	//JSONPatchType is a JSON Patch (RFC 6902), a list of operations,
+	//for example [{"op": "add", "path": "/tags/-", "value": "work"}]
+	JSONPatchType = "application/json-patch+json"
+)
+
+// ErrInvalidPatch is returned when a patch cannot be read, or cannot be
+// applied to the item, for example because a "test" operation failed or
+// the patched item is not a valid item.  It is the caller's mistake.
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// ErrUnsupportedPatch is returned by NewPatch for a content type that is
+// not one of the patch types above
+var ErrUnsupportedPatch = errors.New("unsupported patch type")
+
+// A Patch is a partial update of a ToDoItem.  The stores apply it to the
+// item they hold, so a client can change a single field without sending
+// the whole item, and without overwriting a change someone else made to
+// another field in the meantime.
+type Patch struct {
+	patchType string
+	merge     []byte
+	ops       jsonpatch.Patch
+}
+
+// NewPatch reads a patch document, patchType is MergePatchType or
+// JSONPatchType
+func NewPatch(patchType string, doc []byte) (Patch, error) {
+	switch patchType {
+	case MergePatchType:
+		//A merge patch that is not an object would replace the whole
+		//item, which is what PUT is for
+		trimmed := bytes.TrimSpace(doc)
+		if !json.Valid(trimmed) || len(trimmed) == 0 || trimmed[0] != '{' {
+			return Patch{}, fmt.Errorf("%w: a merge patch must be a json object", ErrInvalidPatch)
+		}
+		return Patch{patchType: patchType, merge: trimmed}, nil
+	case JSONPatchType:
+		ops, err := jsonpatch.DecodePatch(doc)
+		if err != nil {
+			return Patch{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		return Patch{patchType: patchType, ops: ops}, nil
+	}
+	return Patch{}, fmt.Errorf("%w %q, use %s or %s", ErrUnsupportedPatch, patchType, MergePatchType, JSONPatchType)
+}
+
+// donePatch is the patch ChangeItemDoneStatus applies
+func donePatch(value bool) Patch {
+	patch, _ := NewPatch(MergePatchType, []byte(fmt.Sprintf(`{"done": %t}`, value)))
+	return patch
+}
+
+// Apply returns item with the patch applied, item itself is left alone.
+// The id of an item cannot be patched, and the patched item has to pass
+// Validate.  The timestamps can be patched here, but the stores set them
+// afterwards, like they do for UpdateItem.
+func (p Patch) Apply(item ToDoItem) (ToDoItem, error) {
+	doc, err := json.Marshal(item)
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	var patched []byte
+	switch p.patchType {
+	case MergePatchType:
+		patched, err = jsonpatch.MergePatch(doc, p.merge)
+	case JSONPatchType:
+		patched, err = p.ops.Apply(doc)
+	default:
+		return ToDoItem{}, fmt.Errorf("%w: empty patch", ErrInvalidPatch)
+	}
+	if err != nil {
+		return ToDoItem{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	//Unknown fields are most likely a typo, so we refuse them rather
+	//than quietly dropping the change
+	var result ToDoItem
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&result); err != nil {
+		return ToDoItem{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	if result.Id != item.Id {
+		return ToDoItem{}, fmt.Errorf("%w: the id cannot be changed", ErrInvalidPatch)
+	}
+	if err := result.Validate(); err != nil {
+		return ToDoItem{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return result, nil
+}
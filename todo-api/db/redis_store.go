@@ -0,0 +1,853 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/nitishm/go-rejson/v4"
+	"github.com/nitishm/go-rejson/v4/rjs"
+)
+
+//------------------------------------------------------------
+// REDIS STORE
+//------------------------------------------------------------
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+	RedisKeyPrefix       = "todo:"
+
+	//RedisNextIdKey holds the last id handed out by AddItem.  It is
+	//deliberately outside of the todo: prefix, so it is never mistaken
+	//for a todo item
+	RedisNextIdKey = "todo-next-id"
+)
+
+type cache struct {
+	cacheClient *redis.Client
+	jsonHelper  *rejson.Handler
+	context     context.Context
+}
+
+// RedisStore keeps the todo items in redis, as json documents stored
+// with the RedisJSON module under the keys todo:<id>.  Several copies
+// of the api can share one redis, and the items survive a restart of
+// the api.
+type RedisStore struct {
+	//Redis cache connections
+	cache
+}
+
+// NewRedisStore is a constructor function that returns a pointer to a new
+// RedisStore.  It accepts a string that represents the location of the
+// redis cache, see NewStore for where the location comes from.
+func NewRedisStore(location string) (*RedisStore, error) {
+
+	//Connect to redis.  Other options can be provided, but the
+	//defaults are OK
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	//We use this context to coordinate betwen our go code and
+	//the redis operaitons
+	ctx := context.Background()
+
+	//This is the reccomended way to ensure that our redis connection
+	//is working
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error())
+		return nil, err
+	}
+
+	//By default, redis manages keys and values, where the values
+	//are either strings, sets, maps, etc.  Redis has an extension
+	//module called ReJSON that allows us to store JSON objects
+	//however, we need a companion library in order to work with it
+	//Below we create an instance of the JSON helper and associate
+	//it with our redis connnection
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+
+	//Return a pointer to a new RedisStore struct
+	return &RedisStore{
+		cache: cache{
+			cacheClient: client,
+			jsonHelper:  jsonHelper,
+			context:     ctx,
+		},
+	}, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS
+//------------------------------------------------------------
+
+// We will use this later, you can ignore for now
+func isRedisNilError(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
+}
+
+// In redis, our keys will be strings, they will look like
+// todo:<number>.  This function will take an integer and
+// return a string that can be used as a key in redis
+func redisKeyFromId(id int) string {
+	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+}
+
+// Helper to return a ToDoItem from redis provided a key
+func (t *RedisStore) getItemFromRedis(key string, item *ToDoItem) error {
+
+	//Lets query redis for the item, note we can return parts of the
+	//json structure, the second parameter "." means return the entire
+	//json structure
+	itemObject, err := t.jsonHelper.JSONGet(key, ".")
+	if err != nil {
+		return err
+	}
+
+	//JSONGet returns an "any" object, or empty interface,
+	//we need to convert it to a byte array, which is the
+	//underlying type of the object, then we can unmarshal
+	//it into our ToDoItem struct
+	err = json.Unmarshal(itemObject.([]byte), item)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Helper to store an item that must not exist yet, it returns an
+// "item already exists" error if the key is taken
+func (t *RedisStore) setNewItemInRedis(item ToDoItem) error {
+	res, err := t.jsonHelper.JSONSet(redisKeyFromId(item.Id), ".", item, rjs.SetOptionNX)
+	if err != nil {
+		return err
+	}
+	//With NX, redis answers nil instead of OK when the key already
+	//exists.  The rejson helper turns that nil into a nil result, not
+	//an error, so this is how we find out
+	if res == nil {
+		return errors.New("item already exists")
+	}
+	return nil
+}
+
+//------------------------------------------------------------
+// THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
+//------------------------------------------------------------
+
+// AddItem accepts a ToDoItem and adds it to the DB.  An item without an
+// id (an Id of 0) is given the next free id.  The stored item, with its
+// id and timestamps, is returned.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) If the item has an id, it must not already exist
+//	    				in the DB because we use the item.Id as the key,
+//						this function must check if the item already
+//	    				exists in the DB, if so, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be added to the DB
+//		(2) The DB file will be saved with the item added
+//		(3) If there is an error, it will be returned
+func (t *RedisStore) AddItem(item ToDoItem) (ToDoItem, error) {
+	if item.Id < 0 {
+		return ToDoItem{}, errors.New("item id cannot be negative")
+	}
+	if err := item.Validate(); err != nil {
+		return ToDoItem{}, err
+	}
+
+	//The database owns the timestamps, so set them before we
+	//store the item
+	stampNewItem(&item)
+
+	//An item with its own id is stored under that id.  The NX option
+	//tells redis to only set the key if it does not exist yet, so the
+	//check and the write are a single atomic step
+	if item.Id != 0 {
+		if err := t.setNewItemInRedis(item); err != nil {
+			return ToDoItem{}, err
+		}
+		return item, nil
+	}
+
+	//Otherwise we ask redis for the next id.  INCR is atomic, so two
+	//API instances sharing this redis never get the same number.  An
+	//id can still be taken by an item that was added with its own id,
+	//in that case we simply move on to the next number.
+	for {
+		nextId, err := t.cacheClient.Incr(t.context, RedisNextIdKey).Result()
+		if err != nil {
+			return ToDoItem{}, err
+		}
+		item.Id = int(nextId)
+		err = t.setNewItemInRedis(item)
+		if err == nil {
+			return item, nil
+		}
+		if err.Error() != "item already exists" {
+			return ToDoItem{}, err
+		}
+	}
+}
+
+// DeleteItem accepts an item id and removes it from the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+//					(3) The item must still be the one in match,
+//	    				see Match, if not, return
+//						ErrVersionMismatch
+//
+// Postconditions:
+//
+//	 (1) The item will be removed from the DB
+//		(2) The DB file will be saved with the item removed
+//		(3) If there is an error, it will be returned
+func (t *RedisStore) DeleteItem(id int, match Match) (ToDoItem, error) {
+
+	//The item is read and then deleted only if it is still the item we
+	//read, see writeIfVersion, so the item we return is the one we
+	//deleted.  If it moved on in between we read it again.
+	pattern := redisKeyFromId(id)
+	deleteAll := []fieldChange{{path: ".", op: "JSON.DEL"}}
+	for {
+		var existingItem ToDoItem
+		if err := t.getItemFromRedis(pattern, &existingItem); err != nil {
+			if isRedisNilError(err) {
+				return ToDoItem{}, errors.New("item does not exist")
+			}
+			return ToDoItem{}, err
+		}
+		if err := checkMatch(existingItem, match); err != nil {
+			return ToDoItem{}, err
+		}
+
+		res, err := t.writeIfVersion(pattern, existingItem, deleteAll)
+		if err != nil {
+			return ToDoItem{}, err
+		}
+		switch res {
+		case casDone:
+			return existingItem, nil
+		case casMissing:
+			return ToDoItem{}, errors.New("item does not exist")
+		}
+	}
+}
+
+// DeleteAll removes all items from the DB, and returns the items it
+// removed, ordered by id.
+// It will be exposed via a DELETE /todo endpoint
+func (t *RedisStore) DeleteAll() ([]ToDoItem, error) {
+
+	pattern := RedisKeyPrefix + "*"
+	ks, err := t.scanKeys(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	//Each item is read and deleted by the same script, so the items we
+	//return are exactly the ones that were removed.  An item that is
+	//added after the scan is not deleted, and one that someone else
+	//deleted in the meantime is not returned.
+	deleted := make([]ToDoItem, 0, len(ks))
+	for start := 0; start < len(ks); start += redisScanCount {
+		end := start + redisScanCount
+		if end > len(ks) {
+			end = len(ks)
+		}
+		docs, err := getAndDeleteScript.Run(t.context, t.cacheClient, ks[start:end]).StringSlice()
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			var item ToDoItem
+			if err := json.Unmarshal([]byte(doc), &item); err != nil {
+				return nil, err
+			}
+			deleted = append(deleted, item)
+		}
+	}
+
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Id < deleted[j].Id
+	})
+	return deleted, nil
+}
+
+// getAndDeleteScript deletes the documents at KEYS, and returns the ones
+// it deleted.  A key that is already gone is skipped.
+var getAndDeleteScript = redis.NewScript(`
+local deleted = {}
+for _, key in ipairs(KEYS) do
+	local doc = redis.call('JSON.GET', key, '.')
+	if doc then
+		redis.call('DEL', key)
+		table.insert(deleted, doc)
+	end
+end
+return deleted
+`)
+
+// UpdateItem accepts a ToDoItem and updates it in the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+//					(3) The item must still be the one the caller
+//	    				read, match, see Match, if not, return
+//						ErrVersionMismatch
+//
+// Postconditions:
+//
+//	 (1) The item will be updated in the DB, and the item as it was
+//			and as it is now will be returned
+//		(2) The DB file will be saved with the item updated
+//		(3) If there is an error, it will be returned
+func (t *RedisStore) UpdateItem(item ToDoItem, match Match) (ItemChange, error) {
+
+	if err := item.Validate(); err != nil {
+		return ItemChange{}, err
+	}
+
+	//changeItem makes sure the item exists and is the one the caller
+	//expects.  The new item simply replaces the existing one, but only
+	//the fields that are different are written
+	redisKey := redisKeyFromId(item.Id)
+	return t.changeItem(redisKey, match, func(existingItem ToDoItem) (ToDoItem, error) {
+		return item, nil
+	})
+}
+
+// PatchItem accepts an item id and a patch, and applies the patch to the
+// item in the DB.  Only the fields the patch changes are written back,
+// see changeItem.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+//					(3) The item must still be the one in match,
+//	    				see Match, if not, return
+//						ErrVersionMismatch
+//
+// Postconditions:
+//
+//	 (1) The item will be updated in the DB, and the item as it was
+//			and as it is now will be returned
+//		(2) If the patch cannot be applied, an ErrInvalidPatch error
+//			will be returned and the item is left alone
+//		(3) If there is an error, it will be returned
+func (t *RedisStore) PatchItem(id int, match Match, patch Patch) (ItemChange, error) {
+
+	//Redis cannot apply a json patch for us, so we apply it to our
+	//copy of the item, and changeItem sends redis just the fields
+	//that changed
+	redisKey := redisKeyFromId(id)
+	return t.changeItem(redisKey, match, patch.Apply)
+}
+
+// ChangeItemDoneStatus accepts an item id and a boolean status, and
+// marks the item as done or not done.  Only the done flag and the
+// timestamps are written, not the whole item.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB, if not,
+//	    				return an error
+//
+// Postconditions:
+//
+//	 (1) The items status in the database will be updated
+//		(2) If there is an error, it will be returned.
+func (t *RedisStore) ChangeItemDoneStatus(id int, value bool) error {
+	_, err := t.PatchItem(id, Match{}, donePatch(value))
+	return err
+}
+
+// GetItem accepts an item id and returns the item from the DB.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+// Postconditions:
+//
+//	 (1) The item will be returned, if it exists
+//		(2) If there is an error, it will be returned
+//			along with an empty ToDoItem
+//		(3) The database file will not be modified
+func (t *RedisStore) GetItem(id int) (ToDoItem, error) {
+
+	// Check if item exists before trying to get it
+	// this is a good practice, return an error if the
+	// item does not exist
+	var item ToDoItem
+	pattern := redisKeyFromId(id)
+	err := t.getItemFromRedis(pattern, &item)
+	if err != nil {
+		//Every store reports a missing item the same way
+		if isRedisNilError(err) {
+			return ToDoItem{}, errors.New("item does not exist")
+		}
+		return ToDoItem{}, err
+	}
+
+	return item, nil
+}
+
+// GetAllItems returns all items from the DB.  If successful it
+// returns a slice of all of the items to the caller
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) All items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *RedisStore) GetAllItems() ([]ToDoItem, error) {
+
+	//Now that we have the DB loaded, lets crate a slice
+	var toDoList []ToDoItem
+
+	//Lets query redis for all of the items
+	pattern := RedisKeyPrefix + "*"
+	ks, err := t.scanKeys(pattern)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range ks {
+		//Use a fresh item for every key, json.Unmarshal leaves fields
+		//that are missing from the json alone, so reusing one item
+		//would leak tags or dates from the previous item into this one
+		var toDoItem ToDoItem
+		err := t.getItemFromRedis(key, &toDoItem)
+		if err != nil {
+			//The item was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
+			return nil, err
+		}
+		toDoList = append(toDoList, toDoItem)
+	}
+
+	return toDoList, nil
+}
+
+// GetItemsPage returns one page of the items in the DB.  An empty
+// cursor starts at the first item, after that the cursor is the
+// NextCursor of the page before.  A limit of 0 means no limit, so the
+// page holds every item that is left.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The cursor must be empty or come from an
+//	    				earlier page, if not, return ErrInvalidCursor
+//
+// Postconditions:
+//
+//	 (1) Up to limit items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty page
+//		(3) The database file will not be modified
+func (t *RedisStore) GetItemsPage(cursor string, limit int) (ItemPage, error) {
+
+	//Redis hands out the keys in its own order, which is not the
+	//order of the ids, see scanPage for how the cursor works
+	pattern := RedisKeyPrefix + "*"
+	keys, nextCursor, err := t.scanPage(pattern, cursor, limit)
+	if err != nil {
+		return ItemPage{}, err
+	}
+
+	page := ItemPage{
+		Items:      make([]ToDoItem, 0, len(keys)),
+		NextCursor: nextCursor,
+	}
+	for _, key := range keys {
+		var toDoItem ToDoItem
+		err := t.getItemFromRedis(key, &toDoItem)
+		if err != nil {
+			//The item was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
+			return ItemPage{}, err
+		}
+		page.Items = append(page.Items, toDoItem)
+	}
+
+	return page, nil
+}
+
+// CountItems returns the number of items in the DB.  Counting means
+// scanning every key, SCAN never blocks redis the way KEYS does but it
+// still walks the whole keyspace, so the api only counts when the
+// caller asks for it.
+func (t *RedisStore) CountItems() (int, error) {
+	return t.countKeys(RedisKeyPrefix + "*")
+}
+
+// QueryItems returns the items that match the query, sorted the way the
+// query asks for, see Query for the filter syntax.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The matching items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *RedisStore) QueryItems(q Query) ([]ToDoItem, error) {
+
+	//Redis cannot run our query for us, so we load every item and
+	//run the query on this side, exactly the way the other stores do
+	toDoList, err := t.GetAllItems()
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Apply(toDoList), nil
+}
+
+//------------------------------------------------------------
+// REDIS FIELD UPDATE HELPERS
+//------------------------------------------------------------
+
+// RedisJSON can change part of a document in place, JSON.SET with a
+// path such as .done only replaces that field, and JSON.ARRAPPEND adds
+// to the end of an array without sending the array again.  So a patch
+// that flips done, or adds a tag, does not rewrite the whole item.  The
+// version is one of the fields, it is written together with the rest.
+
+// fieldChange is a single change to a top level field of an item
+type fieldChange struct {
+	path string
+	//op is JSON.SET, JSON.DEL or JSON.ARRAPPEND
+	op     string
+	values []json.RawMessage
+}
+
+// changedFields compares the json of two items, field by field, and
+// returns the changes that turn old into item
+func changedFields(old ToDoItem, item ToDoItem) ([]fieldChange, error) {
+	oldFields, err := jsonFields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := jsonFields(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []fieldChange
+	for name, value := range newFields {
+		oldValue, ok := oldFields[name]
+		if ok && bytes.Equal(oldValue, value) {
+			continue
+		}
+		path := "." + name
+		if added, ok := appendedElements(oldValue, value); ok {
+			changes = append(changes, fieldChange{path: path, op: "JSON.ARRAPPEND", values: added})
+			continue
+		}
+		changes = append(changes, fieldChange{path: path, op: "JSON.SET", values: []json.RawMessage{value}})
+	}
+	//Fields marked omitempty disappear from the json when they are
+	//cleared, so they have to be removed from the document
+	for name := range oldFields {
+		if _, ok := newFields[name]; !ok {
+			changes = append(changes, fieldChange{path: "." + name, op: "JSON.DEL"})
+		}
+	}
+
+	//Maps have no order, sorting keeps the commands the same every time
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].path < changes[j].path
+	})
+	return changes, nil
+}
+
+// jsonFields returns the top level fields of the json of an item
+func jsonFields(item ToDoItem) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// appendedElements returns the elements added to the end of an array, if
+// the new array is the old array plus some more elements.  An old value
+// that is missing or null is not an array redis can append to.
+func appendedElements(old json.RawMessage, value json.RawMessage) ([]json.RawMessage, bool) {
+	var oldArray, newArray []json.RawMessage
+	if json.Unmarshal(old, &oldArray) != nil || oldArray == nil {
+		return nil, false
+	}
+	if json.Unmarshal(value, &newArray) != nil || len(newArray) <= len(oldArray) {
+		return nil, false
+	}
+	for i := range oldArray {
+		if !bytes.Equal(oldArray[i], newArray[i]) {
+			return nil, false
+		}
+	}
+	return newArray[len(oldArray):], true
+}
+
+// changeItem reads the item at key, lets change turn it into the new
+// item, and writes the fields that differ back to redis.  The item must
+// be the one in match, see Match.  Another api instance can change the
+// item between our read and our write, so the write only happens if the
+// item is still the one we read, see writeIfVersion.  If it has moved on
+// we simply start over with the item as it is now, so changes are never
+// lost, and a caller that asked for a version gets ErrVersionMismatch.
+// It returns the item as it was when we wrote it, and the item we wrote.
+func (t *RedisStore) changeItem(key string, match Match, change func(existingItem ToDoItem) (ToDoItem, error)) (ItemChange, error) {
+	for {
+		var existingItem ToDoItem
+		if err := t.getItemFromRedis(key, &existingItem); err != nil {
+			if isRedisNilError(err) {
+				return ItemChange{}, errors.New("item does not exist")
+			}
+			return ItemChange{}, err
+		}
+		if err := checkMatch(existingItem, match); err != nil {
+			return ItemChange{}, err
+		}
+
+		item, err := change(existingItem)
+		if err != nil {
+			return ItemChange{}, err
+		}
+		stampUpdatedItem(existingItem, &item)
+
+		changes, err := changedFields(existingItem, item)
+		if err != nil {
+			return ItemChange{}, err
+		}
+		res, err := t.writeIfVersion(key, existingItem, changes)
+		if err != nil {
+			return ItemChange{}, err
+		}
+		switch res {
+		case casDone:
+			return ItemChange{Before: existingItem, After: item}, nil
+		case casMissing:
+			return ItemChange{}, errors.New("item does not exist")
+		}
+	}
+}
+
+// These are the answers of writeIfVersionScript
+const (
+	casMissing  = 0
+	casDone     = 1
+	casConflict = -1
+)
+
+// writeIfVersionScript checks the version of the document at KEYS[1] and
+// the time it was created, and makes the writes if they are ARGV[1] and
+// ARGV[2].  Redis runs a script from start to finish without running any
+// other command in between, so nobody can change the document, or delete
+// it and add it again, after we checked it.  An item that was stored
+// before items had versions has no version field, that is version 0, and
+// one stored before the created time was kept has an empty ARGV[2].
+//
+// The rest of ARGV are the writes, each one is a command, a path, the
+// number of values, and then the values.
+var writeIfVersionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local version = redis.pcall('JSON.GET', KEYS[1], '.version')
+if type(version) ~= 'string' then
+	version = '0'
+end
+if tonumber(version) ~= tonumber(ARGV[1]) then
+	return -1
+end
+local created = redis.pcall('JSON.GET', KEYS[1], '.created_at')
+if type(created) ~= 'string' then
+	created = ''
+end
+if created ~= ARGV[2] then
+	return -1
+end
+
+local i = 3
+while i <= #ARGV do
+	local count = tonumber(ARGV[i + 2])
+	redis.call(ARGV[i], KEYS[1], ARGV[i + 1], unpack(ARGV, i + 3, i + 2 + count))
+	i = i + 3 + count
+end
+return 1
+`)
+
+// writeIfVersion makes the changes to the document at key in a single
+// step, if the document is still existingItem, the version and created
+// time we read.  It returns casDone, casMissing if the document does not
+// exist or casConflict if it has another version or created time.
+func (t *RedisStore) writeIfVersion(key string, existingItem ToDoItem, changes []fieldChange) (int64, error) {
+	//The created time is compared as the json redis holds, which is
+	//what json.Marshal wrote when the item was stored
+	created := ""
+	if existingItem.CreatedAt != nil {
+		data, err := json.Marshal(existingItem.CreatedAt)
+		if err != nil {
+			return 0, err
+		}
+		created = string(data)
+	}
+	args := []interface{}{existingItem.Version, created}
+	for _, change := range changes {
+		args = append(args, change.op, change.path, len(change.values))
+		for _, value := range change.values {
+			args = append(args, string(value))
+		}
+	}
+	return writeIfVersionScript.Run(t.context, t.cacheClient, []string{key}, args...).Int64()
+}
+
+//------------------------------------------------------------
+// REDIS PAGING HELPERS
+//------------------------------------------------------------
+
+// redisScanCount is the COUNT hint we give to SCAN, roughly how many
+// keys redis looks at in one call.  It must not change between two
+// pages, because a page cursor points into a batch of this size.
+const redisScanCount = 100
+
+// The KEYS command walks the whole keyspace in one go, and redis does
+// nothing else until it is done, so a big cache stalls every client.
+// SCAN walks the keyspace a small batch at a time instead.  It hands
+// back a cursor with every batch, and we keep calling it with that
+// cursor until it returns 0.
+
+// scanKeys returns every key that matches pattern, using SCAN
+func (t *RedisStore) scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	iter := t.cacheClient.Scan(t.context, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(t.context) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// countKeys returns the number of keys that match pattern.  It walks
+// the whole keyspace, so only call it when the caller asked for a count.
+func (t *RedisStore) countKeys(pattern string) (int, error) {
+	count := 0
+	iter := t.cacheClient.Scan(t.context, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(t.context) {
+		count++
+	}
+	return count, iter.Err()
+}
+
+// scanPage returns up to limit keys that match pattern, starting at
+// cursor, and the cursor for the keys after them.  A limit of 0 means
+// no limit.
+//
+// A SCAN batch can hold more keys than we need for the page, and redis
+// cannot start halfway through a batch.  So our cursor holds the SCAN
+// cursor of the batch AND the last key we used from it.  The next page
+// asks redis for the same batch again, and skips the keys up to and
+// including that key.  The keys of a batch are sorted first, so this
+// also works if keys were added or removed in between.  Like SCAN
+// itself, an item that is added or removed while a caller is paging
+// may or may not show up.
+func (t *RedisStore) scanPage(pattern string, cursor string, limit int) ([]string, string, error) {
+	scanCursor, after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := make([]string, 0)
+	for {
+		batch, nextScanCursor, err := t.cacheClient.Scan(t.context, scanCursor, pattern, redisScanCount).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Strings(batch)
+		for _, key := range batch {
+			if after != "" && key <= after {
+				continue
+			}
+			if limit > 0 && len(keys) == limit {
+				//The page is full, but this batch has more keys
+				return keys, encodeCursor(scanCursor, keys[len(keys)-1]), nil
+			}
+			keys = append(keys, key)
+		}
+
+		//Redis is done with the keyspace when the cursor comes back as 0
+		if nextScanCursor == 0 {
+			return keys, "", nil
+		}
+		scanCursor, after = nextScanCursor, ""
+		if limit > 0 && len(keys) == limit {
+			return keys, encodeCursor(scanCursor, ""), nil
+		}
+	}
+}
+
+// Our cursors are "<scan cursor>:<last key>", base64 encoded so they
+// are safe to put in a url and callers do not try to build their own
+func encodeCursor(scanCursor uint64, after string) string {
+	raw := strconv.FormatUint(scanCursor, 10) + ":" + after
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (uint64, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	scanCursorS, after, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", ErrInvalidCursor
+	}
+	scanCursor, err := strconv.ParseUint(scanCursorS, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return scanCursor, after, nil
+}
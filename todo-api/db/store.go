@@ -0,0 +1,134 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+//------------------------------------------------------------
+// PLUGGABLE STORES
+//------------------------------------------------------------
+
+// TodoStore is everything the api needs from a place to keep todo items.
+// The in memory map (ToDo), the json file (FileStore) and redis
+// (RedisStore) all implement it, so the api does not know or care where
+// the items actually live.  The rules are the same for every store:
+//
+//   - AddItem gives an item without an id (an Id of 0) the next free id,
+//     and returns an "item already exists" error if the id is taken
+//   - GetItem, UpdateItem, PatchItem, ChangeItemDoneStatus and DeleteItem
+//     return an "item does not exist" error if there is no item with that id
+//   - PatchItem returns an ErrInvalidPatch error if the patch cannot be
+//     applied, and leaves the item alone
+//   - UpdateItem and PatchItem return an ItemChange with the item as it
+//     was and as it was stored, DeleteItem returns the item it deleted
+//     and DeleteAll the items it deleted, ordered by id.  They are read
+//     in the same step as the change, so they are exactly what the
+//     change replaced, even with other callers writing
+//   - Every change bumps the version of the item.  UpdateItem, PatchItem
+//     and DeleteItem take a Match, the version and created time of the
+//     item the caller read, and return ErrVersionMismatch without changing
+//     anything if the item has moved on since, or was deleted and added
+//     again.  A Match with a version of 0 skips the check.  The check and
+//     the change are a single step, two callers that read the same
+//     version can never both succeed
+//   - GetItemsPage only reads the items on the page.  CountItems may have
+//     to walk every item, the redis store scans every key, so the api
+//     only counts when the caller asks for it
+//   - Every function is safe to call from many goroutines at once, gin
+//     runs each request on its own goroutine
+//   - The store owns the created, updated and completed timestamps
+type TodoStore interface {
+	AddItem(item ToDoItem) (ToDoItem, error)
+	GetItem(id int) (ToDoItem, error)
+	UpdateItem(item ToDoItem, match Match) (ItemChange, error)
+	DeleteItem(id int, match Match) (ToDoItem, error)
+
+	//These change part of an item, the rest of the item is left as it
+	//is in the store
+	PatchItem(id int, match Match, patch Patch) (ItemChange, error)
+	ChangeItemDoneStatus(id int, value bool) error
+	DeleteAll() ([]ToDoItem, error)
+
+	//These are the ways to list the items
+	GetAllItems() ([]ToDoItem, error)
+	GetItemsPage(cursor string, limit int) (ItemPage, error)
+	CountItems() (int, error)
+	QueryItems(q Query) ([]ToDoItem, error)
+}
+
+// ItemChange is what UpdateItem or PatchItem did to an item, Before is
+// the item as it was and After the item as it was stored
+type ItemChange struct {
+	Before ToDoItem
+	After  ToDoItem
+}
+
+// The names of the stores, for the --store flag and the TODO_STORE
+// environment variable
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+	StoreRedis  = "redis"
+)
+
+// StoreKinds lists the names NewStore accepts, the first is the default
+var StoreKinds = []string{StoreMemory, StoreFile, StoreRedis}
+
+const (
+	//DefaultFileStoreLocation is where the file store keeps its items
+	//when TODO_FILE is not set
+	DefaultFileStoreLocation = "./data/todo.json"
+)
+
+// These make the compiler check that every store really implements
+// the interface, instead of finding out when the api is wired up
+var (
+	_ TodoStore = (*ToDo)(nil)
+	_ TodoStore = (*FileStore)(nil)
+	_ TodoStore = (*RedisStore)(nil)
+)
+
+// NewStore is a constructor function that returns the store called kind.
+// The location is where that store keeps its items, the name of the json
+// file for the file store or the address of redis for the redis store.
+// An empty location uses the TODO_FILE or REDIS_URL environment variable,
+// and then the default location.  The memory store has no location.
+func NewStore(kind string, location string) (TodoStore, error) {
+	//Note that we check err before we return the store, a nil *FileStore
+	//put in a TodoStore interface is NOT a nil interface
+	switch strings.ToLower(kind) {
+	case StoreMemory, "":
+		store, err := New()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case StoreFile:
+		if location == "" {
+			location = os.Getenv("TODO_FILE")
+		}
+		if location == "" {
+			location = DefaultFileStoreLocation
+		}
+		store, err := NewFileStore(location)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case StoreRedis:
+		if location == "" {
+			location = os.Getenv("REDIS_URL")
+		}
+		if location == "" {
+			location = RedisDefaultLocation
+		}
+		store, err := NewRedisStore(location)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown store %q, use one of %s", kind, strings.Join(StoreKinds, "|"))
+}
@@ -4,15 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 )
 
-// ToDoItem is the struct that represents a single ToDo item
-type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
-}
-
 // DbMap is a type alias for a map of ToDoItems.  The key
 // will be the ToDoItem.Id and the value will be the ToDoItem
 type DbMap map[int]ToDoItem
@@ -22,9 +17,18 @@ type DbMap map[int]ToDoItem
 // the file that is used to store the items.
 //
 // This is just a mock, so we will only be managing an in memory
-// map
+// map.  It is the "memory" store, see TodoStore for the others.
 type ToDo struct {
+	//Gin runs every request on its own goroutine, and a go map must
+	//not be written while anything else reads or writes it.  The lock
+	//lets many readers in at once, or a single writer on its own, so
+	//every function below takes it before it touches the map
+	lock sync.RWMutex
+
 	toDoMap DbMap
+	//nextId is the id that AddItem gives to the next item added
+	//without an id.  It never goes down, so ids are not reused
+	nextId int
 	//more things would be included in a real implementation
 }
 
@@ -39,6 +43,7 @@ func New() (*ToDo, error) {
 	//a valid empty DB, lets create the ToDo struct
 	toDo := &ToDo{
 		toDoMap: make(map[int]ToDoItem),
+		nextId:  1,
 	}
 
 	// We should be all set here, the ToDo struct is ready to go
@@ -50,12 +55,14 @@ func New() (*ToDo, error) {
 // THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
 //------------------------------------------------------------
 
-// AddItem accepts a ToDoItem and adds it to the DB.
+// AddItem accepts a ToDoItem and adds it to the DB.  An item without an
+// id (an Id of 0) is given the next free id.  The stored item, with its
+// id and timestamps, is returned.
 // Preconditions:   (1) The database file must exist and be a valid
 //
-//					(2) The item must not already exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
+//					(2) If the item has an id, it must not already exist
+//	    				in the DB because we use the item.Id as the key,
+//						this function must check if the item already
 //	    				exists in the DB, if so, return an error
 //
 // Postconditions:
@@ -63,20 +70,42 @@ func New() (*ToDo, error) {
 //	 (1) The item will be added to the DB
 //		(2) The DB file will be saved with the item added
 //		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
+func (t *ToDo) AddItem(item ToDoItem) (ToDoItem, error) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	//Hand out the next id to items that do not have one
+	if item.Id == 0 {
+		item.Id = t.nextId
+	}
+	if item.Id < 0 {
+		return ToDoItem{}, errors.New("item id cannot be negative")
+	}
 
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
 	_, ok := t.toDoMap[item.Id]
 	if ok {
-		return errors.New("item already exists")
+		return ToDoItem{}, errors.New("item already exists")
+	}
+	if err := item.Validate(); err != nil {
+		return ToDoItem{}, err
 	}
 
-	//Now that we know the item doesn't exist, lets add it to our map
+	//The database owns the timestamps, so set them before we
+	//add the item to our map
+	stampNewItem(&item)
 	t.toDoMap[item.Id] = item
 
-	//If everything is ok, return nil for the error
-	return nil
+	//An item added with an id of its own pushes the counter past
+	//that id, so the counter only ever moves forward
+	if item.Id >= t.nextId {
+		t.nextId = item.Id + 1
+	}
+
+	//If everything is ok, return the stored item
+	return item, nil
 }
 
 // DeleteItem accepts an item id and removes it from the DB.
@@ -87,33 +116,61 @@ func (t *ToDo) AddItem(item ToDoItem) error {
 //						function must check if the item already
 //	    				exists in the DB, if not, return an error
 //
+//					(3) The item must still be the one in match,
+//	    				see Match, if not, return
+//						ErrVersionMismatch
+//
 // Postconditions:
 //
-//	 (1) The item will be removed from the DB
+//	 (1) The item will be removed from the DB, and returned
 //		(2) The DB file will be saved with the item removed
 //		(3) If there is an error, it will be returned
-func (t *ToDo) DeleteItem(id int) error {
+func (t *ToDo) DeleteItem(id int, match Match) (ToDoItem, error) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
 	// we should if item exists before trying to delete it
 	// this is a good practice, return an error if the
 	// item does not exist
+	existingItem, ok := t.toDoMap[id]
+	if !ok {
+		return ToDoItem{}, errors.New("item does not exist")
+	}
+	if err := checkMatch(existingItem, match); err != nil {
+		return ToDoItem{}, err
+	}
 
 	//Now lets use the built-in go delete() function to remove
 	//the item from our map
 	delete(t.toDoMap, id)
 
-	return nil
+	return existingItem, nil
 }
 
-// DeleteAll removes all items from the DB.
+// DeleteAll removes all items from the DB, and returns the items it
+// removed, ordered by id.
 // It will be exposed via a DELETE /todo endpoint
-func (t *ToDo) DeleteAll() error {
+func (t *ToDo) DeleteAll() ([]ToDoItem, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	//The items are collected under the same lock as the delete, so
+	//they are exactly the items that were removed
+	deleted := make([]ToDoItem, 0, len(t.toDoMap))
+	for _, item := range t.toDoMap {
+		deleted = append(deleted, item)
+	}
+	sort.Slice(deleted, func(i, j int) bool {
+		return deleted[i].Id < deleted[j].Id
+	})
+
 	//To delete everything, we can just create a new map
 	//and assign it to our existing map.  The garbage collector
 	//will clean up the old map for us
 	t.toDoMap = make(map[int]ToDoItem)
 
-	return nil
+	return deleted, nil
 }
 
 // UpdateItem accepts a ToDoItem and updates it in the DB.
@@ -124,25 +181,88 @@ func (t *ToDo) DeleteAll() error {
 //						function must check if the item already
 //	    				exists in the DB, if not, return an error
 //
+//					(3) The item must still be the one the caller
+//	    				read, match, see Match, if not, return
+//						ErrVersionMismatch
+//
 // Postconditions:
 //
-//	 (1) The item will be updated in the DB
+//	 (1) The item will be updated in the DB, and the item as it was
+//			and as it is now will be returned
 //		(2) The DB file will be saved with the item updated
 //		(3) If there is an error, it will be returned
-func (t *ToDo) UpdateItem(item ToDoItem) error {
+func (t *ToDo) UpdateItem(item ToDoItem, match Match) (ItemChange, error) {
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
 
 	// Check if item exists before trying to update it
 	// this is a good practice, return an error if the
 	// item does not exist
-	_, ok := t.toDoMap[item.Id]
+	existingItem, ok := t.toDoMap[item.Id]
 	if !ok {
-		return errors.New("item does not exist")
+		return ItemChange{}, errors.New("item does not exist")
+	}
+	if err := checkMatch(existingItem, match); err != nil {
+		return ItemChange{}, err
+	}
+	if err := item.Validate(); err != nil {
+		return ItemChange{}, err
 	}
 
-	//Now that we know the item exists, lets update it
+	//Now that we know the item exists, lets update it, keeping
+	//the timestamps that belong to the existing item
+	stampUpdatedItem(existingItem, &item)
 	t.toDoMap[item.Id] = item
 
-	return nil
+	return ItemChange{Before: existingItem, After: item}, nil
+}
+
+// PatchItem accepts an item id and a patch, and applies the patch to the
+// item in the DB.  See Patch for the kinds of patches.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The item must exist in the DB
+//	    				because we use the item.Id as the key, this
+//						function must check if the item already
+//	    				exists in the DB, if not, return an error
+//
+//					(3) The item must still be the one in match,
+//	    				see Match, if not, return
+//						ErrVersionMismatch
+//
+// Postconditions:
+//
+//	 (1) The item will be updated in the DB, and the item as it was
+//			and as it is now will be returned
+//		(2) If the patch cannot be applied, an ErrInvalidPatch error
+//			will be returned and the item is left alone
+//		(3) If there is an error, it will be returned
+func (t *ToDo) PatchItem(id int, match Match, patch Patch) (ItemChange, error) {
+
+	//We hold the lock from reading the item until the patched item is
+	//stored, so two patches of the same item cannot lose each other's
+	//changes
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	existingItem, ok := t.toDoMap[id]
+	if !ok {
+		return ItemChange{}, errors.New("item does not exist")
+	}
+	if err := checkMatch(existingItem, match); err != nil {
+		return ItemChange{}, err
+	}
+
+	item, err := patch.Apply(existingItem)
+	if err != nil {
+		return ItemChange{}, err
+	}
+
+	stampUpdatedItem(existingItem, &item)
+	t.toDoMap[id] = item
+
+	return ItemChange{Before: existingItem, After: item}, nil
 }
 
 // GetItem accepts an item id and returns the item from the DB.
@@ -161,6 +281,9 @@ func (t *ToDo) UpdateItem(item ToDoItem) error {
 //		(3) The database file will not be modified
 func (t *ToDo) GetItem(id int) (ToDoItem, error) {
 
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
 	// Check if item exists before trying to get it
 	// this is a good practice, return an error if the
 	// item does not exist
@@ -194,8 +317,22 @@ func (t *ToDo) GetItem(id int) (ToDoItem, error) {
 //			item in the DB (after the status is changed).
 func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
 
-	//update was successful
-	return errors.New("not implemented")
+	for {
+		item, err := t.GetItem(id)
+		if err != nil {
+			return err
+		}
+
+		//UpdateItem sets or clears the completion time for us.  We
+		//ask for the item we read, so if someone changed the item in
+		//between, UpdateItem refuses and we start over rather than
+		//overwrite their change
+		item.IsDone = value
+		_, err = t.UpdateItem(item, MatchOf(item))
+		if !errors.Is(err, ErrVersionMismatch) {
+			return err
+		}
+	}
 }
 
 // GetAllItems returns all items from the DB.  If successful it
@@ -210,6 +347,9 @@ func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
 //		(3) The database file will not be modified
 func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
 	//Now that we have the DB loaded, lets crate a slice
 	var toDoList []ToDoItem
 
@@ -222,6 +362,67 @@ func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 	return toDoList, nil
 }
 
+// GetItemsPage returns one page of the items in the DB, ordered by id.
+// An empty cursor starts at the first item, after that the cursor is the
+// NextCursor of the page before.  A limit of 0 means no limit, so the
+// page holds every item that is left.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The cursor must be empty or come from an
+//	    				earlier page, if not, return ErrInvalidCursor
+//
+// Postconditions:
+//
+//	 (1) Up to limit items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty page
+//		(3) The database file will not be modified
+func (t *ToDo) GetItemsPage(cursor string, limit int) (ItemPage, error) {
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	toDoList := make([]ToDoItem, 0, len(t.toDoMap))
+	for _, item := range t.toDoMap {
+		toDoList = append(toDoList, item)
+	}
+
+	return pageById(toDoList, cursor, limit)
+}
+
+// CountItems returns the number of items in the DB
+func (t *ToDo) CountItems() (int, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return len(t.toDoMap), nil
+}
+
+// QueryItems returns the items that match the query, sorted the way the
+// query asks for, see Query for the filter syntax.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The matching items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *ToDo) QueryItems(q Query) ([]ToDoItem, error) {
+
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	//The query runs against the items in our map, Apply() gives
+	//us a new slice so the map itself is never touched
+	toDoList := make([]ToDoItem, 0, len(t.toDoMap))
+	for _, item := range t.toDoMap {
+		toDoList = append(toDoList, item)
+	}
+
+	return q.Apply(toDoList), nil
+}
+
 // PrintItem accepts a ToDoItem and prints it to the console
 // in a JSON pretty format. As some help, look at the
 // json.MarshalIndent() function from our in class go tutorial.
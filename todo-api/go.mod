@@ -2,7 +2,24 @@ module drexel.edu/todo
 
 go 1.20
 
-require github.com/gin-gonic/gin v1.9.1
+require (
+	github.com/alicebob/miniredis/v2 v2.31.0
+	github.com/evanphx/json-patch/v5 v5.6.0
+	github.com/gin-gonic/gin v1.9.1
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/nitishm/go-rejson/v4 v4.1.0
+	github.com/stretchr/testify v1.8.4
+)
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+)
 
 require (
 	github.com/bytedance/sonic v1.9.1 // indirect
@@ -3,9 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strings"
 
 	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
@@ -13,8 +16,10 @@ import (
 // Global variables to hold the command line flags to drive the todo CLI
 // application
 var (
-	hostFlag string
-	portFlag uint
+	hostFlag     string
+	portFlag     uint
+	storeFlag    string
+	locationFlag string
 )
 
 // processCmdLineFlags parses the command line flags for our CLI
@@ -48,6 +53,18 @@ func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
 
+	//The store decides where the todos live.  The environment variable
+	//is handy for containers, and the flag wins if both are given, for
+	//example: go run main.go --store file --location ./data/todo.json
+	defaultStore := os.Getenv("TODO_STORE")
+	if defaultStore == "" {
+		defaultStore = db.StoreMemory
+	}
+	flag.StringVar(&storeFlag, "store", defaultStore,
+		"Where to keep the todos, one of "+strings.Join(db.StoreKinds, "|")+" (env TODO_STORE)")
+	flag.StringVar(&locationFlag, "location", "",
+		"The json file for the file store (env TODO_FILE), or the redis address for the redis store (env REDIS_URL)")
+
 	flag.Parse()
 }
 
@@ -59,7 +76,14 @@ func main() {
 	r := gin.Default()
 	r.Use(cors.Default())
 
-	apiHandler, err := api.New()
+	store, err := db.NewStore(storeFlag, locationFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	log.Printf("Keeping todos in the %s store", storeFlag)
+
+	apiHandler, err := api.NewWithStore(store)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -71,6 +95,10 @@ func main() {
 	r.DELETE("/todo", apiHandler.DeleteAllToDo)
 	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
 	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.PATCH("/todo/:id", apiHandler.PatchToDo)
+
+	//Who changed what, the X-Actor header says who
+	r.GET("/audit", apiHandler.GetAudit)
 
 	r.GET("/crash", apiHandler.CrashSim)
 	r.GET("/health", apiHandler.HealthCheck)
@@ -0,0 +1,267 @@
+package tests
+
+//These tests drive the api through its http handlers, the same way a
+//client would, without starting a real server.  Run them with -race,
+//the stress test is only useful when the race detector is watching.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter returns a router with the same /todo routes as main.go,
+// backed by a new in memory store
+func newTestRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	apiHandler, err := api.New()
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.GET("/todo", apiHandler.ListAllTodos)
+	r.POST("/todo", apiHandler.AddToDo)
+	r.PUT("/todo", apiHandler.UpdateToDo)
+	r.DELETE("/todo", apiHandler.DeleteAllToDo)
+	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
+	r.GET("/todo/:id", apiHandler.GetToDo)
+	r.PATCH("/todo/:id", apiHandler.PatchToDo)
+	return r
+}
+
+// doRequest sends a request to the router, body is marshalled to json
+// unless it is nil
+func doRequest(r http.Handler, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDeleteMissingTodo(t *testing.T) {
+	r := newTestRouter(t)
+
+	w := doRequest(r, http.MethodDelete, "/todo/42", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPatchTodo(t *testing.T) {
+	r := newTestRouter(t)
+	res := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "patch me"})
+	require.Equal(t, http.StatusOK, res.Code)
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"merge patch", "/todo/1", db.MergePatchType, `{"done": true}`, http.StatusOK},
+		{"plain json is a merge patch", "/todo/1", "application/json", `{"notes": "hi"}`, http.StatusOK},
+		{"json patch", "/todo/1", db.JSONPatchType, `[{"op": "add", "path": "/tags", "value": ["work"]}]`, http.StatusOK},
+		{"failed test operation", "/todo/1", db.JSONPatchType, `[{"op": "test", "path": "/done", "value": false}]`, http.StatusBadRequest},
+		{"not a merge patch", "/todo/1", db.MergePatchType, `[]`, http.StatusBadRequest},
+		{"cannot change the id", "/todo/1", db.MergePatchType, `{"id": 2}`, http.StatusBadRequest},
+		{"missing item", "/todo/42", db.MergePatchType, `{"done": true}`, http.StatusNotFound},
+		{"bad id", "/todo/one", db.MergePatchType, `{"done": true}`, http.StatusBadRequest},
+		{"unsupported type", "/todo/1", "text/plain", `done`, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+		})
+	}
+
+	//Only the successful patches changed the item
+	res = doRequest(r, http.MethodGet, "/todo/1", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var item db.ToDoItem
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &item))
+	assert.Equal(t, "patch me", item.Title)
+	assert.True(t, item.IsDone)
+	assert.Equal(t, "hi", item.Notes)
+	assert.Equal(t, []string{"work"}, item.Tags)
+}
+
+func TestListCount(t *testing.T) {
+	r := newTestRouter(t)
+	for i := 0; i < 3; i++ {
+		res := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "count me"})
+		require.Equal(t, http.StatusOK, res.Code)
+	}
+
+	//Counting is only done when the caller asks for it
+	res := doRequest(r, http.MethodGet, "/todo?limit=2", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Empty(t, res.Header().Get(api.TotalCountHeader))
+	assert.Equal(t, `</todo?cursor=2&limit=2>; rel="next"`, res.Header().Get("Link"))
+
+	//The next link does not ask to count again
+	res = doRequest(r, http.MethodGet, "/todo?limit=2&count=true", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "3", res.Header().Get(api.TotalCountHeader))
+	assert.Equal(t, `</todo?cursor=2&limit=2>; rel="next"`, res.Header().Get("Link"))
+
+	res = doRequest(r, http.MethodGet, "/todo?count=maybe", nil)
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+}
+
+func TestETags(t *testing.T) {
+	r := newTestRouter(t)
+	res := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Id: 1, Title: "v1"})
+	require.Equal(t, http.StatusOK, res.Code)
+
+	//The tag is the version and the created time of the todo, only the
+	//version changes from one change to the next
+	var created db.ToDoItem
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &created))
+	require.NotNil(t, created.CreatedAt)
+	tag := func(version int) string {
+		return fmt.Sprintf(`"%d-%s"`, version, strconv.FormatInt(created.CreatedAt.UnixNano(), 36))
+	}
+	assert.Equal(t, tag(1), res.Header().Get("ETag"))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		body    string
+		status  int
+		etag    string
+	}{
+		{"get", http.MethodGet, "/todo/1", nil, "", http.StatusOK, tag(1)},
+		{"get not modified", http.MethodGet, "/todo/1", map[string]string{"If-None-Match": tag(1)}, "", http.StatusNotModified, tag(1)},
+		{"get weak not modified", http.MethodGet, "/todo/1", map[string]string{"If-None-Match": "W/" + tag(1)}, "", http.StatusNotModified, tag(1)},
+		{"get modified", http.MethodGet, "/todo/1", map[string]string{"If-None-Match": tag(7)}, "", http.StatusOK, tag(1)},
+		{"put current version", http.MethodPut, "/todo", map[string]string{"If-Match": tag(1)}, `{"id": 1, "title": "v2"}`, http.StatusOK, tag(2)},
+		{"put stale version", http.MethodPut, "/todo", map[string]string{"If-Match": tag(1)}, `{"id": 1, "title": "lost"}`, http.StatusPreconditionFailed, ""},
+		{"put stale version in the body", http.MethodPut, "/todo", nil, `{"id": 1, "title": "lost", "version": 1}`, http.StatusPreconditionFailed, ""},
+		{"put weak etag", http.MethodPut, "/todo", map[string]string{"If-Match": "W/" + tag(2)}, `{"id": 1, "title": "lost"}`, http.StatusPreconditionFailed, ""},
+		{"put two etags", http.MethodPut, "/todo", map[string]string{"If-Match": tag(1) + ", " + tag(2)}, `{"id": 1, "title": "lost"}`, http.StatusBadRequest, ""},
+		{"put if none match", http.MethodPut, "/todo", map[string]string{"If-None-Match": "*"}, `{"id": 1, "title": "lost"}`, http.StatusPreconditionFailed, ""},
+		{"patch current version", http.MethodPatch, "/todo/1", map[string]string{"If-Match": tag(2), "Content-Type": db.MergePatchType}, `{"done": true}`, http.StatusOK, tag(3)},
+		{"patch stale version", http.MethodPatch, "/todo/1", map[string]string{"If-Match": tag(2), "Content-Type": db.MergePatchType}, `{"done": false}`, http.StatusPreconditionFailed, ""},
+		{"post create only", http.MethodPost, "/todo", map[string]string{"If-None-Match": "*"}, `{"id": 1, "title": "lost"}`, http.StatusPreconditionFailed, ""},
+		{"delete stale version", http.MethodDelete, "/todo/1", map[string]string{"If-Match": tag(2)}, "", http.StatusPreconditionFailed, ""},
+		{"delete current version", http.MethodDelete, "/todo/1", map[string]string{"If-Match": tag(3)}, "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.etag != "" {
+				assert.Equal(t, tt.etag, w.Header().Get("ETag"))
+			}
+		})
+	}
+
+	//A todo added again with the same id starts over at version 1, the
+	//tags from before it was deleted must not match it
+	time.Sleep(time.Millisecond)
+	res = doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Id: 1, Title: "again"})
+	require.Equal(t, http.StatusOK, res.Code)
+	assert.NotEqual(t, tag(1), res.Header().Get("ETag"))
+
+	req := httptest.NewRequest(http.MethodGet, "/todo/1", nil)
+	req.Header.Set("If-None-Match", tag(1))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodPut, "/todo", strings.NewReader(`{"id": 1, "title": "lost"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", tag(1))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+}
+
+// TestConcurrentRequests hits the handlers from many goroutines at once,
+// before the memory store had a lock this crashed with "concurrent map
+// writes" or was flagged by the race detector
+func TestConcurrentRequests(t *testing.T) {
+	r := newTestRouter(t)
+
+	const workers = 16
+	const perWorker = 20
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				res := doRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "stress"})
+				if !assert.Equal(t, http.StatusOK, res.Code) {
+					return
+				}
+				var item db.ToDoItem
+				if !assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &item)) {
+					return
+				}
+				path := fmt.Sprintf("/todo/%d", item.Id)
+
+				res = doRequest(r, http.MethodGet, path, nil)
+				assert.Equal(t, http.StatusOK, res.Code)
+
+				item.IsDone = true
+				res = doRequest(r, http.MethodPut, "/todo", item)
+				assert.Equal(t, http.StatusOK, res.Code)
+
+				res = doRequest(r, http.MethodGet, "/todo?limit=10", nil)
+				assert.Equal(t, http.StatusOK, res.Code)
+
+				//Delete every other item, the second delete must
+				//find it gone
+				if i%2 == 1 {
+					res = doRequest(r, http.MethodDelete, path, nil)
+					assert.Equal(t, http.StatusOK, res.Code)
+					res = doRequest(r, http.MethodDelete, path, nil)
+					assert.Equal(t, http.StatusNotFound, res.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	res := doRequest(r, http.MethodGet, "/todo?count=true", nil)
+	require.Equal(t, http.StatusOK, res.Code)
+	var items []db.ToDoItem
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &items))
+	assert.Len(t, items, workers*perWorker/2)
+	assert.Equal(t, fmt.Sprint(len(items)), res.Header().Get("X-Total-Count"))
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAuditRouter is newTestRouter with /audit, backed by store
+func newAuditRouter(t *testing.T, store db.TodoStore) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	apiHandler, err := api.NewWithStore(store)
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.POST("/todo", apiHandler.AddToDo)
+	r.PUT("/todo", apiHandler.UpdateToDo)
+	r.DELETE("/todo", apiHandler.DeleteAllToDo)
+	r.DELETE("/todo/:id", apiHandler.DeleteToDo)
+	r.PATCH("/todo/:id", apiHandler.PatchToDo)
+	r.GET("/audit", apiHandler.GetAudit)
+	return r
+}
+
+// doAuditedRequest is doRequest as actor, with a request id
+func doAuditedRequest(r http.Handler, method string, path string, body interface{}, actor string, requestID string) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(api.ActorHeader, actor)
+	req.Header.Set(api.RequestIDHeader, requestID)
+	req.RemoteAddr = "192.0.2.7:4242"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func getAudit(t *testing.T, r http.Handler, query string) []db.AuditEntry {
+	w := doRequest(r, http.MethodGet, "/audit?"+query, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var entries []db.AuditEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	return entries
+}
+
+func auditItem(t *testing.T, data json.RawMessage) *db.ToDoItem {
+	if string(data) == "null" {
+		return nil
+	}
+	var item db.ToDoItem
+	require.NoError(t, json.Unmarshal(data, &item))
+	return &item
+}
+
+func TestAuditTrail(t *testing.T) {
+	for _, factory := range stores {
+		t.Run(factory.name, func(t *testing.T) {
+			r := newAuditRouter(t, factory.new(t))
+
+			w := doAuditedRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "alice", "req-1")
+			require.Equal(t, http.StatusOK, w.Code)
+			w = doAuditedRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Mow the lawn"}, "alice", "req-2")
+			require.Equal(t, http.StatusOK, w.Code)
+			w = doAuditedRequest(r, http.MethodPut, "/todo", db.ToDoItem{Id: 1, Title: "Paint the fence white"}, "bob", "req-3")
+			require.Equal(t, http.StatusOK, w.Code)
+			req := httptest.NewRequest(http.MethodPatch, "/todo/1", bytes.NewReader([]byte(`{"done": true}`)))
+			req.Header.Set("Content-Type", db.MergePatchType)
+			w = httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			generatedID := w.Header().Get(api.RequestIDHeader)
+			assert.Len(t, generatedID, 32)
+			w = doAuditedRequest(r, http.MethodDelete, "/todo/1", nil, "carol", "req-5")
+			require.Equal(t, http.StatusOK, w.Code)
+
+			//Changes that fail are not changes
+			w = doAuditedRequest(r, http.MethodDelete, "/todo/1", nil, "carol", "req-6")
+			require.Equal(t, http.StatusNotFound, w.Code)
+			w = doAuditedRequest(r, http.MethodPut, "/todo", db.ToDoItem{Id: 42, Title: "Nobody"}, "carol", "req-7")
+			require.Equal(t, http.StatusNotFound, w.Code)
+			assert.Empty(t, getAudit(t, r, "resource=todo&id=42"))
+
+			entries := getAudit(t, r, "resource=todo&id=1")
+			require.Len(t, entries, 4)
+			actions := []string{}
+			for _, entry := range entries {
+				actions = append(actions, entry.Action)
+				assert.Equal(t, "todo", entry.Resource)
+				assert.Equal(t, 1, entry.Id)
+				assert.False(t, entry.Time.IsZero())
+			}
+			assert.Equal(t, []string{db.AuditAdd, db.AuditUpdate, db.AuditPatch, db.AuditDelete}, actions)
+
+			add := entries[0]
+			assert.Equal(t, "alice", add.Actor)
+			assert.Equal(t, "req-1", add.RequestId)
+			assert.Equal(t, "192.0.2.7", add.ClientIP)
+			assert.Nil(t, auditItem(t, add.Old))
+			assert.Equal(t, "Paint the fence", auditItem(t, add.New).Title)
+
+			update := entries[1]
+			assert.Equal(t, "bob", update.Actor)
+			assert.Equal(t, "Paint the fence", auditItem(t, update.Old).Title)
+			assert.Equal(t, "Paint the fence white", auditItem(t, update.New).Title)
+
+			patch := entries[2]
+			assert.Equal(t, "anonymous", patch.Actor)
+			assert.Equal(t, generatedID, patch.RequestId)
+			assert.False(t, auditItem(t, patch.Old).IsDone)
+			assert.True(t, auditItem(t, patch.New).IsDone)
+
+			del := entries[3]
+			assert.Equal(t, "carol", del.Actor)
+			assert.Equal(t, "Paint the fence white", auditItem(t, del.Old).Title)
+			assert.Nil(t, auditItem(t, del.New))
+
+			//Deleting everything leaves the audit log alone, and adds a
+			//delete for every todo
+			w = doAuditedRequest(r, http.MethodDelete, "/todo", nil, "dave", "req-7")
+			require.Equal(t, http.StatusOK, w.Code)
+			entries = getAudit(t, r, "resource=todo")
+			require.Len(t, entries, 6)
+			for i := 1; i < len(entries); i++ {
+				assert.Less(t, entries[i-1].Seq, entries[i].Seq)
+			}
+			last := entries[5]
+			assert.Equal(t, 2, last.Id)
+			assert.Equal(t, db.AuditDelete, last.Action)
+			assert.Equal(t, "dave", last.Actor)
+
+			assert.Empty(t, getAudit(t, r, "resource=todo&id=42"))
+		})
+	}
+}
+
+func TestAuditDeleteAllWhileAdding(t *testing.T) {
+	for _, factory := range stores {
+		t.Run(factory.name, func(t *testing.T) {
+			r := newAuditRouter(t, factory.new(t))
+
+			//Todos are added while everything is being deleted, each one
+			//is either deleted with an audit entry or still there for the
+			//last delete
+			const adds = 20
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < adds; i++ {
+					w := doAuditedRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "item"}, "alice", "")
+					assert.Equal(t, http.StatusOK, w.Code)
+				}
+			}()
+			for i := 0; i < adds/2; i++ {
+				w := doAuditedRequest(r, http.MethodDelete, "/todo", nil, "bob", "")
+				assert.Equal(t, http.StatusOK, w.Code)
+			}
+			wg.Wait()
+			w := doAuditedRequest(r, http.MethodDelete, "/todo", nil, "bob", "")
+			require.Equal(t, http.StatusOK, w.Code)
+
+			actions := map[int][]string{}
+			for _, entry := range getAudit(t, r, "resource=todo") {
+				actions[entry.Id] = append(actions[entry.Id], entry.Action)
+			}
+			assert.Len(t, actions, adds)
+			for id, history := range actions {
+				assert.Equal(t, []string{db.AuditAdd, db.AuditDelete}, history, "todo %d", id)
+			}
+		})
+	}
+}
+
+func TestAuditQuery(t *testing.T) {
+	store, err := db.New()
+	require.NoError(t, err)
+	r := newAuditRouter(t, store)
+	tests := []string{"", "resource=voter", "resource=todo&id=x", "resource=todo&id=-1"}
+	for _, query := range tests {
+		t.Run(query, func(t *testing.T) {
+			w := doRequest(r, http.MethodGet, "/audit?"+query, nil)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestFileAuditLogSurvivesRestart(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "todo.json")
+	store, err := db.NewFileStore(fileName)
+	require.NoError(t, err)
+	r := newAuditRouter(t, store)
+	w := doAuditedRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Paint the fence"}, "alice", "req-1")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	//A new api on the same file carries on where the old one stopped
+	store, err = db.NewFileStore(fileName)
+	require.NoError(t, err)
+	r = newAuditRouter(t, store)
+	w = doAuditedRequest(r, http.MethodPost, "/todo", db.ToDoItem{Title: "Mow the lawn"}, "bob", "req-2")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	entries := getAudit(t, r, "resource=todo")
+	require.Len(t, entries, 2)
+	assert.Equal(t, int64(1), entries[0].Seq)
+	assert.Equal(t, int64(2), entries[1].Seq)
+	assert.Equal(t, "bob", entries[1].Actor)
+	assert.FileExists(t, filepath.Join(filepath.Dir(fileName), "todo-audit.jsonl"))
+}
@@ -0,0 +1,347 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+// redisJSONServer is an in process redis for the redis store to talk to,
+// so the tests do not need a redis container.  miniredis does not know
+// the RedisJSON module, so we register the few JSON commands the store
+// uses.  Documents are kept as plain strings, and only the root path
+// (. or $) and the top level fields of a document (.done or $.done) are
+// supported.
+//
+// The commands also work from a Lua script.  miniredis holds its lock
+// while a script runs, so we cannot use its Get and Set functions, they
+// take the same lock.  Instead every command reads and writes the
+// document with the GET and SET commands, on the connection of the
+// caller, which is how a script reaches redis too.
+type redisJSONServer struct {
+	*miniredis.Miniredis
+
+	//Every connection runs on its own goroutine, the lock makes each
+	//command a single step, like real redis.  A script does not need
+	//it, nothing else runs while miniredis runs a script.
+	lock sync.Mutex
+
+	//writes logs the command and path of every JSON write, so a test
+	//can check which parts of a document were written.  It has its own
+	//lock, because scripts write to it too
+	writes     []string
+	writesLock sync.Mutex
+}
+
+// newRedisJSONServer starts a redisJSONServer that is stopped when the
+// test ends
+func newRedisJSONServer(t *testing.T) *redisJSONServer {
+	s := &redisJSONServer{Miniredis: miniredis.RunT(t)}
+
+	commands := map[string]server.Cmd{
+		"JSON.SET":       s.jsonSet,
+		"JSON.GET":       s.jsonGet,
+		"JSON.DEL":       s.jsonDel,
+		"JSON.ARRAPPEND": s.jsonArrAppend,
+	}
+	for name, cmd := range commands {
+		if err := s.Server().Register(name, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// Writes returns the writes logged so far, as "<command> <path>"
+func (s *redisJSONServer) Writes() []string {
+	s.writesLock.Lock()
+	defer s.writesLock.Unlock()
+	return append([]string(nil), s.writes...)
+}
+
+func (s *redisJSONServer) logWrite(cmd string, path string) {
+	s.writesLock.Lock()
+	defer s.writesLock.Unlock()
+	s.writes = append(s.writes, cmd+" "+path)
+}
+
+// JSON.SET <key> <path> <json> [NX | XX]
+func (s *redisJSONServer) jsonSet(c *server.Peer, cmd string, args []string) {
+	if len(args) < 3 || len(args) > 4 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.SET' command")
+		return
+	}
+	key, doc := args[0], args[2]
+	field, err := fieldFromPath(args[1])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	if !json.Valid([]byte(doc)) {
+		c.WriteError("ERR invalid json")
+		return
+	}
+
+	defer s.begin(c)()
+
+	var fields map[string]json.RawMessage
+	exists := s.exists(c, key)
+	if field != "" {
+		if !exists {
+			c.WriteError("ERR new objects must be created at the root")
+			return
+		}
+		if fields, err = s.load(c, key); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+		_, exists = fields[field]
+	}
+
+	if len(args) == 4 {
+		switch strings.ToUpper(args[3]) {
+		case "NX":
+			if exists {
+				c.WriteNull()
+				return
+			}
+		case "XX":
+			if !exists {
+				c.WriteNull()
+				return
+			}
+		default:
+			c.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	if field != "" {
+		fields[field] = json.RawMessage(doc)
+		if err := s.store(c, key, fields); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+	} else if err := s.set(c, key, doc); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	s.logWrite("JSON.SET", args[1])
+	c.WriteOK()
+}
+
+// JSON.GET <key> [path]
+func (s *redisJSONServer) jsonGet(c *server.Peer, cmd string, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.GET' command")
+		return
+	}
+	field := ""
+	if len(args) == 2 {
+		var err error
+		if field, err = fieldFromPath(args[1]); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+	}
+
+	defer s.begin(c)()
+	doc, ok := s.get(c, args[0])
+	if !ok {
+		c.WriteNull()
+		return
+	}
+	if field == "" {
+		c.WriteBulk(doc)
+		return
+	}
+	fields, err := s.load(c, args[0])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	value, ok := fields[field]
+	if !ok {
+		c.WriteError("ERR path does not exist")
+		return
+	}
+	c.WriteBulk(string(value))
+}
+
+// JSON.DEL <key> [path]
+func (s *redisJSONServer) jsonDel(c *server.Peer, cmd string, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.DEL' command")
+		return
+	}
+	path, field := ".", ""
+	if len(args) == 2 {
+		var err error
+		path = args[1]
+		if field, err = fieldFromPath(path); err != nil {
+			c.WriteError(err.Error())
+			return
+		}
+	}
+
+	defer s.begin(c)()
+	if !s.exists(c, args[0]) {
+		c.WriteInt(0)
+		return
+	}
+	s.logWrite("JSON.DEL", path)
+	if field == "" {
+		s.call(c, "DEL", args[0])
+		c.WriteInt(1)
+		return
+	}
+	fields, err := s.load(c, args[0])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	if _, ok := fields[field]; !ok {
+		c.WriteInt(0)
+		return
+	}
+	delete(fields, field)
+	if err := s.store(c, args[0], fields); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	c.WriteInt(1)
+}
+
+// JSON.ARRAPPEND <key> <path> <json> [json ...]
+func (s *redisJSONServer) jsonArrAppend(c *server.Peer, cmd string, args []string) {
+	if len(args) < 3 {
+		c.WriteError("ERR wrong number of arguments for 'JSON.ARRAPPEND' command")
+		return
+	}
+	field, err := fieldFromPath(args[1])
+	if err != nil || field == "" {
+		c.WriteError("ERR only top level fields are supported by this test server")
+		return
+	}
+
+	defer s.begin(c)()
+	if !s.exists(c, args[0]) {
+		c.WriteError("ERR could not perform this operation on a key that doesn't exist")
+		return
+	}
+	fields, err := s.load(c, args[0])
+	if err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	var array []json.RawMessage
+	if err := json.Unmarshal(fields[field], &array); err != nil || array == nil {
+		c.WriteError("ERR path is not an array")
+		return
+	}
+	for _, value := range args[2:] {
+		if !json.Valid([]byte(value)) {
+			c.WriteError("ERR invalid json")
+			return
+		}
+		array = append(array, json.RawMessage(value))
+	}
+	data, _ := json.Marshal(array)
+	fields[field] = data
+	if err := s.store(c, args[0], fields); err != nil {
+		c.WriteError(err.Error())
+		return
+	}
+	s.logWrite("JSON.ARRAPPEND", args[1])
+	c.WriteInt(len(array))
+}
+
+// begin starts a command for c, it returns the function that ends it
+func (s *redisJSONServer) begin(c *server.Peer) func() {
+	if inScript(c) {
+		return func() {}
+	}
+	s.lock.Lock()
+	return s.lock.Unlock
+}
+
+// inScript returns true if c is a redis.call() from a Lua script.
+// miniredis keeps this in the unexported connection context of c, so
+// we have to peek at it.
+func inScript(c *server.Peer) bool {
+	ctx := reflect.ValueOf(c.Ctx)
+	if ctx.Kind() != reflect.Pointer || ctx.IsNil() {
+		return false
+	}
+	nested := ctx.Elem().FieldByName("nested")
+	return nested.IsValid() && nested.Bool()
+}
+
+// call runs a plain redis command for c, and returns the reply
+func (s *redisJSONServer) call(c *server.Peer, args ...string) (interface{}, error) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	peer := server.NewPeer(w)
+	peer.Ctx = c.Ctx
+	s.Server().Dispatch(peer, args)
+	w.Flush()
+	return server.ParseReply(bufio.NewReader(&buf))
+}
+
+func (s *redisJSONServer) get(c *server.Peer, key string) (string, bool) {
+	reply, err := s.call(c, "GET", key)
+	doc, ok := reply.(string)
+	return doc, err == nil && ok
+}
+
+func (s *redisJSONServer) set(c *server.Peer, key string, doc string) error {
+	_, err := s.call(c, "SET", key, doc)
+	return err
+}
+
+func (s *redisJSONServer) exists(c *server.Peer, key string) bool {
+	reply, err := s.call(c, "EXISTS", key)
+	return err == nil && reply == 1
+}
+
+// load reads the document at key as its top level fields
+func (s *redisJSONServer) load(c *server.Peer, key string) (map[string]json.RawMessage, error) {
+	doc, ok := s.get(c, key)
+	if !ok {
+		return nil, errors.New("ERR no such key")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(doc), &fields); err != nil {
+		return nil, errors.New("ERR the document is not an object")
+	}
+	return fields, nil
+}
+
+// store writes the top level fields back as the document at key
+func (s *redisJSONServer) store(c *server.Peer, key string, fields map[string]json.RawMessage) error {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return s.set(c, key, string(data))
+}
+
+// fieldFromPath returns the field a path points at, or "" for the root
+func fieldFromPath(path string) (string, error) {
+	if path == "." || path == "$" {
+		return "", nil
+	}
+	field := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if field == "" || field == path || strings.ContainsAny(field, ".[]") {
+		return "", errors.New("ERR only the root path and top level fields are supported by this test server")
+	}
+	return field, nil
+}
@@ -0,0 +1,596 @@
+package tests
+
+//This is a conformance suite for the todo stores.  Every store that
+//implements db.TodoStore has to follow the same rules, so we write the
+//tests once and run them against every store.  To test a new store, add
+//it to the stores table below.
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeFactory makes a new, empty store for every test.  The skip map
+// lists the tests a store cannot pass yet, with the reason.
+type storeFactory struct {
+	name string
+	new  func(t *testing.T) db.TodoStore
+	skip map[string]string
+}
+
+var stores = []storeFactory{
+	{
+		name: db.StoreMemory,
+		new: func(t *testing.T) db.TodoStore {
+			store, err := db.New()
+			require.NoError(t, err)
+			return store
+		},
+	},
+	{
+		name: db.StoreFile,
+		new: func(t *testing.T) db.TodoStore {
+			store, err := db.NewFileStore(filepath.Join(t.TempDir(), "todo.json"))
+			require.NoError(t, err)
+			return store
+		},
+	},
+	{
+		name: db.StoreRedis,
+		new: func(t *testing.T) db.TodoStore {
+			s := newRedisJSONServer(t)
+			store, err := db.NewRedisStore(s.Addr())
+			require.NoError(t, err)
+			return store
+		},
+	},
+}
+
+// conformanceTests are run against every store
+var conformanceTests = []struct {
+	name string
+	run  func(t *testing.T, store db.TodoStore)
+}{
+	{"add assigns ids", testAddAssignsIds},
+	{"add keeps the item", testAddKeepsTheItem},
+	{"add duplicate id", testAddDuplicateId},
+	{"add invalid item", testAddInvalidItem},
+	{"get missing item", testGetMissingItem},
+	{"update item", testUpdateItem},
+	{"update missing item", testUpdateMissingItem},
+	{"patch item", testPatchItem},
+	{"patch refused", testPatchRefused},
+	{"change done status", testChangeItemDoneStatus},
+	{"versions", testVersions},
+	{"concurrent versioned updates", testConcurrentVersionedUpdates},
+	{"delete item", testDeleteItem},
+	{"delete missing item", testDeleteMissingItem},
+	{"delete all", testDeleteAll},
+	{"list items", testListItems},
+	{"concurrent access", testConcurrentAccess},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, factory := range stores {
+		factory := factory
+		t.Run(factory.name, func(t *testing.T) {
+			for _, tt := range conformanceTests {
+				tt := tt
+				t.Run(tt.name, func(t *testing.T) {
+					if reason, ok := factory.skip[tt.name]; ok {
+						t.Skip(reason)
+					}
+					tt.run(t, factory.new(t))
+				})
+			}
+		})
+	}
+}
+
+func testAddAssignsIds(t *testing.T, store db.TodoStore) {
+	first, err := store.AddItem(db.ToDoItem{Title: "first"})
+	require.NoError(t, err)
+	second, err := store.AddItem(db.ToDoItem{Title: "second"})
+	require.NoError(t, err)
+	assert.Greater(t, first.Id, 0)
+	assert.NotEqual(t, first.Id, second.Id)
+
+	//An item with its own id keeps it, and the ids handed out after it
+	//do not collide with it
+	own, err := store.AddItem(db.ToDoItem{Id: 3, Title: "own id"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, own.Id)
+	next, err := store.AddItem(db.ToDoItem{Title: "next"})
+	require.NoError(t, err)
+	assert.NotContains(t, []int{first.Id, second.Id, own.Id}, next.Id)
+
+	_, err = store.AddItem(db.ToDoItem{Id: -1, Title: "negative"})
+	assert.Error(t, err, "ids cannot be negative")
+}
+
+func testAddKeepsTheItem(t *testing.T, store db.TodoStore) {
+	added, err := store.AddItem(db.ToDoItem{
+		Title:    "Send the invoice",
+		Priority: db.PriorityHigh,
+		Tags:     []string{"work"},
+		Notes:    "ask for the PO number",
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, added.CreatedAt, "the store sets the timestamps")
+	assert.NotNil(t, added.UpdatedAt, "the store sets the timestamps")
+
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(added), withoutTimestamps(stored))
+	assert.True(t, added.CreatedAt.Equal(*stored.CreatedAt))
+}
+
+func testAddDuplicateId(t *testing.T, store db.TodoStore) {
+	_, err := store.AddItem(db.ToDoItem{Id: 5, Title: "original"})
+	require.NoError(t, err)
+
+	_, err = store.AddItem(db.ToDoItem{Id: 5, Title: "duplicate"})
+	assert.EqualError(t, err, "item already exists")
+
+	//The original item must not have been touched
+	stored, err := store.GetItem(5)
+	require.NoError(t, err)
+	assert.Equal(t, "original", stored.Title)
+}
+
+func testAddInvalidItem(t *testing.T, store db.TodoStore) {
+	_, err := store.AddItem(db.ToDoItem{Id: 1, Title: "item", Priority: "urgent"})
+	assert.Error(t, err, "urgent is not a priority")
+
+	items, err := store.GetAllItems()
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func testGetMissingItem(t *testing.T, store db.TodoStore) {
+	_, err := store.GetItem(42)
+	assert.EqualError(t, err, "item does not exist")
+}
+
+func testUpdateItem(t *testing.T, store db.TodoStore) {
+	added, err := store.AddItem(db.ToDoItem{Title: "before"})
+	require.NoError(t, err)
+
+	added.Title = "after"
+	added.CreatedAt = nil
+	change, err := store.UpdateItem(added, db.Match{Version: added.Version})
+	require.NoError(t, err)
+	assert.Equal(t, "before", change.Before.Title, "the item as it was")
+	assert.Equal(t, "after", change.After.Title, "the item as it was stored")
+	assert.Equal(t, change.Before.Version+1, change.After.Version)
+
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "after", stored.Title)
+	if assert.NotNil(t, stored.CreatedAt, "an update keeps the creation time") {
+		assert.True(t, stored.CreatedAt.Equal(*stored.UpdatedAt) || stored.CreatedAt.Before(*stored.UpdatedAt))
+	}
+}
+
+func testUpdateMissingItem(t *testing.T, store db.TodoStore) {
+	_, err := store.UpdateItem(db.ToDoItem{Id: 42, Title: "missing"}, db.Match{})
+	assert.EqualError(t, err, "item does not exist")
+
+	//An update must not create the item
+	_, err = store.GetItem(42)
+	assert.Error(t, err)
+}
+
+func testPatchItem(t *testing.T, store db.TodoStore) {
+	added, err := store.AddItem(db.ToDoItem{
+		Title:    "before",
+		Priority: db.PriorityLow,
+		Tags:     []string{"home"},
+		Notes:    "some notes",
+	})
+	require.NoError(t, err)
+
+	//A merge patch changes the fields it names, null removes a field
+	change, err := store.PatchItem(added.Id, db.Match{}, mustPatch(t, db.MergePatchType,
+		`{"title": "after", "priority": "high", "notes": null}`))
+	require.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(added), withoutTimestamps(change.Before), "the item as it was")
+	patched := change.After
+	assert.Equal(t, "after", patched.Title)
+	assert.Equal(t, db.PriorityHigh, patched.Priority)
+	assert.Empty(t, patched.Notes)
+	assert.Equal(t, []string{"home"}, patched.Tags, "fields the patch does not name are kept")
+
+	//A json patch can add to the end of a list
+	change, err = store.PatchItem(added.Id, db.Match{}, mustPatch(t, db.JSONPatchType,
+		`[{"op": "test", "path": "/title", "value": "after"},
+		  {"op": "add", "path": "/tags/-", "value": "work"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(patched), withoutTimestamps(change.Before))
+	patched = change.After
+	assert.Equal(t, []string{"home", "work"}, patched.Tags)
+
+	//The store holds what PatchItem returned
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(patched), withoutTimestamps(stored))
+	if assert.NotNil(t, stored.CreatedAt) {
+		assert.True(t, added.CreatedAt.Equal(*stored.CreatedAt), "a patch keeps the creation time")
+	}
+
+	_, err = store.PatchItem(42, db.Match{}, mustPatch(t, db.MergePatchType, `{"done": true}`))
+	assert.EqualError(t, err, "item does not exist")
+}
+
+func testPatchRefused(t *testing.T, store db.TodoStore) {
+	added, err := store.AddItem(db.ToDoItem{Title: "keep me"})
+	require.NoError(t, err)
+
+	refused := []struct {
+		patchType string
+		doc       string
+	}{
+		{db.MergePatchType, `{"id": 99}`},
+		{db.MergePatchType, `{"priority": "urgent"}`},
+		{db.MergePatchType, `{"titel": "typo"}`},
+		{db.MergePatchType, `{"done": "yes"}`},
+		{db.JSONPatchType, `[{"op": "test", "path": "/title", "value": "something else"},
+		                     {"op": "replace", "path": "/title", "value": "changed"}]`},
+		{db.JSONPatchType, `[{"op": "remove", "path": "/notes"}]`},
+	}
+	for _, tt := range refused {
+		_, err := store.PatchItem(added.Id, db.Match{}, mustPatch(t, tt.patchType, tt.doc))
+		assert.ErrorIs(t, err, db.ErrInvalidPatch, "patch %s", tt.doc)
+	}
+
+	//None of the patches above touched the item
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(added), withoutTimestamps(stored))
+}
+
+func testChangeItemDoneStatus(t *testing.T, store db.TodoStore) {
+	added, err := store.AddItem(db.ToDoItem{Title: "finish me", Tags: []string{"work"}})
+	require.NoError(t, err)
+
+	require.NoError(t, store.ChangeItemDoneStatus(added.Id, true))
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.True(t, stored.IsDone)
+	assert.NotNil(t, stored.CompletedAt)
+	assert.Equal(t, []string{"work"}, stored.Tags)
+
+	require.NoError(t, store.ChangeItemDoneStatus(added.Id, false))
+	stored, err = store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.False(t, stored.IsDone)
+	assert.Nil(t, stored.CompletedAt)
+
+	assert.EqualError(t, store.ChangeItemDoneStatus(42, true), "item does not exist")
+}
+
+func testVersions(t *testing.T, store db.TodoStore) {
+	added, err := store.AddItem(db.ToDoItem{Title: "v1"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added.Version)
+
+	//Every kind of change bumps the version by one
+	added.Title = "v2"
+	_, err = store.UpdateItem(added, db.MatchOf(added))
+	require.NoError(t, err)
+	patched, err := store.PatchItem(added.Id, db.Match{Version: 2}, mustPatch(t, db.MergePatchType, `{"title": "v3"}`))
+	require.NoError(t, err)
+	assert.Equal(t, 3, patched.After.Version)
+	require.NoError(t, store.ChangeItemDoneStatus(added.Id, true))
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 4, stored.Version)
+
+	//Changes to a version that is no longer current are refused
+	stale := stored
+	stale.Version = 2
+	stale.Title = "stale"
+	_, err = store.UpdateItem(stale, db.Match{Version: 2})
+	assert.ErrorIs(t, err, db.ErrVersionMismatch)
+	_, err = store.PatchItem(added.Id, db.Match{Version: 3}, mustPatch(t, db.MergePatchType, `{"title": "stale"}`))
+	assert.ErrorIs(t, err, db.ErrVersionMismatch)
+	_, err = store.DeleteItem(added.Id, db.Match{Version: 3})
+	assert.ErrorIs(t, err, db.ErrVersionMismatch)
+
+	unchanged, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(stored), withoutTimestamps(unchanged))
+
+	//The current version works, and so does no version at all
+	stored.Title = "v5"
+	_, err = store.UpdateItem(stored, db.MatchOf(stored))
+	require.NoError(t, err)
+	stored.Title = "v6"
+	_, err = store.UpdateItem(stored, db.Match{})
+	require.NoError(t, err)
+	deleted, err := store.DeleteItem(added.Id, db.Match{Version: 6})
+	require.NoError(t, err)
+	assert.Equal(t, "v6", deleted.Title)
+	_, err = store.GetItem(added.Id)
+	assert.Error(t, err)
+
+	//An item that is added again with the same id starts over at
+	//version 1, the created time tells it apart from the one we read
+	first, err := store.AddItem(db.ToDoItem{Title: "first"})
+	require.NoError(t, err)
+	_, err = store.DeleteItem(first.Id, db.Match{})
+	require.NoError(t, err)
+	second, err := store.AddItem(db.ToDoItem{Id: first.Id, Title: "second"})
+	require.NoError(t, err)
+	require.Equal(t, first.Version, second.Version)
+	require.False(t, first.CreatedAt.Equal(*second.CreatedAt))
+
+	_, err = store.UpdateItem(db.ToDoItem{Id: first.Id, Title: "stale"}, db.MatchOf(first))
+	assert.ErrorIs(t, err, db.ErrVersionMismatch)
+	_, err = store.PatchItem(first.Id, db.MatchOf(first), mustPatch(t, db.MergePatchType, `{"title": "stale"}`))
+	assert.ErrorIs(t, err, db.ErrVersionMismatch)
+	_, err = store.DeleteItem(first.Id, db.MatchOf(first))
+	assert.ErrorIs(t, err, db.ErrVersionMismatch)
+
+	//A match without a created time only checks the version
+	_, err = store.PatchItem(first.Id, db.Match{Version: 1}, mustPatch(t, db.MergePatchType, `{"title": "third"}`))
+	require.NoError(t, err)
+	deleted, err = store.DeleteItem(first.Id, db.Match{Version: 2, CreatedAt: second.CreatedAt})
+	require.NoError(t, err)
+	assert.Equal(t, "third", deleted.Title)
+}
+
+func testConcurrentVersionedUpdates(t *testing.T, store db.TodoStore) {
+	const workers = 8
+
+	added, err := store.AddItem(db.ToDoItem{Title: "contested", Tags: []string{"start"}})
+	require.NoError(t, err)
+
+	//Everybody read version 1, only one of them can win
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			item := added
+			item.Title = fmt.Sprintf("writer %d", w)
+			_, err := store.UpdateItem(item, db.MatchOf(item))
+			errs <- err
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	wins := 0
+	for err := range errs {
+		if err == nil {
+			wins++
+			continue
+		}
+		assert.ErrorIs(t, err, db.ErrVersionMismatch)
+	}
+	assert.Equal(t, 1, wins)
+
+	//Changes that do not ask for a version are never lost, each one is
+	//made to the item as the others left it, and gets back the item as
+	//it was just before its own change
+	befores := make(chan int, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			patch := fmt.Sprintf(`[{"op": "add", "path": "/tags/-", "value": "tag%d"}]`, w)
+			change, err := store.PatchItem(added.Id, db.Match{}, mustPatch(t, db.JSONPatchType, patch))
+			if assert.NoError(t, err) {
+				assert.Equal(t, change.Before.Version+1, change.After.Version)
+				assert.Len(t, change.After.Tags, len(change.Before.Tags)+1)
+				befores <- change.Before.Version
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(befores)
+
+	seen := map[int]bool{}
+	for version := range befores {
+		assert.False(t, seen[version], "two patches saw version %d as the item before them", version)
+		seen[version] = true
+	}
+
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 2+workers, stored.Version)
+	assert.Len(t, stored.Tags, 1+workers)
+}
+
+// TestRedisPatchWritesFields checks that the redis store writes just the
+// fields a patch changes, and not the whole document
+func TestRedisPatchWritesFields(t *testing.T) {
+	s := newRedisJSONServer(t)
+	store, err := db.NewRedisStore(s.Addr())
+	require.NoError(t, err)
+
+	added, err := store.AddItem(db.ToDoItem{Title: "item", Tags: []string{"home"}, Notes: "notes"})
+	require.NoError(t, err)
+	before := len(s.Writes())
+
+	require.NoError(t, store.ChangeItemDoneStatus(added.Id, true))
+	_, err = store.PatchItem(added.Id, db.Match{}, mustPatch(t, db.JSONPatchType,
+		`[{"op": "add", "path": "/tags/-", "value": "work"},
+		  {"op": "remove", "path": "/notes"}]`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"JSON.SET .completed_at", "JSON.SET .done", "JSON.SET .updated_at", "JSON.SET .version",
+		"JSON.DEL .notes", "JSON.ARRAPPEND .tags", "JSON.SET .updated_at", "JSON.SET .version",
+	}, s.Writes()[before:])
+
+	stored, err := store.GetItem(added.Id)
+	require.NoError(t, err)
+	assert.True(t, stored.IsDone)
+	assert.Equal(t, []string{"home", "work"}, stored.Tags)
+	assert.Empty(t, stored.Notes)
+}
+
+func testDeleteItem(t *testing.T, store db.TodoStore) {
+	keep, err := store.AddItem(db.ToDoItem{Title: "keep"})
+	require.NoError(t, err)
+	remove, err := store.AddItem(db.ToDoItem{Title: "remove"})
+	require.NoError(t, err)
+
+	deleted, err := store.DeleteItem(remove.Id, db.Match{})
+	require.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(remove), withoutTimestamps(deleted), "the item as it was deleted")
+	_, err = store.GetItem(remove.Id)
+	assert.Error(t, err)
+	_, err = store.GetItem(keep.Id)
+	assert.NoError(t, err)
+}
+
+func testDeleteMissingItem(t *testing.T, store db.TodoStore) {
+	_, err := store.DeleteItem(42, db.Match{})
+	assert.EqualError(t, err, "item does not exist")
+}
+
+func testDeleteAll(t *testing.T, store db.TodoStore) {
+	//Deleting everything from an empty store is fine
+	deleted, err := store.DeleteAll()
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+
+	var added []db.ToDoItem
+	for i := 0; i < 3; i++ {
+		item, err := store.AddItem(db.ToDoItem{Title: "item"})
+		require.NoError(t, err)
+		added = append(added, item)
+	}
+
+	//The items that were deleted come back, ordered by id
+	deleted, err = store.DeleteAll()
+	require.NoError(t, err)
+	assert.Equal(t, added, deleted)
+
+	items, err := store.GetAllItems()
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	//The store still works after it was emptied
+	_, err = store.AddItem(db.ToDoItem{Title: "after"})
+	assert.NoError(t, err)
+}
+
+func testListItems(t *testing.T, store db.TodoStore) {
+	const count = 7
+	for i := 0; i < count; i++ {
+		item := db.ToDoItem{Title: "item"}
+		if i%2 == 0 {
+			item.Tags = []string{"even"}
+		}
+		_, err := store.AddItem(item)
+		require.NoError(t, err)
+	}
+
+	items, err := store.GetAllItems()
+	require.NoError(t, err)
+	assert.Len(t, items, count)
+
+	//Walking the pages returns every item exactly once
+	seen := make(map[int]bool)
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, count, "paging never ended")
+		page, err := store.GetItemsPage(cursor, 3)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Items), 3)
+		for _, item := range page.Items {
+			assert.False(t, seen[item.Id], "item %d is on two pages", item.Id)
+			seen[item.Id] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.Len(t, seen, count)
+	total, err := store.CountItems()
+	require.NoError(t, err)
+	assert.Equal(t, count, total)
+
+	_, err = store.GetItemsPage("not a cursor", 3)
+	assert.ErrorIs(t, err, db.ErrInvalidCursor)
+
+	q, err := db.NewQuery("tag:even", "", "")
+	require.NoError(t, err)
+	even, err := store.QueryItems(q)
+	require.NoError(t, err)
+	assert.Len(t, even, 4)
+}
+
+func testConcurrentAccess(t *testing.T, store db.TodoStore) {
+	const workers = 8
+	const perWorker = 10
+
+	ids := make(chan int, workers*perWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				item, err := store.AddItem(db.ToDoItem{Title: "concurrent"})
+				if !assert.NoError(t, err) {
+					return
+				}
+				ids <- item.Id
+
+				//Mix in reads, updates and deletes of our own items
+				item.Title = "updated"
+				_, err = store.UpdateItem(item, db.MatchOf(item))
+				assert.NoError(t, err)
+				_, err = store.GetItem(item.Id)
+				assert.NoError(t, err)
+				_, err = store.GetAllItems()
+				assert.NoError(t, err)
+				if i%2 == 1 {
+					_, err = store.DeleteItem(item.Id, db.Match{})
+					assert.NoError(t, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "id %d was handed out twice", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, workers*perWorker)
+
+	items, err := store.GetAllItems()
+	require.NoError(t, err)
+	assert.Len(t, items, workers*perWorker/2)
+}
+
+func mustPatch(t *testing.T, patchType string, doc string) db.Patch {
+	patch, err := db.NewPatch(patchType, []byte(doc))
+	require.NoError(t, err)
+	return patch
+}
+
+// withoutTimestamps clears the timestamps, which the store sets, so the
+// rest of an item can be compared with assert.Equal
+func withoutTimestamps(item db.ToDoItem) db.ToDoItem {
+	item.CreatedAt = nil
+	item.UpdatedAt = nil
+	item.CompletedAt = nil
+	return item
+}
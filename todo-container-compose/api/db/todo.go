@@ -10,19 +10,18 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/nitishm/go-rejson/v4"
+	"github.com/nitishm/go-rejson/v4/rjs"
 )
 
-// ToDoItem is the struct that represents a single ToDo item
-type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
-}
-
 const (
 	RedisNilError        = "redis: nil"
 	RedisDefaultLocation = "0.0.0.0:6379"
 	RedisKeyPrefix       = "todo:"
+
+	//RedisNextIdKey holds the last id handed out by AddItem.  It is
+	//deliberately outside of the todo: prefix, so it is never mistaken
+	//for a todo item
+	RedisNextIdKey = "todo-next-id"
 )
 
 type cache struct {
@@ -134,16 +133,34 @@ func (t *ToDo) getItemFromRedis(key string, item *ToDoItem) error {
 	return nil
 }
 
+// Helper to store an item that must not exist yet, it returns an
+// "item already exists" error if the key is taken
+func (t *ToDo) setNewItemInRedis(item ToDoItem) error {
+	res, err := t.jsonHelper.JSONSet(redisKeyFromId(item.Id), ".", item, rjs.SetOptionNX)
+	if err != nil {
+		return err
+	}
+	//With NX, redis answers nil instead of OK when the key already
+	//exists.  The rejson helper turns that nil into a nil result, not
+	//an error, so this is how we find out
+	if res == nil {
+		return errors.New("item already exists")
+	}
+	return nil
+}
+
 //------------------------------------------------------------
 // THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
 //------------------------------------------------------------
 
-// AddItem accepts a ToDoItem and adds it to the DB.
+// AddItem accepts a ToDoItem and adds it to the DB.  An item without an
+// id (an Id of 0) is given the next free id.  The stored item, with its
+// id and timestamps, is returned.
 // Preconditions:   (1) The database file must exist and be a valid
 //
-//					(2) The item must not already exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
+//					(2) If the item has an id, it must not already exist
+//	    				in the DB because we use the item.Id as the key,
+//						this function must check if the item already
 //	    				exists in the DB, if so, return an error
 //
 // Postconditions:
@@ -151,23 +168,46 @@ func (t *ToDo) getItemFromRedis(key string, item *ToDoItem) error {
 //	 (1) The item will be added to the DB
 //		(2) The DB file will be saved with the item added
 //		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
-
-	//Before we add an item to the DB, lets make sure
-	//it does not exist, if it does, return an error
-	redisKey := redisKeyFromId(item.Id)
-	var existingItem ToDoItem
-	if err := t.getItemFromRedis(redisKey, &existingItem); err == nil {
-		return errors.New("item already exists")
+func (t *ToDo) AddItem(item ToDoItem) (ToDoItem, error) {
+	if item.Id < 0 {
+		return ToDoItem{}, errors.New("item id cannot be negative")
+	}
+	if err := item.Validate(); err != nil {
+		return ToDoItem{}, err
 	}
 
-	//Add item to database with JSON Set
-	if _, err := t.jsonHelper.JSONSet(redisKey, ".", item); err != nil {
-		return err
+	//The database owns the timestamps, so set them before we
+	//store the item
+	stampNewItem(&item)
+
+	//An item with its own id is stored under that id.  The NX option
+	//tells redis to only set the key if it does not exist yet, so the
+	//check and the write are a single atomic step
+	if item.Id != 0 {
+		if err := t.setNewItemInRedis(item); err != nil {
+			return ToDoItem{}, err
+		}
+		return item, nil
 	}
 
-	//If everything is ok, return nil for the error
-	return nil
+	//Otherwise we ask redis for the next id.  INCR is atomic, so two
+	//API instances sharing this redis never get the same number.  An
+	//id can still be taken by an item that was added with its own id,
+	//in that case we simply move on to the next number.
+	for {
+		nextId, err := t.cacheClient.Incr(t.context, RedisNextIdKey).Result()
+		if err != nil {
+			return ToDoItem{}, err
+		}
+		item.Id = int(nextId)
+		err = t.setNewItemInRedis(item)
+		if err == nil {
+			return item, nil
+		}
+		if err.Error() != "item already exists" {
+			return ToDoItem{}, err
+		}
+	}
 }
 
 // DeleteItem accepts an item id and removes it from the DB.
@@ -202,7 +242,14 @@ func (t *ToDo) DeleteItem(id int) error {
 func (t *ToDo) DeleteAll() error {
 
 	pattern := RedisKeyPrefix + "*"
-	ks, _ := t.cacheClient.Keys(t.context, pattern).Result()
+	ks, err := t.scanKeys(pattern)
+	if err != nil {
+		return err
+	}
+	//DEL needs at least one key
+	if len(ks) == 0 {
+		return nil
+	}
 	//Note delete can take a collection of keys.  In go we can
 	//expand a slice into individual arguments by using the ...
 	//operator
@@ -240,6 +287,12 @@ func (t *ToDo) UpdateItem(item ToDoItem) error {
 	if err := t.getItemFromRedis(redisKey, &existingItem); err != nil {
 		return errors.New("item does not exist")
 	}
+	if err := item.Validate(); err != nil {
+		return err
+	}
+
+	//Keep the timestamps that belong to the existing item
+	stampUpdatedItem(existingItem, &item)
 
 	//Add item to database with JSON Set.  Note there is no update
 	//functionality, so we just overwrite the existing item
@@ -320,14 +373,24 @@ func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 
 	//Now that we have the DB loaded, lets crate a slice
 	var toDoList []ToDoItem
-	var toDoItem ToDoItem
 
 	//Lets query redis for all of the items
 	pattern := RedisKeyPrefix + "*"
-	ks, _ := t.cacheClient.Keys(t.context, pattern).Result()
+	ks, err := t.scanKeys(pattern)
+	if err != nil {
+		return nil, err
+	}
 	for _, key := range ks {
+		//Use a fresh item for every key, json.Unmarshal leaves fields
+		//that are missing from the json alone, so reusing one item
+		//would leak tags or dates from the previous item into this one
+		var toDoItem ToDoItem
 		err := t.getItemFromRedis(key, &toDoItem)
 		if err != nil {
+			//The item was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
 			return nil, err
 		}
 		toDoList = append(toDoList, toDoItem)
@@ -336,6 +399,59 @@ func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
 	return toDoList, nil
 }
 
+// GetItemsPage returns one page of the items in the DB.  An empty
+// cursor starts at the first item, after that the cursor is the
+// NextCursor of the page before.  A limit of 0 means no limit, so the
+// page holds every item that is left.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//					(2) The cursor must be empty or come from an
+//	    				earlier page, if not, return ErrInvalidCursor
+//
+// Postconditions:
+//
+//	 (1) Up to limit items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty page
+//		(3) The database file will not be modified
+func (t *ToDo) GetItemsPage(cursor string, limit int) (ItemPage, error) {
+
+	//Redis hands out the keys in its own order, which is not the
+	//order of the ids, see scanPage for how the cursor works
+	pattern := RedisKeyPrefix + "*"
+	keys, nextCursor, err := t.scanPage(pattern, cursor, limit)
+	if err != nil {
+		return ItemPage{}, err
+	}
+
+	page := ItemPage{
+		Items:      make([]ToDoItem, 0, len(keys)),
+		NextCursor: nextCursor,
+	}
+	for _, key := range keys {
+		var toDoItem ToDoItem
+		err := t.getItemFromRedis(key, &toDoItem)
+		if err != nil {
+			//The item was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
+			return ItemPage{}, err
+		}
+		page.Items = append(page.Items, toDoItem)
+	}
+
+	return page, nil
+}
+
+// CountItems returns the number of items in the DB.  Counting means
+// scanning every key, SCAN never blocks redis the way KEYS does but it
+// still walks the whole keyspace, so the api only counts when the
+// caller asks for it.
+func (t *ToDo) CountItems() (int, error) {
+	return t.countKeys(RedisKeyPrefix + "*")
+}
+
 // PrintItem accepts a ToDoItem and prints it to the console
 // in a JSON pretty format. As some help, look at the
 // json.MarshalIndent() function from our in class go tutorial.
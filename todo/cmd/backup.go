@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+
+	"drexel.edu/todo/db"
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the database from the sample backup file",
+	Long: `Restore the database from the sample backup file, that is the database
+file name with .bak added, for example ./data/todo.json.bak.  The database
+that is replaced is kept as a timestamped backup, see "todo backup".`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		if err := todo.RestoreDB(); err != nil {
+			return err
+		}
+		message(cmd.OutOrStdout(), "Database restored from backup file")
+		return nil
+	},
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "List, compare and restore the timestamped backups",
+	Long: `Every time the database is changed, the version from right before the
+change is kept as a timestamped backup.  Backups are numbered newest first,
+so backup 1 always holds the database from before the last change.  A backup
+can be referred to by its number or by its name.`,
+}
+
+var backupListCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List the timestamped backups, newest first",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		backups, err := todo.ListBackups()
+		if err != nil {
+			return err
+		}
+		if backups == nil {
+			backups = make([]db.Backup, 0)
+		}
+
+		table := tableData{headers: []string{"number", "time", "items", "name"}}
+		for i, b := range backups {
+			count := fmt.Sprint(b.ItemCount)
+			if b.ItemCount < 0 {
+				count = "DAMAGED"
+			}
+			table.rows = append(table.rows, []string{fmt.Sprint(i + 1),
+				b.Time.Local().Format("2006-01-02 15:04:05"), count, b.Name})
+		}
+		return render(cmd.OutOrStdout(), backups, table)
+	},
+}
+
+var backupDiffCmd = &cobra.Command{
+	Use:     "diff BACKUP",
+	Short:   "Show what changed between a backup and the database",
+	Example: "  todo backup diff 1",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		diff, err := todo.DiffBackup(args[0])
+		if err != nil {
+			return err
+		}
+
+		table := tableData{headers: append([]string{"change"}, itemHeaders()...)}
+		for _, item := range diff.Added {
+			table.rows = append(table.rows, append([]string{"added"}, itemRow(item)...))
+		}
+		for _, item := range diff.Removed {
+			table.rows = append(table.rows, append([]string{"removed"}, itemRow(item)...))
+		}
+		for _, change := range diff.Changed {
+			table.rows = append(table.rows, append([]string{"before"}, itemRow(change.Before)...))
+			table.rows = append(table.rows, append([]string{"after"}, itemRow(change.After)...))
+		}
+		message(cmd.OutOrStdout(), "Comparing backup %s to the database", diff.Backup.Name)
+		if diff.IsEmpty() {
+			message(cmd.OutOrStdout(), "No differences")
+			if outputFlag == FormatTable {
+				return nil
+			}
+		}
+		return render(cmd.OutOrStdout(), diff, table)
+	},
+}
+
+var backupRestoreCmd = &cobra.Command{
+	Use:   "restore BACKUP",
+	Short: "Replace the database with a backup",
+	Long: `Replace the database with a backup.  The database that is replaced is
+backed up first, so "todo backup restore 1" can undo a restore as well.`,
+	Example: "  todo backup restore 1",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		if err := todo.RestoreBackup(args[0]); err != nil {
+			return err
+		}
+		message(cmd.OutOrStdout(), "Database restored from backup %s", args[0])
+		return nil
+	},
+}
+
+func init() {
+	backupCmd.AddCommand(backupListCmd, backupDiffCmd, backupRestoreCmd)
+	rootCmd.AddCommand(restoreCmd, backupCmd)
+}
@@ -0,0 +1,302 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+
+	"drexel.edu/todo/db"
+	"github.com/spf13/cobra"
+)
+
+// Flags for the item sub commands.  Each sub command has its own flag
+// set, so for example --title means something to "add" and "edit", but
+// is rejected by "list".
+var (
+	addIdFlag   int
+	addDoneFlag bool
+	editTitle   string
+	editDone    bool
+	addFields   itemFieldFlags
+	editFields  itemFieldFlags
+
+	listSortFlag  string
+	listOrderFlag string
+)
+
+// itemFieldFlags holds the flags for the optional item fields, they are
+// the same for "add" and "edit" so we register them in one place
+type itemFieldFlags struct {
+	due      string
+	priority string
+	tags     []string
+	notes    string
+}
+
+func (f *itemFieldFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.due, "due", "", `Due date, as 2006-01-02 or RFC 3339, "" removes it`)
+	cmd.Flags().StringVar(&f.priority, "priority", "", "Priority, one of low|medium|high")
+	cmd.Flags().StringSliceVar(&f.tags, "tag", nil, "Tag for the item, repeat the flag or separate tags with commas")
+	cmd.Flags().StringVar(&f.notes, "notes", "", "Free form notes")
+}
+
+// changed returns true if any of the optional field flags were given
+func (f *itemFieldFlags) changed(cmd *cobra.Command) bool {
+	for _, name := range []string{"due", "priority", "tag", "notes"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply copies the optional field flags that were given on the command
+// line into item, flags that were not given leave the item alone
+func (f *itemFieldFlags) apply(cmd *cobra.Command, item *db.ToDoItem) error {
+	if cmd.Flags().Changed("due") {
+		due, err := parseDate(f.due)
+		if err != nil {
+			return err
+		}
+		item.DueDate = due
+	}
+	if cmd.Flags().Changed("priority") {
+		item.Priority = db.Priority(strings.ToLower(f.priority))
+	}
+	if cmd.Flags().Changed("tag") {
+		item.Tags = f.tags
+	}
+	if cmd.Flags().Changed("notes") {
+		item.Notes = f.notes
+	}
+	return item.Validate()
+}
+
+var addCmd = &cobra.Command{
+	Use:   "add [--id ID] [--done] [--due DATE] [--priority P] [--tag TAG]... [--notes N] TITLE... | add JSON",
+	Short: "Add an item to the database",
+	Long: `Add an item to the database.  The item can either be given as a title
+with flags, or as a json todo item like the one printed by "todo get -o json".
+Items added without an id get the next free id, the new item is printed so
+you can see which id it got.`,
+	Example: `  todo add Learn Cobra
+  todo add --due 2026-11-01 --priority high --tag work,billing Send the invoice
+  todo add '{"title": "Learn Cobra", "done": false, "tags": ["learning"]}'`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+
+		var item db.ToDoItem
+		if len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+			if item, err = todo.JsonToItem(args[0]); err != nil {
+				return errors.New("add requires a valid JSON todo item string: " + err.Error())
+			}
+		} else {
+			item = db.ToDoItem{
+				Id:     addIdFlag,
+				Title:  strings.Join(args, " "),
+				IsDone: addDoneFlag,
+			}
+			if err := addFields.apply(cmd, &item); err != nil {
+				return err
+			}
+		}
+		if strings.TrimSpace(item.Title) == "" {
+			return errors.New("the item needs a title")
+		}
+
+		//The database fills in the id and the timestamps, so show
+		//the stored item rather than the one we built
+		item, err = todo.AddItem(item)
+		if err != nil {
+			return err
+		}
+		return renderItem(cmd.OutOrStdout(), item)
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:     "list [FILTER...] [--sort FIELD] [--order asc|desc]",
+	Aliases: []string{"ls"},
+	Short:   "List the items in the database, all of them or the ones matching a filter",
+	Long: `List the items in the database.  Without a filter every item is listed,
+otherwise only the items that match every term of the filter.  A term is a
+field, an operator and a value:
+
+  field:value   the field equals the value (for tag: the item has the tag)
+  field~value   the field contains the value, ignoring case
+  field<value   also <=, > and >=, for id, priority and the dates
+
+The fields are id, title, notes, done, tag, priority, due, created, updated
+and completed.  Dates are written as 2006-01-02 or as an RFC 3339 time, and
+"none" matches items without a priority or date, for example due:none.
+Values with spaces go in double quotes, a term that starts with - is negated
+and a word on its own searches the title and the notes.  Put negated terms
+after --, so they are not mistaken for flags.  The same filters work with
+the todo API, as GET /v2/todo?q=...`,
+	Example: `  todo list
+  todo list -o csv
+  todo list done:false tag:work 'due<2026-11-01' --sort due
+  todo list --sort priority --order desc -- 'title~"send the invoice"' -tag:home`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		//The shell has already split the filter into words, so we
+		//join them back together before parsing
+		query, err := db.NewQuery(strings.Join(args, " "), listSortFlag, listOrderFlag)
+		if err != nil {
+			return err
+		}
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		items, err := todo.QueryItems(query)
+		if err != nil {
+			return err
+		}
+
+		return renderItems(cmd.OutOrStdout(), items)
+	},
+}
+
+var getCmd = &cobra.Command{
+	Use:     "get ID",
+	Aliases: []string{"show"},
+	Short:   "Show a single item",
+	Example: `  todo get 3 -o yaml`,
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ids, err := parseIDs(args)
+		if err != nil {
+			return err
+		}
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		item, err := todo.GetItem(ids[0])
+		if err != nil {
+			return err
+		}
+		return renderItem(cmd.OutOrStdout(), item)
+	},
+}
+
+var editCmd = &cobra.Command{
+	Use:   "edit ID [--title TITLE] [--done=true|false] [--due DATE] [--priority P] [--tag TAG]... [--notes N]",
+	Short: "Change an item in the database",
+	Long: `Change an item in the database.  Only the fields that are given as
+flags are changed, everything else about the item is left alone.`,
+	Example: `  todo edit 3 --title "Learn Cloud Native Architecture, for real"
+  todo edit 3 --priority low --due ""`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ids, err := parseIDs(args)
+		if err != nil {
+			return err
+		}
+		if !cmd.Flags().Changed("title") && !cmd.Flags().Changed("done") && !editFields.changed(cmd) {
+			return errors.New("nothing to change, pass at least one of the flags, see todo edit -h")
+		}
+		if cmd.Flags().Changed("title") && strings.TrimSpace(editTitle) == "" {
+			return errors.New("the item needs a title")
+		}
+
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		//The item is read and saved under one lock, so a change another
+		//todo process makes at the same time is not overwritten
+		item, err := todo.ModifyItem(ids[0], func(item *db.ToDoItem) error {
+			if cmd.Flags().Changed("title") {
+				item.Title = editTitle
+			}
+			if cmd.Flags().Changed("done") {
+				item.IsDone = editDone
+			}
+			return editFields.apply(cmd, item)
+		})
+		if err != nil {
+			return err
+		}
+		return renderItem(cmd.OutOrStdout(), item)
+	},
+}
+
+// newDoneCmd builds the "done" and "undone" commands, they only differ
+// in the status they set
+func newDoneCmd(use string, short string, status bool) *cobra.Command {
+	return &cobra.Command{
+		Use:     use + " ID...",
+		Short:   short,
+		Example: "  todo " + use + " 3 4",
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids, err := parseIDs(args)
+			if err != nil {
+				return err
+			}
+			todo, err := openDB()
+			if err != nil {
+				return err
+			}
+
+			var items []db.ToDoItem
+			for _, id := range ids {
+				item, err := todo.ModifyItem(id, func(item *db.ToDoItem) error {
+					item.IsDone = status
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+			return renderItems(cmd.OutOrStdout(), items)
+		},
+	}
+}
+
+var rmCmd = &cobra.Command{
+	Use:     "rm ID...",
+	Aliases: []string{"delete"},
+	Short:   "Delete items from the database",
+	Example: "  todo rm 3",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ids, err := parseIDs(args)
+		if err != nil {
+			return err
+		}
+		todo, err := openDB()
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := todo.DeleteItem(id); err != nil {
+				return err
+			}
+			message(cmd.OutOrStdout(), "Deleted item %d", id)
+		}
+		return nil
+	},
+}
+
+func init() {
+	addCmd.Flags().IntVar(&addIdFlag, "id", 0, "Id of the new item, by default the next free id")
+	addCmd.Flags().BoolVar(&addDoneFlag, "done", false, "Add the item as already done")
+	addFields.register(addCmd)
+
+	editCmd.Flags().StringVar(&editTitle, "title", "", "New title for the item")
+	editCmd.Flags().BoolVar(&editDone, "done", false, "New done status for the item")
+	editFields.register(editCmd)
+
+	listCmd.Flags().StringVar(&listSortFlag, "sort", "id", "Field to sort by, one of id|title|done|priority|due|created|updated|completed")
+	listCmd.Flags().StringVar(&listOrderFlag, "order", "asc", "Sort order, asc or desc")
+
+	rootCmd.AddCommand(addCmd, listCmd, getCmd, editCmd, rmCmd,
+		newDoneCmd("done", "Mark items as done", true),
+		newDoneCmd("undone", "Mark items as not done", false))
+}
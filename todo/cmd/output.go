@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"drexel.edu/todo/db"
+	"gopkg.in/yaml.v3"
+)
+
+// These are the values accepted by the --output flag.  The table format
+// is meant for people, the others are meant for scripts.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+	FormatYAML  = "yaml"
+)
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case FormatTable, FormatJSON, FormatCSV, FormatYAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, use one of json|table|csv|yaml", format)
+}
+
+// tableData is a value rendered as rows and columns, it is used for the
+// table and csv formats.  The json and yaml formats render the original
+// value instead, so they keep its full structure.
+type tableData struct {
+	headers []string
+	rows    [][]string
+}
+
+// render writes value to w in the format selected with --output
+func render(w io.Writer, value any, table tableData) error {
+	switch outputFlag {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(value)
+	case FormatYAML:
+		return renderYAML(w, value)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(table.headers); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(table.rows); err != nil {
+			return err
+		}
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(table.headers, "\t")))
+		for _, row := range table.rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	}
+}
+
+// renderYAML goes through json first so the yaml output uses the same
+// field names as the json output and the database file.  Decoding into
+// a yaml.Node keeps the fields in their original order.
+func renderYAML(w io.Writer, value any) error {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(jsonBytes, &node); err != nil {
+		return err
+	}
+	//json is yaml "flow style", switch back to the usual block style
+	clearYAMLStyle(&node)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// clearYAMLStyle resets the style of every node so the encoder picks the
+// default block style
+func clearYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYAMLStyle(child)
+	}
+}
+
+// message prints a status line for people.  Scripts that ask for a
+// machine readable format only get the data, so it is skipped for them.
+func message(w io.Writer, format string, a ...any) {
+	if outputFlag == FormatTable {
+		fmt.Fprintf(w, format+"\n", a...)
+	}
+}
+
+// The table format only shows the columns that fit on a terminal, csv
+// is for scripts so it gets every field
+var (
+	itemTableHeaders = []string{"id", "title", "done", "priority", "due", "tags"}
+	itemCSVHeaders   = []string{"id", "title", "done", "priority", "due", "tags", "notes",
+		"created_at", "updated_at", "completed_at"}
+)
+
+func itemHeaders() []string {
+	if outputFlag == FormatCSV {
+		return itemCSVHeaders
+	}
+	return itemTableHeaders
+}
+
+func itemRow(item db.ToDoItem) []string {
+	if outputFlag == FormatCSV {
+		return []string{fmt.Sprint(item.Id), item.Title, fmt.Sprint(item.IsDone),
+			string(item.Priority), formatTime(item.DueDate), strings.Join(item.Tags, ","),
+			item.Notes, formatTime(item.CreatedAt), formatTime(item.UpdatedAt),
+			formatTime(item.CompletedAt)}
+	}
+
+	due := ""
+	if item.DueDate != nil {
+		due = item.DueDate.Local().Format(dateFormat)
+	}
+	return []string{fmt.Sprint(item.Id), item.Title, fmt.Sprint(item.IsDone),
+		string(item.Priority), due, strings.Join(item.Tags, ",")}
+}
+
+// formatTime writes an optional time as RFC 3339, the same way it is
+// written to json
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// renderItems writes a list of items.  A nil list is rendered as an
+// empty list, so json output is [] rather than null.
+func renderItems(w io.Writer, items []db.ToDoItem) error {
+	if items == nil {
+		items = make([]db.ToDoItem, 0)
+	}
+	table := tableData{headers: itemHeaders()}
+	for _, item := range items {
+		table.rows = append(table.rows, itemRow(item))
+	}
+	return render(w, items, table)
+}
+
+// renderItem writes a single item, as an object rather than a list
+func renderItem(w io.Writer, item db.ToDoItem) error {
+	return render(w, item, tableData{headers: itemHeaders(), rows: [][]string{itemRow(item)}})
+}
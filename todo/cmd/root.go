@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/spf13/cobra"
+)
+
+// Global variables to hold the flags that are shared by every todo
+// sub command.  They are registered as "persistent" flags on the root
+// command, which means cobra accepts them before or after the name of
+// any sub command, for example both "todo --db x.json list" and
+// "todo list --db x.json" work.
+var (
+	dbFileNameFlag string
+	keepFlag       int
+	outputFlag     string
+)
+
+// rootCmd is the "todo" command itself.  It does not do anything on its
+// own, it just holds the sub commands (add, list, done, ...).  Cobra
+// builds the help output for every command from the Use, Short, Long
+// and Example fields, so "todo help done" or "todo done -h" explain
+// just that one command.
+var rootCmd = &cobra.Command{
+	Use:   "todo",
+	Short: "todo manages a simple list of todo items",
+	Long: `todo manages a simple list of todo items that are kept in a json file.
+
+Every change to the database is crash safe, and the version from right
+before the change is kept as a timestamped backup, see "todo backup".`,
+	//We print our own errors, there is no need to dump the usage text
+	//every time an item is not found
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return validateOutputFormat(outputFlag)
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&dbFileNameFlag, "db", "./data/todo.json", "Name of the database file")
+	rootCmd.PersistentFlags().IntVar(&keepFlag, "keep", db.DefaultBackupCount, "Number of timestamped backups to keep")
+	rootCmd.PersistentFlags().StringVarP(&outputFlag, "output", "o", FormatTable, "Output format, one of json|table|csv|yaml")
+}
+
+// Execute runs the todo command line, it is called from main()
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// openDB opens the database selected with the --db flag
+func openDB() (*db.ToDo, error) {
+	return db.NewWithBackupCount(dbFileNameFlag, keepFlag)
+}
+
+// parseIDs converts the command line arguments into item ids
+func parseIDs(args []string) ([]int, error) {
+	ids := make([]int, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid item id", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// dateFormat is the short date format accepted by --due and used to show
+// due dates in tables
+const dateFormat = "2006-01-02"
+
+// parseDate accepts a date as 2006-01-02, which is midnight local time,
+// or as a full RFC 3339 time.  An empty string means no date.
+func parseDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if t, err := time.ParseInLocation(dateFormat, s, time.Local); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid date, use %s or RFC 3339", s, dateFormat)
+	}
+	return &t, nil
+}
@@ -0,0 +1,319 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------
+// ROTATING BACKUPS
+//------------------------------------------------------------
+
+const (
+	// DefaultBackupCount is the number of timestamped backups that are
+	// kept when the database is opened with New()
+	DefaultBackupCount = 10
+
+	// backupDirName is the directory, next to the database file, that
+	// holds the timestamped backups
+	backupDirName = "backups"
+
+	// backupTimeFormat is fixed width, so sorting backup file names
+	// alphabetically also sorts them by time
+	backupTimeFormat = "20060102T150405.000000000Z"
+	backupFileSuffix = ".bak"
+)
+
+// Backup describes one timestamped snapshot of the database.  A backup
+// holds the database as it was right BEFORE a change was made, so
+// restoring the newest backup undoes the last change.
+type Backup struct {
+	Name      string    `json:"name"`
+	Time      time.Time `json:"time"`
+	ItemCount int       `json:"items"`
+	path      string
+}
+
+// ItemChange holds the two versions of an item that is different
+// between a backup and the live database
+type ItemChange struct {
+	Before ToDoItem `json:"before"`
+	After  ToDoItem `json:"after"`
+}
+
+// BackupDiff describes what changed between a backup and the live
+// database.  Added items are in the live database but not in the backup,
+// Removed items are in the backup but no longer in the live database.
+type BackupDiff struct {
+	Backup  Backup       `json:"backup"`
+	Added   []ToDoItem   `json:"added"`
+	Removed []ToDoItem   `json:"removed"`
+	Changed []ItemChange `json:"changed"`
+}
+
+// IsEmpty returns true if the backup and the live database are the same
+func (d BackupDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// ListBackups returns all of the backups of the database, newest first.
+// The position of a backup in this list (starting at 1) can be used to
+// refer to it in RestoreBackup() and DiffBackup().
+func (t *ToDo) ListBackups() ([]Backup, error) {
+	lock, err := t.lockDB(false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
+
+	return t.listBackups()
+}
+
+// RestoreBackup replaces the live database with the backup identified by
+// ref.  The ref can either be the backup file name or its position in the
+// list returned by ListBackups(), where 1 is the newest backup.  The live
+// database is itself backed up first, so a restore can also be undone.
+func (t *ToDo) RestoreBackup(ref string) error {
+	lock, err := t.lockDB(true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	backup, err := t.findBackup(ref)
+	if err != nil {
+		return err
+	}
+	return t.restoreFrom(backup.path)
+}
+
+// DiffBackup compares the backup identified by ref (see RestoreBackup()
+// for the format) with the live database
+func (t *ToDo) DiffBackup(ref string) (BackupDiff, error) {
+	lock, err := t.lockDB(false)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	defer lock.unlock()
+
+	backup, err := t.findBackup(ref)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	data, err := os.ReadFile(backup.path)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	backupContents, err := parseDB(data)
+	if err != nil {
+		return BackupDiff{}, err
+	}
+	backupItems := backupContents.Items
+	if err := t.loadDB(); err != nil {
+		return BackupDiff{}, err
+	}
+
+	diff := BackupDiff{
+		Backup:  backup,
+		Added:   make([]ToDoItem, 0),
+		Removed: make([]ToDoItem, 0),
+		Changed: make([]ItemChange, 0),
+	}
+	old := make(DbMap, len(backupItems))
+	for _, item := range backupItems {
+		old[item.Id] = item
+	}
+	for _, item := range t.sortedItems() {
+		before, ok := old[item.Id]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, item)
+		case !reflect.DeepEqual(before, item):
+			diff.Changed = append(diff.Changed, ItemChange{Before: before, After: item})
+		}
+	}
+	for _, item := range backupItems {
+		if _, ok := t.toDoMap[item.Id]; !ok {
+			diff.Removed = append(diff.Removed, item)
+		}
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool {
+		return diff.Removed[i].Id < diff.Removed[j].Id
+	})
+
+	return diff, nil
+}
+
+// backupDir returns the directory that holds the backups of our database
+func (t *ToDo) backupDir() string {
+	return filepath.Join(filepath.Dir(t.dbFileName), backupDirName)
+}
+
+// backupPrefix returns the file name prefix for our backups, more than one
+// database can live in the same directory so we include its name
+func (t *ToDo) backupPrefix() string {
+	return filepath.Base(t.dbFileName) + "."
+}
+
+// listBackups reads the backup directory, newest backup first
+// Precondition: the caller holds a database lock
+func (t *ToDo) listBackups() ([]Backup, error) {
+	entries, err := os.ReadDir(t.backupDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var backups []Backup
+	prefix := t.backupPrefix()
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, backupFileSuffix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), backupFileSuffix)
+		ts, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			//not one of ours
+			continue
+		}
+		backup := Backup{
+			Name: name,
+			Time: ts,
+			path: filepath.Join(t.backupDir(), name),
+		}
+		//A backup that cannot be parsed is still listed, but with a
+		//count of -1 so the user can tell it is damaged
+		backup.ItemCount = -1
+		if data, err := os.ReadFile(backup.path); err == nil {
+			if contents, err := parseDB(data); err == nil {
+				backup.ItemCount = len(contents.Items)
+			}
+		}
+		backups = append(backups, backup)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+	return backups, nil
+}
+
+// findBackup looks up a backup by position (1 is the newest) or by name
+// Precondition: the caller holds a database lock
+func (t *ToDo) findBackup(ref string) (Backup, error) {
+	backups, err := t.listBackups()
+	if err != nil {
+		return Backup{}, err
+	}
+
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(backups) {
+			return Backup{}, fmt.Errorf("there is no backup number %d, there are %d backups", n, len(backups))
+		}
+		return backups[n-1], nil
+	}
+
+	for _, b := range backups {
+		if b.Name == ref || b.Name == filepath.Base(ref) {
+			return b, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("backup %q not found", ref)
+}
+
+// createBackup copies the current database file into a new timestamped
+// backup and then removes the oldest backups so that at most maxBackups
+// are kept.  A database file that is not valid is never backed up, so a
+// damaged file cannot push good backups out of the rotation.
+// Precondition: the caller holds the exclusive database lock
+func (t *ToDo) createBackup() error {
+	if t.maxBackups <= 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(t.dbFileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if _, err := parseDB(data); err != nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(t.backupDir(), 0755); err != nil {
+		return err
+	}
+
+	//Two saves within the same nanosecond would collide, so make sure
+	//the new backup sorts after every existing one
+	now := time.Now().UTC()
+	existing, err := t.listBackups()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 && !now.After(existing[0].Time) {
+		now = existing[0].Time.Add(time.Nanosecond)
+	}
+
+	name := t.backupPrefix() + now.Format(backupTimeFormat) + backupFileSuffix
+	if err := writeFileAtomic(filepath.Join(t.backupDir(), name), data, 0644); err != nil {
+		return err
+	}
+
+	return t.pruneBackups()
+}
+
+// pruneBackups removes the oldest backups beyond maxBackups
+// Precondition: the caller holds the exclusive database lock
+func (t *ToDo) pruneBackups() error {
+	backups, err := t.listBackups()
+	if err != nil {
+		return err
+	}
+	for i := t.maxBackups; i < len(backups); i++ {
+		if err := os.Remove(backups[i].path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFrom replaces the live database with the contents of fileName,
+// after making a backup of the live database.  The items come from the
+// backup, but the next id counter never goes backwards, so ids that were
+// handed out after the backup was taken are not handed out again.
+// Precondition: the caller holds the exclusive database lock
+func (t *ToDo) restoreFrom(fileName string) error {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	restored, err := parseDB(data)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid todo database: %w", fileName, err)
+	}
+
+	if err := t.createBackup(); err != nil {
+		return err
+	}
+
+	//Keep the larger of the two counters, a live database that cannot
+	//be read is simply replaced
+	if err := t.loadDB(); err == nil && t.nextId > restored.NextId {
+		restored.NextId = t.nextId
+	}
+	t.setContents(restored)
+	return t.writeDB()
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileLock is an advisory lock on a file, on unix like systems this is
+// implemented with flock(2).  The lock is released when unlock is called
+// or when the process exits, so a crashed todo process never leaves the
+// database locked.
+type fileLock struct {
+	f *os.File
+}
+
+func lockFile(fileName string, exclusive bool) (*fileLock, error) {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	//Flock blocks until the lock is available, retry if we get interrupted
+	//by a signal while waiting
+	for {
+		err = syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) unlock() error {
+	defer l.f.Close()
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+}
+
+// syncDir flushes a directory so that a rename inside of it is durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,47 @@
+//go:build windows
+
+package db
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileLock is an advisory lock on a file, on windows this is implemented
+// with LockFileEx.  The lock is released when unlock is called or when the
+// process exits, so a crashed todo process never leaves the database locked.
+type fileLock struct {
+	f *os.File
+}
+
+func lockFile(fileName string, exclusive bool) (*fileLock, error) {
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	ol := new(windows.Overlapped)
+	if err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) unlock() error {
+	defer l.f.Close()
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(l.f.Fd()), 0, 1, 0, ol)
+}
+
+// syncDir is a no-op on windows, directories cannot be opened for syncing
+// and NTFS journals the rename for us
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//------------------------------------------------------------
+// CRASH SAFE PERSISTENCE HELPERS
+//------------------------------------------------------------
+
+const (
+	// lockFileSuffix is appended to the database file name to build the
+	// name of the advisory lock file.  We lock a separate file rather than
+	// the database itself because the database file gets replaced by a
+	// rename on every save, and a lock held on the old file would not
+	// protect the new one.
+	lockFileSuffix = ".lock"
+)
+
+// lockDB acquires the advisory lock that guards the database file.  Use a
+// shared lock for read only operations and an exclusive lock for anything
+// that will load, modify and then save the database.  Two todo processes
+// that both ask for an exclusive lock will take turns, so their
+// load/modify/save cycles can never interleave.
+func (t *ToDo) lockDB(exclusive bool) (*fileLock, error) {
+	return lockFile(t.dbFileName+lockFileSuffix, exclusive)
+}
+
+// withReadLock runs fn with a fresh copy of the database loaded into the
+// private map, while holding a shared lock on the database
+func (t *ToDo) withReadLock(fn func() error) error {
+	lock, err := t.lockDB(false)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	if err := t.loadDB(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// withWriteLock is like withReadLock, but it holds an exclusive lock and
+// saves the database after fn returns without an error
+func (t *ToDo) withWriteLock(fn func() error) error {
+	lock, err := t.lockDB(true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	if err := t.loadDB(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	return t.saveDB()
+}
+
+// writeFileAtomic replaces fileName with data such that a reader will
+// either see the complete old contents or the complete new contents, never
+// a partially written file.  It works by writing to a temporary file in
+// the same directory, flushing it to disk with fsync, and then renaming it
+// over the original.  Renames within a single directory are atomic on all
+// of the operating systems that we care about.
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(fileName)
+	tmp, err := os.CreateTemp(dir, filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	//If anything below fails we do not want to leave the temp file behind
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	//Sync makes sure the bytes are actually on disk before the rename
+	//makes them visible under the real file name
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, fileName); err != nil {
+		return err
+	}
+
+	//Finally flush the directory entry so the rename itself survives a crash
+	return syncDir(dir)
+}
+
+// dbContents is the layout of the database file.  Besides the items it
+// holds the id that will be handed to the next item added without one,
+// so ids are never reused, not even after the newest item is deleted.
+//
+// Older databases are a plain json array of items.  parseDB still reads
+// those, they are written in the new layout the next time they are saved.
+type dbContents struct {
+	NextId int        `json:"next_id"`
+	Items  []ToDoItem `json:"items"`
+}
+
+// parseDB checks that data holds a valid todo database, in either the
+// current layout or the old plain array layout, and returns its contents
+func parseDB(data []byte) (dbContents, error) {
+	var contents dbContents
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &contents.Items); err != nil {
+			return dbContents{}, err
+		}
+	} else if err := json.Unmarshal(trimmed, &contents); err != nil {
+		return dbContents{}, err
+	}
+
+	//An old file has no counter, and a file that was edited by hand
+	//could have one that is too small, either way never hand out an
+	//id that is already taken
+	for _, item := range contents.Items {
+		if item.Id >= contents.NextId {
+			contents.NextId = item.Id + 1
+		}
+	}
+	if contents.NextId < 1 {
+		contents.NextId = 1
+	}
+	return contents, nil
+}
+
+// recoverDB checks the database file and, if it cannot be parsed, replaces
+// it with the newest backup that is still valid.  The damaged file is kept
+// next to the database with a .corrupt-<timestamp> suffix so nothing is lost.
+// Precondition: the caller holds the exclusive database lock
+func (t *ToDo) recoverDB() error {
+	data, err := os.ReadFile(t.dbFileName)
+	if err != nil {
+		return err
+	}
+	_, parseErr := parseDB(data)
+	if parseErr == nil {
+		return nil
+	}
+
+	backups, err := t.listBackups()
+	if err != nil {
+		return err
+	}
+	var snapshot []byte
+	var snapshotName string
+	for _, b := range backups {
+		if b.ItemCount < 0 {
+			continue
+		}
+		if snapshot, err = os.ReadFile(b.path); err == nil {
+			snapshotName = b.path
+			break
+		}
+	}
+	if snapshotName == "" {
+		return fmt.Errorf("database %s is corrupt (%v) and there is no valid backup to recover from", t.dbFileName, parseErr)
+	}
+
+	corruptName := fmt.Sprintf("%s.corrupt-%s", t.dbFileName, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Rename(t.dbFileName, corruptName); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(t.dbFileName, snapshot, 0644); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "WARNING: %s was corrupt (%v), restored it from %s, the damaged file was saved as %s\n",
+		t.dbFileName, parseErr, snapshotName, corruptName)
+	return nil
+}
@@ -0,0 +1,449 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//------------------------------------------------------------
+// QUERY LANGUAGE
+//------------------------------------------------------------
+
+// A Query selects and orders todo items.  The filter is a list of terms
+// separated by spaces, an item has to match every term, for example:
+//
+//	done:false tag:work due<2026-11-01 title~"invoice"
+//
+// Each term is a field, an operator and a value:
+//
+//	field:value   the field equals the value (for tag: the item has the tag)
+//	field~value   the field contains the value, ignoring case
+//	field<value   also <=, > and >=, for id, priority and the dates
+//
+// The fields are id, title, notes, done, tag, priority, due, created,
+// updated and completed.  Dates are written as 2006-01-02, which covers
+// that whole day, or as an RFC 3339 time.  The value "none" matches items
+// that do not have a priority or date at all, for example due:none.
+// Values with spaces go in double quotes.  A term that starts with - is
+// negated, so -tag:home skips items tagged home, and a word on its own,
+// such as invoice, is short for a title or notes search.
+type Query struct {
+	terms  []queryTerm
+	sortBy string
+	desc   bool
+}
+
+// These are the fields a query can sort on, with the default first
+var sortFields = []string{"id", "title", "done", "priority", "due", "created", "updated", "completed"}
+
+// queryTerm is a single parsed term of a query filter
+type queryTerm struct {
+	negate bool
+	field  string
+	op     string
+	value  string
+
+	//The value is converted once, when the query is parsed, into
+	//the type that matches the field
+	number   int
+	boolean  bool
+	priority int
+	none     bool
+	from, to time.Time
+}
+
+// NewQuery parses a filter expression and the sort options.  An empty
+// filter matches every item, an empty sortBy sorts by id and order is
+// either asc (the default) or desc.
+func NewQuery(filter string, sortBy string, order string) (Query, error) {
+	var q Query
+
+	terms, err := parseFilter(filter)
+	if err != nil {
+		return Query{}, err
+	}
+	q.terms = terms
+
+	q.sortBy = strings.ToLower(sortBy)
+	if q.sortBy == "" {
+		q.sortBy = sortFields[0]
+	}
+	if !contains(sortFields, q.sortBy) {
+		return Query{}, fmt.Errorf("cannot sort by %q, use one of %s", sortBy, strings.Join(sortFields, "|"))
+	}
+
+	switch strings.ToLower(order) {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return Query{}, fmt.Errorf("invalid order %q, use asc or desc", order)
+	}
+
+	return q, nil
+}
+
+// Matches returns true if item matches every term of the query filter
+func (q Query) Matches(item ToDoItem) bool {
+	for _, term := range q.terms {
+		if term.matches(item) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns the items that match the query, in the order that the
+// query asks for.  The items slice itself is left alone.
+func (q Query) Apply(items []ToDoItem) []ToDoItem {
+	selected := make([]ToDoItem, 0, len(items))
+	for _, item := range items {
+		if q.Matches(item) {
+			selected = append(selected, item)
+		}
+	}
+	q.Sort(selected)
+	return selected
+}
+
+// Sort orders items the way the query asks for.  Items without a value
+// for the sort field, such as items without a due date, always come
+// last, whatever the order.  Ties are listed by id, lowest first.
+func (q Query) Sort(items []ToDoItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		aMissing, bMissing := q.missing(a), q.missing(b)
+		if aMissing != bMissing {
+			return bMissing
+		}
+		c := 0
+		if !aMissing {
+			c = q.compare(a, b)
+		}
+		if c == 0 {
+			return a.Id < b.Id
+		}
+		if q.desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// missing returns true if item has no value for the sort field
+func (q Query) missing(item ToDoItem) bool {
+	switch q.sortBy {
+	case "priority":
+		return item.Priority == PriorityNone
+	case "due", "created", "updated", "completed":
+		return itemTime(item, q.sortBy) == nil
+	}
+	return false
+}
+
+// compare compares the sort field of two items that both have a value
+func (q Query) compare(a, b ToDoItem) int {
+	switch q.sortBy {
+	case "title":
+		return strings.Compare(strings.ToLower(a.Title), strings.ToLower(b.Title))
+	case "done":
+		return boolRank(a.IsDone) - boolRank(b.IsDone)
+	case "priority":
+		return priorityRank(a.Priority) - priorityRank(b.Priority)
+	case "due", "created", "updated", "completed":
+		return itemTime(a, q.sortBy).Compare(*itemTime(b, q.sortBy))
+	}
+	return a.Id - b.Id
+}
+
+//------------------------------------------------------------
+// FILTER PARSING AND MATCHING
+//------------------------------------------------------------
+
+// The operators, longest first so <= is not read as <
+var queryOperators = []string{"<=", ">=", "<", ">", ":", "~"}
+
+// parseFilter splits a filter expression into terms
+func parseFilter(filter string) ([]queryTerm, error) {
+	var terms []queryTerm
+	pos := 0
+	for {
+		//Skip the spaces between terms
+		for pos < len(filter) && isQuerySpace(filter[pos]) {
+			pos++
+		}
+		if pos == len(filter) {
+			return terms, nil
+		}
+
+		start := pos
+		var term queryTerm
+		if filter[pos] == '-' {
+			term.negate = true
+			pos++
+		}
+
+		//The field name is made of letters, a term without an
+		//operator after the name is a plain word to search for
+		nameEnd := pos
+		for nameEnd < len(filter) && filter[nameEnd] >= 'a' && filter[nameEnd] <= 'z' {
+			nameEnd++
+		}
+		for _, op := range queryOperators {
+			if nameEnd > pos && strings.HasPrefix(filter[nameEnd:], op) {
+				term.field = filter[pos:nameEnd]
+				term.op = op
+				pos = nameEnd + len(op)
+				break
+			}
+		}
+
+		value, next, err := readQueryValue(filter, pos)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			return nil, fmt.Errorf("query term %q at position %d has no value", filter[start:next], start+1)
+		}
+		term.value = value
+		pos = next
+
+		if term.op == "" {
+			//A plain word searches the title and the notes
+			term.field, term.op = "text", "~"
+		}
+		if err := term.compile(); err != nil {
+			return nil, fmt.Errorf("query term %q at position %d: %w", filter[start:pos], start+1, err)
+		}
+		terms = append(terms, term)
+	}
+}
+
+// readQueryValue reads a plain or a double quoted value starting at pos,
+// it returns the value and the position right after it
+func readQueryValue(filter string, pos int) (string, int, error) {
+	if pos < len(filter) && filter[pos] == '"' {
+		var value strings.Builder
+		for i := pos + 1; i < len(filter); i++ {
+			switch filter[i] {
+			case '\\':
+				//A backslash keeps the next character, so \" and \\
+				//can be used inside quotes
+				if i+1 < len(filter) {
+					i++
+					value.WriteByte(filter[i])
+				}
+			case '"':
+				return value.String(), i + 1, nil
+			default:
+				value.WriteByte(filter[i])
+			}
+		}
+		return "", 0, fmt.Errorf("missing closing quote for the value at position %d", pos+1)
+	}
+
+	end := pos
+	for end < len(filter) && !isQuerySpace(filter[end]) {
+		end++
+	}
+	return filter[pos:end], end, nil
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// compile checks that the operator makes sense for the field, and
+// converts the value to the type of the field
+func (term *queryTerm) compile() error {
+	switch term.field {
+	case "title", "notes", "text":
+		return term.allowOps(":", "~")
+	case "tag":
+		return term.allowOps(":", "~")
+	case "done":
+		if err := term.allowOps(":"); err != nil {
+			return err
+		}
+		b, err := strconv.ParseBool(term.value)
+		if err != nil {
+			return errors.New("done must be true or false")
+		}
+		term.boolean = b
+	case "id":
+		if err := term.allowOps(":", "<", "<=", ">", ">="); err != nil {
+			return err
+		}
+		n, err := strconv.Atoi(term.value)
+		if err != nil {
+			return errors.New("id must be a number")
+		}
+		term.number = n
+	case "priority":
+		if err := term.allowOps(":", "<", "<=", ">", ">="); err != nil {
+			return err
+		}
+		if strings.EqualFold(term.value, "none") {
+			term.none = true
+			return term.allowOps(":")
+		}
+		p := Priority(strings.ToLower(term.value))
+		if p == PriorityNone || (ToDoItem{Priority: p}).Validate() != nil {
+			return errors.New("priority must be low, medium, high or none")
+		}
+		term.priority = priorityRank(p)
+	case "due", "created", "updated", "completed":
+		if err := term.allowOps(":", "<", "<=", ">", ">="); err != nil {
+			return err
+		}
+		if strings.EqualFold(term.value, "none") {
+			term.none = true
+			return term.allowOps(":")
+		}
+		from, to, err := parseQueryDate(term.value)
+		if err != nil {
+			return err
+		}
+		term.from, term.to = from, to
+	default:
+		return fmt.Errorf("unknown field %q", term.field)
+	}
+	return nil
+}
+
+func (term *queryTerm) allowOps(ops ...string) error {
+	if contains(ops, term.op) {
+		return nil
+	}
+	if term.none {
+		return fmt.Errorf("%s:none cannot be used with %s", term.field, term.op)
+	}
+	return fmt.Errorf("%s cannot be used with %s", term.field, term.op)
+}
+
+// parseQueryDate returns the time span that a date value covers.  A day
+// covers midnight to midnight in local time, a full time covers just
+// that instant.  The span includes from but not to.
+func parseQueryDate(value string) (time.Time, time.Time, error) {
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return day, day.AddDate(0, 0, 1), nil
+	}
+	instant, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("dates must be 2006-01-02 or RFC 3339")
+	}
+	return instant, instant.Add(time.Nanosecond), nil
+}
+
+// matches checks a single term, ignoring the negation
+func (term *queryTerm) matches(item ToDoItem) bool {
+	switch term.field {
+	case "title":
+		return matchText(term.op, item.Title, term.value)
+	case "notes":
+		return matchText(term.op, item.Notes, term.value)
+	case "text":
+		return matchText(term.op, item.Title, term.value) || matchText(term.op, item.Notes, term.value)
+	case "tag":
+		for _, tag := range item.Tags {
+			if matchText(term.op, tag, term.value) {
+				return true
+			}
+		}
+		return false
+	case "done":
+		return item.IsDone == term.boolean
+	case "id":
+		return compareInts(term.op, item.Id, term.number)
+	case "priority":
+		if term.none || item.Priority == PriorityNone {
+			return term.none && item.Priority == PriorityNone
+		}
+		return compareInts(term.op, priorityRank(item.Priority), term.priority)
+	default:
+		t := itemTime(item, term.field)
+		if term.none || t == nil {
+			return term.none && t == nil
+		}
+		switch term.op {
+		case "<":
+			return t.Before(term.from)
+		case "<=":
+			return t.Before(term.to)
+		case ">":
+			return !t.Before(term.to)
+		case ">=":
+			return !t.Before(term.from)
+		}
+		return !t.Before(term.from) && t.Before(term.to)
+	}
+}
+
+func matchText(op string, text string, value string) bool {
+	if op == "~" {
+		return strings.Contains(strings.ToLower(text), strings.ToLower(value))
+	}
+	return strings.EqualFold(text, value)
+}
+
+func compareInts(op string, a int, b int) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return a == b
+}
+
+// itemTime returns the date field of an item by its query name
+func itemTime(item ToDoItem, field string) *time.Time {
+	switch field {
+	case "due":
+		return item.DueDate
+	case "created":
+		return item.CreatedAt
+	case "updated":
+		return item.UpdatedAt
+	case "completed":
+		return item.CompletedAt
+	}
+	return nil
+}
+
+// priorityRank orders the priorities, low is the smallest
+func priorityRank(p Priority) int {
+	switch p {
+	case PriorityLow:
+		return 1
+	case PriorityMedium:
+		return 2
+	case PriorityHigh:
+		return 3
+	}
+	return 0
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
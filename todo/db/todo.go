@@ -5,15 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
 )
 
-// ToDoItem is the struct that represents a single ToDo item
-type ToDoItem struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	IsDone bool   `json:"done"`
-}
-
 // DbMap is a type alias for a map of ToDoItems.  The key
 // will be the ToDoItem.Id and the value will be the ToDoItem
 type DbMap map[int]ToDoItem
@@ -30,15 +24,42 @@ type DbMap map[int]ToDoItem
 // ANSWER: <GOES HERE>
 type ToDo struct {
 	toDoMap    DbMap
+	nextId     int
 	dbFileName string
+	maxBackups int
 }
 
 // New is a constructor function that returns a pointer to a new
 // ToDo struct.  It takes a single string argument that is the
 // name of the file that will be used to store the ToDo items.
 // If the file doesn't exist, it will be created.  If the file
-// does exist, it will be loaded into the ToDo struct.
+// does exist, it will be loaded into the ToDo struct.  If the file
+// exists but is corrupt, it is replaced with the newest good backup
+// of the database.  New keeps DefaultBackupCount backups, use the
+// companion constructor NewWithBackupCount to change that.
 func New(dbFile string) (*ToDo, error) {
+	return NewWithBackupCount(dbFile, DefaultBackupCount)
+}
+
+// NewWithBackupCount is like New, but it keeps at most maxBackups
+// timestamped backups of the database.  A timestamped backup is taken
+// before every change to the database, the oldest ones are removed once
+// there are more than maxBackups of them.  Pass 0 to turn backups off.
+func NewWithBackupCount(dbFile string, maxBackups int) (*ToDo, error) {
+
+	toDo := &ToDo{
+		toDoMap:    make(map[int]ToDoItem),
+		dbFileName: dbFile,
+		maxBackups: maxBackups,
+	}
+
+	//Another todo process could be creating or repairing the file at the
+	//same time, so take the exclusive lock before we look at it
+	lock, err := toDo.lockDB(true)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.unlock()
 
 	//Check if the database file exists, if not use initDB to create it
 	//In go, you use the os.Stat function to get information about a file
@@ -50,14 +71,14 @@ func New(dbFile string) (*ToDo, error) {
 		if err != nil {
 			return nil, err
 		}
+	} else if err := toDo.recoverDB(); err != nil {
+		//The file exists, but it could not be parsed and we could
+		//not recover it from a backup
+		return nil, err
 	}
 
 	//Now that we know the file exists, at at the minimum we have
-	//a valid empty DB, lets create the ToDo struct
-	toDo := &ToDo{
-		toDoMap:    make(map[int]ToDoItem),
-		dbFileName: dbFile,
-	}
+	//a valid empty DB
 
 	// We should be all set here, the ToDo struct is ready to go
 	// so we can support the public database operations
@@ -70,8 +91,10 @@ func New(dbFile string) (*ToDo, error) {
 // directory there is a todo.json.bak file.  By default your program expects the
 // database file to be named todo.json.
 //
-// This function should copy the todo.json.bak file to todo.json.  This will
-// restore the database to a known state.
+// This function copies the todo.json.bak file to todo.json.  This will
+// restore the database to a known state.  The database that is being
+// replaced is kept as a timestamped backup first, see RestoreBackup()
+// to bring it back.
 //
 // Precondition:  The backup file named todo.json.bak must exist in the
 // ./data directory
@@ -81,43 +104,29 @@ func New(dbFile string) (*ToDo, error) {
 // existing todo.json file if it exists, or create it if it
 // does not exist.
 func (t *ToDo) RestoreDB() error {
-	//Copy the backup file to the db file
-	dbFileName := t.dbFileName
-	backupFileName := t.dbFileName + ".bak"
+	lock, err := t.lockDB(true)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
 
 	//Copy the backup file to the db file
-	//HINT: research the os package, specifically the Open, Create, and Copy functions
-	//		the basic idea is to open the src file using the os.Open() function,
-	//		create the dst file using the os.Create() function, and then
-	//		copy the contents of the src file to the dst file using the os.Copy() function
-	//		NOTE: that both os.Open and os.Create will return you a file object
-	//			  of type *os.File.  In order to prevent any leaks it is best
-	//			  practice to ensure that you close these files before the function
-	//			  returns.  You do this by calling the Close() method on the file
-	//			  object.  The defer statement is a great way to ensure that this
-	//			  happens.  For example:
-	//
-	//				srcFile, err := os.Open(srcFileName)
-	//				//Handle error ...
-	//				defer srcFile.Close()
-
-	// TODO: Implement this function
-	fmt.Println("RestoreDB() is not implemented")
-	fmt.Println("DB File:", dbFileName)
-	fmt.Println("Backup DB File:", backupFileName)
-	return nil
+	backupFileName := t.dbFileName + ".bak"
+	return t.restoreFrom(backupFileName)
 }
 
 //------------------------------------------------------------
 // THESE ARE THE PUBLIC FUNCTIONS THAT SUPPORT OUR TODO APP
 //------------------------------------------------------------
 
-// AddItem accepts a ToDoItem and adds it to the DB.
+// AddItem accepts a ToDoItem and adds it to the DB.  An item without an
+// id (an Id of 0) is given the next free id.  The stored item, with its
+// id and timestamps, is returned.
 // Preconditions:   (1) The database file must exist and be a valid
 //
-//					(2) The item must not already exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
+//					(2) If the item has an id, it must not already exist
+//	    				in the DB because we use the item.Id as the key,
+//						this function must check if the item already
 //	    				exists in the DB, if so, return an error
 //
 // Postconditions:
@@ -125,20 +134,45 @@ func (t *ToDo) RestoreDB() error {
 //	 (1) The item will be added to the DB
 //		(2) The DB file will be saved with the item added
 //		(3) If there is an error, it will be returned
-func (t *ToDo) AddItem(item ToDoItem) error {
-	//TODO: Implement this function
-	//Start by loading the database into the private map in our struct
-	//see the loadDB() helper.  Then make sure the item we want to load
-	//has a unique ID.  Do this by checking if the item already exists
-	//in the map.  If it does, return an error with a proper message
-	//see (errors.New("MESSAGE GOES HERE")).  If the item does not exist
-	//in the map, add it to the map.  Then save the DB using the saveDB()
-	//helper.  If there are any errors, return them, as appropriate.
-	//If everything there are no errors, this function should return nil
-	//at the end to indicate that the item was properly added to the
-	//database.
-
-	return errors.New("AddItem() is currently not implemented")
+func (t *ToDo) AddItem(item ToDoItem) (ToDoItem, error) {
+	//withWriteLock loads the database, runs our function and then
+	//saves the database, all while holding the database lock
+	err := t.withWriteLock(func() error {
+		//The counter is loaded and saved along with the items, so two
+		//todo processes can never hand out the same id
+		if item.Id == 0 {
+			item.Id = t.nextId
+		}
+		if item.Id < 0 {
+			return errors.New("item id cannot be negative")
+		}
+
+		//Before we add an item to the DB, lets make sure
+		//it does not exist, if it does, return an error
+		if _, ok := t.toDoMap[item.Id]; ok {
+			return errors.New("item already exists")
+		}
+		if err := item.Validate(); err != nil {
+			return err
+		}
+
+		//The database owns the timestamps, so set them before we
+		//add the item to our map
+		stampNewItem(&item)
+		t.toDoMap[item.Id] = item
+
+		//An item added with an id of its own pushes the counter past
+		//that id, so the counter only ever moves forward
+		if item.Id >= t.nextId {
+			t.nextId = item.Id + 1
+		}
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	return item, nil
 }
 
 // DeleteItem accepts an item id and removes it from the DB.
@@ -155,19 +189,17 @@ func (t *ToDo) AddItem(item ToDoItem) error {
 //		(2) The DB file will be saved with the item removed
 //		(3) If there is an error, it will be returned
 func (t *ToDo) DeleteItem(id int) error {
-	//TODO: Implement this function
-	//Like the add item function, start by loading the database into the
-	//private map in our struct.  Then make sure the item we want to delete
-	//exists in the map.  After all we cannot delete an item that is not
-	//in the database. If the item is in our internal map t.toDoMap, then
-	//delete it.  You can use the built-in go delete() function to do this.
-	//We covered this in the go tutorial. As the final step, save the DB
-	//using the saveDB() helper.  If there are any errors, return them, as
-	//appropriate.  If everything there are no errors, this function should
-	//return nil at the end to indicate that the item was properly deleted
-	//from the database.
-
-	return errors.New("DeleteItem() is currently not implemented")
+	return t.withWriteLock(func() error {
+		//We cannot delete an item that is not in the database
+		if _, ok := t.toDoMap[id]; !ok {
+			return errors.New("item does not exist")
+		}
+
+		//Now lets use the built-in go delete() function to remove
+		//the item from our map
+		delete(t.toDoMap, id)
+		return nil
+	})
 }
 
 // UpdateItem accepts a ToDoItem and updates it in the DB.
@@ -184,19 +216,24 @@ func (t *ToDo) DeleteItem(id int) error {
 //		(2) The DB file will be saved with the item updated
 //		(3) If there is an error, it will be returned
 func (t *ToDo) UpdateItem(item ToDoItem) error {
-	//TODO: Implement this function
-	//Like the add and delete functions, start by loading the database
-	//into the private map in our struct.  Then make sure the item we
-	//want to update exists in the map.  After all we cannot update an
-	//item that is not in the database. If the item is in our internal
-	//map t.toDoMap, then update it.  You can do this by simply assigning
-	//the item to the map.  We covered this in the go tutorial. As the
-	//final step, save the DB using the saveDB() helper.  If there are
-	//any errors, return them, as appropriate.  If everything there are
-	//no errors, this function should return nil at the end to indicate
-	//that the item was properly updated in the database.
-
-	return errors.New("UpdateItem() is currently not implemented")
+	return t.withWriteLock(func() error {
+		// Check if item exists before trying to update it
+		// this is a good practice, return an error if the
+		// item does not exist
+		existingItem, ok := t.toDoMap[item.Id]
+		if !ok {
+			return errors.New("item does not exist")
+		}
+		if err := item.Validate(); err != nil {
+			return err
+		}
+
+		//Now that we know the item exists, lets update it, keeping
+		//the timestamps that belong to the existing item
+		stampUpdatedItem(existingItem, &item)
+		t.toDoMap[item.Id] = item
+		return nil
+	})
 }
 
 // GetItem accepts an item id and returns the item from the DB.
@@ -214,19 +251,23 @@ func (t *ToDo) UpdateItem(item ToDoItem) error {
 //			along with an empty ToDoItem
 //		(3) The database file will not be modified
 func (t *ToDo) GetItem(id int) (ToDoItem, error) {
-	//TODO: Implement this function
-	//Like the add, delete, and update functions, start by loading the
-	//database into the private map in our struct.  Then make sure the
-	//item we want to get exists in the map.  After all we cannot get
-	//an item that is not in the database. If the item is in our internal
-	//map t.toDoMap, then return it.  You can do this by simply returning
-	//the item from the map.  We covered this in the go tutorial.  If there
-	//are any errors, return them, as appropriate.  If everything there are
-	//no errors, this function should return the item requested and nil
-	//as the error value the end to indicate that the item was
-	//properly returned from the database.
-
-	return ToDoItem{}, errors.New("GetItem() is currently not implemented")
+	var item ToDoItem
+	err := t.withReadLock(func() error {
+		// Check if item exists before trying to get it
+		// this is a good practice, return an error if the
+		// item does not exist
+		var ok bool
+		item, ok = t.toDoMap[id]
+		if !ok {
+			return errors.New("item does not exist")
+		}
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, err
+	}
+
+	return item, nil
 }
 
 // GetAllItems returns all items from the DB.  If successful it
@@ -240,18 +281,39 @@ func (t *ToDo) GetItem(id int) (ToDoItem, error) {
 //			along with an empty slice
 //		(3) The database file will not be modified
 func (t *ToDo) GetAllItems() ([]ToDoItem, error) {
-	//TODO: Implement this function
-	//Like many of the other functions start by loading the database into
-	//the private map in our struct.  Dont forget to return nil and an
-	//appropriate error if the database cannot be loaded. Next create an
-	//empty slice of ToDoItems.  Remember from the tutorial you can do this
-	//by "var toDoList []ToDoItem".  Now that we have an empty slice,
-	//iterate over our map and add each item to our slice.  Remember you
-	//use the built in append() function in go to add an item in a slice.
-	//Finally, if there were no errors along the way, return the slice
-	//and nil as the error value.
-
-	return nil, errors.New("GetAllItems() is currently not implemented")
+	var toDoList []ToDoItem
+	err := t.withReadLock(func() error {
+		toDoList = t.sortedItems()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toDoList, nil
+}
+
+// QueryItems returns the items that match the query, sorted the way the
+// query asks for, see Query for the filter syntax.
+// Preconditions:   (1) The database file must exist and be a valid
+//
+// Postconditions:
+//
+//	 (1) The matching items will be returned, if any exist
+//		(2) If there is an error, it will be returned
+//			along with an empty slice
+//		(3) The database file will not be modified
+func (t *ToDo) QueryItems(q Query) ([]ToDoItem, error) {
+	var toDoList []ToDoItem
+	err := t.withReadLock(func() error {
+		toDoList = q.Apply(t.sortedItems())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toDoList, nil
 }
 
 // PrintItem accepts a ToDoItem and prints it to the console
@@ -271,6 +333,12 @@ func (t *ToDo) PrintAllItems(itemList []ToDoItem) {
 	}
 }
 
+// ItemToJson returns a ToDoItem as a compact, single line, json string
+func (t *ToDo) ItemToJson(item ToDoItem) string {
+	jsonBytes, _ := json.Marshal(item)
+	return string(jsonBytes)
+}
+
 // JsonToItem accepts a json string and returns a ToDoItem
 // This is helpful because the CLI accepts todo items for insertion
 // and updates in JSON format.  We need to convert it to a ToDoItem
@@ -285,6 +353,51 @@ func (t *ToDo) JsonToItem(jsonString string) (ToDoItem, error) {
 	return item, nil
 }
 
+// ModifyItem reads the item with id, hands it to modify to change, and
+// saves the changed item, all in a single locked load/modify/save cycle.
+// A second todo process cannot change the item in between, so its change
+// is never quietly overwritten.  If modify returns an error, or the
+// changed item is not valid, nothing is saved.  It returns the item as
+// it was saved.
+//
+// Preconditions:   (1) The database file must exist and be a valid
+//
+//	(2) The item must exist in the DB, if not, an
+//		"item does not exist" error is returned
+//
+// Postconditions:
+//
+//	 (1) The changed item will be saved, keeping its id and the
+//			timestamps that belong to the existing item
+//		(2) If there is an error, it will be returned
+func (t *ToDo) ModifyItem(id int, modify func(item *ToDoItem) error) (ToDoItem, error) {
+	var item ToDoItem
+	err := t.withWriteLock(func() error {
+		existingItem, ok := t.toDoMap[id]
+		if !ok {
+			return errors.New("item does not exist")
+		}
+
+		item = existingItem
+		if err := modify(&item); err != nil {
+			return err
+		}
+		//The id is the key, modify does not get to change it
+		item.Id = id
+		if err := item.Validate(); err != nil {
+			return err
+		}
+
+		stampUpdatedItem(existingItem, &item)
+		t.toDoMap[id] = item
+		return nil
+	})
+	if err != nil {
+		return ToDoItem{}, err
+	}
+	return item, nil
+}
+
 // ChangeItemDoneStatus accepts an item id and a boolean status.
 // It returns an error if the status could not be updated for any
 // reason.  For example, the item itself does not exist, or an
@@ -301,20 +414,14 @@ func (t *ToDo) JsonToItem(jsonString string) (ToDoItem, error) {
 //
 //	 (1) The items status in the database will be updated
 //		(2) If there is an error, it will be returned.
-//		(3) This function MUST use existing functionality for most of its
-//			work.  For example, it should call GetItem() to get the item
-//			from the DB, then it should call UpdateItem() to update the
-//			item in the DB (after the status is changed).
+//		(3) The item is read, changed and saved in a single locked
+//			load/modify/save cycle, see ModifyItem.
 func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
-	//TODO: Implement this function for EXTRA CREDIT if you want
-	//This function builds on all of the other functions you have
-	//implemented.  It should call GetItem() to get the item from
-	//the DB, then it should call UpdateItem() to update the item
-	//in the DB (after the status is changed).  If there are any
-	//errors along the way, return them.  If everything is successful
-	//return nil at the end to indicate that the item was properly
-
-	return errors.New("ChangeItemDoneStatus() is currently not implemented")
+	_, err := t.ModifyItem(id, func(item *ToDoItem) error {
+		item.IsDone = value
+		return nil
+	})
+	return err
 }
 
 //------------------------------------------------------------
@@ -328,51 +435,72 @@ func (t *ToDo) ChangeItemDoneStatus(id int, value bool) error {
 // exist.  Notice this function does not have a receiver as its
 // used by New() to create the DB file
 func initDB(dbFileName string) error {
-	f, err := os.Create(dbFileName)
+	// Our DB structure is a json object with the next id and an
+	// array of items, a new database starts at id 1 with an empty
+	// array, which in json is represented as "[]"
+	data, err := json.MarshalIndent(dbContents{NextId: 1, Items: []ToDoItem{}}, "", "  ")
 	if err != nil {
 		return err
 	}
+	return writeFileAtomic(dbFileName, data, 0644)
+}
 
-	// Given we are working with a json array as our DB structure
-	// we should initialize the file with an empty array, which
-	// in json is represented as "[]
-	_, err = f.Write([]byte("[]"))
-	if err != nil {
-		return err
+// sortedItems returns the items in our private map as a slice that
+// is ordered by id, so the output is the same every time
+func (t *ToDo) sortedItems() []ToDoItem {
+	var toDoList []ToDoItem
+	for _, item := range t.toDoMap {
+		toDoList = append(toDoList, item)
 	}
-
-	f.Close()
-
-	return nil
+	sort.Slice(toDoList, func(i, j int) bool {
+		return toDoList[i].Id < toDoList[j].Id
+	})
+	return toDoList
 }
 
+// saveDB writes the private map to the database file.  The write is
+// crash safe, see writeFileAtomic(), so if the process dies half way
+// through, the file on disk still holds the previous version.
+// Precondition: the caller holds the exclusive database lock
 func (t *ToDo) saveDB() error {
+	//Keep the current file as a timestamped backup, then write
+	//the new version
+	if err := t.createBackup(); err != nil {
+		return err
+	}
+	return t.writeDB()
+}
+
+// writeDB writes the private map and the next id to the database file,
+// without taking a backup first
+// Precondition: the caller holds the exclusive database lock
+func (t *ToDo) writeDB() error {
 	//1. Convert our map into a slice
-	//2. Marshal the slice into json
+	//2. Marshal the slice and the next id into json
 	//3. Write the json to our file
 
 	//1. Convert our map into a slice
-	var toDoList []ToDoItem
-	for _, item := range t.toDoMap {
-		toDoList = append(toDoList, item)
-	}
+	toDoList := t.sortedItems()
 
-	//2. Marshal the slice into json, lets pretty print it, but
-	//   this is not required
-	data, err := json.MarshalIndent(toDoList, "", "  ")
-	if err != nil {
-		return err
+	//An empty database should be saved as [] and not null
+	if toDoList == nil {
+		toDoList = make([]ToDoItem, 0)
 	}
 
-	//3. Write the json to our file
-	err = os.WriteFile(t.dbFileName, data, 0644)
+	//2. Marshal into json, lets pretty print it, but this is
+	//   not required
+	data, err := json.MarshalIndent(dbContents{NextId: t.nextId, Items: toDoList}, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return nil
+	//3. Write the json to our file
+	return writeFileAtomic(t.dbFileName, data, 0644)
 }
 
+// loadDB reads the database file into the private map, replacing
+// anything that was in the map before.
+// Precondition: the caller holds a database lock
 func (t *ToDo) loadDB() error {
 	data, err := os.ReadFile(t.dbFileName)
 	if err != nil {
@@ -380,16 +508,24 @@ func (t *ToDo) loadDB() error {
 	}
 
 	//Now let's unmarshal the data into our map
-	var toDoList []ToDoItem
-	err = json.Unmarshal(data, &toDoList)
+	contents, err := parseDB(data)
 	if err != nil {
 		return err
 	}
+	t.setContents(contents)
+
+	return nil
+}
+
+// setContents replaces the private map and the next id with contents
+func (t *ToDo) setContents(contents dbContents) {
+	//Another process may have changed the file since we last looked
+	//at it, so start from an empty map
+	t.toDoMap = make(DbMap, len(contents.Items))
 
 	//Now let's iterate over our slice and add each item to our map
-	for _, item := range toDoList {
+	for _, item := range contents.Items {
 		t.toDoMap[item.Id] = item
 	}
-
-	return nil
+	t.nextId = contents.NextId
 }
@@ -3,9 +3,16 @@ module drexel.edu/todo
 go 1.21
 
 require (
-	github.com/brianvoe/gofakeit/v6 v6.26.3 // indirect
+	github.com/brianvoe/gofakeit/v6 v6.26.3
+	github.com/spf13/cobra v1.8.0
+	github.com/stretchr/testify v1.8.4
+	golang.org/x/sys v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.4 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
 )
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupsRotate(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "todo.json")
+	todo, err := db.NewWithBackupCount(dbFile, 3)
+	assert.NoError(t, err)
+
+	for i := 1; i <= 6; i++ {
+		addItem(t, todo, db.ToDoItem{Id: i, Title: "item"})
+	}
+
+	backups, err := todo.ListBackups()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(backups), "only the newest backups should be kept")
+
+	//Newest first, each backup holds the database from before a change
+	assert.Equal(t, 5, backups[0].ItemCount)
+	assert.Equal(t, 4, backups[1].ItemCount)
+	assert.Equal(t, 3, backups[2].ItemCount)
+	assert.True(t, backups[0].Time.After(backups[1].Time))
+}
+
+func TestBackupsDisabled(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "todo.json")
+	todo, err := db.NewWithBackupCount(dbFile, 0)
+	assert.NoError(t, err)
+
+	addItem(t, todo, db.ToDoItem{Id: 1, Title: "item"})
+	addItem(t, todo, db.ToDoItem{Id: 2, Title: "item"})
+
+	backups, err := todo.ListBackups()
+	assert.NoError(t, err)
+	assert.Empty(t, backups)
+}
+
+func TestDiffBackup(t *testing.T) {
+	todo, _ := newTempDB(t)
+	addItem(t, todo, db.ToDoItem{Id: 1, Title: "keep"})
+	addItem(t, todo, db.ToDoItem{Id: 2, Title: "remove"})
+	addItem(t, todo, db.ToDoItem{Id: 3, Title: "change"})
+
+	//Now change things, every change takes a new backup first
+	assert.NoError(t, todo.DeleteItem(2))
+	assert.NoError(t, todo.UpdateItem(db.ToDoItem{Id: 3, Title: "changed", IsDone: true}))
+	addItem(t, todo, db.ToDoItem{Id: 4, Title: "new"})
+
+	//Newest first, backup 3 is the one taken before the delete,
+	//it holds items 1, 2 and 3
+	diff, err := todo.DiffBackup("3")
+	assert.NoError(t, err)
+	assert.Equal(t, []db.ToDoItem{{Id: 4, Title: "new"}}, withoutTimestamps(diff.Added...))
+	assert.Equal(t, []db.ToDoItem{{Id: 2, Title: "remove"}}, withoutTimestamps(diff.Removed...))
+	if assert.Len(t, diff.Changed, 1) {
+		change := diff.Changed[0]
+		assert.Equal(t, withoutTimestamps(db.ToDoItem{Id: 3, Title: "change"}),
+			withoutTimestamps(change.Before))
+		assert.Equal(t, withoutTimestamps(db.ToDoItem{Id: 3, Title: "changed", IsDone: true}),
+			withoutTimestamps(change.After))
+	}
+
+	_, err = todo.DiffBackup("99")
+	assert.Error(t, err, "there is no backup 99")
+}
+
+func TestRestoreBackupCanBeUndone(t *testing.T) {
+	todo, _ := newTempDB(t)
+	addItem(t, todo, db.ToDoItem{Id: 1, Title: "first"})
+	addItem(t, todo, db.ToDoItem{Id: 2, Title: "oops"})
+
+	//Undo the last change
+	assert.NoError(t, todo.RestoreBackup("1"))
+	items, err := todo.GetAllItems()
+	assert.NoError(t, err)
+	assert.Equal(t, []db.ToDoItem{{Id: 1, Title: "first"}}, withoutTimestamps(items...))
+
+	//The restore itself was backed up, so we can go right back,
+	//restoring by name this time
+	backups, err := todo.ListBackups()
+	assert.NoError(t, err)
+	assert.NoError(t, todo.RestoreBackup(backups[0].Name))
+	items, err = todo.GetAllItems()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(items))
+}
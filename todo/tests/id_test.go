@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAssignsIds(t *testing.T) {
+	todo, _ := newTempDB(t)
+
+	assert.Equal(t, 1, addItem(t, todo, db.ToDoItem{Title: "first"}).Id)
+	assert.Equal(t, 2, addItem(t, todo, db.ToDoItem{Title: "second"}).Id)
+
+	//An item with its own id moves the counter past it
+	assert.Equal(t, 10, addItem(t, todo, db.ToDoItem{Id: 10, Title: "ten"}).Id)
+	eleven := addItem(t, todo, db.ToDoItem{Title: "eleven"})
+	assert.Equal(t, 11, eleven.Id)
+
+	//Deleting the newest item does not free its id
+	assert.NoError(t, todo.DeleteItem(eleven.Id))
+	assert.Equal(t, 12, addItem(t, todo, db.ToDoItem{Title: "twelve"}).Id)
+
+	//Explicit ids still have to be free
+	_, err := todo.AddItem(db.ToDoItem{Id: 2, Title: "taken"})
+	assert.Error(t, err, "id 2 is taken")
+	_, err = todo.AddItem(db.ToDoItem{Id: -1, Title: "negative"})
+	assert.Error(t, err, "ids cannot be negative")
+}
+
+func TestOldArrayDatabaseGetsACounter(t *testing.T) {
+	todo, dbFile := newTempDB(t)
+	old := `[{"id": 1, "title": "one", "done": false}, {"id": 5, "title": "five", "done": true}]`
+	assert.NoError(t, os.WriteFile(dbFile, []byte(old), 0644))
+
+	assert.Equal(t, 6, addItem(t, todo, db.ToDoItem{Title: "six"}).Id)
+
+	//The file is saved in the new layout, with the counter
+	data, err := os.ReadFile(dbFile)
+	assert.NoError(t, err)
+	var contents struct {
+		NextId int           `json:"next_id"`
+		Items  []db.ToDoItem `json:"items"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &contents))
+	assert.Equal(t, 7, contents.NextId)
+	assert.Len(t, contents.Items, 3)
+}
+
+func TestRestoreDoesNotReuseIds(t *testing.T) {
+	todo, _ := newTempDB(t)
+	addItem(t, todo, db.ToDoItem{Title: "first"})
+	addItem(t, todo, db.ToDoItem{Title: "second"})
+
+	//Undo adding item 2, its id was handed out so it is not reused
+	assert.NoError(t, todo.RestoreBackup("1"))
+	assert.Equal(t, 3, addItem(t, todo, db.ToDoItem{Title: "third"}).Id)
+}
+
+func TestConcurrentAddsGetDifferentIds(t *testing.T) {
+	_, dbFile := newTempDB(t)
+
+	const writers = 4
+	const perWriter = 5
+	ids := make(chan int, writers*perWriter)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			//Every writer has its own handle, like separate todo processes
+			todo, err := db.New(dbFile)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for i := 0; i < perWriter; i++ {
+				ids <- addItem(t, todo, db.ToDoItem{Title: "concurrent"}).Id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "id %d was handed out twice", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, writers*perWriter)
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionalFieldsRoundTrip(t *testing.T) {
+	todo, dbFile := newTempDB(t)
+
+	due := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	item := db.ToDoItem{
+		Id:       1,
+		Title:    "Send the invoice",
+		DueDate:  &due,
+		Priority: db.PriorityHigh,
+		Tags:     []string{"work", "billing"},
+		Notes:    "ask for the PO number",
+	}
+	addItem(t, todo, item)
+
+	//Open the file again so the item really comes from the json file
+	reopened, err := db.New(dbFile)
+	assert.NoError(t, err)
+	stored, err := reopened.GetItem(1)
+	assert.NoError(t, err)
+	assert.Equal(t, withoutTimestamps(item), withoutTimestamps(stored))
+	assert.True(t, due.Equal(*stored.DueDate))
+}
+
+func TestTimestampsAreSetByTheDB(t *testing.T) {
+	todo, _ := newTempDB(t)
+
+	//Whatever the caller puts in the timestamps is ignored
+	bogus := time.Date(1999, time.January, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Now()
+	addItem(t, todo, db.ToDoItem{Id: 1, Title: "item", CreatedAt: &bogus, CompletedAt: &bogus})
+	added, err := todo.GetItem(1)
+	assert.NoError(t, err)
+	if assert.NotNil(t, added.CreatedAt) && assert.NotNil(t, added.UpdatedAt) {
+		assert.False(t, added.CreatedAt.Before(before.Truncate(time.Second)))
+		assert.Equal(t, *added.CreatedAt, *added.UpdatedAt)
+	}
+	assert.Nil(t, added.CompletedAt, "the item is not done")
+
+	//Marking the item done sets the completion time, the creation
+	//time never changes
+	assert.NoError(t, todo.ChangeItemDoneStatus(1, true))
+	done, err := todo.GetItem(1)
+	assert.NoError(t, err)
+	assert.Equal(t, added.CreatedAt, done.CreatedAt)
+	assert.NotNil(t, done.CompletedAt)
+	assert.True(t, done.UpdatedAt.After(*added.UpdatedAt))
+
+	//Updating a done item keeps its completion time
+	done.Title = "renamed"
+	done.CreatedAt = &bogus
+	assert.NoError(t, todo.UpdateItem(done))
+	renamed, err := todo.GetItem(1)
+	assert.NoError(t, err)
+	assert.Equal(t, added.CreatedAt, renamed.CreatedAt)
+	assert.Equal(t, done.CompletedAt, renamed.CompletedAt)
+
+	//And marking it not done clears the completion time
+	assert.NoError(t, todo.ChangeItemDoneStatus(1, false))
+	undone, err := todo.GetItem(1)
+	assert.NoError(t, err)
+	assert.Nil(t, undone.CompletedAt)
+}
+
+func TestOldRecordsStillLoad(t *testing.T) {
+	todo, dbFile := newTempDB(t)
+
+	//This is what the database looked like before the optional fields
+	old := `[{"id": 1, "title": "Learn Go / GoLang", "done": true}]`
+	assert.NoError(t, os.WriteFile(dbFile, []byte(old), 0644))
+
+	item, err := todo.GetItem(1)
+	assert.NoError(t, err)
+	assert.Equal(t, db.ToDoItem{Id: 1, Title: "Learn Go / GoLang", IsDone: true}, item)
+
+	//Old records can be updated like any other item
+	item.Tags = []string{"learning"}
+	assert.NoError(t, todo.UpdateItem(item))
+	item, err = todo.GetItem(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"learning"}, item.Tags)
+	assert.Nil(t, item.CreatedAt, "we do not know when an old record was created")
+	assert.NotNil(t, item.UpdatedAt)
+}
+
+func TestInvalidOptionalFieldsAreRejected(t *testing.T) {
+	todo, _ := newTempDB(t)
+
+	_, err := todo.AddItem(db.ToDoItem{Id: 1, Title: "item", Priority: "urgent"})
+	assert.Error(t, err, "urgent is not a priority")
+	_, err = todo.AddItem(db.ToDoItem{Id: 1, Title: "item", Tags: []string{"two words"}})
+	assert.Error(t, err, "tags cannot contain spaces")
+
+	items, err := todo.GetAllItems()
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTempDB creates a database in a fresh temporary directory so that
+// these tests never touch the sample database in ../data
+func newTempDB(t *testing.T) (*db.ToDo, string) {
+	dbFile := filepath.Join(t.TempDir(), "todo.json")
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err, "Error creating temp DB")
+	return todo, dbFile
+}
+
+// addItem adds an item and returns it as it was stored
+func addItem(t *testing.T, todo *db.ToDo, item db.ToDoItem) db.ToDoItem {
+	stored, err := todo.AddItem(item)
+	assert.NoError(t, err, "Error adding item")
+	return stored
+}
+
+// withoutTimestamps clears the timestamps the database sets on every
+// add and update, so tests can compare items with plain literals
+func withoutTimestamps(items ...db.ToDoItem) []db.ToDoItem {
+	cleared := make([]db.ToDoItem, 0, len(items))
+	for _, item := range items {
+		item.CreatedAt, item.UpdatedAt, item.CompletedAt = nil, nil, nil
+		cleared = append(cleared, item)
+	}
+	return cleared
+}
+
+func TestSaveLeavesNoTempFiles(t *testing.T) {
+	todo, dbFile := newTempDB(t)
+
+	for i := 1; i <= 3; i++ {
+		addItem(t, todo, db.ToDoItem{Id: i, Title: "item"})
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(dbFile))
+	assert.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), ".tmp-", "temp file was left behind")
+	}
+
+	items, err := todo.GetAllItems()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(items))
+}
+
+func TestNewRecoversCorruptDBFromSnapshot(t *testing.T) {
+	todo, dbFile := newTempDB(t)
+	addItem(t, todo, db.ToDoItem{Id: 1, Title: "first"})
+	addItem(t, todo, db.ToDoItem{Id: 2, Title: "second"})
+
+	//Simulate a truncated write
+	assert.NoError(t, os.WriteFile(dbFile, []byte(`[{"id": 1, "ti`), 0644))
+
+	recovered, err := db.New(dbFile)
+	assert.NoError(t, err, "New() should recover from the snapshot")
+
+	//The snapshot is the version from before the last save
+	items, err := recovered.GetAllItems()
+	assert.NoError(t, err)
+	assert.Equal(t, []db.ToDoItem{{Id: 1, Title: "first"}}, withoutTimestamps(items...))
+
+	//The damaged file must be kept around
+	entries, err := os.ReadDir(filepath.Dir(dbFile))
+	assert.NoError(t, err)
+	found := false
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "todo.json.corrupt-") {
+			found = true
+		}
+	}
+	assert.True(t, found, "corrupt file should be preserved")
+}
+
+func TestNewFailsOnCorruptDBWithoutSnapshot(t *testing.T) {
+	dbFile := filepath.Join(t.TempDir(), "todo.json")
+	assert.NoError(t, os.WriteFile(dbFile, []byte("not json"), 0644))
+
+	_, err := db.New(dbFile)
+	assert.Error(t, err)
+}
+
+// Each goroutine opens its own handle on the same file, just like two
+// separate todo processes would.  Without the file lock some of the
+// load/modify/save cycles would overwrite each other and items would
+// go missing.
+func TestConcurrentWritersDoNotLoseUpdates(t *testing.T) {
+	_, dbFile := newTempDB(t)
+
+	const writers = 8
+	const itemsPerWriter = 5
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			todo, err := db.New(dbFile)
+			if !assert.NoError(t, err) {
+				return
+			}
+			for i := 0; i < itemsPerWriter; i++ {
+				id := w*itemsPerWriter + i + 1
+				addItem(t, todo, db.ToDoItem{Id: id, Title: "concurrent"})
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	todo, err := db.New(dbFile)
+	assert.NoError(t, err)
+	items, err := todo.GetAllItems()
+	assert.NoError(t, err)
+	assert.Equal(t, writers*itemsPerWriter, len(items))
+}
+
+// ModifyItem reads and saves the item under one lock, so writers that
+// each change the same item from their own handle all keep their change.
+// A GetItem followed by an UpdateItem would let one writer overwrite the
+// tag another writer just added.
+func TestConcurrentModifyItemDoesNotLoseUpdates(t *testing.T) {
+	todo, dbFile := newTempDB(t)
+	item := addItem(t, todo, db.ToDoItem{Title: "shared"})
+
+	const writers = 8
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			todo, err := db.New(dbFile)
+			if !assert.NoError(t, err) {
+				return
+			}
+			_, err = todo.ModifyItem(item.Id, func(item *db.ToDoItem) error {
+				item.Tags = append(item.Tags, "writer-"+strconv.Itoa(w))
+				return nil
+			})
+			assert.NoError(t, err)
+		}(w)
+	}
+	wg.Wait()
+
+	stored, err := todo.GetItem(item.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, writers, len(stored.Tags))
+}
+
+func TestModifyItemLeavesTheItemAloneOnError(t *testing.T) {
+	todo, _ := newTempDB(t)
+	item := addItem(t, todo, db.ToDoItem{Title: "unchanged"})
+
+	_, err := todo.ModifyItem(item.Id, func(item *db.ToDoItem) error {
+		item.Title = "changed"
+		item.Tags = []string{"not valid"}
+		return nil
+	})
+	assert.Error(t, err)
+
+	_, err = todo.ModifyItem(42, func(item *db.ToDoItem) error { return nil })
+	assert.EqualError(t, err, "item does not exist")
+
+	stored, err := todo.GetItem(item.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "unchanged", stored.Title)
+	assert.Empty(t, stored.Tags)
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// queryTestItems adds a small, varied set of items and returns the db
+func queryTestItems(t *testing.T) *db.ToDo {
+	todo, _ := newTempDB(t)
+	day := func(d int) *time.Time {
+		due := time.Date(2026, time.October, d, 12, 0, 0, 0, time.Local)
+		return &due
+	}
+	addItem(t, todo, db.ToDoItem{Title: "Send the invoice", Tags: []string{"work", "billing"},
+		Priority: db.PriorityHigh, DueDate: day(30)})
+	addItem(t, todo, db.ToDoItem{Title: "Mow the lawn", Tags: []string{"home"},
+		Priority: db.PriorityLow, IsDone: true})
+	addItem(t, todo, db.ToDoItem{Title: "Call the client", Tags: []string{"work"},
+		Notes: "about the INVOICE", DueDate: day(31)})
+	addItem(t, todo, db.ToDoItem{Title: "Read a book", Priority: db.PriorityMedium})
+	return todo
+}
+
+func TestQueryFilters(t *testing.T) {
+	todo := queryTestItems(t)
+
+	tests := []struct {
+		filter string
+		ids    []int
+	}{
+		{"", []int{1, 2, 3, 4}},
+		{"done:false", []int{1, 3, 4}},
+		{"done:true", []int{2}},
+		{"tag:work", []int{1, 3}},
+		{"tag:WORK", []int{1, 3}},
+		{"tag~bill", []int{1}},
+		{"-tag:work", []int{2, 4}},
+		{`title~"the invoice"`, []int{1}},
+		{`title:"read a book"`, []int{4}},
+		{"notes~invoice", []int{3}},
+		{"invoice", []int{1, 3}},
+		{"priority:high", []int{1}},
+		{"priority>=medium", []int{1, 4}},
+		{"priority:none", []int{3}},
+		{"due<2026-10-31", []int{1}},
+		{"due<=2026-10-31", []int{1, 3}},
+		{"due:2026-10-31", []int{3}},
+		{"due>2026-10-30", []int{3}},
+		{"due:none", []int{2, 4}},
+		{"completed:none", []int{1, 3, 4}},
+		{"id>2", []int{3, 4}},
+		{`done:false tag:work due<2026-11-01 title~"invoice"`, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			q, err := db.NewQuery(tt.filter, "", "")
+			assert.NoError(t, err)
+			items, err := todo.QueryItems(q)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ids, itemIds(items))
+		})
+	}
+}
+
+func TestQuerySort(t *testing.T) {
+	todo := queryTestItems(t)
+
+	tests := []struct {
+		sortBy string
+		order  string
+		ids    []int
+	}{
+		{"", "", []int{1, 2, 3, 4}},
+		{"id", "desc", []int{4, 3, 2, 1}},
+		{"title", "asc", []int{3, 2, 4, 1}},
+		//Items without a value always come last
+		{"priority", "desc", []int{1, 4, 2, 3}},
+		{"priority", "asc", []int{2, 4, 1, 3}},
+		{"due", "desc", []int{3, 1, 2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy+" "+tt.order, func(t *testing.T) {
+			q, err := db.NewQuery("", tt.sortBy, tt.order)
+			assert.NoError(t, err)
+			items, err := todo.QueryItems(q)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.ids, itemIds(items))
+		})
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	bad := []struct {
+		filter, sortBy, order string
+	}{
+		{"colour:red", "", ""},
+		{"done:maybe", "", ""},
+		{"title<b", "", ""},
+		{"priority:urgent", "", ""},
+		{"priority<none", "", ""},
+		{"due<tomorrow", "", ""},
+		{"id:one", "", ""},
+		{`title~"no closing quote`, "", ""},
+		{"tag:", "", ""},
+		{"", "colour", ""},
+		{"", "", "sideways"},
+	}
+	for _, tt := range bad {
+		_, err := db.NewQuery(tt.filter, tt.sortBy, tt.order)
+		assert.Error(t, err, "query %+v should not parse", tt)
+	}
+}
+
+func itemIds(items []db.ToDoItem) []int {
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
@@ -3,7 +3,9 @@ package api
 import (
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 	"voter-api/db"
 	"voter-api/voter"
@@ -15,6 +17,13 @@ import (
 type VoterAPI struct {
 	db        *db.ToDo
 	voterList voter.VoterList
+	//auditLog records every change to voterList, see GetAudit
+	auditLog *voter.AuditLog
+
+	//gin runs every request on its own goroutine, so the handlers take
+	//the lock before they touch voterList.  A handler that changes a
+	//voter holds it from the version check to the write.
+	lock sync.RWMutex
 }
 
 func New() (*VoterAPI, error) {
@@ -23,15 +32,66 @@ func New() (*VoterAPI, error) {
 		return nil, err
 	}
 
-	return &VoterAPI{db: dbHandler}, nil
+	return &VoterAPI{
+		db:        dbHandler,
+		voterList: voter.VoterList{Voters: make(map[uint]voter.Voter)},
+		auditLog:  voter.NewAuditLog(),
+	}, nil
 }
 
+// GetVoterList returns all voters, a page at a time if the caller asks
+// for it.  The limit query parameter sets the page size and the cursor
+// parameter picks up where the previous page stopped, for example
+// /voters?limit=10&cursor=<cursor>.  With count=true the X-Total-Count
+// header holds the number of voters in the whole list, and while there
+// are more voters the Link header points at the next page.
 func (td *VoterAPI) GetVoterList(c *gin.Context) {
-	if td.voterList.Voters == nil {
-		td.voterList.Voters = make(map[uint]voter.Voter)
+	cursor, limit, count, err := pageParams(c)
+	if err != nil {
+		log.Println("Error reading paging parameters: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	td.lock.RLock()
+	defer td.lock.RUnlock()
+
+	//The cursor is the id of the first voter on the page
+	var from uint64
+	if cursor != "" {
+		from, err = strconv.ParseUint(cursor, 10, 32)
+		if err != nil {
+			log.Println("Error reading cursor: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
 	}
 
-	c.JSON(http.StatusOK, td.voterList)
+	//Maps have no order, so sort the ids to get a stable order from
+	//one page to the next
+	ids := make([]uint, 0, len(td.voterList.Voters))
+	for id := range td.voterList.Voters {
+		if id >= uint(from) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	page := voter.VoterList{Voters: make(map[uint]voter.Voter)}
+	nextCursor := ""
+	for _, id := range ids {
+		if limit > 0 && len(page.Voters) == limit {
+			nextCursor = strconv.FormatUint(uint64(id), 10)
+			break
+		}
+		page.Voters[id] = td.voterList.Voters[id]
+	}
+
+	if count {
+		setTotalCountHeader(c, len(td.voterList.Voters))
+	}
+	setPageHeaders(c, nextCursor)
+	c.JSON(http.StatusOK, page)
 }
 
 func (td *VoterAPI) GetVoter(c *gin.Context) {
@@ -44,6 +104,9 @@ func (td *VoterAPI) GetVoter(c *gin.Context) {
 		return
 	}
 
+	td.lock.RLock()
+	defer td.lock.RUnlock()
+
 	voter, ok := td.voterList.Voters[uint(id64)]
 	if !ok {
 		log.Println("Item not found")
@@ -51,6 +114,14 @@ func (td *VoterAPI) GetVoter(c *gin.Context) {
 		return
 	}
 
+	//A client that already has this version of the voter does not need
+	//it sent again
+	c.Header("ETag", etag(voter))
+	if noneMatch(c, voter) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
 	c.JSON(http.StatusOK, voter)
 }
 
@@ -64,18 +135,77 @@ func (td *VoterAPI) AddVoter(c *gin.Context) {
 		return
 	}
 
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
 	_, doesVoterExist := td.voterList.Voters[newVoter.VoterId]
 	if doesVoterExist {
 		log.Println("Voter already exists")
+		//If-None-Match: * asks to only create the voter if it is not
+		//there, a client that sends it expects a 412
+		if c.GetHeader("If-None-Match") == "*" {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "the voter already exists"})
+			return
+		}
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	newVoter.Version = 1
 	td.voterList.Voters[newVoter.VoterId] = newVoter
+	td.audit(c, voter.AuditAdd, newVoter.VoterId, nil, newVoter)
 
+	c.Header("ETag", etag(newVoter))
 	c.JSON(http.StatusOK, newVoter)
 }
 
+// UpdateVoter changes the profile of a voter, its poll history stays as
+// it is
+func (td *VoterAPI) UpdateVoter(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var update voterUpdate
+	if err := bindUpdate(c, &update); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := update.validate(uint(id64)); err != nil {
+		log.Println("Invalid voter: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	user, ok := td.voterList.Voters[uint(id64)]
+	if !ok {
+		log.Println("Voter not found")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if preconditionFailed(c, user) {
+		return
+	}
+
+	before := user
+	user.Name = update.Name
+	user.Version++
+	td.voterList.Voters[uint(id64)] = user
+	td.audit(c, voter.AuditUpdate, user.VoterId, before, user)
+
+	c.Header("ETag", etag(user))
+	c.JSON(http.StatusOK, user)
+}
+
 func (td *VoterAPI) DeleteVoter(c *gin.Context) {
 	id := c.Param("id")
 	id64, err := strconv.ParseInt(id, 10, 32)
@@ -86,20 +216,33 @@ func (td *VoterAPI) DeleteVoter(c *gin.Context) {
 		return
 	}
 
-	_, doesVoterExist := td.voterList.Voters[uint(id64)]
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	current, doesVoterExist := td.voterList.Voters[uint(id64)]
 	if !doesVoterExist {
 		log.Println("Voter not found")
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	if preconditionFailed(c, current) {
+		return
+	}
 
 	delete(td.voterList.Voters, uint(id64))
+	td.audit(c, voter.AuditDelete, uint(id64), current, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Voter successfully deleted"})
 }
 
 func (td *VoterAPI) DeleteAllVoters(c *gin.Context) {
+	td.lock.Lock()
+	defer td.lock.Unlock()
 
+	//Every voter that is deleted gets its own audit entry
+	for id, current := range td.voterList.Voters {
+		td.audit(c, voter.AuditDelete, id, current, nil)
+	}
 	td.voterList.Voters = make(map[uint]voter.Voter)
 
 	// if err := td.db.DeleteAll(); err != nil {
@@ -122,6 +265,9 @@ func (td *VoterAPI) ListVoterPolls(c *gin.Context) {
 		return
 	}
 
+	td.lock.RLock()
+	defer td.lock.RUnlock()
+
 	voter, ok := td.voterList.Voters[uint(id64)]
 	if !ok {
 		log.Println("Item not found")
@@ -142,6 +288,9 @@ func (td *VoterAPI) GetVoterPoll(c *gin.Context) {
 		return
 	}
 
+	td.lock.RLock()
+	defer td.lock.RUnlock()
+
 	voter, ok := td.voterList.Voters[uint(id64)]
 	if !ok {
 		log.Println("Item not found")
@@ -188,12 +337,20 @@ func (td *VoterAPI) AddVoterPoll(c *gin.Context) {
 		return
 	}
 
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
 	user, ok := td.voterList.Voters[uint(voterId64)]
 	if !ok {
 		log.Println("Voter not found")
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
+	//Adding a poll changes the voter, so If-Match holds the ETag of the
+	//voter
+	if preconditionFailed(c, user) {
+		return
+	}
 
 	for _, poll := range user.VoteHistory {
 		if int64(poll.PollId) == pollId64 {
@@ -211,12 +368,81 @@ func (td *VoterAPI) AddVoterPoll(c *gin.Context) {
 		return
 	}
 
-	user.VoteHistory = append(user.VoteHistory, *newVoterPoll)
+	before := user
+	user.VoteHistory = append(user.VoteHistory[:len(user.VoteHistory):len(user.VoteHistory)], *newVoterPoll)
+	user.Version++
 	td.voterList.Voters[uint(voterId64)] = user
+	td.audit(c, voter.AuditAddPoll, user.VoterId, before, user)
 
+	c.Header("ETag", etag(user))
 	c.JSON(http.StatusOK, newVoterPoll)
 }
 
+// UpdateVoterPoll changes the vote date of a poll in the history of a
+// voter, the rest of the voter stays as it is
+func (td *VoterAPI) UpdateVoterPoll(c *gin.Context) {
+	voterIdS := c.Param("id")
+	voterId64, err := strconv.ParseInt(voterIdS, 10, 32)
+
+	if err != nil {
+		log.Println("Error converting voterId to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	pollId := c.Param("pollid")
+	pollId64, pollErr := strconv.ParseInt(pollId, 10, 32)
+
+	if pollErr != nil {
+		log.Println("Error converting pollId to int64: ", pollErr)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var update voterPollUpdate
+	if err := bindUpdate(c, &update); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := update.validate(uint(pollId64)); err != nil {
+		log.Println("Invalid voter poll: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	user, ok := td.voterList.Voters[uint(voterId64)]
+	if !ok {
+		log.Println("Voter not found")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	for i, poll := range user.VoteHistory {
+		if int64(poll.PollId) == pollId64 {
+			if preconditionFailed(c, user) {
+				return
+			}
+			//The history is copied, so before keeps the old date
+			before := user
+			user.VoteHistory = append([]voter.VoterHistory{}, user.VoteHistory...)
+			user.VoteHistory[i].VoteDate = update.VoteDate
+			user.Version++
+			td.voterList.Voters[uint(voterId64)] = user
+			td.audit(c, voter.AuditUpdatePoll, user.VoterId, before, user)
+			c.Header("ETag", etag(user))
+			c.JSON(http.StatusOK, user.VoteHistory[i])
+			return
+		}
+	}
+
+	log.Println("Voter poll not found")
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
 func (td *VoterAPI) DeleteVoterPoll(c *gin.Context) {
 	voterId := c.Param("id")
 	voterId64, err := strconv.ParseInt(voterId, 10, 32)
@@ -236,6 +462,9 @@ func (td *VoterAPI) DeleteVoterPoll(c *gin.Context) {
 		return
 	}
 
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
 	user, ok := td.voterList.Voters[uint(voterId64)]
 	if !ok {
 		log.Println("Voter not found")
@@ -245,8 +474,16 @@ func (td *VoterAPI) DeleteVoterPoll(c *gin.Context) {
 
 	for i, poll := range user.VoteHistory {
 		if int64(poll.PollId) == pollId64 {
-			user.VoteHistory = append(user.VoteHistory[:i], user.VoteHistory[i+1:]...)
+			if preconditionFailed(c, user) {
+				return
+			}
+			//The history is copied, so before keeps the poll
+			before := user
+			user.VoteHistory = append(user.VoteHistory[:i:i], user.VoteHistory[i+1:]...)
+			user.Version++
 			td.voterList.Voters[uint(voterId64)] = user
+			td.audit(c, voter.AuditDeletePoll, user.VoterId, before, user)
+			c.Header("ETag", etag(user))
 			c.JSON(http.StatusOK, gin.H{"message": "Voter poll successfully deleted"})
 			return
 		}
@@ -257,6 +494,16 @@ func (td *VoterAPI) DeleteVoterPoll(c *gin.Context) {
 }
 
 func (td *VoterAPI) AddSampleVoters(c *gin.Context) {
+	td.lock.Lock()
+	defer td.lock.Unlock()
+
+	before := map[uint]any{}
+	for _, id := range []uint{0, 1} {
+		if current, ok := td.voterList.Voters[id]; ok {
+			before[id] = current
+		}
+	}
+
 	td.voterList.Voters[0] = voter.Voter{
 		VoterId: 0,
 		Name:    "Moo Moo",
@@ -266,6 +513,7 @@ func (td *VoterAPI) AddSampleVoters(c *gin.Context) {
 				VoteDate: time.Now(),
 			},
 		},
+		Version: 1,
 	}
 
 	td.voterList.Voters[1] = voter.Voter{
@@ -277,40 +525,14 @@ func (td *VoterAPI) AddSampleVoters(c *gin.Context) {
 				VoteDate: time.Now(),
 			},
 		},
+		Version: 1,
 	}
-}
 
-// TODO: Remove unused boilerplate code
-
-// implementation for PUT /todo
-// func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
-// 	var todoItem db.ToDoItem
-// 	if err := c.ShouldBindJSON(&todoItem); err != nil {
-// 		log.Println("Error binding JSON: ", err)
-// 		c.AbortWithStatus(http.StatusBadRequest)
-// 		return
-// 	}
-
-// 	if err := td.db.UpdateItem(todoItem); err != nil {
-// 		log.Println("Error updating item: ", err)
-// 		c.AbortWithStatus(http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	c.JSON(http.StatusOK, todoItem)
-// }
-
-// Deletes all todos
-// func (td *ToDoAPI) DeleteAllToDo(c *gin.Context) {
-
-// 	if err := td.db.DeleteAll(); err != nil {
-// 		log.Println("Error deleting all items: ", err)
-// 		c.AbortWithStatus(http.StatusInternalServerError)
-// 		return
-// 	}
-
-// 	c.Status(http.StatusOK)
-// }
+	//The samples replace voters 0 and 1 if they were there
+	for _, id := range []uint{0, 1} {
+		td.audit(c, voter.AuditAdd, id, before[id], td.voterList.Voters[id])
+	}
+}
 
 func (td *VoterAPI) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK,
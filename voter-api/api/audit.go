@@ -0,0 +1,109 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"voter-api/voter"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every change made through the api is written to the audit log, see
+// voter.AuditLog.  The api has no logins, so the actor is whoever the
+// client says it is in the X-Actor header.
+
+const (
+	//ActorHeader names who is making the change, for the audit log
+	ActorHeader = "X-Actor"
+
+	//RequestIDHeader ties an audit entry to a request.  A client can
+	//send its own id, otherwise the api makes one up, either way it is
+	//sent back in the response.
+	RequestIDHeader = "X-Request-ID"
+
+	//AuditResource is the resource the voters are logged under
+	AuditResource = "voter"
+
+	//anonymousActor is the actor of a change without an X-Actor header
+	anonymousActor = "anonymous"
+)
+
+// requestID returns the id of the request, and makes one up the first
+// time it is asked if the client did not send one
+func requestID(c *gin.Context) string {
+	if id := c.GetString(RequestIDHeader); id != "" {
+		return id
+	}
+	id := c.GetHeader(RequestIDHeader)
+	if id == "" {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	c.Set(RequestIDHeader, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// auditJSON returns the json of a voter for the audit log, nil becomes
+// null
+func auditJSON(v any) json.RawMessage {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error converting voter for the audit log: ", err)
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+// audit records a change to the voter with id, before and after are the
+// voter before and after the change
+func (td *VoterAPI) audit(c *gin.Context, action string, id uint, before any, after any) {
+	actor := c.GetHeader(ActorHeader)
+	if actor == "" {
+		actor = anonymousActor
+	}
+
+	td.auditLog.Record(voter.AuditEntry{
+		Resource:  AuditResource,
+		Id:        int(id),
+		Action:    action,
+		Actor:     actor,
+		ClientIP:  c.ClientIP(),
+		RequestId: requestID(c),
+		Old:       auditJSON(before),
+		New:       auditJSON(after),
+	})
+}
+
+// GetAudit returns the audit entries of a resource, oldest first, for
+// example /audit?resource=voter&id=7 for the changes of voter 7.
+// Without id the entries of every voter are returned.
+func (td *VoterAPI) GetAudit(c *gin.Context) {
+	resource := c.Query("resource")
+	if resource != AuditResource {
+		log.Println("Unknown audit resource: ", resource)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "resource must be " + AuditResource})
+		return
+	}
+
+	id := voter.AuditAnyId
+	if idS := c.Query("id"); idS != "" {
+		id64, err := strconv.ParseInt(idS, 10, 32)
+		if err != nil || id64 < 0 {
+			log.Println("Error converting id to int64: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "id must be a positive number"})
+			return
+		}
+		id = int(id64)
+	}
+
+	c.JSON(http.StatusOK, td.auditLog.Entries(resource, id))
+}
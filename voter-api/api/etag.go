@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"voter-api/voter"
+)
+
+// Every voter has a version that goes up each time it changes, the ETag of
+// a voter is simply that version in quotes, for example "3".  A client that
+// sends the ETag back in an If-Match header is saying "only make this
+// change if nobody changed the voter since I read it".  If somebody did,
+// the api answers 412 Precondition Failed and the client should read the
+// voter again.
+
+// etag returns the ETag header for a voter
+func etag(v voter.Voter) string {
+	return `"` + strconv.Itoa(v.Version) + `"`
+}
+
+// parseETags splits an If-Match or If-None-Match header into its
+// entity tags, star is true if the header is *
+func parseETags(header string) (tags []string, star bool) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		switch tag {
+		case "":
+		case "*":
+			star = true
+		default:
+			tags = append(tags, tag)
+		}
+	}
+	return tags, star
+}
+
+// ifMatchVersion returns the version the If-Match header asks for.  It
+// returns 0, which the stores read as any version, when there is no
+// If-Match header or it is *, a voter has to exist to be changed anyway.
+// If-Match compares strictly, so a weak ETag (W/"3") or a tag that is not
+// one of ours never matches, we return -1 for those, a version no voter
+// ever has.
+func ifMatchVersion(c *gin.Context) (int, error) {
+	tags, star := parseETags(c.GetHeader("If-Match"))
+	if star || len(tags) == 0 {
+		return 0, nil
+	}
+	if len(tags) > 1 {
+		return 0, errors.New("If-Match can only hold one ETag")
+	}
+
+	tag := tags[0]
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return -1, nil
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return -1, nil
+	}
+	return version, nil
+}
+
+// noneMatch returns true if the If-None-Match header matches v, that is
+// if it is * or holds the ETag of v.  If-None-Match compares
+// loosely, so W/"3" matches version 3 too.
+func noneMatch(c *gin.Context, v voter.Voter) bool {
+	tags, star := parseETags(c.GetHeader("If-None-Match"))
+	if star {
+		return true
+	}
+	for _, tag := range tags {
+		if strings.TrimPrefix(tag, "W/") == etag(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed checks the If-Match and If-None-Match headers of a
+// request that changes current.  If they do not allow the change it
+// answers 400 or 412 and returns true.  The caller holds the lock, so
+// the voter cannot change between this check and the change itself.
+func preconditionFailed(c *gin.Context, current voter.Voter) bool {
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return true
+	}
+	if version != 0 && version != current.Version {
+		log.Println("Voter has changed, version does not match")
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "voter has changed, version does not match"})
+		return true
+	}
+	if noneMatch(c, current) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "the voter matches If-None-Match"})
+		return true
+	}
+	return false
+}
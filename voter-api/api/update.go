@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// A voter has a profile, its name, and a poll history.  PUT /voters/:id
+// changes the profile and PUT /voters/:id/polls/:pollid one poll of the
+// history, and neither one ever touches the other.  The bodies only have
+// the fields that can be changed, anything that cannot is a 400 Bad
+// Request rather than being quietly dropped.  That includes fields we do
+// not know, like version or voterId, and a voter_history of null, the
+// history is not changed here at all, not even emptied.
+
+// bindUpdate reads the json body of a PUT into update.  Unlike
+// ShouldBindJSON, a field that update does not have is an error.
+func bindUpdate(c *gin.Context, update interface{}) error {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(update); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("the body must be a single json object")
+	}
+	return nil
+}
+
+// voterUpdate is the body of PUT /voters/:id
+type voterUpdate struct {
+	//VoterId can be left out, if it is there it has to match the path
+	VoterId *uint  `json:"voter_id"`
+	Name    string `json:"name"`
+
+	//VoteHistory is only here to tell the client off, a null is
+	//still there so it gets told off too
+	VoteHistory json.RawMessage `json:"voter_history"`
+}
+
+// validate checks a voterUpdate for the voter with id
+func (u voterUpdate) validate(id uint) error {
+	if u.VoterId != nil && *u.VoterId != id {
+		return fmt.Errorf("voter_id %d does not match the path", *u.VoterId)
+	}
+	if strings.TrimSpace(u.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(u.VoteHistory) > 0 {
+		return errors.New("voter_history cannot be changed here, use /voters/:id/polls/:pollid")
+	}
+	return nil
+}
+
+// voterPollUpdate is the body of PUT /voters/:id/polls/:pollid
+type voterPollUpdate struct {
+	//PollId can be left out, if it is there it has to match the path
+	PollId   *uint     `json:"poll_id"`
+	VoteDate time.Time `json:"vote_date"`
+}
+
+// validate checks a voterPollUpdate for the poll with pollId
+func (u voterPollUpdate) validate(pollId uint) error {
+	if u.PollId != nil && *u.PollId != pollId {
+		return fmt.Errorf("poll_id %d does not match the path", *u.PollId)
+	}
+	if u.VoteDate.IsZero() {
+		return errors.New("vote_date is required")
+	}
+	if u.VoteDate.After(time.Now()) {
+		return errors.New("vote_date cannot be in the future")
+	}
+	return nil
+}
@@ -34,21 +34,23 @@ func main() {
 		os.Exit(1)
 	}
 
-	// TODO: Implement PUT routes for extra credit
 	router.GET("/voters", apiHandler.GetVoterList)
 	router.GET("/voters/:id", apiHandler.GetVoter)
 	router.POST("/voters/:id", apiHandler.AddVoter)
-	// router.PUT("/voters/:id", apiHandler.UpdateVoter)
+	router.PUT("/voters/:id", apiHandler.UpdateVoter)
 	router.DELETE("/voters/:id", apiHandler.DeleteVoter)
 	router.DELETE("/voters", apiHandler.DeleteAllVoters)
 	router.GET("/voters/:id/polls", apiHandler.ListVoterPolls)
 	router.GET("/voters/:id/polls/:pollid", apiHandler.GetVoterPoll)
 	router.POST("/voters/:id/polls/:pollid", apiHandler.AddVoterPoll)
-	// router.PUT("/voters/:id/polls/:pollid", apiHandler.UpdateVoterPoll)
+	router.PUT("/voters/:id/polls/:pollid", apiHandler.UpdateVoterPoll)
 	router.DELETE("/voters/:id/polls/:pollid", apiHandler.DeleteVoterPoll)
 	router.GET("/voters/health", apiHandler.HealthCheck)
 	router.GET("/voters/add-sample-voters", apiHandler.AddSampleVoters)
 
+	//Who changed what, the X-Actor header says who
+	router.GET("/audit", apiHandler.GetAudit)
+
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
 	router.Run(serverPath)
 }
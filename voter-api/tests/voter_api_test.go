@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 	"voter-api/voter"
 
 	"github.com/go-resty/resty/v2"
@@ -109,3 +110,219 @@ func Test_DeleteVoter(t *testing.T) {
 	assert.Equal(t, 200, getResponse.StatusCode())
 	assert.Equal(t, 1, len(myResponse.Voters))
 }
+
+func Test_VoterETags(t *testing.T) {
+	addResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{
+			"voter_id": 7,
+			"name": "Snorlax"
+		}`).
+		Post(BASE_API + "/voters/7")
+
+	assert.Equal(t, 200, addResponse.StatusCode())
+	assert.Equal(t, `"1"`, addResponse.Header().Get("ETag"))
+
+	//Adding the same voter again with If-None-Match: * is refused
+	duplicateResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("If-None-Match", "*").
+		SetBody(`{
+			"voter_id": 7,
+			"name": "Snorlax"
+		}`).
+		Post(BASE_API + "/voters/7")
+
+	assert.Equal(t, 412, duplicateResponse.StatusCode())
+
+	getResponse, _ := client.R().Get(BASE_API + "/voters/7")
+	assert.Equal(t, 200, getResponse.StatusCode())
+	assert.Equal(t, `"1"`, getResponse.Header().Get("ETag"))
+
+	notModifiedResponse, _ := client.R().
+		SetHeader("If-None-Match", `"1"`).
+		Get(BASE_API + "/voters/7")
+	assert.Equal(t, 304, notModifiedResponse.StatusCode())
+
+	//Adding a poll changes the voter, so the ETag moves on
+	pollResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("If-Match", `"1"`).
+		SetBody(`{ "poll_id": 1 }`).
+		Post(BASE_API + "/voters/7/polls/1")
+	assert.Equal(t, 200, pollResponse.StatusCode())
+	assert.Equal(t, `"2"`, pollResponse.Header().Get("ETag"))
+
+	stalePollResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("If-Match", `"1"`).
+		SetBody(`{ "poll_id": 2 }`).
+		Post(BASE_API + "/voters/7/polls/2")
+	assert.Equal(t, 412, stalePollResponse.StatusCode())
+
+	staleDeleteResponse, _ := client.R().
+		SetHeader("If-Match", `"1"`).
+		Delete(BASE_API + "/voters/7")
+	assert.Equal(t, 412, staleDeleteResponse.StatusCode())
+
+	deleteResponse, _ := client.R().
+		SetHeader("If-Match", `"2"`).
+		Delete(BASE_API + "/voters/7")
+	assert.Equal(t, 200, deleteResponse.StatusCode())
+}
+
+func Test_VoterAudit(t *testing.T) {
+	addResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("X-Actor", "alice").
+		SetHeader("X-Request-ID", "req-add").
+		SetBody(`{
+			"voter_id": 8,
+			"name": "Eevee"
+		}`).
+		Post(BASE_API + "/voters/8")
+	assert.Equal(t, 200, addResponse.StatusCode())
+
+	pollResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("X-Actor", "bob").
+		SetBody(`{ "poll_id": 3 }`).
+		Post(BASE_API + "/voters/8/polls/3")
+	assert.Equal(t, 200, pollResponse.StatusCode())
+	assert.NotEmpty(t, pollResponse.Header().Get("X-Request-ID"))
+
+	deleteResponse, _ := client.R().Delete(BASE_API + "/voters/8")
+	assert.Equal(t, 200, deleteResponse.StatusCode())
+
+	auditResponse, _ := client.R().Get(BASE_API + "/audit?resource=voter&id=8")
+	assert.Equal(t, 200, auditResponse.StatusCode())
+	entries := []voter.AuditEntry{}
+	err := json.Unmarshal(auditResponse.Body(), &entries)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	if len(entries) != 3 {
+		return
+	}
+
+	assert.Equal(t, voter.AuditAdd, entries[0].Action)
+	assert.Equal(t, "alice", entries[0].Actor)
+	assert.Equal(t, "req-add", entries[0].RequestId)
+	assert.NotEmpty(t, entries[0].ClientIP)
+	assert.Equal(t, "null", string(entries[0].Old))
+
+	assert.Equal(t, voter.AuditAddPoll, entries[1].Action)
+	assert.Equal(t, "bob", entries[1].Actor)
+	assert.Equal(t, pollResponse.Header().Get("X-Request-ID"), entries[1].RequestId)
+	before, after := voter.Voter{}, voter.Voter{}
+	assert.Nil(t, json.Unmarshal(entries[1].Old, &before))
+	assert.Nil(t, json.Unmarshal(entries[1].New, &after))
+	assert.Equal(t, 0, len(before.VoteHistory))
+	assert.Equal(t, 1, len(after.VoteHistory))
+
+	assert.Equal(t, voter.AuditDelete, entries[2].Action)
+	assert.Equal(t, "anonymous", entries[2].Actor)
+	assert.Equal(t, "null", string(entries[2].New))
+
+	badResponse, _ := client.R().Get(BASE_API + "/audit?resource=todo")
+	assert.Equal(t, 400, badResponse.StatusCode())
+}
+
+// Changing the profile of a voter leaves its poll history alone, and
+// changing a poll of the history leaves the profile alone
+func Test_UpdateVoter(t *testing.T) {
+	addResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{
+			"voter_id": 10,
+			"name": "Bulbasaur"
+		}`).
+		Post(BASE_API + "/voters/10")
+	assert.Equal(t, 200, addResponse.StatusCode())
+
+	pollResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_id": 1 }`).
+		Post(BASE_API + "/voters/10/polls/1")
+	assert.Equal(t, 200, pollResponse.StatusCode())
+
+	updateResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("If-Match", `"2"`).
+		SetBody(`{ "voter_id": 10, "name": "Ivysaur" }`).
+		Put(BASE_API + "/voters/10")
+	assert.Equal(t, 200, updateResponse.StatusCode())
+	assert.Equal(t, `"3"`, updateResponse.Header().Get("ETag"))
+	updated := voter.Voter{}
+	assert.Nil(t, json.Unmarshal(updateResponse.Body(), &updated))
+	assert.Equal(t, "Ivysaur", updated.Name)
+	assert.Equal(t, 1, len(updated.VoteHistory))
+
+	for _, body := range []string{
+		`{ "name": "" }`,
+		`{ "name": "   " }`,
+		`{ "voter_id": 11, "name": "Ivysaur" }`,
+		`{ "name": "Ivysaur", "voter_history": [] }`,
+		`{ "name": "Ivysaur", "voter_history": null }`,
+		`{ "name": "Ivysaur", "version": 7 }`,
+		`{ "voterId": 10, "name": "Ivysaur" }`,
+		`{ "name": "Ivysaur" } { "name": "Venusaur" }`,
+	} {
+		badResponse, _ := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Put(BASE_API + "/voters/10")
+		assert.Equal(t, 400, badResponse.StatusCode(), body)
+	}
+
+	staleResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("If-Match", `"2"`).
+		SetBody(`{ "name": "Venusaur" }`).
+		Put(BASE_API + "/voters/10")
+	assert.Equal(t, 412, staleResponse.StatusCode())
+
+	notFoundResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "name": "Mew" }`).
+		Put(BASE_API + "/voters/99")
+	assert.Equal(t, 404, notFoundResponse.StatusCode())
+
+	updatePollResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_id": 1, "vote_date": "2024-01-02T03:04:05Z" }`).
+		Put(BASE_API + "/voters/10/polls/1")
+	assert.Equal(t, 200, updatePollResponse.StatusCode())
+	assert.Equal(t, `"4"`, updatePollResponse.Header().Get("ETag"))
+
+	for _, body := range []string{
+		`{ "poll_id": 1 }`,
+		`{ "poll_id": 2, "vote_date": "2024-01-02T03:04:05Z" }`,
+		`{ "poll_id": 1, "vote_date": "2999-01-01T00:00:00Z" }`,
+		`{ "poll_id": 1, "vote_date": "2024-01-02T03:04:05Z", "voter_id": 10 }`,
+	} {
+		badResponse, _ := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(body).
+			Put(BASE_API + "/voters/10/polls/1")
+		assert.Equal(t, 400, badResponse.StatusCode(), body)
+	}
+
+	missingPollResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "vote_date": "2024-01-02T03:04:05Z" }`).
+		Put(BASE_API + "/voters/10/polls/2")
+	assert.Equal(t, 404, missingPollResponse.StatusCode())
+
+	getResponse, _ := client.R().Get(BASE_API + "/voters/10")
+	got := voter.Voter{}
+	assert.Nil(t, json.Unmarshal(getResponse.Body(), &got))
+	assert.Equal(t, "Ivysaur", got.Name)
+	assert.Equal(t, 1, len(got.VoteHistory))
+	if len(got.VoteHistory) == 1 {
+		assert.Equal(t, uint(1), got.VoteHistory[0].PollId)
+		assert.Equal(t, "2024-01-02T03:04:05Z", got.VoteHistory[0].VoteDate.Format(time.RFC3339))
+	}
+
+	deleteResponse, _ := client.R().Delete(BASE_API + "/voters/10")
+	assert.Equal(t, 200, deleteResponse.StatusCode())
+}
@@ -0,0 +1,80 @@
+package voter
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// The audit log records every change made through the api, who made it,
+// from where, and what the voter looked like before and after.  Entries
+// are only ever added, there is no way to change or remove one.  The
+// voters are kept in memory, so the audit log is too.
+
+// The actions of an AuditEntry
+const (
+	AuditAdd        = "add"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditAddPoll    = "add_poll"
+	AuditUpdatePoll = "update_poll"
+	AuditDeletePoll = "delete_poll"
+)
+
+// AuditAnyId asks Entries for the entries of every id
+const AuditAnyId = -1
+
+// AuditEntry is a single change.  Old is null for an add and New is null
+// for a delete.  Seq goes up by one for every entry.
+type AuditEntry struct {
+	Seq       int64           `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Resource  string          `json:"resource"`
+	Id        int             `json:"id"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor"`
+	ClientIP  string          `json:"client_ip"`
+	RequestId string          `json:"request_id"`
+	Old       json.RawMessage `json:"old"`
+	New       json.RawMessage `json:"new"`
+}
+
+// AuditLog keeps the audit entries in memory
+type AuditLog struct {
+	lock    sync.RWMutex
+	entries []AuditEntry
+}
+
+// Constructor for AuditLog struct
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Record gives entry the next Seq, and the time if it has none, adds it
+// to the log and returns it
+func (l *AuditLog) Record(entry AuditEntry) AuditEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entry.Seq = int64(len(l.entries)) + 1
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	l.entries = append(l.entries, entry)
+	return entry
+}
+
+// Entries returns the entries of resource, oldest first, only those of
+// id unless id is AuditAnyId
+func (l *AuditLog) Entries(resource string, id int) []AuditEntry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	entries := []AuditEntry{}
+	for _, entry := range l.entries {
+		if entry.Resource == resource && (id == AuditAnyId || entry.Id == id) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -13,6 +13,10 @@ type Voter struct {
 	VoterId     uint           `json:"voter_id"`
 	Name        string         `json:"name"`
 	VoteHistory []VoterHistory `json:"voter_history"`
+
+	//Version starts at 1 and goes up every time the voter changes, the
+	//api hands it out as the ETag of the voter
+	Version int `json:"version,omitempty"`
 }
 type VoterList struct {
 	Voters map[uint]Voter `json:"voters"` //A map of VoterIDs as keys and Voter structs as values
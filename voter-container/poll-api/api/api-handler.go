@@ -0,0 +1,452 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"poll-api/db"
+	"poll-api/poll"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the version of the poll api, for the health check
+const Version = "1.0.0"
+
+type PollAPI struct {
+	store   db.PollStore
+	refs    *referenceClient
+	started time.Time
+}
+
+// NewWithStore returns a PollAPI that keeps its polls in store, on its
+// own, without voters or votes that use the polls
+func NewWithStore(store db.PollStore) *PollAPI {
+	pa, _ := NewWithReferences(store, References{})
+	return pa
+}
+
+// NewWithReferences returns a PollAPI that keeps its polls in store, and
+// deletes polls by the delete policy in refs
+func NewWithReferences(store db.PollStore, refs References) (*PollAPI, error) {
+	rc, err := newReferenceClient(refs)
+	if err != nil {
+		return nil, err
+	}
+	return &PollAPI{
+		store:   store,
+		refs:    rc,
+		started: time.Now(),
+	}, nil
+}
+
+// idParam returns the path parameter name as an id, on a bad id the
+// request is answered with a 400 Bad Request and ok is false
+func idParam(c *gin.Context, name string) (int, bool) {
+	id64, err := strconv.ParseInt(c.Param(name), 10, 32)
+	if err != nil || id64 < 0 {
+		log.Println("Error converting "+name+" to int64: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": name + " must be a positive number"})
+		return 0, false
+	}
+	return int(id64), true
+}
+
+// abortWithStoreError answers a request that failed in the store
+func abortWithStoreError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, db.ErrPollNotFound), errors.Is(err, db.ErrOptionNotFound):
+		log.Println("Item not found: ", err)
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrPollExists):
+		log.Println("Error adding item: ", err)
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, poll.ErrInvalidPoll):
+		log.Println("Invalid poll: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Println("Error in the poll store: ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// bindPoll reads the poll in the body of a request on /polls/:id.  The
+// poll_id in the body can be left out, if it is there it has to be the id
+// in the path.
+func bindPoll(c *gin.Context) (poll.Poll, bool) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return poll.Poll{}, false
+	}
+
+	p := *poll.NewPoll(uint(id), "", "")
+	if err := c.ShouldBindJSON(&p); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return poll.Poll{}, false
+	}
+	if p.PollID != uint(id) {
+		log.Println("Poll id in body does not match the path: ", p.PollID)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "poll_id does not match the id in the path"})
+		return poll.Poll{}, false
+	}
+	if p.PollOptions == nil {
+		p.PollOptions = []poll.PollOption{}
+	}
+	return p, true
+}
+
+//------------------------------------------------------------
+// POLLS
+//------------------------------------------------------------
+
+// implementation for GET /polls
+func (pa *PollAPI) GetAllPolls(c *gin.Context) {
+	polls, err := pa.store.GetAllPolls()
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, polls)
+}
+
+// implementation for GET /polls/:id
+func (pa *PollAPI) GetPoll(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	p, err := pa.store.GetPoll(id)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// implementation for POST /polls/:id
+func (pa *PollAPI) AddPoll(c *gin.Context) {
+	p, ok := bindPoll(c)
+	if !ok {
+		return
+	}
+
+	added, err := pa.store.AddPoll(p)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, added)
+}
+
+// implementation for PUT /polls/:id
+// replaces the title, question and options of the poll
+func (pa *PollAPI) UpdatePoll(c *gin.Context) {
+	p, ok := bindPoll(c)
+	if !ok {
+		return
+	}
+
+	updated, err := db.UpdatePoll(pa.store, p)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// implementation for DELETE /polls/:id
+func (pa *PollAPI) DeletePoll(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	//A poll that is not there is a 404, whatever uses it
+	if _, err := pa.store.GetPoll(id); err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
+	used, err := pa.refs.usage(id)
+	if err != nil {
+		log.Println("Error checking poll usage: ", err)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "could not check what uses the poll"})
+		return
+	}
+	if used.inUse() && pa.refs.DeletePolicy == DeleteBlock {
+		log.Println("Poll is in use: ", id, used)
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error":  fmt.Sprintf("poll %d is used by %s", id, used),
+			"votes":  len(used.voteIds),
+			"voters": len(used.voterIds),
+		})
+		return
+	}
+
+	//The poll goes first, so no new votes can be cast in it while we
+	//clean up after it.  With block a vote can still be cast after the
+	//check above, the store checks for votes again in the same step as
+	//the delete.
+	if pa.refs.DeletePolicy == DeleteBlock {
+		err = pa.store.DeletePollIfUnused(id)
+	} else {
+		err = pa.store.DeletePoll(id)
+	}
+	if errors.Is(err, db.ErrPollInUse) {
+		log.Println("Poll got votes while deleting: ", id)
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("poll %d is used by votes", id)})
+		return
+	}
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	if err := pa.refs.cleanUp(id, used); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "poll deleted, but not everything that used it: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Poll successfully deleted"})
+}
+
+// implementation for DELETE /polls
+func (pa *PollAPI) DeleteAllPolls(c *gin.Context) {
+	polls, err := pa.store.GetAllPolls()
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
+	//Check every poll before deleting any, so a blocked delete leaves
+	//all of them alone
+	usages := make(map[int]usage)
+	var inUse []uint
+	for _, p := range polls {
+		used, err := pa.refs.usage(int(p.PollID))
+		if err != nil {
+			log.Println("Error checking poll usage: ", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "could not check what uses the polls"})
+			return
+		}
+		if used.inUse() {
+			usages[int(p.PollID)] = used
+			inUse = append(inUse, p.PollID)
+		}
+	}
+	if len(inUse) > 0 && pa.refs.DeletePolicy == DeleteBlock {
+		log.Println("Polls are in use: ", inUse)
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "polls are in use", "polls": inUse})
+		return
+	}
+
+	//With block every poll is deleted on its own, so the store can
+	//check for votes cast after the check above in the same step as the
+	//delete.  A poll that got votes is left alone, the others are gone.
+	var blocked []uint
+	if pa.refs.DeletePolicy == DeleteBlock {
+		for _, p := range polls {
+			err := pa.store.DeletePollIfUnused(int(p.PollID))
+			switch {
+			case errors.Is(err, db.ErrPollInUse):
+				blocked = append(blocked, p.PollID)
+			case err != nil && !errors.Is(err, db.ErrPollNotFound):
+				abortWithStoreError(c, err)
+				return
+			}
+		}
+	} else if err := pa.store.DeleteAll(); err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
+	var cascadeErr error
+	for _, p := range polls {
+		if slices.Contains(blocked, p.PollID) {
+			continue
+		}
+		id := int(p.PollID)
+		if err := pa.refs.cleanUp(id, usages[id]); err != nil && cascadeErr == nil {
+			cascadeErr = err
+		}
+	}
+	if len(blocked) > 0 {
+		log.Println("Polls got votes while deleting: ", blocked)
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "polls are in use", "polls": blocked})
+		return
+	}
+	if cascadeErr != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": "polls deleted, but not everything that used them: " + cascadeErr.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All polls successfully deleted"})
+}
+
+//------------------------------------------------------------
+// POLL OPTIONS
+//------------------------------------------------------------
+
+// implementation for GET /polls/:id/options
+func (pa *PollAPI) GetPollOptions(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	p, err := pa.store.GetPoll(id)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p.PollOptions)
+}
+
+// implementation for GET /polls/:id/options/:optionid
+func (pa *PollAPI) GetPollOption(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	optionId, ok := idParam(c, "optionid")
+	if !ok {
+		return
+	}
+
+	p, err := pa.store.GetPoll(id)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	option, found := p.Option(uint(optionId))
+	if !found {
+		abortWithStoreError(c, db.ErrOptionNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, option)
+}
+
+// implementation for POST /polls/:id/options
+// the body is the new option, its id has to be new to the poll
+func (pa *PollAPI) AddPollOption(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var option poll.PollOption
+	if err := c.ShouldBindJSON(&option); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := db.AddOption(pa.store, id, option); err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, option)
+}
+
+// implementation for PUT /polls/:id/options/:optionid
+// changes the value of the option, the poll_option_id in the body can be
+// left out
+func (pa *PollAPI) UpdatePollOption(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	optionId, ok := idParam(c, "optionid")
+	if !ok {
+		return
+	}
+
+	option := poll.PollOption{PollOptionID: uint(optionId)}
+	if err := c.ShouldBindJSON(&option); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if option.PollOptionID != uint(optionId) {
+		log.Println("Option id in body does not match the path: ", option.PollOptionID)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "poll_option_id does not match the id in the path"})
+		return
+	}
+
+	if _, err := db.UpdateOption(pa.store, id, option); err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, option)
+}
+
+// implementation for DELETE /polls/:id/options/:optionid
+func (pa *PollAPI) DeletePollOption(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+	optionId, ok := idParam(c, "optionid")
+	if !ok {
+		return
+	}
+
+	if _, err := db.DeleteOption(pa.store, id, uint(optionId)); err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Poll option successfully deleted"})
+}
+
+//------------------------------------------------------------
+// HEALTH AND SAMPLES
+//------------------------------------------------------------
+
+// implementation for GET /polls/health
+// the same shape as the health check of the voter-api, with a 503 when
+// the store cannot be reached
+func (pa *PollAPI) HealthCheck(c *gin.Context) {
+	status, code := "ok", http.StatusOK
+	if err := pa.store.Ping(); err != nil {
+		log.Println("Poll store is not available: ", err)
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	c.JSON(code,
+		gin.H{
+			"status":  status,
+			"version": Version,
+			"uptime":  int(time.Since(pa.started).Seconds()),
+		})
+}
+
+// implementation for GET /polls/add-sample-polls
+// a sample that is already there is left alone
+func (pa *PollAPI) AddSamplePolls(c *gin.Context) {
+	samples := []poll.Poll{
+		*poll.NewSamplePoll(),
+		{
+			PollID:       2,
+			PollTitle:    "Favorite Season",
+			PollQuestion: "Which season do you like best?",
+			PollOptions: []poll.PollOption{
+				{PollOptionID: 1, PollOptionValue: "Spring"},
+				{PollOptionID: 2, PollOptionValue: "Summer"},
+				{PollOptionID: 3, PollOptionValue: "Fall"},
+				{PollOptionID: 4, PollOptionValue: "Winter"},
+			},
+		},
+	}
+
+	for _, sample := range samples {
+		if _, err := pa.store.AddPoll(sample); err != nil && !errors.Is(err, db.ErrPollExists) {
+			abortWithStoreError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sample polls added"})
+}
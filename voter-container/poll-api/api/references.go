@@ -0,0 +1,223 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// A poll is used by the voters that have it in their history, in the
+// voter-api, and by the votes cast in it, in the votes-api.  Deleting a
+// poll that is still used is either blocked, or the votes and history
+// entries go with it, depending on the delete policy.  We find them the
+// same way a client would, over http, so the poll-api does not need to
+// know how the other apis store anything.  It does need to know their
+// answers though: the voters come from the voter-api in this directory,
+// which returns a json array of voters a page at a time, and the votes
+// from the votes-api.  The voter-api at the top of the repo returns a
+// map instead, it cannot be used here.
+
+// The delete policies
+const (
+	//DeleteBlock answers 409 Conflict to a delete of a poll that is
+	//still used
+	DeleteBlock = "block"
+
+	//DeleteCascade deletes the poll, then its votes, then the poll from
+	//the history of every voter
+	DeleteCascade = "cascade"
+)
+
+// DeletePolicies lists every delete policy, for help and error messages
+var DeletePolicies = []string{DeleteBlock, DeleteCascade}
+
+const (
+	DefaultVoterAPIURL = "http://localhost:1080"
+	DefaultVotesAPIURL = "http://localhost:1082"
+)
+
+// voterPageLimit is the page size we ask the voter-api for, its largest
+const voterPageLimit = 1000
+
+// References says what to do with the votes and voters of a poll that is
+// deleted, and where to find them.  An empty url leaves that api out, a
+// poll-api that runs on its own has no voters or votes.
+type References struct {
+	DeletePolicy string
+	VoterAPIURL  string
+	VotesAPIURL  string
+}
+
+// usage is what still uses a poll
+type usage struct {
+	voteIds  []uint
+	voterIds []uint
+}
+
+func (u usage) inUse() bool {
+	return len(u.voteIds) > 0 || len(u.voterIds) > 0
+}
+
+func (u usage) String() string {
+	return fmt.Sprintf("%d votes and %d voters", len(u.voteIds), len(u.voterIds))
+}
+
+// referenceClient finds and deletes what uses a poll
+type referenceClient struct {
+	References
+	apiClient *resty.Client
+}
+
+func newReferenceClient(refs References) (*referenceClient, error) {
+	refs.DeletePolicy = strings.ToLower(refs.DeletePolicy)
+	switch refs.DeletePolicy {
+	case "":
+		refs.DeletePolicy = DeleteBlock
+	case DeleteBlock, DeleteCascade:
+	default:
+		return nil, fmt.Errorf("unknown delete policy %q, use one of %s", refs.DeletePolicy, strings.Join(DeletePolicies, "|"))
+	}
+
+	return &referenceClient{
+		References: refs,
+		apiClient:  resty.New().SetTimeout(5 * time.Second),
+	}, nil
+}
+
+// usage returns the votes and voters of the poll with pollId
+func (rc *referenceClient) usage(pollId int) (usage, error) {
+	var used usage
+
+	if rc.VotesAPIURL != "" {
+		var votes []struct {
+			VoteID uint `json:"vote_id"`
+		}
+		votesURL := fmt.Sprintf("%s/votes?poll_id=%d", rc.VotesAPIURL, pollId)
+		response, err := rc.apiClient.R().SetResult(&votes).Get(votesURL)
+		if err != nil {
+			return usage{}, fmt.Errorf("could not get votes from API: (%s) %w", votesURL, err)
+		}
+		if response.StatusCode() != http.StatusOK {
+			return usage{}, fmt.Errorf("could not get votes from API: (%s) %s", votesURL, response.Status())
+		}
+		for _, vote := range votes {
+			used.voteIds = append(used.voteIds, vote.VoteID)
+		}
+	}
+
+	if rc.VoterAPIURL != "" {
+		voterIds, err := rc.votersWithPoll(pollId)
+		if err != nil {
+			return usage{}, err
+		}
+		used.voterIds = voterIds
+	}
+
+	return used, nil
+}
+
+// votersWithPoll returns the ids of the voters that have the poll with
+// pollId in their history.  The voter-api hands out the voters a page at
+// a time, we follow the Link header from one page to the next until
+// there is no next page.
+func (rc *referenceClient) votersWithPoll(pollId int) ([]uint, error) {
+	var voterIds []uint
+	votersURL := fmt.Sprintf("%s/voters?limit=%d", rc.VoterAPIURL, voterPageLimit)
+	for votersURL != "" {
+		var voters []struct {
+			VoterId     uint `json:"voter_id"`
+			VoteHistory []struct {
+				PollId uint `json:"poll_id"`
+			} `json:"voter_history"`
+		}
+		response, err := rc.apiClient.R().SetResult(&voters).Get(votersURL)
+		if err != nil {
+			return nil, fmt.Errorf("could not get voters from API: (%s) %w", votersURL, err)
+		}
+		if response.StatusCode() != http.StatusOK {
+			return nil, fmt.Errorf("could not get voters from API: (%s) %s", votersURL, response.Status())
+		}
+		for _, voter := range voters {
+			for _, poll := range voter.VoteHistory {
+				if poll.PollId == uint(pollId) {
+					voterIds = append(voterIds, voter.VoterId)
+					break
+				}
+			}
+		}
+
+		votersURL = nextPageURL(rc.VoterAPIURL, response.Header().Get("Link"))
+	}
+	return voterIds, nil
+}
+
+// nextPageURL returns the url of the next page from a Link header like
+// </voters?cursor=abc&limit=1000>; rel="next", or "" on the last page.
+// The link is relative to the api, so it goes after baseURL.
+func nextPageURL(baseURL string, link string) string {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		target = strings.TrimSpace(target)
+		if strings.HasPrefix(target, "<") && strings.HasSuffix(target, ">") {
+			return strings.TrimSuffix(baseURL, "/") + target[1:len(target)-1]
+		}
+	}
+	return ""
+}
+
+// cleanUp deletes what used the poll with pollId, after the poll itself
+// is deleted.  Something can start using the poll between the usage
+// check and the delete, a voter that takes the poll into its history or
+// a vote cast with the cascade policy, so it looks again first.  After
+// the delete no vote can be cast in the poll, the votes-api checks the
+// poll in the same step as it adds the vote.  The voter-api remembers
+// polls that exist for a while though, so a voter can still take the
+// poll into its history for that long.  That race is left open, it only
+// leaves a history entry for a poll that is gone.
+func (rc *referenceClient) cleanUp(pollId int, used usage) error {
+	again, err := rc.usage(pollId)
+	if err != nil {
+		//Delete at least what we knew about
+		if cascadeErr := rc.cascade(pollId, used); cascadeErr != nil {
+			return cascadeErr
+		}
+		return err
+	}
+	return rc.cascade(pollId, again)
+}
+
+// cascade deletes the votes of the poll with pollId, and the poll from
+// the history of its voters.  Something that is already gone is fine, it
+// keeps going after an error and returns the first one.
+func (rc *referenceClient) cascade(pollId int, used usage) error {
+	var firstErr error
+	remove := func(url string) {
+		response, err := rc.apiClient.R().Delete(url)
+		if err == nil && response.StatusCode() != http.StatusOK && response.StatusCode() != http.StatusNotFound {
+			err = fmt.Errorf("%s", response.Status())
+		}
+		if err != nil {
+			log.Println("Error deleting "+url+": ", err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("could not delete %s: %w", url, err)
+			}
+		}
+	}
+
+	//Deleting a vote also takes the poll out of the history of its
+	//voter, so most voters are done after this
+	for _, voteId := range used.voteIds {
+		remove(fmt.Sprintf("%s/votes/%d", rc.VotesAPIURL, voteId))
+	}
+	for _, voterId := range used.voterIds {
+		remove(fmt.Sprintf("%s/voters/%d/polls/%d", rc.VoterAPIURL, voterId, pollId))
+	}
+	return firstErr
+}
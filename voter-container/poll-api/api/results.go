@@ -0,0 +1,114 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"poll-api/poll"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// ResultsEventType is the SSE event name of the results of a poll
+const ResultsEventType = "results"
+
+// resultsHeartbeat is how often an idle stream gets a keep alive, so
+// proxies do not close it
+const resultsHeartbeat = 15 * time.Second
+
+// results returns the results of the poll with id, from the tallies the
+// votes api keeps, nothing is counted here
+func (pa *PollAPI) results(id int) (poll.PollResults, error) {
+	p, err := pa.store.GetPoll(id)
+	if err != nil {
+		return poll.PollResults{}, err
+	}
+	tallies, err := pa.store.GetTallies(id)
+	if err != nil {
+		return poll.PollResults{}, err
+	}
+	return poll.NewPollResults(p, tallies), nil
+}
+
+// implementation for GET /polls/:id/results
+// returns the votes and percentage of every option, the turnout and the
+// leading options
+func (pa *PollAPI) GetPollResults(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	results, err := pa.results(id)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// implementation for GET /polls/:id/results/stream
+// streams the results of the poll as Server-Sent Events.  The first
+// event is the results as they are now, after that every change of the
+// tallies sends the results again, so a client that reconnects only
+// needs the next event to catch up.
+func (pa *PollAPI) PollResultsStream(c *gin.Context) {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return
+	}
+
+	//Watch before the first read, so a vote in between is not missed
+	changes, err := pa.store.WatchTallies(c.Request.Context(), id)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+	results, err := pa.results(id)
+	if err != nil {
+		abortWithStoreError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	//Stops nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if err := sse.Encode(c.Writer, sse.Event{Event: ResultsEventType, Data: results}); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(resultsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			results, err := pa.results(id)
+			if err != nil {
+				//The poll was deleted, or the store is gone
+				log.Println("Error reading poll results: ", err)
+				return
+			}
+			if err := sse.Encode(c.Writer, sse.Event{Event: ResultsEventType, Data: results}); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			//A line that starts with a colon is a comment
+			if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
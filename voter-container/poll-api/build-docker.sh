@@ -0,0 +1,2 @@
+#!/bin/bash
+docker build --tag poll-api:v1  -f ./dockerfile .
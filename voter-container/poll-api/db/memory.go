@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"poll-api/poll"
+)
+
+//------------------------------------------------------------
+// MEMORY STORE
+//------------------------------------------------------------
+
+// MemoryStore keeps the polls in a map, they are gone when the api
+// stops.  The lock lets many requests read at once, a change waits for
+// the others to finish.
+type MemoryStore struct {
+	lock     sync.RWMutex
+	pollList poll.PollList
+}
+
+// constructor for MemoryStore struct
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pollList: poll.PollList{
+			Polls: make(map[uint]poll.Poll),
+		},
+	}
+}
+
+// copyPoll returns p with its own copy of the options, so a poll handed
+// out by the store cannot change the one in the map
+func copyPoll(p poll.Poll) poll.Poll {
+	p.PollOptions = append([]poll.PollOption{}, p.PollOptions...)
+	return p
+}
+
+func (s *MemoryStore) AddPoll(p poll.Poll) (poll.Poll, error) {
+	if err := p.Validate(); err != nil {
+		return poll.Poll{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.pollList.Polls[p.PollID]; ok {
+		return poll.Poll{}, ErrPollExists
+	}
+	p = copyPoll(p)
+	s.pollList.Polls[p.PollID] = p
+	return copyPoll(p), nil
+}
+
+func (s *MemoryStore) GetPoll(id int) (poll.Poll, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	p, ok := s.pollList.Polls[uint(id)]
+	if !ok {
+		return poll.Poll{}, ErrPollNotFound
+	}
+	return copyPoll(p), nil
+}
+
+func (s *MemoryStore) GetAllPolls() ([]poll.Poll, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	polls := make([]poll.Poll, 0, len(s.pollList.Polls))
+	for _, p := range s.pollList.Polls {
+		polls = append(polls, copyPoll(p))
+	}
+	sort.Slice(polls, func(i, j int) bool { return polls[i].PollID < polls[j].PollID })
+	return polls, nil
+}
+
+func (s *MemoryStore) ChangePoll(id int, change func(p *poll.Poll) error) (poll.Poll, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stored, ok := s.pollList.Polls[uint(id)]
+	if !ok {
+		return poll.Poll{}, ErrPollNotFound
+	}
+	p := copyPoll(stored)
+	if err := change(&p); err != nil {
+		return poll.Poll{}, err
+	}
+	//The id is the key of the poll, it cannot be changed
+	p.PollID = stored.PollID
+	if err := p.Validate(); err != nil {
+		return poll.Poll{}, err
+	}
+	s.pollList.Polls[p.PollID] = p
+	return copyPoll(p), nil
+}
+
+func (s *MemoryStore) DeletePoll(id int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.pollList.Polls[uint(id)]; !ok {
+		return ErrPollNotFound
+	}
+	delete(s.pollList.Polls, uint(id))
+	return nil
+}
+
+// DeletePollIfUnused is DeletePoll, the votes api only works with polls
+// that are kept in redis so a poll in memory never has votes
+func (s *MemoryStore) DeletePollIfUnused(id int) error {
+	return s.DeletePoll(id)
+}
+
+func (s *MemoryStore) DeleteAll() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pollList.Polls = make(map[uint]poll.Poll)
+	return nil
+}
+
+// GetTallies returns no votes, the votes api only works with polls that
+// are kept in redis
+func (s *MemoryStore) GetTallies(id int) (map[uint]int64, error) {
+	return map[uint]int64{}, nil
+}
+
+// WatchTallies returns a channel that is closed when ctx is done, the
+// tallies of a poll in memory never change
+func (s *MemoryStore) WatchTallies(ctx context.Context, id int) (<-chan struct{}, error) {
+	changes := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(changes)
+	}()
+	return changes, nil
+}
+
+func (s *MemoryStore) Ping() error {
+	return nil
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"fmt"
+
+	"poll-api/poll"
+)
+
+//------------------------------------------------------------
+// POLL CHANGES
+//------------------------------------------------------------
+
+// These are built on ChangePoll, so every store gets them, and each one
+// is a single step in that store.
+
+// UpdatePoll replaces the title, question and options of the poll with
+// the id of p, and returns the poll as stored
+func UpdatePoll(store PollStore, p poll.Poll) (poll.Poll, error) {
+	return store.ChangePoll(int(p.PollID), func(stored *poll.Poll) error {
+		stored.PollTitle = p.PollTitle
+		stored.PollQuestion = p.PollQuestion
+		stored.PollOptions = p.PollOptions
+		return nil
+	})
+}
+
+// AddOption adds option to the poll with pollId and returns the poll.  An
+// option id the poll already has fails poll.Validate.
+func AddOption(store PollStore, pollId int, option poll.PollOption) (poll.Poll, error) {
+	return store.ChangePoll(pollId, func(p *poll.Poll) error {
+		p.PollOptions = append(p.PollOptions, option)
+		return nil
+	})
+}
+
+// UpdateOption changes the value of the option of the poll with pollId
+// that has the id of option, and returns the poll
+func UpdateOption(store PollStore, pollId int, option poll.PollOption) (poll.Poll, error) {
+	return store.ChangePoll(pollId, func(p *poll.Poll) error {
+		for i := range p.PollOptions {
+			if p.PollOptions[i].PollOptionID == option.PollOptionID {
+				p.PollOptions[i].PollOptionValue = option.PollOptionValue
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %d", ErrOptionNotFound, option.PollOptionID)
+	})
+}
+
+// DeleteOption removes the option with optionId from the poll with
+// pollId, and returns the poll
+func DeleteOption(store PollStore, pollId int, optionId uint) (poll.Poll, error) {
+	return store.ChangePoll(pollId, func(p *poll.Poll) error {
+		for i, option := range p.PollOptions {
+			if option.PollOptionID == optionId {
+				p.PollOptions = append(p.PollOptions[:i:i], p.PollOptions[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %d", ErrOptionNotFound, optionId)
+	})
+}
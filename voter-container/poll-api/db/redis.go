@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"poll-api/poll"
+
+	"github.com/nitishm/go-rejson/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+//------------------------------------------------------------
+// REDIS STORE
+//------------------------------------------------------------
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+	RedisKeyPrefix       = "poll:"
+
+	//RedisTallyKeyPrefix is the start of the keys of the tallies, a
+	//hash for every poll from poll_option_id to the number of votes.
+	//The votes api keeps them up to date, and publishes on a channel
+	//with the same name as the key when it changes one.
+	RedisTallyKeyPrefix = "tally:poll:"
+
+	//RedisVotesKeyPrefix is the start of the keys of the votes of a
+	//poll, a hash for every poll from voter_id to vote_id that the
+	//votes api keeps.
+	RedisVotesKeyPrefix = "votes:poll:"
+)
+
+// redisScanCount is the COUNT hint we give to SCAN, roughly how many
+// keys redis looks at in one call
+const redisScanCount = 100
+
+// maxChangeRetries is how many times ChangePoll tries again when another
+// client writes the poll between our read and our write
+const maxChangeRetries = 10
+
+type cache struct {
+	cacheClient *redis.Client
+	jsonHelper  *rejson.Handler
+	context     context.Context
+}
+
+// RedisStore keeps the polls in redis, as json documents stored with the
+// RedisJSON module under the keys poll:<id>.  The voter-api can share the
+// same redis.
+type RedisStore struct {
+	cache
+}
+
+func NewRedisStore(location string) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	ctx := context.Background()
+
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error() + "cache might not be available, continuing...")
+	}
+
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+
+	return &RedisStore{
+		cache: cache{
+			cacheClient: client,
+			jsonHelper:  jsonHelper,
+			context:     ctx,
+		},
+	}, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS
+//------------------------------------------------------------
+
+func isRedisNilError(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
+}
+
+func redisKeyFromId(id int) string {
+	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+}
+
+func (s *RedisStore) getPollFromRedis(key string, p *poll.Poll) error {
+	pollObject, err := s.jsonHelper.JSONGet(key, ".")
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(pollObject.([]byte), p)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *RedisStore) scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	iter := s.cacheClient.Scan(s.context, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(s.context) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+//------------------------------------------------------------
+// POLLS
+//------------------------------------------------------------
+
+// AddPoll adds a new poll.  NX makes the existence check and the write a
+// single step, so two clients adding the same poll cannot both succeed.
+func (s *RedisStore) AddPoll(p poll.Poll) (poll.Poll, error) {
+	if err := p.Validate(); err != nil {
+		return poll.Poll{}, err
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return poll.Poll{}, err
+	}
+	res, err := s.cacheClient.Do(s.context, "JSON.SET", redisKeyFromId(int(p.PollID)), ".", string(data), "NX").Result()
+	if err != nil && !isRedisNilError(err) {
+		return poll.Poll{}, err
+	}
+	if res == nil {
+		return poll.Poll{}, ErrPollExists
+	}
+
+	return p, nil
+}
+
+func (s *RedisStore) GetPoll(id int) (poll.Poll, error) {
+	var p poll.Poll
+	if err := s.getPollFromRedis(redisKeyFromId(id), &p); err != nil {
+		if isRedisNilError(err) {
+			return poll.Poll{}, ErrPollNotFound
+		}
+		return poll.Poll{}, err
+	}
+	return p, nil
+}
+
+func (s *RedisStore) GetAllPolls() ([]poll.Poll, error) {
+	keys, err := s.scanKeys(RedisKeyPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	polls := make([]poll.Poll, 0, len(keys))
+	for _, key := range keys {
+		var p poll.Poll
+		if err := s.getPollFromRedis(key, &p); err != nil {
+			//The poll was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
+			return nil, err
+		}
+		polls = append(polls, p)
+	}
+	sort.Slice(polls, func(i, j int) bool { return polls[i].PollID < polls[j].PollID })
+	return polls, nil
+}
+
+// A plain read, change, write lets two clients read the same poll and
+// the second write quietly undoes the first.  So the write is made by
+// writeIfUnchangedScript, which only writes if the poll is still the
+// json we read.  If it is not, somebody wrote it in between, and we read
+// the poll again and apply the change to that.
+
+// These are the answers of writeIfUnchangedScript
+const (
+	casMissing  = 0
+	casDone     = 1
+	casConflict = -1
+)
+
+// writeIfUnchangedScript replaces the poll at KEYS[1] with the json in
+// ARGV[2] if JSON.GET still returns ARGV[1].  Redis runs a script from
+// start to finish without running any other command in between, so
+// nobody can change the poll after we checked it.
+var writeIfUnchangedScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if redis.call('JSON.GET', KEYS[1], '.') ~= ARGV[1] then
+	return -1
+end
+redis.call('JSON.SET', KEYS[1], '.', ARGV[2])
+return 1
+`)
+
+// ChangePoll reads the poll, applies change to it and writes it back, in
+// a single step
+func (s *RedisStore) ChangePoll(id int, change func(p *poll.Poll) error) (poll.Poll, error) {
+	redisKey := redisKeyFromId(id)
+
+	for i := 0; i < maxChangeRetries; i++ {
+		pollObject, err := s.jsonHelper.JSONGet(redisKey, ".")
+		if err != nil {
+			if isRedisNilError(err) {
+				return poll.Poll{}, ErrPollNotFound
+			}
+			return poll.Poll{}, err
+		}
+		read := pollObject.([]byte)
+
+		var p poll.Poll
+		if err := json.Unmarshal(read, &p); err != nil {
+			return poll.Poll{}, err
+		}
+		stored := p.PollID
+		if err := change(&p); err != nil {
+			return poll.Poll{}, err
+		}
+		//The id is the key of the poll, it cannot be changed
+		p.PollID = stored
+		if err := p.Validate(); err != nil {
+			return poll.Poll{}, err
+		}
+		data, err := json.Marshal(p)
+		if err != nil {
+			return poll.Poll{}, err
+		}
+
+		res, err := writeIfUnchangedScript.Run(s.context, s.cacheClient, []string{redisKey}, string(read), string(data)).Int64()
+		if err != nil {
+			return poll.Poll{}, err
+		}
+		switch res {
+		case casDone:
+			return p, nil
+		case casMissing:
+			return poll.Poll{}, ErrPollNotFound
+		}
+		//casConflict, read the poll again
+	}
+	return poll.Poll{}, errors.New("poll is changing too often, try again")
+}
+
+func (s *RedisStore) DeletePoll(id int) error {
+	numDeleted, err := s.cacheClient.Del(s.context, redisKeyFromId(id)).Result()
+	if err != nil {
+		return err
+	}
+	if numDeleted == 0 {
+		return ErrPollNotFound
+	}
+	return nil
+}
+
+// deleteIfNoVotesScript deletes the poll at KEYS[1] if the hash of its
+// votes at KEYS[2] is empty, and returns the number of polls deleted, or
+// -1 if the poll has votes.  The votes api checks that the poll is there
+// in the same script that adds a vote to the hash, so a vote and a
+// delete can never both get in.
+var deleteIfNoVotesScript = redis.NewScript(`
+if redis.call('HLEN', KEYS[2]) > 0 then
+	return -1
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+func (s *RedisStore) DeletePollIfUnused(id int) error {
+	keys := []string{redisKeyFromId(id), fmt.Sprintf("%s%d", RedisVotesKeyPrefix, id)}
+	numDeleted, err := deleteIfNoVotesScript.Run(s.context, s.cacheClient, keys).Int64()
+	if err != nil {
+		return err
+	}
+	switch numDeleted {
+	case -1:
+		return ErrPollInUse
+	case 0:
+		return ErrPollNotFound
+	}
+	return nil
+}
+
+func (s *RedisStore) DeleteAll() error {
+	keys, err := s.scanKeys(RedisKeyPrefix + "*")
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err = s.cacheClient.Del(s.context, keys...).Result()
+	return err
+}
+
+//------------------------------------------------------------
+// TALLIES
+//------------------------------------------------------------
+
+func redisTallyKey(id int) string {
+	return fmt.Sprintf("%s%d", RedisTallyKeyPrefix, id)
+}
+
+func (s *RedisStore) GetTallies(id int) (map[uint]int64, error) {
+	fields, err := s.cacheClient.HGetAll(s.context, redisTallyKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tallies := make(map[uint]int64, len(fields))
+	for field, value := range fields {
+		optionId, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			log.Println("Skipping tally of unknown option: ", field)
+			continue
+		}
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		tallies[uint(optionId)] = count
+	}
+	return tallies, nil
+}
+
+// WatchTallies subscribes to the channel of the tallies of the poll.  The
+// subscription is made before WatchTallies returns, so a caller that
+// reads the tallies after that cannot miss a change.
+func (s *RedisStore) WatchTallies(ctx context.Context, id int) (<-chan struct{}, error) {
+	pubsub := s.cacheClient.Subscribe(ctx, redisTallyKey(id))
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+				//A change that is already waiting to be read covers
+				//this one too
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+func (s *RedisStore) Ping() error {
+	return s.cacheClient.Ping(s.context).Err()
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"poll-api/poll"
+)
+
+//------------------------------------------------------------
+// POLL STORES
+//------------------------------------------------------------
+
+// PollStore is everything the api needs from a place to keep polls.  The
+// in memory map (MemoryStore) and redis (RedisStore) both implement it,
+// so the api does not know or care where the polls live.  The rules are
+// the same for every store:
+//
+//   - AddPoll returns ErrPollExists if the id is taken
+//   - GetPoll, ChangePoll and DeletePoll return ErrPollNotFound if there
+//     is no poll with that id
+//   - ChangePoll hands the stored poll to change and writes it back, as
+//     a single step, nobody can change the poll in between.  A poll that
+//     fails poll.Validate after the change is not written
+//   - DeletePollIfUnused deletes a poll like DeletePoll, but returns
+//     ErrPollInUse and leaves it alone if votes were cast in it.  The
+//     check and the delete are a single step, a vote cast at the same
+//     time either comes first and blocks the delete, or finds the poll
+//     gone
+//   - GetAllPolls returns the polls in order of their id
+//   - GetTallies returns the votes of every option of a poll, counted by
+//     the votes api as they are cast.  The memory store never gets votes
+//   - WatchTallies returns a channel that gets a value when the tallies
+//     of a poll change, until ctx is done and the channel is closed.
+//     Changes that come quicker than they are read are merged into one
+//   - Every function is safe to call from many goroutines at once, gin
+//     runs each request on its own goroutine
+type PollStore interface {
+	AddPoll(p poll.Poll) (poll.Poll, error)
+	GetPoll(id int) (poll.Poll, error)
+	GetAllPolls() ([]poll.Poll, error)
+	ChangePoll(id int, change func(p *poll.Poll) error) (poll.Poll, error)
+	DeletePoll(id int) error
+	DeletePollIfUnused(id int) error
+	DeleteAll() error
+
+	GetTallies(id int) (map[uint]int64, error)
+	WatchTallies(ctx context.Context, id int) (<-chan struct{}, error)
+
+	//Ping returns an error if the store cannot be reached, for the
+	//health check
+	Ping() error
+}
+
+// The kinds of store NewStore knows about
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+// StoreKinds lists every kind of store, for help and error messages
+var StoreKinds = []string{StoreMemory, StoreRedis}
+
+var (
+	// ErrPollNotFound is returned for a poll that does not exist
+	ErrPollNotFound = errors.New("poll does not exist")
+
+	// ErrPollExists is returned when a poll is added with an id that is
+	// already taken
+	ErrPollExists = errors.New("poll already exists")
+
+	// ErrOptionNotFound is returned for an option the poll does not have
+	ErrOptionNotFound = errors.New("poll option does not exist")
+
+	// ErrPollInUse is returned by DeletePollIfUnused for a poll that has
+	// votes
+	ErrPollInUse = errors.New("poll has votes")
+)
+
+// make sure every store implements PollStore
+var (
+	_ PollStore = (*MemoryStore)(nil)
+	_ PollStore = (*RedisStore)(nil)
+)
+
+// NewStore returns the store called kind.  The location is the address
+// of redis for the redis store, an empty location uses the REDIS_URL
+// environment variable, and then the default location.  The memory
+// store has no location.
+func NewStore(kind string, location string) (PollStore, error) {
+	switch strings.ToLower(kind) {
+	case StoreMemory, "":
+		return NewMemoryStore(), nil
+	case StoreRedis:
+		if location == "" {
+			location = os.Getenv("REDIS_URL")
+		}
+		if location == "" {
+			location = RedisDefaultLocation
+		}
+		store, err := NewRedisStore(location)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, fmt.Errorf("unknown store %q, use one of %s", kind, strings.Join(StoreKinds, "|"))
+}
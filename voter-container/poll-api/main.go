@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"poll-api/api"
+	"poll-api/db"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	hostFlag     string
+	portFlag     uint
+	storeFlag    string
+	locationFlag string
+	deleteFlag   string
+	voterAPIFlag string
+	votesAPIFlag string
+)
+
+func envVarOrDefault(envVar string, defaultVal string) string {
+	envVal := os.Getenv(envVar)
+	if envVal != "" {
+		return envVal
+	}
+	return defaultVal
+}
+
+func processCmdLineFlags() {
+	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
+	flag.UintVar(&portFlag, "p", 1081, "Default Port")
+
+	//The store decides where the polls live, the flag wins over the
+	//environment variable, for example: go run main.go -store redis
+	defaultStore := os.Getenv("POLL_STORE")
+	if defaultStore == "" {
+		defaultStore = db.StoreMemory
+	}
+	flag.StringVar(&storeFlag, "store", defaultStore,
+		"Where to keep the polls, one of "+strings.Join(db.StoreKinds, "|")+" (env POLL_STORE)")
+	flag.StringVar(&locationFlag, "location", "",
+		"The redis address for the redis store (env REDIS_URL)")
+
+	//What happens to the votes and voters of a deleted poll, see
+	//api/references.go
+	flag.StringVar(&deleteFlag, "delete", envVarOrDefault("POLL_DELETE_POLICY", api.DeleteBlock),
+		"What to do when a poll in use is deleted, one of "+strings.Join(api.DeletePolicies, "|")+" (env POLL_DELETE_POLICY)")
+	flag.StringVar(&voterAPIFlag, "voterapi", envVarOrDefault("POLL_VOTER_API_URL", api.DefaultVoterAPIURL),
+		"The voter api url, empty to leave voters out (env POLL_VOTER_API_URL)")
+	flag.StringVar(&votesAPIFlag, "votesapi", envVarOrDefault("POLL_VOTES_API_URL", api.DefaultVotesAPIURL),
+		"The votes api url, empty to leave votes out (env POLL_VOTES_API_URL)")
+
+	flag.Parse()
+}
+
+func main() {
+	processCmdLineFlags()
+	router := gin.Default()
+	router.Use(cors.Default())
+
+	store, err := db.NewStore(storeFlag, locationFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	apiHandler, err := api.NewWithReferences(store, api.References{
+		DeletePolicy: deleteFlag,
+		VoterAPIURL:  voterAPIFlag,
+		VotesAPIURL:  votesAPIFlag,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	router.GET("/polls", apiHandler.GetAllPolls)
+	router.DELETE("/polls", apiHandler.DeleteAllPolls)
+	router.GET("/polls/:id", apiHandler.GetPoll)
+	router.POST("/polls/:id", apiHandler.AddPoll)
+	router.PUT("/polls/:id", apiHandler.UpdatePoll)
+	router.DELETE("/polls/:id", apiHandler.DeletePoll)
+	router.GET("/polls/:id/options", apiHandler.GetPollOptions)
+	router.POST("/polls/:id/options", apiHandler.AddPollOption)
+	router.GET("/polls/:id/options/:optionid", apiHandler.GetPollOption)
+	router.PUT("/polls/:id/options/:optionid", apiHandler.UpdatePollOption)
+	router.DELETE("/polls/:id/options/:optionid", apiHandler.DeletePollOption)
+	router.GET("/polls/:id/results", apiHandler.GetPollResults)
+	router.GET("/polls/:id/results/stream", apiHandler.PollResultsStream)
+	router.GET("/polls/health", apiHandler.HealthCheck)
+	router.GET("/polls/add-sample-polls", apiHandler.AddSamplePolls)
+
+	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
+	router.Run(serverPath)
+}
@@ -0,0 +1,101 @@
+package poll
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// This is the poll package of vote-api-starter, with the option made
+// public so the api can send and receive it, json names that match the
+// voter-api, and the checks a poll has to pass before it is stored.
+
+type PollOption struct {
+	PollOptionID    uint   `json:"poll_option_id"`
+	PollOptionValue string `json:"poll_option_value"`
+}
+
+type Poll struct {
+	PollID       uint         `json:"poll_id"`
+	PollTitle    string       `json:"poll_title"`
+	PollQuestion string       `json:"poll_question"`
+	PollOptions  []PollOption `json:"poll_options"`
+}
+
+type PollList struct {
+	Polls map[uint]Poll `json:"polls"` //A map of PollIDs as keys and Poll structs as values
+}
+
+// ErrInvalidPoll is returned when a poll or an option does not pass
+// Validate, the api turns it into a 400 Bad Request
+var ErrInvalidPoll = errors.New("invalid poll")
+
+// constructor for Poll struct
+func NewPoll(id uint, title, question string) *Poll {
+	return &Poll{
+		PollID:       id,
+		PollTitle:    title,
+		PollQuestion: question,
+		PollOptions:  []PollOption{},
+	}
+}
+
+func NewSamplePoll() *Poll {
+	return &Poll{
+		PollID:       1,
+		PollTitle:    "Favorite Pet",
+		PollQuestion: "What type of pet do you like best?",
+		PollOptions: []PollOption{
+			{PollOptionID: 1, PollOptionValue: "Dog"},
+			{PollOptionID: 2, PollOptionValue: "Cat"},
+			{PollOptionID: 3, PollOptionValue: "Fish"},
+			{PollOptionID: 4, PollOptionValue: "Bird"},
+			{PollOptionID: 5, PollOptionValue: "NONE"},
+		},
+	}
+}
+
+// Validate checks an option on its own, the value cannot be empty
+func (o PollOption) Validate() error {
+	if strings.TrimSpace(o.PollOptionValue) == "" {
+		return fmt.Errorf("%w: option %d has no value", ErrInvalidPoll, o.PollOptionID)
+	}
+	return nil
+}
+
+// Validate checks the poll before it is stored.  It needs a title, and
+// every option needs a value and an id that no other option of the poll
+// has.
+func (p *Poll) Validate() error {
+	if strings.TrimSpace(p.PollTitle) == "" {
+		return fmt.Errorf("%w: poll %d has no title", ErrInvalidPoll, p.PollID)
+	}
+
+	seen := map[uint]bool{}
+	for _, option := range p.PollOptions {
+		if err := option.Validate(); err != nil {
+			return err
+		}
+		if seen[option.PollOptionID] {
+			return fmt.Errorf("%w: option id %d is used more than once", ErrInvalidPoll, option.PollOptionID)
+		}
+		seen[option.PollOptionID] = true
+	}
+	return nil
+}
+
+// Option returns the option with id, and false if the poll has none
+func (p *Poll) Option(id uint) (PollOption, bool) {
+	for _, option := range p.PollOptions {
+		if option.PollOptionID == id {
+			return option, true
+		}
+	}
+	return PollOption{}, false
+}
+
+func (p *Poll) ToJson() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
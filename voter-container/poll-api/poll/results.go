@@ -0,0 +1,63 @@
+package poll
+
+import "math"
+
+// The votes api counts the votes of every option as they are cast, the
+// results put those counts next to the options of the poll.
+
+type OptionResult struct {
+	PollOptionID    uint    `json:"poll_option_id"`
+	PollOptionValue string  `json:"poll_option_value"`
+	Votes           int64   `json:"votes"`
+	Percent         float64 `json:"percent"`
+}
+
+type PollResults struct {
+	PollID    uint           `json:"poll_id"`
+	PollTitle string         `json:"poll_title"`
+	Turnout   int64          `json:"turnout"`
+	Options   []OptionResult `json:"options"`
+
+	//Leading holds the ids of the options with the most votes, more
+	//than one on a tie, and none before the first vote
+	Leading []uint `json:"leading"`
+}
+
+// NewPollResults returns the results of p, counts holds the number of
+// votes of every option id.  The turnout counts every vote, also those
+// of an option that was removed from the poll after it got them, and the
+// percentages are of the turnout, rounded to two decimals.
+func NewPollResults(p Poll, counts map[uint]int64) PollResults {
+	results := PollResults{
+		PollID:    p.PollID,
+		PollTitle: p.PollTitle,
+		Options:   make([]OptionResult, 0, len(p.PollOptions)),
+		Leading:   []uint{},
+	}
+	for _, count := range counts {
+		results.Turnout += count
+	}
+
+	var most int64
+	for _, option := range p.PollOptions {
+		result := OptionResult{
+			PollOptionID:    option.PollOptionID,
+			PollOptionValue: option.PollOptionValue,
+			Votes:           counts[option.PollOptionID],
+		}
+		if results.Turnout > 0 {
+			result.Percent = math.Round(float64(result.Votes)*10000/float64(results.Turnout)) / 100
+		}
+		results.Options = append(results.Options, result)
+
+		switch {
+		case result.Votes == 0:
+		case result.Votes > most:
+			most = result.Votes
+			results.Leading = []uint{option.PollOptionID}
+		case result.Votes == most:
+			results.Leading = append(results.Leading, option.PollOptionID)
+		}
+	}
+	return results
+}
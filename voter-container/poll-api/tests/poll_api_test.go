@@ -0,0 +1,279 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+
+	"poll-api/db"
+	"poll-api/poll"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	BASE_API  = "http://localhost:1081"
+	VOTER_API = "http://localhost:1080"
+
+	client = resty.New()
+)
+
+// Reset the polls before the tests
+func TestMain(m *testing.M) {
+	DeleteAllPollsResponse, error := client.R().Delete(BASE_API + "/polls")
+	if DeleteAllPollsResponse.StatusCode() != 200 {
+		fmt.Printf("error clearing database, %v", error)
+	}
+
+	AddSamplePollsResponse, error := client.R().Get(BASE_API + "/polls/add-sample-polls")
+	if AddSamplePollsResponse.StatusCode() != 200 {
+		fmt.Printf("error adding sample polls, %v", error)
+	}
+
+	os.Exit(m.Run())
+}
+
+func Test_GetAllPolls(t *testing.T) {
+	response, _ := client.R().Get(BASE_API + "/polls")
+	polls := []poll.Poll{}
+
+	err := json.Unmarshal(response.Body(), &polls)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, 2, len(polls))
+}
+
+func Test_GetPoll(t *testing.T) {
+	response, _ := client.R().Get(BASE_API + "/polls/1")
+	p := poll.Poll{}
+
+	err := json.Unmarshal(response.Body(), &p)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "Favorite Pet", p.PollTitle)
+	assert.Equal(t, 5, len(p.PollOptions))
+
+	notFoundResponse, _ := client.R().Get(BASE_API + "/polls/42")
+	assert.Equal(t, 404, notFoundResponse.StatusCode())
+}
+
+func Test_AddUpdateDeletePoll(t *testing.T) {
+	response, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{
+			"poll_title": "Favorite Color",
+			"poll_question": "Which color do you like best?",
+			"poll_options": [
+				{ "poll_option_id": 1, "poll_option_value": "Red" },
+				{ "poll_option_id": 2, "poll_option_value": "Blue" }
+			]
+		}`).
+		Post(BASE_API + "/polls/3")
+	assert.Equal(t, 200, response.StatusCode())
+
+	duplicateResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_title": "Favorite Color" }`).
+		Post(BASE_API + "/polls/3")
+	assert.Equal(t, 409, duplicateResponse.StatusCode())
+
+	updateResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{
+			"poll_id": 3,
+			"poll_title": "Favorite Colour",
+			"poll_question": "Which colour do you like best?",
+			"poll_options": [ { "poll_option_id": 1, "poll_option_value": "Green" } ]
+		}`).
+		Put(BASE_API + "/polls/3")
+	assert.Equal(t, 200, updateResponse.StatusCode())
+
+	getResponse, _ := client.R().Get(BASE_API + "/polls/3")
+	p := poll.Poll{}
+	assert.Nil(t, json.Unmarshal(getResponse.Body(), &p))
+	assert.Equal(t, "Favorite Colour", p.PollTitle)
+	assert.Equal(t, []poll.PollOption{{PollOptionID: 1, PollOptionValue: "Green"}}, p.PollOptions)
+
+	mismatchResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_id": 4, "poll_title": "Favorite Colour" }`).
+		Put(BASE_API + "/polls/3")
+	assert.Equal(t, 400, mismatchResponse.StatusCode())
+
+	deleteResponse, _ := client.R().Delete(BASE_API + "/polls/3")
+	assert.Equal(t, 200, deleteResponse.StatusCode())
+
+	deleteAgainResponse, _ := client.R().Delete(BASE_API + "/polls/3")
+	assert.Equal(t, 404, deleteAgainResponse.StatusCode())
+}
+
+// With the default block policy a poll in the history of a voter cannot
+// be deleted, until the voter lets go of it
+func Test_DeletePollInUse(t *testing.T) {
+	addResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{
+			"poll_id": 6,
+			"poll_title": "Favorite Starter",
+			"poll_options": [ { "poll_option_id": 1, "poll_option_value": "Bulbasaur" } ]
+		}`).
+		Post(BASE_API + "/polls/6")
+	assert.Equal(t, 200, addResponse.StatusCode())
+
+	voterResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "voter_id": 30, "name": "Ash" }`).
+		Post(VOTER_API + "/voters/30")
+	assert.Equal(t, 200, voterResponse.StatusCode())
+
+	historyResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_id": 6 }`).
+		Post(VOTER_API + "/voters/30/polls/6")
+	assert.Equal(t, 200, historyResponse.StatusCode())
+
+	blockedResponse, _ := client.R().Delete(BASE_API + "/polls/6")
+	assert.Equal(t, 409, blockedResponse.StatusCode())
+
+	getResponse, _ := client.R().Get(BASE_API + "/polls/6")
+	assert.Equal(t, 200, getResponse.StatusCode())
+
+	releaseResponse, _ := client.R().Delete(VOTER_API + "/voters/30/polls/6")
+	assert.Equal(t, 200, releaseResponse.StatusCode())
+
+	deleteResponse, _ := client.R().Delete(BASE_API + "/polls/6")
+	assert.Equal(t, 200, deleteResponse.StatusCode())
+
+	client.R().Delete(VOTER_API + "/voters/30")
+}
+
+// A vote cast after the usage check still blocks the delete, the redis
+// store looks for votes in the same step as it deletes
+func Test_DeletePollIfUnused(t *testing.T) {
+	store, err := db.NewRedisStore(db.RedisDefaultLocation)
+	assert.Nil(t, err)
+	if err != nil {
+		return
+	}
+	_, err = store.AddPoll(poll.Poll{PollID: 7, PollTitle: "Favorite Gym"})
+	assert.Nil(t, err)
+
+	//This is what the votes api writes when a vote is cast
+	ctx := context.Background()
+	votes := redis.NewClient(&redis.Options{Addr: db.RedisDefaultLocation})
+	defer votes.Close()
+	assert.Nil(t, votes.HSet(ctx, db.RedisVotesKeyPrefix+"7", "30", "1").Err())
+
+	assert.ErrorIs(t, store.DeletePollIfUnused(7), db.ErrPollInUse)
+	_, err = store.GetPoll(7)
+	assert.Nil(t, err)
+
+	assert.Nil(t, votes.Del(ctx, db.RedisVotesKeyPrefix+"7").Err())
+	assert.Nil(t, store.DeletePollIfUnused(7))
+	assert.ErrorIs(t, store.DeletePollIfUnused(7), db.ErrPollNotFound)
+}
+
+func Test_PollValidation(t *testing.T) {
+	tests := map[string]string{
+		"no title":        `{ "poll_title": " " }`,
+		"empty option":    `{ "poll_title": "Lunch", "poll_options": [ { "poll_option_id": 1, "poll_option_value": "" } ] }`,
+		"duplicate ids":   `{ "poll_title": "Lunch", "poll_options": [ { "poll_option_id": 1, "poll_option_value": "Soup" }, { "poll_option_id": 1, "poll_option_value": "Salad" } ] }`,
+		"not a poll json": `[ 1, 2, 3 ]`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			response, _ := client.R().
+				SetHeader("Content-Type", "application/json").
+				SetBody(body).
+				Post(BASE_API + "/polls/5")
+			assert.Equal(t, 400, response.StatusCode())
+		})
+	}
+
+	getResponse, _ := client.R().Get(BASE_API + "/polls/5")
+	assert.Equal(t, 404, getResponse.StatusCode())
+}
+
+func Test_PollOptions(t *testing.T) {
+	response, _ := client.R().Get(BASE_API + "/polls/2/options")
+	options := []poll.PollOption{}
+	assert.Nil(t, json.Unmarshal(response.Body(), &options))
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, 4, len(options))
+
+	addResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_option_id": 5, "poll_option_value": "Monsoon" }`).
+		Post(BASE_API + "/polls/2/options")
+	assert.Equal(t, 200, addResponse.StatusCode())
+
+	duplicateResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_option_id": 5, "poll_option_value": "Dry season" }`).
+		Post(BASE_API + "/polls/2/options")
+	assert.Equal(t, 400, duplicateResponse.StatusCode())
+
+	emptyResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_option_value": "" }`).
+		Put(BASE_API + "/polls/2/options/5")
+	assert.Equal(t, 400, emptyResponse.StatusCode())
+
+	updateResponse, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{ "poll_option_value": "Rainy season" }`).
+		Put(BASE_API + "/polls/2/options/5")
+	assert.Equal(t, 200, updateResponse.StatusCode())
+
+	getResponse, _ := client.R().Get(BASE_API + "/polls/2/options/5")
+	option := poll.PollOption{}
+	assert.Nil(t, json.Unmarshal(getResponse.Body(), &option))
+	assert.Equal(t, 200, getResponse.StatusCode())
+	assert.Equal(t, "Rainy season", option.PollOptionValue)
+
+	deleteResponse, _ := client.R().Delete(BASE_API + "/polls/2/options/5")
+	assert.Equal(t, 200, deleteResponse.StatusCode())
+
+	missingResponse, _ := client.R().Get(BASE_API + "/polls/2/options/5")
+	assert.Equal(t, 404, missingResponse.StatusCode())
+
+	unknownPollResponse, _ := client.R().Get(BASE_API + "/polls/42/options")
+	assert.Equal(t, 404, unknownPollResponse.StatusCode())
+}
+
+func Test_PollResults(t *testing.T) {
+	response, _ := client.R().Get(BASE_API + "/polls/1/results")
+	results := poll.PollResults{}
+
+	err := json.Unmarshal(response.Body(), &results)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, uint(1), results.PollID)
+	assert.Equal(t, 5, len(results.Options))
+	var votes int64
+	for _, option := range results.Options {
+		votes += option.Votes
+	}
+	assert.Equal(t, results.Turnout, votes)
+
+	notFoundResponse, _ := client.R().Get(BASE_API + "/polls/42/results")
+	assert.Equal(t, 404, notFoundResponse.StatusCode())
+}
+
+func Test_HealthCheck(t *testing.T) {
+	response, _ := client.R().Get(BASE_API + "/polls/health")
+	health := map[string]any{}
+
+	err := json.Unmarshal(response.Body(), &health)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "ok", health["status"])
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -13,30 +14,63 @@ import (
 type VoterAPI struct {
 	db        *db.ToDo
 	voterList db.VoterList
+	polls     *pollChecker
 }
 
 func New() (*VoterAPI, error) {
+	return NewWithPollAPI(DefaultPollAPIURL)
+}
+
+// NewWithPollAPI returns a VoterAPI that checks the polls of the voters
+// with the poll-api at pollAPIURL
+func NewWithPollAPI(pollAPIURL string) (*VoterAPI, error) {
 	dbHandler, err := db.New()
 	if err != nil {
 		return nil, err
 	}
 
-	return &VoterAPI{db: dbHandler}, nil
+	return &VoterAPI{db: dbHandler, polls: newPollChecker(pollAPIURL)}, nil
 }
 
+// GetAllVoters returns all voters, a page at a time if the caller asks
+// for it.  The limit query parameter sets the page size and the cursor
+// parameter picks up where the previous page stopped, for example
+// /voters?limit=10&cursor=<cursor>.  With count=true the X-Total-Count
+// header holds the number of voters in the whole list, and while there
+// are more voters the Link header points at the next page.
 func (td *VoterAPI) GetAllVoters(c *gin.Context) {
-	voters, err := td.db.GetAllVoters()
+	cursor, limit, count, err := pageParams(c)
+	if err != nil {
+		log.Println("Error reading paging parameters: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := td.db.GetVotersPage(cursor, limit)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			log.Println("Error reading cursor: ", err)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		log.Println("Error Getting All Items: ", err)
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	if voters == nil {
-		voters = make([]db.Voter, 0)
+	//Counting walks every key, so only do it when the caller asks
+	if count {
+		total, err := td.db.CountVoters()
+		if err != nil {
+			log.Println("Error counting voters: ", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		setTotalCountHeader(c, total)
 	}
 
-	c.JSON(http.StatusOK, voters)
+	setPageHeaders(c, page.NextCursor)
+	c.JSON(http.StatusOK, page.Voters)
 }
 
 func (td *VoterAPI) GetVoter(c *gin.Context) {
@@ -57,6 +91,14 @@ func (td *VoterAPI) GetVoter(c *gin.Context) {
 		return
 	}
 
+	//A client that already has this version of the voter does not need
+	//it sent again
+	c.Header("ETag", etag(voter))
+	if noneMatch(c, voter) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
 	c.JSON(http.StatusOK, voter)
 }
 
@@ -70,13 +112,88 @@ func (td *VoterAPI) AddVoter(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.AddVoter(newVoter); err != nil {
+	//The store never overwrites a voter on add, so If-None-Match: * (only
+	//create it if it is not there) needs no extra check, but a client
+	//that sends it expects a 412 rather than a 409
+	added, err := td.db.AddVoter(newVoter)
+	if err != nil {
 		log.Println("Error adding item: ", err)
+		if c.GetHeader("If-None-Match") == "*" {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "the voter already exists"})
+			return
+		}
 		c.AbortWithStatus(http.StatusConflict)
 		return
 	}
+	td.audit(c, db.AuditAdd, added.VoterId, nil, added)
+
+	c.Header("ETag", etag(added))
+	c.JSON(http.StatusOK, added)
+}
+
+// abortWithChangeError answers a request whose change to a voter failed
+func abortWithChangeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, db.ErrVersionMismatch):
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrVoterNotFound), errors.Is(err, db.ErrVoterPollNotFound):
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// UpdateVoter changes the profile of a voter, its poll history stays as
+// it is
+func (td *VoterAPI) UpdateVoter(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var update voterUpdate
+	if err := bindUpdate(c, &update); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := update.validate(uint(id64)); err != nil {
+		log.Println("Invalid voter: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, newVoter)
+	voter, err := td.db.GetVoter(int(id64))
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if td.noneMatchFailed(c, int(id64)) {
+		return
+	}
+
+	changed, err := td.db.UpdateVoter(int(id64), update.Name, version)
+	if err != nil {
+		log.Println("Error updating voter: ", err)
+		abortWithChangeError(c, err)
+		return
+	}
+	td.audit(c, db.AuditUpdate, changed.VoterId, voter, changed)
+
+	c.Header("ETag", etag(changed))
+	c.JSON(http.StatusOK, changed)
 }
 
 func (td *VoterAPI) DeleteVoter(c *gin.Context) {
@@ -89,22 +206,52 @@ func (td *VoterAPI) DeleteVoter(c *gin.Context) {
 		return
 	}
 
-	if err := td.db.DeleteVoter(int(id64)); err != nil {
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if td.noneMatchFailed(c, int(id64)) {
+		return
+	}
+
+	//A voter that is not there fails in DeleteVoter below
+	before, _ := td.db.GetVoter(int(id64))
+
+	if err := td.db.DeleteVoter(int(id64), version); err != nil {
 		log.Println("Error deleting voter: ", err)
+		if errors.Is(err, db.ErrVersionMismatch) {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
+	td.audit(c, db.AuditDelete, uint(id64), before, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Voter successfully deleted"})
 }
 
 func (td *VoterAPI) DeleteAllVoters(c *gin.Context) {
+	//Every voter that is deleted gets its own audit entry
+	voters, err := td.db.GetAllVoters()
+	if err != nil {
+		log.Println("Error deleting all voters: ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
 	if err := td.db.DeleteAll(); err != nil {
 		log.Println("Error deleting all voters: ", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	for _, voter := range voters {
+		td.audit(c, db.AuditDelete, voter.VoterId, voter, nil)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "All voters successfully deleted"})
 }
 
@@ -209,11 +356,106 @@ func (td *VoterAPI) AddVoterPoll(c *gin.Context) {
 		return
 	}
 
-	td.db.AddVoterPollHistory(int(voterId64), int(pollId64), currentTime)
+	//Adding a poll changes the voter, so If-Match holds the ETag of the
+	//voter
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if td.noneMatchFailed(c, int(voterId64)) {
+		return
+	}
+	if td.unknownPoll(c, int(pollId64)) {
+		return
+	}
+
+	changed, err := td.db.AddVoterPollHistory(int(voterId64), int(pollId64), currentTime, version)
+	if err != nil {
+		log.Println("Error adding voter poll: ", err)
+		if errors.Is(err, db.ErrVersionMismatch) {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	td.audit(c, db.AuditAddPoll, changed.VoterId, voter, changed)
 
+	c.Header("ETag", etag(changed))
 	c.JSON(http.StatusOK, newVoterPoll)
 }
 
+// UpdateVoterPoll changes the vote date of a poll in the history of a
+// voter, the rest of the voter stays as it is
+func (td *VoterAPI) UpdateVoterPoll(c *gin.Context) {
+	voterIdS := c.Param("id")
+	voterId64, err := strconv.ParseInt(voterIdS, 10, 32)
+
+	if err != nil {
+		log.Println("Error converting voterId to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	pollId := c.Param("pollid")
+	pollId64, pollErr := strconv.ParseInt(pollId, 10, 32)
+
+	if pollErr != nil {
+		log.Println("Error converting pollId to int64: ", pollErr)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var update voterPollUpdate
+	if err := bindUpdate(c, &update); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := update.validate(uint(pollId64)); err != nil {
+		log.Println("Invalid voter poll: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	voter, err := td.db.GetVoter(int(voterId64))
+	if err != nil {
+		log.Println("Voter not found: ", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	//The poll is already in the history, so the poll api was asked when
+	//it was added and there is no need to ask again
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if td.noneMatchFailed(c, int(voterId64)) {
+		return
+	}
+
+	changed, err := td.db.UpdateVoterPoll(int(voterId64), int(pollId64), update.VoteDate, version)
+	if err != nil {
+		log.Println("Error updating voter poll: ", err)
+		abortWithChangeError(c, err)
+		return
+	}
+	td.audit(c, db.AuditUpdatePoll, changed.VoterId, voter, changed)
+
+	c.Header("ETag", etag(changed))
+	for _, poll := range changed.VoteHistory {
+		if int64(poll.PollId) == pollId64 {
+			c.JSON(http.StatusOK, poll)
+			return
+		}
+	}
+}
+
 func (td *VoterAPI) DeleteVoterPoll(c *gin.Context) {
 	voterId := c.Param("id")
 	voterId64, err := strconv.ParseInt(voterId, 10, 32)
@@ -240,38 +482,45 @@ func (td *VoterAPI) DeleteVoterPoll(c *gin.Context) {
 		return
 	}
 
-	for i, poll := range voter.VoteHistory {
+	found := false
+	for _, poll := range voter.VoteHistory {
 		if int64(poll.PollId) == pollId64 {
-			voter.VoteHistory = append(voter.VoteHistory[:i], voter.VoteHistory[i+1:]...)
-			td.db.DeleteVoterPoll(int(voterId64), int(pollId64))
-			c.JSON(http.StatusOK, gin.H{"message": "Voter poll successfully deleted"})
-			return
+			found = true
+			break
 		}
 	}
+	if !found {
+		log.Println("Voter poll not found")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
 
-	log.Println("Voter poll not found")
-	c.AbortWithStatus(http.StatusNotFound)
-}
-
-// TODO: Remove unused boilerplate code
-
-// implementation for PUT /todo
-// func (td *ToDoAPI) UpdateToDo(c *gin.Context) {
-// 	var todoItem db.ToDoItem
-// 	if err := c.ShouldBindJSON(&todoItem); err != nil {
-// 		log.Println("Error binding JSON: ", err)
-// 		c.AbortWithStatus(http.StatusBadRequest)
-// 		return
-// 	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		log.Println("Error reading If-Match: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if noneMatch(c, voter) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "the voter matches If-None-Match"})
+		return
+	}
 
-// 	if err := td.db.UpdateItem(todoItem); err != nil {
-// 		log.Println("Error updating item: ", err)
-// 		c.AbortWithStatus(http.StatusInternalServerError)
-// 		return
-// 	}
+	changed, err := td.db.DeleteVoterPoll(int(voterId64), int(pollId64), version)
+	if err != nil {
+		log.Println("Error deleting voter poll: ", err)
+		if errors.Is(err, db.ErrVersionMismatch) {
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	td.audit(c, db.AuditDeletePoll, changed.VoterId, voter, changed)
 
-// 	c.JSON(http.StatusOK, todoItem)
-// }
+	c.Header("ETag", etag(changed))
+	c.JSON(http.StatusOK, gin.H{"message": "Voter poll successfully deleted"})
+}
 
 func (td *VoterAPI) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK,
@@ -292,7 +541,7 @@ func (td *VoterAPI) AddSampleVoters(c *gin.Context) {
 	newVoterOne.Name = "Moo Moo"
 	newVoterOne.VoteHistory = []db.VoterHistory{
 		{
-			PollId:   0,
+			PollId:   1,
 			VoteDate: time.Now(),
 		},
 	}
@@ -301,11 +550,15 @@ func (td *VoterAPI) AddSampleVoters(c *gin.Context) {
 	newVoterTwo.Name = "Totoro"
 	newVoterTwo.VoteHistory = []db.VoterHistory{
 		{
-			PollId:   0,
+			PollId:   1,
 			VoteDate: time.Now(),
 		},
 	}
 
-	td.db.AddVoter(newVoterOne)
-	td.db.AddVoter(newVoterTwo)
+	//A sample that is already there is left alone
+	for _, sample := range []db.Voter{newVoterOne, newVoterTwo} {
+		if added, err := td.db.AddVoter(sample); err == nil {
+			td.audit(c, db.AuditAdd, added.VoterId, nil, added)
+		}
+	}
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"voter-api/db"
+)
+
+// Every voter has a version that goes up each time it changes, the ETag of
+// a voter is simply that version in quotes, for example "3".  A client that
+// sends the ETag back in an If-Match header is saying "only make this
+// change if nobody changed the voter since I read it".  If somebody did,
+// the api answers 412 Precondition Failed and the client should read the
+// voter again.
+
+// etag returns the ETag header for a voter
+func etag(voter db.Voter) string {
+	return `"` + strconv.Itoa(voter.Version) + `"`
+}
+
+// parseETags splits an If-Match or If-None-Match header into its
+// entity tags, star is true if the header is *
+func parseETags(header string) (tags []string, star bool) {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		switch tag {
+		case "":
+		case "*":
+			star = true
+		default:
+			tags = append(tags, tag)
+		}
+	}
+	return tags, star
+}
+
+// ifMatchVersion returns the version the If-Match header asks for.  It
+// returns 0, which the stores read as any version, when there is no
+// If-Match header or it is *, a voter has to exist to be changed anyway.
+// If-Match compares strictly, so a weak ETag (W/"3") or a tag that is not
+// one of ours never matches, we return -1 for those, a version no voter
+// ever has.
+func ifMatchVersion(c *gin.Context) (int, error) {
+	tags, star := parseETags(c.GetHeader("If-Match"))
+	if star || len(tags) == 0 {
+		return 0, nil
+	}
+	if len(tags) > 1 {
+		return 0, errors.New("If-Match can only hold one ETag")
+	}
+
+	tag := tags[0]
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return -1, nil
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return -1, nil
+	}
+	return version, nil
+}
+
+// noneMatch returns true if the If-None-Match header matches voter, that
+// is if it is * or holds the ETag of voter.  If-None-Match compares
+// loosely, so W/"3" matches version 3 too.
+func noneMatch(c *gin.Context, voter db.Voter) bool {
+	tags, star := parseETags(c.GetHeader("If-None-Match"))
+	if star {
+		return true
+	}
+	for _, tag := range tags {
+		if strings.TrimPrefix(tag, "W/") == etag(voter) {
+			return true
+		}
+	}
+	return false
+}
+
+// noneMatchFailed checks the If-None-Match header of a request that
+// changes the voter with id.  If the header matches the voter as it is now
+// the request must not go ahead, we answer 412 and return true.  Unlike
+// If-Match this is not checked in the same step as the change, the
+// header is mostly used to make sure a client does not overwrite a voter
+// at all.
+func (td *VoterAPI) noneMatchFailed(c *gin.Context, id int) bool {
+	if c.GetHeader("If-None-Match") == "" {
+		return false
+	}
+	current, err := td.db.GetVoter(id)
+	if err != nil {
+		//The store reports the missing voter when we try to change it
+		return false
+	}
+	if noneMatch(c, current) {
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "the voter matches If-None-Match"})
+		return true
+	}
+	return false
+}
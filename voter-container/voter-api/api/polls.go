@@ -0,0 +1,104 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+// The polls belong to the poll-api, a voter can only have a poll in its
+// history that the poll-api knows about.  We ask the poll-api over http,
+// like the readinglist-api asks the publications-api, and remember the
+// answer for a while so adding polls to many voters does not cost a
+// request every time.
+
+const (
+	//DefaultPollAPIURL is where the poll-api listens when it runs next
+	//to the voter api
+	DefaultPollAPIURL = "http://localhost:1081"
+
+	//pollFoundTTL is how long we remember a poll that exists.  A poll
+	//that is deleted can still be added to a voter for this long.
+	pollFoundTTL = 30 * time.Second
+
+	//pollMissingTTL is how long we remember a poll that does not exist,
+	//short, so a poll that was just added can be used right away
+	pollMissingTTL = 5 * time.Second
+)
+
+type pollLookup struct {
+	found   bool
+	expires time.Time
+}
+
+// pollChecker answers whether a poll exists, from the cache or the
+// poll-api
+type pollChecker struct {
+	pollAPIURL string
+	apiClient  *resty.Client
+
+	lock    sync.Mutex
+	lookups map[int]pollLookup
+}
+
+func newPollChecker(pollAPIURL string) *pollChecker {
+	return &pollChecker{
+		pollAPIURL: pollAPIURL,
+		apiClient:  resty.New().SetTimeout(5 * time.Second),
+		lookups:    make(map[int]pollLookup),
+	}
+}
+
+// pollExists returns true if the poll-api has a poll with pollId.  An
+// error means the poll-api could not tell us, and nothing is cached.
+func (pc *pollChecker) pollExists(pollId int) (bool, error) {
+	pc.lock.Lock()
+	lookup, ok := pc.lookups[pollId]
+	pc.lock.Unlock()
+	if ok && time.Now().Before(lookup.expires) {
+		return lookup.found, nil
+	}
+
+	pollURL := fmt.Sprintf("%s/polls/%d", pc.pollAPIURL, pollId)
+	response, err := pc.apiClient.R().Get(pollURL)
+	if err != nil {
+		return false, fmt.Errorf("could not get poll from API: (%s) %w", pollURL, err)
+	}
+
+	switch response.StatusCode() {
+	case http.StatusOK:
+		lookup = pollLookup{found: true, expires: time.Now().Add(pollFoundTTL)}
+	case http.StatusNotFound:
+		lookup = pollLookup{found: false, expires: time.Now().Add(pollMissingTTL)}
+	default:
+		return false, fmt.Errorf("could not get poll from API: (%s) %s", pollURL, response.Status())
+	}
+
+	pc.lock.Lock()
+	pc.lookups[pollId] = lookup
+	pc.lock.Unlock()
+	return lookup.found, nil
+}
+
+// unknownPoll checks that the poll with pollId exists before it goes into
+// the history of a voter.  If it does not, or the poll-api cannot be
+// reached, the request is answered and unknownPoll returns true.
+func (td *VoterAPI) unknownPoll(c *gin.Context, pollId int) bool {
+	found, err := td.polls.pollExists(pollId)
+	if err != nil {
+		log.Println("Error checking poll: ", err)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "the poll api is not available"})
+		return true
+	}
+	if !found {
+		log.Println("Poll not found: ", pollId)
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("poll %d does not exist", pollId)})
+		return true
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//------------------------------------------------------------
+// AUDIT LOG
+//------------------------------------------------------------
+
+// The audit log records every change made through the api, who made it,
+// from where, and what the voter looked like before and after.  It is
+// kept in redis next to the voters, in the lists audit:voter, with every
+// entry, and audit:voter:<id>, with the entries of one voter.  Entries
+// are only ever pushed, there is no way to change or remove one, and the
+// keys are outside of the voter: prefix, so DeleteAll leaves them alone.
+
+// The actions of an AuditEntry
+const (
+	AuditAdd        = "add"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditAddPoll    = "add_poll"
+	AuditUpdatePoll = "update_poll"
+	AuditDeletePoll = "delete_poll"
+)
+
+const (
+	RedisAuditKeyPrefix = "audit:"
+
+	//RedisAuditSeqKey holds the Seq of the last entry
+	RedisAuditSeqKey = "audit-seq"
+
+	//AuditAnyId asks AuditEntries for the entries of every id
+	AuditAnyId = -1
+)
+
+// AuditEntry is a single change.  Old is null for an add and New is null
+// for a delete.  Seq goes up by one for every entry.
+type AuditEntry struct {
+	Seq       int64           `json:"seq"`
+	Time      time.Time       `json:"time"`
+	Resource  string          `json:"resource"`
+	Id        int             `json:"id"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor"`
+	ClientIP  string          `json:"client_ip"`
+	RequestId string          `json:"request_id"`
+	Old       json.RawMessage `json:"old"`
+	New       json.RawMessage `json:"new"`
+}
+
+func redisAuditKey(resource string, id int) string {
+	if id == AuditAnyId {
+		return RedisAuditKeyPrefix + resource
+	}
+	return fmt.Sprintf("%s%s:%d", RedisAuditKeyPrefix, resource, id)
+}
+
+// RecordAudit gives entry the next Seq, and the time if it has none,
+// adds it to the audit log and returns it
+func (t *ToDo) RecordAudit(entry AuditEntry) (AuditEntry, error) {
+	seq, err := t.cacheClient.Incr(t.context, RedisAuditSeqKey).Result()
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	entry.Seq = seq
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+
+	//Both lists get the entry, or neither does
+	pipe := t.cacheClient.TxPipeline()
+	pipe.RPush(t.context, redisAuditKey(entry.Resource, AuditAnyId), data)
+	pipe.RPush(t.context, redisAuditKey(entry.Resource, entry.Id), data)
+	if _, err := pipe.Exec(t.context); err != nil {
+		return AuditEntry{}, err
+	}
+	return entry, nil
+}
+
+// AuditEntries returns the audit entries of resource, oldest first, only
+// those of id unless id is AuditAnyId
+func (t *ToDo) AuditEntries(resource string, id int) ([]AuditEntry, error) {
+	values, err := t.cacheClient.LRange(t.context, redisAuditKey(resource, id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0, len(values))
+	for _, value := range values {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	//Two entries recorded at the same time can be pushed in the other
+	//order than they got their Seq
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Seq < entries[j].Seq })
+	return entries, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//------------------------------------------------------------
+// PAGING
+//------------------------------------------------------------
+
+// ErrInvalidCursor is returned when a cursor was not handed out by
+// GetVotersPage, the api turns it into a 400 Bad Request
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// VoterPage is one page of the voter list.  NextCursor is passed back
+// to GetVotersPage to get the page after this one, it is empty on the
+// last page.
+type VoterPage struct {
+	Voters     []Voter
+	NextCursor string
+}
+
+// redisScanCount is the COUNT hint we give to SCAN, roughly how many
+// keys redis looks at in one call.  It must not change between two
+// pages, because a page cursor points into a batch of this size.
+const redisScanCount = 100
+
+// The KEYS command walks the whole keyspace in one go, and redis does
+// nothing else until it is done, so a big cache stalls every client.
+// SCAN walks the keyspace a small batch at a time instead.  It hands
+// back a cursor with every batch, and we keep calling it with that
+// cursor until it returns 0.
+
+// scanKeys returns every key that matches pattern, using SCAN
+func (t *ToDo) scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	iter := t.cacheClient.Scan(t.context, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(t.context) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// countKeys returns the number of keys that match pattern.  It walks
+// the whole keyspace, so only call it when the caller asked for a count.
+func (t *ToDo) countKeys(pattern string) (int, error) {
+	count := 0
+	iter := t.cacheClient.Scan(t.context, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(t.context) {
+		count++
+	}
+	return count, iter.Err()
+}
+
+// scanPage returns up to limit keys that match pattern, starting at
+// cursor, and the cursor for the keys after them.  A limit of 0 means
+// no limit.
+//
+// A SCAN batch can hold more keys than we need for the page, and redis
+// cannot start halfway through a batch.  So our cursor holds the SCAN
+// cursor of the batch AND the last key we used from it.  The next page
+// asks redis for the same batch again, and skips the keys up to and
+// including that key.  The keys of a batch are sorted first, so this
+// also works if keys were added or removed in between.  Like SCAN
+// itself, a voter that is added or removed while a caller is paging
+// may or may not show up.
+func (t *ToDo) scanPage(pattern string, cursor string, limit int) ([]string, string, error) {
+	scanCursor, after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys := make([]string, 0)
+	for {
+		batch, nextScanCursor, err := t.cacheClient.Scan(t.context, scanCursor, pattern, redisScanCount).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Strings(batch)
+		for _, key := range batch {
+			if after != "" && key <= after {
+				continue
+			}
+			if limit > 0 && len(keys) == limit {
+				//The page is full, but this batch has more keys
+				return keys, encodeCursor(scanCursor, keys[len(keys)-1]), nil
+			}
+			keys = append(keys, key)
+		}
+
+		//Redis is done with the keyspace when the cursor comes back as 0
+		if nextScanCursor == 0 {
+			return keys, "", nil
+		}
+		scanCursor, after = nextScanCursor, ""
+		if limit > 0 && len(keys) == limit {
+			return keys, encodeCursor(scanCursor, ""), nil
+		}
+	}
+}
+
+// Our cursors are "<scan cursor>:<last key>", base64 encoded so they
+// are safe to put in a url and callers do not try to build their own
+func encodeCursor(scanCursor uint64, after string) string {
+	raw := strconv.FormatUint(scanCursor, 10) + ":" + after
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (uint64, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	scanCursorS, after, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", ErrInvalidCursor
+	}
+	scanCursor, err := strconv.ParseUint(scanCursorS, 10, 64)
+	if err != nil {
+		return 0, "", ErrInvalidCursor
+	}
+	return scanCursor, after, nil
+}
@@ -22,6 +22,10 @@ type Voter struct {
 	VoterId     uint           `json:"voter_id"`
 	Name        string         `json:"name"`
 	VoteHistory []VoterHistory `json:"voter_history"`
+
+	//Version starts at 1 and goes up every time the voter changes, the
+	//api hands it out as the ETag of the voter
+	Version int `json:"version,omitempty"`
 }
 
 type VoterList struct {
@@ -119,27 +123,38 @@ func (t *ToDo) getItemFromRedis(key string, item *Voter) error {
 	return nil
 }
 
-func (t *ToDo) AddVoter(voter Voter) error {
+// AddVoter adds a new voter at version 1 and returns it.  NX makes the
+// existence check and the write a single step, so two clients adding
+// the same voter cannot both succeed.
+func (t *ToDo) AddVoter(voter Voter) (Voter, error) {
 	redisKey := redisKeyFromId(int(voter.VoterId))
-	var existingVoter Voter
-	if err := t.getItemFromRedis(redisKey, &existingVoter); err == nil {
-		return errors.New("Voter already exists")
-	}
+	voter.Version = 1
 
-	if _, err := t.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
-		return err
+	data, err := json.Marshal(voter)
+	if err != nil {
+		return Voter{}, err
+	}
+	res, err := t.cacheClient.Do(t.context, "JSON.SET", redisKey, ".", string(data), "NX").Result()
+	if err != nil && !isRedisNilError(err) {
+		return Voter{}, err
+	}
+	if res == nil {
+		return Voter{}, errors.New("Voter already exists")
 	}
 
-	return nil
+	return voter, nil
 }
 
 func (t *ToDo) GetAllVoters() ([]Voter, error) {
 	var voters []Voter
-	var voter Voter
 
 	pattern := RedisKeyPrefix + "*"
-	ks, _ := t.cacheClient.Keys(t.context, pattern).Result()
+	ks, err := t.scanKeys(pattern)
+	if err != nil {
+		return nil, err
+	}
 	for _, key := range ks {
+		var voter Voter
 		err := t.getItemFromRedis(key, &voter)
 		if err != nil {
 			return nil, err
@@ -150,14 +165,57 @@ func (t *ToDo) GetAllVoters() ([]Voter, error) {
 	return voters, nil
 }
 
-func (t *ToDo) DeleteVoter(id int) error {
+// GetVotersPage returns up to limit voters, starting at cursor.  An
+// empty cursor starts at the first voter, after that the cursor is the
+// NextCursor of the page before.  A limit of 0 means no limit.
+func (t *ToDo) GetVotersPage(cursor string, limit int) (VoterPage, error) {
+	pattern := RedisKeyPrefix + "*"
+	ks, nextCursor, err := t.scanPage(pattern, cursor, limit)
+	if err != nil {
+		return VoterPage{}, err
+	}
+
+	page := VoterPage{
+		Voters:     make([]Voter, 0, len(ks)),
+		NextCursor: nextCursor,
+	}
+	for _, key := range ks {
+		var voter Voter
+		if err := t.getItemFromRedis(key, &voter); err != nil {
+			//The voter was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
+			return VoterPage{}, err
+		}
+		page.Voters = append(page.Voters, voter)
+	}
+
+	return page, nil
+}
+
+// CountVoters returns the number of voters.  Counting means scanning
+// every key, so the api only counts when the caller asks for it.
+func (t *ToDo) CountVoters() (int, error) {
+	return t.countKeys(RedisKeyPrefix + "*")
+}
+
+// DeleteVoter deletes the voter with id.  If version is not 0 the voter
+// is only deleted if it is still at that version, otherwise
+// ErrVersionMismatch is returned.
+func (t *ToDo) DeleteVoter(id int, version int) error {
+	if version != 0 {
+		_, err := t.changeVoter(id, version, nil)
+		return err
+	}
+
 	pattern := redisKeyFromId(id)
 	numDeleted, err := t.cacheClient.Del(t.context, pattern).Result()
 	if err != nil {
 		return err
 	}
 	if numDeleted == 0 {
-		return errors.New("attempted to delete non-existent item")
+		return ErrVoterNotFound
 	}
 
 	return nil
@@ -165,7 +223,13 @@ func (t *ToDo) DeleteVoter(id int) error {
 
 func (t *ToDo) DeleteAll() error {
 	pattern := RedisKeyPrefix + "*"
-	keyStrings, _ := t.cacheClient.Keys(t.context, pattern).Result()
+	keyStrings, err := t.scanKeys(pattern)
+	if err != nil {
+		return err
+	}
+	if len(keyStrings) == 0 {
+		return nil
+	}
 	numDeleted, err := t.cacheClient.Del(t.context, keyStrings...).Result()
 	if err != nil {
 		return err
@@ -204,68 +268,60 @@ func (t *ToDo) GetVoterPoll(voterId int, pollId int) (VoterHistory, error) {
 	return VoterHistory{}, errors.New("poll not found")
 }
 
-func (t *ToDo) AddVoterPollHistory(voterId int, pollId int, voteDate time.Time) error {
-	redisKey := redisKeyFromId(voterId)
-	var voter Voter
-	if err := t.getItemFromRedis(redisKey, &voter); err != nil {
-		return err
-	}
-
-	voter.VoteHistory = append(voter.VoteHistory, VoterHistory{PollId: uint(pollId), VoteDate: voteDate})
-	if _, err := t.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
-		return err
-	}
-
-	return nil
+// AddVoterPollHistory adds a poll to the history of a voter and returns
+// the changed voter.  If version is not 0 the voter has to be at that
+// version, otherwise ErrVersionMismatch is returned.
+func (t *ToDo) AddVoterPollHistory(voterId int, pollId int, voteDate time.Time, version int) (Voter, error) {
+	return t.changeVoter(voterId, version, func(voter *Voter) error {
+		for _, poll := range voter.VoteHistory {
+			if int(poll.PollId) == pollId {
+				return errors.New("poll already exists")
+			}
+		}
+		voter.VoteHistory = append(voter.VoteHistory, VoterHistory{PollId: uint(pollId), VoteDate: voteDate})
+		return nil
+	})
 }
 
-func (t *ToDo) DeleteVoterPoll(voterId int, pollId int) error {
-	redisKey := redisKeyFromId(voterId)
-	var voter Voter
-	if err := t.getItemFromRedis(redisKey, &voter); err != nil {
-		return err
-	}
-
-	for index, poll := range voter.VoteHistory {
-		if int(poll.PollId) == pollId {
-			voter.VoteHistory = append(voter.VoteHistory[:index], voter.VoteHistory[index+1:]...)
-			if _, err := t.jsonHelper.JSONSet(redisKey, ".", voter); err != nil {
-				return err
+// DeleteVoterPoll removes a poll from the history of a voter and
+// returns the changed voter.  If version is not 0 the voter has to be at
+// that version, otherwise ErrVersionMismatch is returned.
+func (t *ToDo) DeleteVoterPoll(voterId int, pollId int, version int) (Voter, error) {
+	return t.changeVoter(voterId, version, func(voter *Voter) error {
+		for index, poll := range voter.VoteHistory {
+			if int(poll.PollId) == pollId {
+				voter.VoteHistory = append(voter.VoteHistory[:index], voter.VoteHistory[index+1:]...)
+				return nil
 			}
-			return nil
 		}
-	}
-
-	return errors.New("poll not found")
+		return ErrVoterPollNotFound
+	})
 }
 
-// UpdateItem accepts a ToDoItem and updates it in the DB.
-// Preconditions:   (1) The database file must exist and be a valid
-//
-//					(2) The item must exist in the DB
-//	    				because we use the item.Id as the key, this
-//						function must check if the item already
-//	    				exists in the DB, if not, return an error
-//
-// Postconditions:
-//
-//	 (1) The item will be updated in the DB
-//		(2) The DB file will be saved with the item updated
-//		(3) If there is an error, it will be returned
-func (t *ToDo) UpdateItem(item Voter) error {
-
-	// Check if item exists before trying to update it
-	// this is a good practice, return an error if the
-	// item does not exist
-	_, ok := t.toDoMap[int(item.VoterId)]
-	if !ok {
-		return errors.New("item does not exist")
-	}
-
-	//Now that we know the item exists, lets update it
-	t.toDoMap[int(item.VoterId)] = item
+// UpdateVoter changes the name of a voter and returns the changed voter,
+// the poll history is left as it is.  If version is not 0 the voter has
+// to be at that version, otherwise ErrVersionMismatch is returned.
+func (t *ToDo) UpdateVoter(id int, name string, version int) (Voter, error) {
+	return t.changeVoter(id, version, func(voter *Voter) error {
+		voter.Name = name
+		return nil
+	})
+}
 
-	return nil
+// UpdateVoterPoll changes the vote date of a poll in the history of a
+// voter and returns the changed voter, the rest of the voter is left as
+// it is.  If version is not 0 the voter has to be at that version,
+// otherwise ErrVersionMismatch is returned.
+func (t *ToDo) UpdateVoterPoll(voterId int, pollId int, voteDate time.Time, version int) (Voter, error) {
+	return t.changeVoter(voterId, version, func(voter *Voter) error {
+		for index, poll := range voter.VoteHistory {
+			if int(poll.PollId) == pollId {
+				voter.VoteHistory[index].VoteDate = voteDate
+				return nil
+			}
+		}
+		return ErrVoterPollNotFound
+	})
 }
 
 func (t *ToDo) GetVoter(id int) (Voter, error) {
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//------------------------------------------------------------
+// VERSIONS
+//------------------------------------------------------------
+
+// ErrVersionMismatch is returned when a change asks for a version of a
+// voter that is not the one stored, somebody else changed the voter
+// since the caller read it.  The api turns it into a 412 Precondition
+// Failed.
+var ErrVersionMismatch = errors.New("voter has changed, version does not match")
+
+// ErrVoterNotFound is returned when a change is made to a voter that
+// does not exist
+var ErrVoterNotFound = errors.New("voter does not exist")
+
+// ErrVoterPollNotFound is returned when a change is made to a poll that
+// is not in the history of the voter
+var ErrVoterPollNotFound = errors.New("poll is not in the voter history")
+
+// maxChangeRetries is how many times changeVoter tries again when
+// another client writes the voter between our read and our write
+const maxChangeRetries = 10
+
+// checkVersion returns ErrVersionMismatch if the caller asked for a
+// version and it is not the stored one, a version of 0 means any version
+func checkVersion(stored Voter, version int) error {
+	if version != 0 && stored.Version != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// A plain read, change, write lets two clients read the same voter and
+// the second write quietly undoes the first.  So the write is made by
+// writeIfVersionScript, which only writes if the voter still has the
+// version we read.  If it does not, somebody wrote it in between, and we
+// read the voter again and retry.  When the caller asked for a version
+// the retry finds the new version and fails with ErrVersionMismatch,
+// otherwise the change is applied to the new voter.
+
+// These are the answers of writeIfVersionScript
+const (
+	casMissing  = 0
+	casDone     = 1
+	casConflict = -1
+)
+
+// writeIfVersionScript replaces the voter at KEYS[1] with the json in
+// ARGV[2] if the voter is at version ARGV[1], an empty ARGV[2] deletes
+// the voter.  Redis runs a script from start to finish without running
+// any other command in between, so nobody can change the voter after we
+// checked it.  A voter that was stored before voters had versions has
+// no version field, that is version 0.
+var writeIfVersionScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+local version = redis.pcall('JSON.GET', KEYS[1], '.version')
+if type(version) ~= 'string' then
+	version = '0'
+end
+if tonumber(version) ~= tonumber(ARGV[1]) then
+	return -1
+end
+if ARGV[2] == '' then
+	redis.call('DEL', KEYS[1])
+else
+	redis.call('JSON.SET', KEYS[1], '.', ARGV[2])
+end
+return 1
+`)
+
+// changeVoter reads the voter with id, checks its version, applies
+// change to it and writes it back with the version bumped, all in a
+// single step.  A nil change deletes the voter instead.  It returns the
+// voter as written.
+func (t *ToDo) changeVoter(id int, version int, change func(voter *Voter) error) (Voter, error) {
+	redisKey := redisKeyFromId(id)
+
+	for i := 0; i < maxChangeRetries; i++ {
+		var voter Voter
+		if err := t.getItemFromRedis(redisKey, &voter); err != nil {
+			if isRedisNilError(err) {
+				return Voter{}, ErrVoterNotFound
+			}
+			return Voter{}, err
+		}
+		if err := checkVersion(voter, version); err != nil {
+			return Voter{}, err
+		}
+		read := voter.Version
+
+		data := ""
+		if change != nil {
+			if err := change(&voter); err != nil {
+				return Voter{}, err
+			}
+			voter.Version++
+			newData, err := json.Marshal(voter)
+			if err != nil {
+				return Voter{}, err
+			}
+			data = string(newData)
+		}
+
+		res, err := writeIfVersionScript.Run(t.context, t.cacheClient, []string{redisKey}, read, data).Int64()
+		if err != nil {
+			return Voter{}, err
+		}
+		switch res {
+		case casDone:
+			return voter, nil
+		case casMissing:
+			return Voter{}, ErrVoterNotFound
+		}
+		//casConflict, read the voter again
+	}
+	return Voter{}, errors.New("voter is changing too often, try again")
+}
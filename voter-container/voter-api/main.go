@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
 
 	"voter-api/api"
@@ -12,43 +13,58 @@ import (
 )
 
 var (
-	hostFlag string
-	portFlag uint
+	hostFlag   string
+	portFlag   uint
+	pollAPIURL string
 )
 
 func processCmdLineFlags() {
 	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
 	flag.UintVar(&portFlag, "p", 1080, "Default Port")
+	flag.StringVar(&pollAPIURL, "pollapi", api.DefaultPollAPIURL, "Default endpoint for poll API")
 
 	flag.Parse()
 }
 
+func envVarOrDefault(envVar string, defaultVal string) string {
+	envVal := os.Getenv(envVar)
+	if envVal != "" {
+		return envVal
+	}
+	return defaultVal
+}
+
 func main() {
 	processCmdLineFlags()
+	pollAPIURL = envVarOrDefault("VOTER_POLL_API_URL", pollAPIURL)
+	log.Println("Init/pollAPIURL: " + pollAPIURL)
+
 	router := gin.Default()
 	router.Use(cors.Default())
 
-	apiHandler, err := api.New()
+	apiHandler, err := api.NewWithPollAPI(pollAPIURL)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	// TODO: Implement PUT routes for extra credit
 	router.GET("/voters", apiHandler.GetAllVoters)
 	router.GET("/voters/:id", apiHandler.GetVoter)
 	router.POST("/voters/:id", apiHandler.AddVoter)
-	// router.PUT("/voters/:id", apiHandler.UpdateVoter)
+	router.PUT("/voters/:id", apiHandler.UpdateVoter)
 	router.DELETE("/voters/:id", apiHandler.DeleteVoter)
 	router.DELETE("/voters", apiHandler.DeleteAllVoters)
 	router.GET("/voters/:id/polls", apiHandler.GetVoterPolls)
 	router.GET("/voters/:id/polls/:pollid", apiHandler.GetVoterPoll)
 	router.POST("/voters/:id/polls/:pollid", apiHandler.AddVoterPoll)
-	// router.PUT("/voters/:id/polls/:pollid", apiHandler.UpdateVoterPoll)
+	router.PUT("/voters/:id/polls/:pollid", apiHandler.UpdateVoterPoll)
 	router.DELETE("/voters/:id/polls/:pollid", apiHandler.DeleteVoterPoll)
 	router.GET("/voters/health", apiHandler.HealthCheck)
 	router.GET("/voters/add-sample-voters", apiHandler.AddSampleVoters)
 
+	//Who changed what, the X-Actor header says who
+	router.GET("/audit", apiHandler.GetAudit)
+
 	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
 	router.Run(serverPath)
 }
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"votes-api/db"
+	"votes-api/election"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the version of the votes api, for the health check
+const Version = "1.0.0"
+
+type VotesAPI struct {
+	db      *db.Votes
+	started time.Time
+}
+
+func New() (*VotesAPI, error) {
+	dbHandler, err := db.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &VotesAPI{db: dbHandler, started: time.Now()}, nil
+}
+
+// Ballot is the body of POST /votes.  Every field has to be there, 0 is
+// a real id, so they are pointers to tell a 0 from a missing field.
+type Ballot struct {
+	VoterID   *uint `json:"voter_id" binding:"required"`
+	PollID    *uint `json:"poll_id" binding:"required"`
+	VoteValue *uint `json:"vote_value" binding:"required"`
+}
+
+// implementation for POST /votes
+// casts the ballot in the body.  A voter or poll that does not exist, or
+// a vote_value that is not an option of the poll, is a 422
+// Unprocessable Entity, and a second vote of the same voter in a poll
+// is a 409 Conflict.
+func (va *VotesAPI) CastVote(c *gin.Context) {
+	var ballot Ballot
+	if err := c.ShouldBindJSON(&ballot); err != nil {
+		log.Println("Error binding JSON: ", err)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	vote, err := va.db.CastVote(election.Vote{
+		VoterID:   *ballot.VoterID,
+		PollID:    *ballot.PollID,
+		VoteValue: *ballot.VoteValue,
+	})
+	if err != nil {
+		log.Println("Error casting vote: ", err)
+		switch {
+		case errors.Is(err, db.ErrVoterNotFound), errors.Is(err, db.ErrPollNotFound), errors.Is(err, db.ErrInvalidVote):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		case errors.Is(err, db.ErrDuplicateVote):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.AbortWithStatus(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, vote)
+}
+
+// implementation for GET /votes
+// /votes?poll_id=1 returns only the votes of poll 1
+func (va *VotesAPI) GetAllVotes(c *gin.Context) {
+	var votes []election.Vote
+	var err error
+	if pollIdS := c.Query("poll_id"); pollIdS != "" {
+		pollId64, convErr := strconv.ParseInt(pollIdS, 10, 32)
+		if convErr != nil {
+			log.Println("Error converting poll_id to int64: ", convErr)
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		votes, err = va.db.GetPollVotes(int(pollId64))
+	} else {
+		votes, err = va.db.GetAllVotes()
+	}
+	if err != nil {
+		log.Println("Error Getting All Votes: ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, votes)
+}
+
+// implementation for GET /votes/:id
+func (va *VotesAPI) GetVote(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	vote, err := va.db.GetVote(int(id64))
+	if err != nil {
+		log.Println("Vote not found: ", err)
+		if errors.Is(err, db.ErrVoteNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, vote)
+}
+
+// implementation for DELETE /votes/:id
+// the voter loses the poll from its history, and can vote in it again
+func (va *VotesAPI) DeleteVote(c *gin.Context) {
+	idS := c.Param("id")
+	id64, err := strconv.ParseInt(idS, 10, 32)
+	if err != nil {
+		log.Println("Error converting id to int64: ", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := va.db.DeleteVote(int(id64)); err != nil {
+		log.Println("Error deleting vote: ", err)
+		if errors.Is(err, db.ErrVoteNotFound) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Vote successfully deleted"})
+}
+
+// implementation for DELETE /votes
+func (va *VotesAPI) DeleteAllVotes(c *gin.Context) {
+	if err := va.db.DeleteAll(); err != nil {
+		log.Println("Error deleting all votes: ", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "All votes successfully deleted"})
+}
+
+// implementation for GET /votes/health
+// the same shape as the health check of the voter-api, with a 503 when
+// redis cannot be reached
+func (va *VotesAPI) HealthCheck(c *gin.Context) {
+	status, code := "ok", http.StatusOK
+	if err := va.db.Ping(); err != nil {
+		log.Println("Redis is not available: ", err)
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	c.JSON(code,
+		gin.H{
+			"status":  status,
+			"version": Version,
+			"uptime":  int(time.Since(va.started).Seconds()),
+		})
+}
@@ -0,0 +1,2 @@
+#!/bin/bash
+docker build --tag votes-api:v1  -f ./dockerfile .
@@ -0,0 +1,489 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"votes-api/election"
+
+	"github.com/nitishm/go-rejson/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+//------------------------------------------------------------
+// VOTES
+//------------------------------------------------------------
+
+// The votes live in the same redis as the voters of the voter-api and
+// the polls of the poll-api, that is what lets a vote and the history
+// of the voter be written in a single step.  The keys are
+//
+//   - vote:<id>, a json document for every vote
+//   - votes:poll:<poll_id>, a hash from voter_id to vote_id, the votes
+//     of a poll, used to turn away a second vote
+//   - votes-next-id, the last vote id handed out
+//   - tally:poll:<poll_id>, a hash from poll_option_id to the number of
+//     votes, kept up to date as votes are cast and deleted, so the
+//     poll-api never has to count the votes.  Every change is published
+//     on a channel with the same name as the key
+//   - voter:<id> and poll:<id>, owned by the voter-api and poll-api
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+	RedisKeyPrefix       = "vote:"
+	RedisPollVotesPrefix = "votes:poll:"
+	RedisNextIdKey       = "votes-next-id"
+	RedisTallyKeyPrefix  = "tally:poll:"
+
+	//The keys of the voter-api and the poll-api
+	RedisVoterKeyPrefix = "voter:"
+	RedisPollKeyPrefix  = "poll:"
+)
+
+// redisScanCount is the COUNT hint we give to SCAN, roughly how many
+// keys redis looks at in one call
+const redisScanCount = 100
+
+var (
+	// ErrVoteNotFound is returned for a vote that does not exist
+	ErrVoteNotFound = errors.New("vote does not exist")
+
+	// ErrVoterNotFound is returned for a ballot of a voter that does
+	// not exist
+	ErrVoterNotFound = errors.New("voter does not exist")
+
+	// ErrPollNotFound is returned for a ballot in a poll that does not
+	// exist
+	ErrPollNotFound = errors.New("poll does not exist")
+
+	// ErrInvalidVote is returned when the vote_value of a ballot is not
+	// an option of the poll
+	ErrInvalidVote = errors.New("vote_value is not an option of the poll")
+
+	// ErrDuplicateVote is returned when the voter already voted in the
+	// poll
+	ErrDuplicateVote = errors.New("voter already voted in this poll")
+)
+
+type cache struct {
+	cacheClient *redis.Client
+	jsonHelper  *rejson.Handler
+	context     context.Context
+}
+
+type Votes struct {
+	cache
+}
+
+func New() (*Votes, error) {
+	redisUrl := os.Getenv("REDIS_URL")
+	if redisUrl == "" {
+		redisUrl = RedisDefaultLocation
+	}
+	return NewWithCacheInstance(redisUrl)
+}
+
+func NewWithCacheInstance(location string) (*Votes, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	ctx := context.Background()
+
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error() + "cache might not be available, continuing...")
+	}
+
+	jsonHelper := rejson.NewReJSONHandler()
+	jsonHelper.SetGoRedisClientWithContext(ctx, client)
+
+	return &Votes{
+		cache: cache{
+			cacheClient: client,
+			jsonHelper:  jsonHelper,
+			context:     ctx,
+		},
+	}, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS
+//------------------------------------------------------------
+
+func isRedisNilError(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
+}
+
+func redisKeyFromId(id int) string {
+	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+}
+
+func (v *Votes) getVoteFromRedis(key string, vote *election.Vote) error {
+	voteObject, err := v.jsonHelper.JSONGet(key, ".")
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(voteObject.([]byte), vote)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (v *Votes) scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	iter := v.cacheClient.Scan(v.context, 0, pattern, redisScanCount).Iterator()
+	for iter.Next(v.context) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+//------------------------------------------------------------
+// CASTING A VOTE
+//------------------------------------------------------------
+
+// Checking the ballot and then writing the vote and the voter one after
+// the other would leave a gap where a second ballot of the same voter
+// passes the check too, or where the vote is written and the voter is
+// not.  So castVoteScript does all of it.  Redis runs a script from
+// start to finish without running any other command in between, and a
+// script that fails an error check has not written anything yet.
+//
+// Redis wants every key a script touches in KEYS, so it can tell which
+// keys the script uses before running it, a cluster or a proxy needs
+// that to send the script to the right place.  A key we only know inside
+// the script, like the key of a vote id the script hands out, cannot be
+// in KEYS, so the id is handed out first, with INCR, and the script gets
+// the key of the vote.  A ballot that is turned away leaves a gap in the
+// vote ids, nothing else.
+
+// These are the answers of castVoteScript
+const (
+	castDone         = 1
+	castVoterMissing = -1
+	castPollMissing  = -2
+	castInvalidValue = -3
+	castDuplicate    = -4
+)
+
+// castVoteScript checks the ballot and writes the vote, the votes of the
+// poll and the history of the voter.  It answers with castDone, or one
+// of the other cast answers above.
+//
+// KEYS[1] is the voter, KEYS[2] the poll, KEYS[3] the votes of the poll,
+// KEYS[4] the vote and KEYS[5] the tally of the poll.  ARGV[1] is the
+// voter_id, ARGV[2] the poll_id, ARGV[3] the vote_value, ARGV[4] the
+// vote_id, ARGV[5] the json of the vote and ARGV[6] the json of the
+// history entry.
+//
+// The voter gets a new version, like any other change made by the
+// voter-api, so an If-Match on the voter sees that it changed.
+var castVoteScript = redis.NewScript(`
+local voterJson = redis.call('JSON.GET', KEYS[1], '.')
+if not voterJson then
+	return -1
+end
+local pollJson = redis.call('JSON.GET', KEYS[2], '.')
+if not pollJson then
+	return -2
+end
+
+local poll = cjson.decode(pollJson)
+local valid = false
+if type(poll['poll_options']) == 'table' then
+	for _, option in ipairs(poll['poll_options']) do
+		if option['poll_option_id'] == tonumber(ARGV[3]) then
+			valid = true
+		end
+	end
+end
+if not valid then
+	return -3
+end
+
+if redis.call('HEXISTS', KEYS[3], ARGV[1]) == 1 then
+	return -4
+end
+local voter = cjson.decode(voterJson)
+local history = voter['voter_history']
+if type(history) == 'table' then
+	for _, entry in ipairs(history) do
+		if entry['poll_id'] == tonumber(ARGV[2]) then
+			return -4
+		end
+	end
+end
+
+redis.call('JSON.SET', KEYS[4], '.', ARGV[5])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[4])
+redis.call('HINCRBY', KEYS[5], ARGV[3], 1)
+
+if type(history) == 'table' then
+	redis.call('JSON.ARRAPPEND', KEYS[1], '.voter_history', ARGV[6])
+else
+	redis.call('JSON.SET', KEYS[1], '.voter_history', '[' .. ARGV[6] .. ']')
+end
+local version = tonumber(voter['version']) or 0
+redis.call('JSON.SET', KEYS[1], '.version', tostring(version + 1))
+
+redis.call('PUBLISH', KEYS[5], ARGV[3])
+return 1
+`)
+
+// CastVote records the ballot in vote, and adds the poll to the history
+// of the voter, in a single step.  It returns the vote with its id and
+// date, or ErrVoterNotFound, ErrPollNotFound, ErrInvalidVote or
+// ErrDuplicateVote without writing anything.
+func (v *Votes) CastVote(vote election.Vote) (election.Vote, error) {
+	voteId, err := v.cacheClient.Incr(v.context, RedisNextIdKey).Result()
+	if err != nil {
+		return election.Vote{}, err
+	}
+	vote.VoteID = uint(voteId)
+	vote.VoteDate = time.Now().UTC()
+
+	voteJson, err := json.Marshal(vote)
+	if err != nil {
+		return election.Vote{}, err
+	}
+	//The same entry the voter-api adds to the history of a voter
+	historyEntry, err := json.Marshal(map[string]any{
+		"poll_id":   vote.PollID,
+		"vote_date": vote.VoteDate,
+	})
+	if err != nil {
+		return election.Vote{}, err
+	}
+
+	keys := []string{
+		fmt.Sprintf("%s%d", RedisVoterKeyPrefix, vote.VoterID),
+		fmt.Sprintf("%s%d", RedisPollKeyPrefix, vote.PollID),
+		fmt.Sprintf("%s%d", RedisPollVotesPrefix, vote.PollID),
+		redisKeyFromId(int(vote.VoteID)),
+		fmt.Sprintf("%s%d", RedisTallyKeyPrefix, vote.PollID),
+	}
+	res, err := castVoteScript.Run(v.context, v.cacheClient, keys,
+		vote.VoterID, vote.PollID, vote.VoteValue, vote.VoteID, string(voteJson), string(historyEntry)).Int64()
+	if err != nil {
+		return election.Vote{}, err
+	}
+
+	switch res {
+	case castVoterMissing:
+		return election.Vote{}, ErrVoterNotFound
+	case castPollMissing:
+		return election.Vote{}, ErrPollNotFound
+	case castInvalidValue:
+		return election.Vote{}, ErrInvalidVote
+	case castDuplicate:
+		return election.Vote{}, ErrDuplicateVote
+	}
+	return vote, nil
+}
+
+//------------------------------------------------------------
+// DELETING A VOTE
+//------------------------------------------------------------
+
+// The keys a delete touches depend on the poll and the voter of the
+// vote, so the vote is read first and deleteVoteScript gets all of them
+// in KEYS.  The script checks that the vote still has the voter and poll
+// we read, and if it does not, we read it again.
+
+// These are the answers of deleteVoteScript
+const (
+	deleteMissing = 0
+	deleteDone    = 1
+	deleteChanged = -1
+)
+
+// maxDeleteRetries is how many times DeleteVote reads the vote again
+// when it changed between the read and the delete
+const maxDeleteRetries = 10
+
+// deleteVoteScript deletes the vote at KEYS[1], takes it out of the
+// votes of its poll at KEYS[2] and the tally at KEYS[3], and takes the
+// poll out of the history of the voter at KEYS[4], so the voter can vote
+// in the poll again.  ARGV[1] is the voter_id and ARGV[2] the poll_id
+// the vote was read with.  It answers with one of the delete answers
+// above.
+//
+// cjson turns an empty list into {}, so an empty history is written as
+// [] by hand.
+var deleteVoteScript = redis.NewScript(`
+local voteJson = redis.call('JSON.GET', KEYS[1], '.')
+if not voteJson then
+	return 0
+end
+local vote = cjson.decode(voteJson)
+if vote['voter_id'] ~= tonumber(ARGV[1]) or vote['poll_id'] ~= tonumber(ARGV[2]) then
+	return -1
+end
+redis.call('DEL', KEYS[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HINCRBY', KEYS[3], vote['vote_value'], -1)
+redis.call('PUBLISH', KEYS[3], vote['vote_value'])
+
+local voterJson = redis.call('JSON.GET', KEYS[4], '.')
+if not voterJson then
+	return 1
+end
+local voter = cjson.decode(voterJson)
+if type(voter['voter_history']) ~= 'table' then
+	return 1
+end
+
+local history = {}
+local found = false
+for _, entry in ipairs(voter['voter_history']) do
+	if entry['poll_id'] == vote['poll_id'] then
+		found = true
+	else
+		table.insert(history, entry)
+	end
+end
+if found then
+	local historyJson = '[]'
+	if #history > 0 then
+		historyJson = cjson.encode(history)
+	end
+	redis.call('JSON.SET', KEYS[4], '.voter_history', historyJson)
+	local version = tonumber(voter['version']) or 0
+	redis.call('JSON.SET', KEYS[4], '.version', tostring(version + 1))
+end
+return 1
+`)
+
+// DeleteVote deletes the vote with id and takes the poll out of the
+// history of the voter, in a single step
+func (v *Votes) DeleteVote(id int) error {
+	return v.deleteVoteKey(redisKeyFromId(id))
+}
+
+// deleteVoteKey deletes the vote at key, see DeleteVote
+func (v *Votes) deleteVoteKey(key string) error {
+	for i := 0; i < maxDeleteRetries; i++ {
+		var vote election.Vote
+		if err := v.getVoteFromRedis(key, &vote); err != nil {
+			if isRedisNilError(err) {
+				return ErrVoteNotFound
+			}
+			return err
+		}
+
+		keys := []string{
+			key,
+			fmt.Sprintf("%s%d", RedisPollVotesPrefix, vote.PollID),
+			fmt.Sprintf("%s%d", RedisTallyKeyPrefix, vote.PollID),
+			fmt.Sprintf("%s%d", RedisVoterKeyPrefix, vote.VoterID),
+		}
+		res, err := deleteVoteScript.Run(v.context, v.cacheClient, keys, vote.VoterID, vote.PollID).Int64()
+		if err != nil {
+			return err
+		}
+		switch res {
+		case deleteDone:
+			return nil
+		case deleteMissing:
+			return ErrVoteNotFound
+		}
+		//deleteChanged, read the vote again
+	}
+	return errors.New("vote is changing too often, try again")
+}
+
+// DeleteAll deletes every vote, one at a time, so every voter loses
+// the polls of its votes
+func (v *Votes) DeleteAll() error {
+	keys, err := v.scanKeys(RedisKeyPrefix + "*")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		//A vote that is already gone is fine
+		if err := v.deleteVoteKey(key); err != nil && !errors.Is(err, ErrVoteNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+//------------------------------------------------------------
+// READING VOTES
+//------------------------------------------------------------
+
+func (v *Votes) GetVote(id int) (election.Vote, error) {
+	var vote election.Vote
+	if err := v.getVoteFromRedis(redisKeyFromId(id), &vote); err != nil {
+		if isRedisNilError(err) {
+			return election.Vote{}, ErrVoteNotFound
+		}
+		return election.Vote{}, err
+	}
+	return vote, nil
+}
+
+// GetAllVotes returns every vote in order of id
+func (v *Votes) GetAllVotes() ([]election.Vote, error) {
+	keys, err := v.scanKeys(RedisKeyPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+
+	votes := make([]election.Vote, 0, len(keys))
+	for _, key := range keys {
+		var vote election.Vote
+		if err := v.getVoteFromRedis(key, &vote); err != nil {
+			//The vote was deleted after we found its key
+			if isRedisNilError(err) {
+				continue
+			}
+			return nil, err
+		}
+		votes = append(votes, vote)
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].VoteID < votes[j].VoteID })
+	return votes, nil
+}
+
+// GetPollVotes returns the votes of the poll with pollId in order of id,
+// from the votes of the poll, without looking at any other vote
+func (v *Votes) GetPollVotes(pollId int) ([]election.Vote, error) {
+	voteIds, err := v.cacheClient.HVals(v.context, fmt.Sprintf("%s%d", RedisPollVotesPrefix, pollId)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	votes := make([]election.Vote, 0, len(voteIds))
+	for _, voteId := range voteIds {
+		var vote election.Vote
+		if err := v.getVoteFromRedis(RedisKeyPrefix+voteId, &vote); err != nil {
+			//The vote was deleted after we found its id
+			if isRedisNilError(err) {
+				continue
+			}
+			return nil, err
+		}
+		votes = append(votes, vote)
+	}
+	sort.Slice(votes, func(i, j int) bool { return votes[i].VoteID < votes[j].VoteID })
+	return votes, nil
+}
+
+func (v *Votes) Ping() error {
+	return v.cacheClient.Ping(v.context).Err()
+}
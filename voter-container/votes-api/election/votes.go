@@ -0,0 +1,46 @@
+package election
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// This is the votes package of vote-api-starter, with json names that
+// match the voter-api and the poll-api.  VoteValue is the
+// poll_option_id of the option the voter picked.
+
+type Vote struct {
+	VoteID    uint      `json:"vote_id"`
+	VoterID   uint      `json:"voter_id"`
+	PollID    uint      `json:"poll_id"`
+	VoteValue uint      `json:"vote_value"`
+	VoteDate  time.Time `json:"vote_date"`
+}
+
+type VoteData struct {
+	Votes []Vote `json:"votes"`
+}
+
+// constructor for Vote struct
+func NewVote(pid, vid, vtrid, vval uint) *Vote {
+	return &Vote{
+		VoteID:    vid,
+		VoterID:   vtrid,
+		PollID:    pid,
+		VoteValue: vval,
+	}
+}
+
+func NewSampleVote() *Vote {
+	return &Vote{
+		VoteID:    1,
+		PollID:    1,
+		VoterID:   1,
+		VoteValue: 1,
+	}
+}
+
+func (p *Vote) ToJson() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"votes-api/api"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	hostFlag string
+	portFlag uint
+)
+
+func processCmdLineFlags() {
+	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
+	flag.UintVar(&portFlag, "p", 1082, "Default Port")
+
+	flag.Parse()
+}
+
+func main() {
+	processCmdLineFlags()
+	router := gin.Default()
+	router.Use(cors.Default())
+
+	apiHandler, err := api.New()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	router.GET("/votes", apiHandler.GetAllVotes)
+	router.POST("/votes", apiHandler.CastVote)
+	router.DELETE("/votes", apiHandler.DeleteAllVotes)
+	router.GET("/votes/:id", apiHandler.GetVote)
+	router.DELETE("/votes/:id", apiHandler.DeleteVote)
+	router.GET("/votes/health", apiHandler.HealthCheck)
+
+	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
+	router.Run(serverPath)
+}
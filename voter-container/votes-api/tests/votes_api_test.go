@@ -0,0 +1,239 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"votes-api/election"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// The votes api needs the voter-api and the poll-api, with the polls in
+// redis, running on the same redis
+var (
+	BASE_API  = "http://localhost:1082"
+	VOTER_API = "http://localhost:1080"
+	POLL_API  = "http://localhost:1081"
+
+	client = resty.New()
+)
+
+type voterHistory struct {
+	PollId uint `json:"poll_id"`
+}
+
+type voter struct {
+	VoterId     uint           `json:"voter_id"`
+	VoteHistory []voterHistory `json:"voter_history"`
+	Version     int            `json:"version"`
+}
+
+type optionResult struct {
+	PollOptionID uint    `json:"poll_option_id"`
+	Votes        int64   `json:"votes"`
+	Percent      float64 `json:"percent"`
+}
+
+type pollResults struct {
+	Turnout int64          `json:"turnout"`
+	Options []optionResult `json:"options"`
+	Leading []uint         `json:"leading"`
+}
+
+// votesFor returns the votes of the option with id
+func (r pollResults) votesFor(id uint) int64 {
+	for _, option := range r.Options {
+		if option.PollOptionID == id {
+			return option.Votes
+		}
+	}
+	return -1
+}
+
+// Reset the votes, and add the polls and voters the tests vote with
+func TestMain(m *testing.M) {
+	DeleteAllVotesResponse, error := client.R().Delete(BASE_API + "/votes")
+	if DeleteAllVotesResponse.StatusCode() != 200 {
+		fmt.Printf("error clearing votes, %v", error)
+	}
+
+	AddSamplePollsResponse, error := client.R().Get(POLL_API + "/polls/add-sample-polls")
+	if AddSamplePollsResponse.StatusCode() != 200 {
+		fmt.Printf("error adding sample polls, %v", error)
+	}
+
+	for _, id := range []int{20, 21, 22} {
+		client.R().Delete(fmt.Sprintf("%s/voters/%d", VOTER_API, id))
+		AddVoterResponse, error := client.R().
+			SetHeader("Content-Type", "application/json").
+			SetBody(fmt.Sprintf(`{ "voter_id": %d, "name": "Voter %d" }`, id, id)).
+			Post(fmt.Sprintf("%s/voters/%d", VOTER_API, id))
+		if AddVoterResponse.StatusCode() != 200 {
+			fmt.Printf("error adding voter %d, %v", id, error)
+		}
+	}
+
+	os.Exit(m.Run())
+}
+
+func getVoter(t *testing.T, id int) voter {
+	response, _ := client.R().Get(fmt.Sprintf("%s/voters/%d", VOTER_API, id))
+	assert.Equal(t, 200, response.StatusCode())
+	v := voter{}
+	assert.Nil(t, json.Unmarshal(response.Body(), &v))
+	return v
+}
+
+func castVote(body string) *resty.Response {
+	response, _ := client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(body).
+		Post(BASE_API + "/votes")
+	return response
+}
+
+func Test_CastVote(t *testing.T) {
+	before := getVoter(t, 20)
+
+	response := castVote(`{ "voter_id": 20, "poll_id": 1, "vote_value": 2 }`)
+	assert.Equal(t, 200, response.StatusCode())
+	vote := election.Vote{}
+	assert.Nil(t, json.Unmarshal(response.Body(), &vote))
+	assert.NotZero(t, vote.VoteID)
+	assert.Equal(t, uint(20), vote.VoterID)
+	assert.Equal(t, uint(1), vote.PollID)
+	assert.Equal(t, uint(2), vote.VoteValue)
+
+	//The vote and the history of the voter were written together
+	after := getVoter(t, 20)
+	assert.Equal(t, []voterHistory{{PollId: 1}}, after.VoteHistory)
+	assert.Equal(t, before.Version+1, after.Version)
+
+	getResponse, _ := client.R().Get(fmt.Sprintf("%s/votes/%d", BASE_API, vote.VoteID))
+	stored := election.Vote{}
+	assert.Nil(t, json.Unmarshal(getResponse.Body(), &stored))
+	assert.Equal(t, 200, getResponse.StatusCode())
+	assert.Equal(t, vote.VoteValue, stored.VoteValue)
+
+	//One vote per voter per poll, another poll is fine
+	duplicateResponse := castVote(`{ "voter_id": 20, "poll_id": 1, "vote_value": 3 }`)
+	assert.Equal(t, 409, duplicateResponse.StatusCode())
+	otherPollResponse := castVote(`{ "voter_id": 20, "poll_id": 2, "vote_value": 3 }`)
+	assert.Equal(t, 200, otherPollResponse.StatusCode())
+	assert.Equal(t, 2, len(getVoter(t, 20).VoteHistory))
+}
+
+func Test_CastVoteRejected(t *testing.T) {
+	before := getVoter(t, 21)
+
+	tests := map[string]struct {
+		body   string
+		status int
+	}{
+		"not an option": {`{ "voter_id": 21, "poll_id": 1, "vote_value": 99 }`, 422},
+		"unknown poll":  {`{ "voter_id": 21, "poll_id": 42, "vote_value": 1 }`, 422},
+		"unknown voter": {`{ "voter_id": 4242, "poll_id": 1, "vote_value": 1 }`, 422},
+		"no vote_value": {`{ "voter_id": 21, "poll_id": 1 }`, 400},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			response := castVote(test.body)
+			assert.Equal(t, test.status, response.StatusCode())
+		})
+	}
+
+	//Nothing was written
+	after := getVoter(t, 21)
+	assert.Equal(t, before.Version, after.Version)
+	assert.Equal(t, 0, len(after.VoteHistory))
+}
+
+func Test_DeleteVote(t *testing.T) {
+	response := castVote(`{ "voter_id": 22, "poll_id": 1, "vote_value": 1 }`)
+	assert.Equal(t, 200, response.StatusCode())
+	vote := election.Vote{}
+	assert.Nil(t, json.Unmarshal(response.Body(), &vote))
+
+	deleteResponse, _ := client.R().Delete(fmt.Sprintf("%s/votes/%d", BASE_API, vote.VoteID))
+	assert.Equal(t, 200, deleteResponse.StatusCode())
+	assert.Equal(t, 0, len(getVoter(t, 22).VoteHistory))
+
+	getResponse, _ := client.R().Get(fmt.Sprintf("%s/votes/%d", BASE_API, vote.VoteID))
+	assert.Equal(t, 404, getResponse.StatusCode())
+
+	//The voter can vote in the poll again
+	againResponse := castVote(`{ "voter_id": 22, "poll_id": 1, "vote_value": 4 }`)
+	assert.Equal(t, 200, againResponse.StatusCode())
+}
+
+// readResults returns the data of the next results event of a stream
+func readResults(t *testing.T, reader *bufio.Reader) pollResults {
+	results := pollResults{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			assert.Nil(t, json.Unmarshal([]byte(data), &results))
+			return results
+		}
+	}
+}
+
+func Test_PollResults(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, POLL_API+"/polls/2/results/stream", nil)
+	stream, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	assert.Equal(t, 200, stream.StatusCode)
+	reader := bufio.NewReader(stream.Body)
+
+	before := readResults(t, reader)
+	assert.Equal(t, 4, len(before.Options))
+
+	response := castVote(`{ "voter_id": 21, "poll_id": 2, "vote_value": 3 }`)
+	assert.Equal(t, 200, response.StatusCode())
+
+	//The vote is counted as it is cast, and streamed
+	after := readResults(t, reader)
+	assert.Equal(t, before.Turnout+1, after.Turnout)
+	assert.Equal(t, before.votesFor(3)+1, after.votesFor(3))
+	assert.Equal(t, before.votesFor(1), after.votesFor(1))
+
+	getResponse, _ := client.R().Get(POLL_API + "/polls/2/results")
+	results := pollResults{}
+	assert.Nil(t, json.Unmarshal(getResponse.Body(), &results))
+	assert.Equal(t, 200, getResponse.StatusCode())
+	assert.Equal(t, after, results)
+
+	var percent float64
+	for _, option := range results.Options {
+		percent += option.Percent
+	}
+	assert.InDelta(t, 100, percent, 0.1)
+}
+
+func Test_HealthCheck(t *testing.T) {
+	response, _ := client.R().Get(BASE_API + "/votes/health")
+	health := map[string]any{}
+
+	err := json.Unmarshal(response.Body(), &health)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, response.StatusCode())
+	assert.Equal(t, "ok", health["status"])
+}